@@ -6,23 +6,99 @@ import (
 )
 
 type InMemoryCacheAdapter struct {
-	inMemoryCache *data.InMemoryCache[string, loop.CacheEntry]
+	namespaces *data.Namespaces[string, loop.Entry]
 }
 
-func (adapter *InMemoryCacheAdapter) Get(key string) (loop.CacheEntry, bool) {
-	return adapter.inMemoryCache.Read(key)
+func (adapter *InMemoryCacheAdapter) Get(namespace string, key string) (loop.Entry, bool) {
+	cache, ok := adapter.namespaces.Lookup(namespace)
+	if !ok {
+		return loop.Entry{}, false
+	}
+	return cache.Read(key)
+}
+
+func (adapter *InMemoryCacheAdapter) Peek(namespace string, key string) (loop.Entry, bool) {
+	cache, ok := adapter.namespaces.Lookup(namespace)
+	if !ok {
+		return loop.Entry{}, false
+	}
+	return cache.Peek(key)
+}
+
+func (adapter *InMemoryCacheAdapter) Set(namespace string, key string, entry loop.Entry) error {
+	return adapter.namespaces.Get(namespace).Insert(key, entry)
+}
+
+func (adapter *InMemoryCacheAdapter) Fits(namespace string, key string, entry loop.Entry) error {
+	return adapter.namespaces.Fits(namespace, key, entry)
+}
+
+func (adapter *InMemoryCacheAdapter) Delete(namespace string, key string) error {
+	cache, ok := adapter.namespaces.Lookup(namespace)
+	if !ok {
+		return nil
+	}
+	return cache.Remove(key)
 }
 
-func (adapter *InMemoryCacheAdapter) Set(key string, val loop.CacheEntry) error {
-	return adapter.inMemoryCache.Insert(key, val)
+func (adapter *InMemoryCacheAdapter) Keys() []loop.Key {
+	keys := []loop.Key{}
+	for _, namespace := range adapter.namespaces.Names() {
+		cache, ok := adapter.namespaces.Lookup(namespace)
+		if !ok {
+			continue
+		}
+		for _, key := range cache.Keys() {
+			keys = append(keys, loop.Key{Namespace: namespace, Key: key})
+		}
+	}
+	return keys
+}
+
+func (adapter *InMemoryCacheAdapter) Tagged(namespace string, tag string) []string {
+	cache, ok := adapter.namespaces.Lookup(namespace)
+	if !ok {
+		return []string{}
+	}
+	return cache.Tagged(tag)
 }
 
-func (adapter *InMemoryCacheAdapter) Delete(key string) error {
-	return adapter.inMemoryCache.Remove(key)
+func (adapter *InMemoryCacheAdapter) Stats() []loop.NamespaceStats {
+	all := adapter.namespaces.Stats()
+	stats := make([]loop.NamespaceStats, 0, len(all))
+	for _, namespace := range adapter.namespaces.Names() {
+		s, ok := all[namespace]
+		if !ok {
+			continue
+		}
+		stats = append(stats, loop.NamespaceStats{
+			Namespace: namespace,
+			Entries:   s.Entries,
+			Bytes:     s.Bytes,
+			Hits:      s.Hits,
+			Misses:    s.Misses,
+			Evictions: s.Evictions,
+		})
+	}
+	return stats
 }
 
-func NewInMemoryCacheAdapter(cache *data.InMemoryCache[string, loop.CacheEntry]) loop.Cache {
+func SizeOf(key string, entry loop.Entry) int {
+	size := len(key) + len(entry.Value) + len(entry.ContentType)
+	for _, tag := range entry.Tags {
+		size += len(tag)
+	}
+	return size
+}
+
+func TagsOf(entry loop.Entry) []string {
+	return entry.Tags
+}
+
+// indexes the namespaces' entries by tag, so it has to be given them before they're used
+func NewInMemoryCacheAdapter(namespaces *data.Namespaces[string, loop.Entry]) loop.Cache {
+	namespaces.IndexTags(TagsOf)
 	return &InMemoryCacheAdapter{
-		inMemoryCache: cache,
+		namespaces: namespaces,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	READ_OP   = "read"
+	WRITE_OP  = "write"
+	DELETE_OP = "delete"
+	ADMIN_OP  = "admin"
+	// pub/sub channels aren't in a namespace, only rules without one cover them
+	PUBLISH_OP   = "publish"
+	SUBSCRIBE_OP = "subscribe"
+
+	API_KEY_HEADER = "X-Api-Key"
+)
+
+var (
+	ErrUnauthenticated = fmt.Errorf("missing or unknown credential")
+	ErrForbidden       = fmt.Errorf("credential isn't allowed to do this")
+)
+
+// grants the ops on every key starting with Prefix in Namespace. an empty prefix covers
+// every key and an empty namespace covers every namespace, node wide admin routes included
+type Rule struct {
+	Namespace string
+	Prefix    string
+	Ops       []string
+}
+
+type Credential struct {
+	Name  string
+	Key   string
+	Rules []Rule
+}
+
+type ACL struct {
+	credentials []credential
+}
+
+type credential struct {
+	Credential
+	hash [sha256.Size]byte
+}
+
+func New(credentials []Credential) *ACL {
+	acl := &ACL{}
+	for _, c := range credentials {
+		acl.credentials = append(acl.credentials, credential{
+			Credential: c,
+			hash:       sha256.Sum256([]byte(c.Key)),
+		})
+	}
+
+	return acl
+}
+
+// every credential is compared so the time taken doesn't give away which one was close
+func (a *ACL) Authenticate(key string) (Credential, bool) {
+	hash := sha256.Sum256([]byte(key))
+
+	var found Credential
+	ok := false
+	for _, c := range a.credentials {
+		if subtle.ConstantTimeCompare(hash[:], c.hash[:]) == 1 {
+			found = c.Credential
+			ok = true
+		}
+	}
+
+	return found, ok && key != ""
+}
+
+func (a *ACL) Authorize(key string, op string, namespace string, cacheKey string) error {
+	c, ok := a.Authenticate(key)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !c.Allows(op, namespace, cacheKey) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func (c Credential) Allows(op string, namespace string, cacheKey string) bool {
+	for _, rule := range c.Rules {
+		if rule.Namespace != "" && rule.Namespace != namespace {
+			continue
+		}
+		if !strings.HasPrefix(cacheKey, rule.Prefix) {
+			continue
+		}
+		for _, allowed := range rule.Ops {
+			if allowed == op {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// takes either "Authorization: Bearer <key>" or "X-Api-Key: <key>"
+func KeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(API_KEY_HEADER); key != "" {
+		return key
+	}
+
+	return BearerToken(r)
+}
+
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// for the shared node credential, which isn't scoped by an acl
+func TokenMatches(token string, expected string) bool {
+	a := sha256.Sum256([]byte(token))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1 && expected != ""
+}
+
+func IsValidOp(op string) bool {
+	switch op {
+	case READ_OP, WRITE_OP, DELETE_OP, ADMIN_OP, PUBLISH_OP, SUBSCRIBE_OP:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createACL() *ACL {
+	return New([]Credential{
+		{
+			Name: "sessions",
+			Key:  "session-key",
+			Rules: []Rule{
+				{Prefix: "session:", Ops: []string{READ_OP, WRITE_OP}},
+			},
+		},
+		{
+			Name: "ops",
+			Key:  "ops-key",
+			Rules: []Rule{
+				{Prefix: "", Ops: []string{READ_OP, DELETE_OP, ADMIN_OP}},
+			},
+		},
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	acl := createACL()
+
+	c, ok := acl.Authenticate("ops-key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "ops", c.Name)
+}
+
+func TestAuthenticateUnknownKey(t *testing.T) {
+	acl := createACL()
+
+	_, ok := acl.Authenticate("nope")
+	assert.False(t, ok)
+
+	_, ok = acl.Authenticate("")
+	assert.False(t, ok)
+}
+
+func TestAuthorizePrefix(t *testing.T) {
+	acl := createACL()
+
+	assert.Nil(t, acl.Authorize("session-key", WRITE_OP, "", "session:123"))
+	assert.ErrorIs(t, acl.Authorize("session-key", WRITE_OP, "", "user:123"), ErrForbidden)
+	assert.ErrorIs(t, acl.Authorize("session-key", DELETE_OP, "", "session:123"), ErrForbidden)
+	assert.ErrorIs(t, acl.Authorize("", READ_OP, "", "session:123"), ErrUnauthenticated)
+}
+
+func TestAuthorizeEmptyPrefixCoversEveryKey(t *testing.T) {
+	acl := createACL()
+
+	assert.Nil(t, acl.Authorize("ops-key", DELETE_OP, "", "anything"))
+	assert.Nil(t, acl.Authorize("ops-key", ADMIN_OP, "", ""))
+	assert.ErrorIs(t, acl.Authorize("ops-key", WRITE_OP, "", "anything"), ErrForbidden)
+}
+
+func TestKeyFromRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/get", nil)
+	r.Header.Set("Authorization", "Bearer abc")
+	assert.Equal(t, "abc", KeyFromRequest(r))
+
+	r = httptest.NewRequest(http.MethodPost, "/get", nil)
+	r.Header.Set(API_KEY_HEADER, "def")
+	assert.Equal(t, "def", KeyFromRequest(r))
+
+	r = httptest.NewRequest(http.MethodPost, "/get", nil)
+	r.Header.Set("Authorization", "Basic abc")
+	assert.Equal(t, "", KeyFromRequest(r))
+}
+
+func TestTokenMatches(t *testing.T) {
+	assert.True(t, TokenMatches("node", "node"))
+	assert.False(t, TokenMatches("other", "node"))
+	assert.False(t, TokenMatches("", ""))
+}
+
+func TestAuthorizeNamespace(t *testing.T) {
+	acl := New([]Credential{
+		{
+			Name:  "tenant",
+			Key:   "tenant-key",
+			Rules: []Rule{{Namespace: "tenant", Ops: []string{READ_OP, ADMIN_OP}}},
+		},
+	})
+
+	assert.Nil(t, acl.Authorize("tenant-key", READ_OP, "tenant", "anything"))
+	assert.Nil(t, acl.Authorize("tenant-key", ADMIN_OP, "tenant", ""))
+	assert.ErrorIs(t, acl.Authorize("tenant-key", READ_OP, "other", "anything"), ErrForbidden)
+	// node wide admin routes need a rule that isn't tied to a namespace
+	assert.ErrorIs(t, acl.Authorize("tenant-key", ADMIN_OP, "", ""), ErrForbidden)
+}
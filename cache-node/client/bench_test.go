@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+)
+
+// go test ./client -run '^$' -bench .
+const BENCH_BATCH_SIZE = 100
+
+var benchValue = make([]byte, 128)
+
+func BenchmarkHTTPGet(b *testing.B) {
+	_, ts := createNode(b, data.Options{})
+	c := createClient(b, Options{Url: ts.URL})
+	c.Set(context.Background(), "key", Item{Value: benchValue})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Get(context.Background(), "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTCPGet(b *testing.B) {
+	node, _ := createNode(b, data.Options{})
+	c := createTCPClient(b, TCPOptions{Addr: serveTCP(b, node)})
+	c.Set(context.Background(), "key", Item{Value: benchValue})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Get(context.Background(), "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// http gets a connection per concurrent request, tcp pipelines them all over one
+func BenchmarkHTTPGetParallel(b *testing.B) {
+	_, ts := createNode(b, data.Options{})
+	c := createClient(b, Options{Url: ts.URL})
+	c.Set(context.Background(), "key", Item{Value: benchValue})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := c.Get(context.Background(), "key"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTCPGetParallel(b *testing.B) {
+	node, _ := createNode(b, data.Options{})
+	c := createTCPClient(b, TCPOptions{Addr: serveTCP(b, node)})
+	c.Set(context.Background(), "key", Item{Value: benchValue})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := c.Get(context.Background(), "key"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// reported per key rather than per batch
+func BenchmarkTCPBatchGet(b *testing.B) {
+	node, _ := createNode(b, data.Options{})
+	c := createTCPClient(b, TCPOptions{Addr: serveTCP(b, node)})
+	requests := make([]wire.Request, BENCH_BATCH_SIZE)
+	for i := range requests {
+		key := fmt.Sprint("key", i)
+		c.Set(context.Background(), key, Item{Value: benchValue})
+		requests[i] = wire.Request{Op: wire.OP_GET, Key: key}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += BENCH_BATCH_SIZE {
+		if _, _, err := c.Batch(context.Background(), requests); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
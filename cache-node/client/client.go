@@ -0,0 +1,516 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/load"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
+)
+
+const (
+	DEFAULT_TIMEOUT = 5 * time.Second
+	// a load shared by several Gets isn't cut short when one of them gives up
+	DEFAULT_LOAD_TIMEOUT = 10 * time.Second
+
+	NAMESPACE_HEADER = "X-Cache-Namespace"
+	TTL_HEADER       = "X-Cache-Ttl"
+	SOFT_TTL_HEADER  = "X-Cache-Soft-Ttl"
+	COST_HEADER      = "X-Cache-Cost"
+)
+
+// compare with errors.Is, every error from a node matches one of these
+var (
+	ErrBadRequest         = loop.ErrBadRequest
+	ErrUnauthenticated    = loop.ErrUnauthenticated
+	ErrForbidden          = loop.ErrForbidden
+	ErrNotFound           = loop.ErrNotFound
+	ErrConflict           = loop.ErrConflict
+	ErrPreconditionFailed = loop.ErrPreconditionFailed
+	ErrTooLarge           = loop.ErrTooLarge
+	ErrRateLimited        = loop.ErrRateLimited
+	ErrOverloaded         = loop.ErrOverloaded
+	ErrInternal           = loop.ErrInternal
+)
+
+var (
+	ErrNoNode = fmt.Errorf("set either Url or Registry")
+	// some nodes couldn't be reached and still have keys carrying the tag, invalidating again is safe
+	ErrPartialInvalidation = fmt.Errorf("tag wasn't invalidated on every node")
+)
+
+// what's stored under a key, the node keeps Value byte for byte
+type Item struct {
+	Value []byte
+	// defaults to application/octet-stream
+	ContentType string
+	Flags       uint32
+	// Invalidate deletes every key carrying one of them
+	Tags []string
+	// only sent by Set, rounded up to whole seconds. 0 never expires
+	TTL time.Duration
+	// the same for when it goes stale, shorter than TTL. 0 never goes stale
+	SoftTTL time.Duration
+	// only sent by Set, rounded up to whole milliseconds. how long the value took to compute,
+	// Gets close to the item going stale or expiring are picked to refresh it earlier the
+	// costlier it is. 0 never refreshes early
+	Cost time.Duration
+	// set by Get once the item is past its soft ttl, it's still served until it expires
+	Stale bool
+	// set by Get when it was picked to refresh the item early
+	Refresh bool
+}
+
+// the same json as the node's request and response bodies
+type requestBody struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	// always sent, an empty value is still a value
+	Data        []byte   `json:"data"`
+	ContentType string   `json:"content_type,omitempty"`
+	Flags       uint32   `json:"flags,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// sent as headers rather than in the body
+	ttl     time.Duration
+	softTTL time.Duration
+	cost    time.Duration
+	// only write over this version, or only create the key
+	ifMatch     uint64
+	ifNoneMatch bool
+	// tracked for the near cache
+	track bool
+}
+
+type response struct {
+	Error       string          `json:"error"`
+	Code        string          `json:"code"`
+	Message     string          `json:"message"`
+	Value       json.RawMessage `json:"value"`
+	Data        []byte          `json:"data"`
+	ContentType string          `json:"content_type"`
+	Flags       uint32          `json:"flags"`
+	Tags        []string        `json:"tags"`
+	Stale       bool            `json:"stale"`
+	Refresh     bool            `json:"refresh"`
+	// from the ETag header
+	version uint64
+	// the node that answered and the headers it answered with
+	node   string
+	header http.Header
+}
+
+type invalidateResponse struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Value   struct {
+		Deleted int      `json:"deleted"`
+		Failed  []string `json:"failed"`
+	} `json:"value"`
+}
+
+type Options struct {
+	// a cache node, used for every key when Registry is empty
+	Url string
+	// asked for the node that owns each key
+	Registry string
+	// the default namespace when empty
+	Namespace string
+	ApiKey    string
+	// defaults to a client with DEFAULT_TIMEOUT
+	HttpClient *http.Client
+	// the body format, defaults to msgpack
+	Codec codec.Codec
+	// Get keeps up to this many items in process, and the nodes push invalidations when they
+	// change. 0 turns the near cache off
+	NearCacheSize int
+	// the longest an item is kept, 0 keeps it until it changes, is evicted or its ttl runs out
+	NearCacheTTL time.Duration
+}
+
+type Client struct {
+	options Options
+	http    *http.Client
+	loaders load.Patterns[registeredLoader]
+	loads   load.Group[Item]
+	// nil without a near cache
+	near *nearCache
+}
+
+// fetches a missing key from where it really lives. an error matching ErrNotFound
+// leaves the key missing
+type Loader func(ctx context.Context, key string) (Item, error)
+
+type registeredLoader struct {
+	load    Loader
+	softTTL time.Duration
+	ttl     time.Duration
+}
+
+func New(options Options) (*Client, error) {
+	if options.Url == "" && options.Registry == "" {
+		return nil, ErrNoNode
+	}
+	httpClient := options.HttpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DEFAULT_TIMEOUT}
+	}
+	if options.Codec == nil {
+		options.Codec = codec.Msgpack
+	}
+
+	c := &Client{
+		options: options,
+		http:    httpClient,
+	}
+	if options.NearCacheSize > 0 {
+		c.near = newNearCache(options.NearCacheSize, options.NearCacheTTL, httpClient, c.setHeaders)
+	}
+
+	return c, nil
+}
+
+// stops the near cache's tracking streams, after which it keeps nothing new
+func (c *Client) Close() {
+	if c.near != nil {
+		c.near.close()
+	}
+}
+
+// a client for another namespace sharing this one's connections and near cache, but not its loaders
+func (c *Client) Namespace(namespace string) *Client {
+	options := c.options
+	options.Namespace = namespace
+	return &Client{options: options, http: c.http, near: c.near}
+}
+
+// Get on a missing key matching pattern calls loader and stores what it returns with ttl
+// and softTTL, the item's own wins when it has them. a stale item, or one a Get was picked to
+// refresh early, is returned as is while loader refreshes it in the background. the time
+// loader takes is the item's cost unless it sets its own. a key in this client only has one load or refresh
+// running at a time, the first pattern added that matches wins
+func (c *Client) AddLoader(pattern string, softTTL time.Duration, ttl time.Duration, loader Loader) {
+	c.loaders.Add(pattern, registeredLoader{load: loader, softTTL: softTTL, ttl: ttl})
+}
+
+// a missing key returns ErrNotFound, unless a loader covers it. items from the near cache share
+// their Value, it mustn't be changed
+func (c *Client) Get(ctx context.Context, key string) (Item, error) {
+	if c.near != nil {
+		if item, ok := c.near.get(c.nearKey(key)); ok {
+			return item, nil
+		}
+	}
+
+	item, version, err := c.get(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return item, err
+	}
+	if err == nil && !item.Stale && !item.Refresh {
+		return item, nil
+	}
+	loader, ok := c.loaders.Match(key)
+	if !ok {
+		return item, err
+	}
+
+	if err == nil {
+		c.loads.Go(key, func() (Item, error) {
+			return c.load(key, version, loader)
+		})
+		return item, nil
+	}
+
+	return c.loads.Do(key, func() (Item, error) {
+		return c.load(key, 0, loader)
+	})
+}
+
+func (c *Client) get(ctx context.Context, key string) (Item, uint64, error) {
+	var fill uint64
+	if c.near != nil {
+		fill = c.near.startFill(c.nearKey(key))
+	}
+	resp, err := c.do(ctx, "/get", requestBody{Key: key, track: c.near != nil})
+	if err != nil {
+		return Item{}, 0, err
+	}
+
+	// json values come back as json rather than base64
+	value := resp.Data
+	if resp.Value != nil {
+		value = resp.Value
+	}
+	item := Item{Value: value, ContentType: resp.ContentType, Flags: resp.Flags, Tags: resp.Tags, Stale: resp.Stale, Refresh: resp.Refresh}
+
+	// stale items and ones picked to refresh are left to the node, which decides when they're done
+	tracking := resp.header.Get(TRACKING_HEADER)
+	if lifetime, ok := keepFor(resp.header); ok && tracking != "" && !item.Stale && !item.Refresh {
+		c.near.fill(c.nearKey(key), fill, resp.node, tracking, item, lifetime)
+	}
+
+	return item, resp.version, nil
+}
+
+func (c *Client) Set(ctx context.Context, key string, item Item) error {
+	return c.write(ctx, "/set", key, setBody(key, item))
+}
+
+// the near cache drops the key before the write, so nothing older is kept while it's in flight,
+// and after it, so a Get after the write never sees what was there before
+func (c *Client) write(ctx context.Context, path string, key string, body requestBody) error {
+	if c.near != nil {
+		c.near.invalidate(c.nearKey(key))
+		defer c.near.invalidate(c.nearKey(key))
+	}
+
+	_, err := c.do(ctx, path, body)
+	return err
+}
+
+// near cache keys always name their namespace, the nodes' invalidations do
+func (c *Client) nearKey(key string) loop.Key {
+	namespace := c.options.Namespace
+	if namespace == "" {
+		namespace = loop.DEFAULT_NAMESPACE
+	}
+
+	return loop.Key{Namespace: namespace, Key: key}
+}
+
+func setBody(key string, item Item) requestBody {
+	value := item.Value
+	if value == nil {
+		// nil would be sent as null, which the node reads as no value at all
+		value = []byte{}
+	}
+
+	return requestBody{
+		Key:         key,
+		Data:        value,
+		ContentType: item.ContentType,
+		Flags:       item.Flags,
+		Tags:        item.Tags,
+		ttl:         item.TTL,
+		softTTL:     item.SoftTTL,
+		cost:        item.Cost,
+	}
+}
+
+// version is the stale item's that's being refreshed, 0 for a miss. a write that lands during
+// the load wins over the loaded item, which is returned either way. so is one that couldn't
+// be stored, the next Get just loads it again
+func (c *Client) load(key string, version uint64, loader registeredLoader) (Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_LOAD_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	item, err := loader.load(ctx, key)
+	if err != nil {
+		return Item{}, err
+	}
+	if item.Cost == 0 {
+		item.Cost = time.Since(start)
+	}
+	if item.TTL == 0 {
+		item.TTL = loader.ttl
+	}
+	if item.SoftTTL == 0 {
+		item.SoftTTL = loader.softTTL
+	}
+
+	body := setBody(key, item)
+	body.ifMatch = version
+	body.ifNoneMatch = version == 0
+	c.write(ctx, "/set", key, body)
+
+	return item, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.write(ctx, "/delete", key, requestBody{Key: key})
+}
+
+// deletes the keys carrying tag on every node in the cluster, the node that gets the call
+// fans it out. returns how many keys were deleted, along with ErrPartialInvalidation
+// when some nodes couldn't be reached
+func (c *Client) Invalidate(ctx context.Context, tag string) (int, error) {
+	node, err := c.nodeFor(ctx, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, nodeUrl(node, "/invalidate?tag="+url.QueryEscape(tag)), nil)
+	if err != nil {
+		return 0, err
+	}
+	c.setHeaders(r)
+	if c.options.Namespace != "" {
+		r.Header.Set(NAMESPACE_HEADER, c.options.Namespace)
+	}
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body invalidateResponse
+	err = checkResponse(resp, decodeBody(resp, &body), body.Code, body.Error)
+	if err != nil {
+		return 0, err
+	}
+	if len(body.Value.Failed) > 0 {
+		return body.Value.Deleted, fmt.Errorf("%w: %s", ErrPartialInvalidation, strings.Join(body.Value.Failed, ", "))
+	}
+
+	return body.Value.Deleted, nil
+}
+
+func (c *Client) do(ctx context.Context, path string, body requestBody) (response, error) {
+	node, err := c.nodeFor(ctx, body.Key)
+	if err != nil {
+		return response{}, err
+	}
+
+	body.Namespace = c.options.Namespace
+	buf := bytes.NewBuffer(nil)
+	if err := c.options.Codec.Encode(buf, body); err != nil {
+		return response{}, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, nodeUrl(node, path), buf)
+	if err != nil {
+		return response{}, err
+	}
+	r.Header.Set("Content-Type", c.options.Codec.ContentType())
+	c.setHeaders(r)
+	if body.ttl > 0 {
+		r.Header.Set(TTL_HEADER, seconds(body.ttl))
+	}
+	if body.softTTL > 0 {
+		r.Header.Set(SOFT_TTL_HEADER, seconds(body.softTTL))
+	}
+	if body.cost > 0 {
+		r.Header.Set(COST_HEADER, strconv.FormatInt(int64(math.Ceil(float64(body.cost)/float64(time.Millisecond))), 10))
+	}
+	if body.ifMatch != 0 {
+		r.Header.Set("If-Match", `"`+strconv.FormatUint(body.ifMatch, 10)+`"`)
+	}
+	if body.ifNoneMatch {
+		r.Header.Set("If-None-Match", "*")
+	}
+	if body.track {
+		if id := c.near.trackingId(node); id != "" {
+			r.Header.Set(TRACKING_HEADER, id)
+		}
+	}
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	decoded, err := decodeResponse(resp)
+	decoded.node = node
+	decoded.header = resp.Header
+	return decoded, err
+}
+
+func (c *Client) setHeaders(r *http.Request) {
+	r.Header.Set("Accept", c.options.Codec.ContentType())
+	if c.options.ApiKey != "" {
+		r.Header.Set(auth.API_KEY_HEADER, c.options.ApiKey)
+	}
+}
+
+func decodeResponse(resp *http.Response) (response, error) {
+	var body response
+	err := decodeBody(resp, &body)
+	body.version, _ = strconv.ParseUint(strings.Trim(resp.Header.Get("ETag"), `"`), 10, 64)
+	return body, checkResponse(resp, err, body.Code, body.Error)
+}
+
+// rounded up, the node only takes whole seconds
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// errors come back with a code, which is turned back into the matching sentinel
+func checkResponse(resp *http.Response, decodeErr error, code string, message string) error {
+	if decodeErr != nil {
+		return loop.ErrorFromCode(loop.INTERNAL_CODE, fmt.Sprintf("unexpected %d response: %s", resp.StatusCode, decodeErr))
+	}
+	if code != "" {
+		return loop.ErrorFromCode(code, message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return loop.ErrorFromCode(loop.INTERNAL_CODE, fmt.Sprintf("unexpected %d response", resp.StatusCode))
+	}
+
+	return nil
+}
+
+type nodeResponse struct {
+	Message string `json:"message"`
+	Url     string `json:"url"`
+}
+
+func (c *Client) nodeFor(ctx context.Context, key string) (string, error) {
+	if c.options.Registry == "" {
+		return c.options.Url, nil
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeUrl(c.options.Registry, "/node?key="+url.QueryEscape(key)), nil)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Accept", c.options.Codec.ContentType())
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body nodeResponse
+	if err := decodeBody(resp, &body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || body.Url == "" {
+		return "", fmt.Errorf("registry couldn't find a node: %s", body.Message)
+	}
+
+	return body.Url, nil
+}
+
+// in whatever format the server answered with, older servers only answer json
+// and don't always say so
+func decodeBody(resp *http.Response, v any) error {
+	c, err := codec.ForContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		c = codec.Json
+	}
+
+	return c.Decode(resp.Body, v)
+}
+
+func nodeUrl(node string, path string) string {
+	if strings.HasPrefix(node, "http://") || strings.HasPrefix(node, "https://") {
+		return node + path
+	}
+
+	return "http://" + node + path
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/server"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func createNode(t testing.TB, options data.Options) (*server.Server, *httptest.Server) {
+	namespaces := data.NewNamespaces[string, loop.Entry](options, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	tracking := track.NewTable()
+	eventLoop.SetPublisher(tracking)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+
+	node := server.New(eventLoop, ":0", nil)
+	node.SetTrackingTable(tracking)
+	ts := httptest.NewServer(node.Handler)
+	t.Cleanup(ts.Close)
+	return node, ts
+}
+
+func createClient(t testing.TB, options Options) *Client {
+	c, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSetAndGet(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+
+	assert.Nil(t, c.Set(context.Background(), "key", Item{Value: []byte{0, 255}, ContentType: "image/png", Flags: 3}))
+	item, err := c.Get(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Item{Value: []byte{0, 255}, ContentType: "image/png", Flags: 3}, item)
+}
+
+func TestJSONValuesRoundTrip(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+
+	for _, value := range []string{`{"a":"<b> & </b>"}`, "{\n  \"a\": \"<b> & </b>\"\n}\n"} {
+		assert.Nil(t, c.Set(context.Background(), "key", Item{Value: []byte(value), ContentType: "application/json"}))
+		item, err := c.Get(context.Background(), "key")
+
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(item.Value))
+	}
+}
+
+func TestSetEmptyValue(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+
+	assert.Nil(t, c.Set(context.Background(), "key", Item{}))
+	item, err := c.Get(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Empty(t, item.Value)
+}
+
+func TestGetMissing(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+
+	_, err := c.Get(context.Background(), "missing")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDelete(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+	c.Set(context.Background(), "key", Item{Value: []byte("value")})
+
+	assert.Nil(t, c.Delete(context.Background(), "key"))
+	_, err := c.Get(context.Background(), "key")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNamespace(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+	c.Namespace("a").Set(context.Background(), "key", Item{Value: []byte("a")})
+
+	_, err := c.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	item, err := c.Namespace("a").Get(context.Background(), "key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), item.Value)
+
+	_, err = c.Namespace("not valid").Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrBadRequest)
+}
+
+func TestErrorCodes(t *testing.T) {
+	node, ts := createNode(t, data.Options{MaxBytes: 16})
+	node.SetACL(auth.New([]auth.Credential{
+		{Name: "reader", Key: "reader-key", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}},
+		{Name: "writer", Key: "writer-key", Rules: []auth.Rule{{Ops: []string{auth.WRITE_OP}}}},
+	}))
+
+	err := createClient(t, Options{Url: ts.URL}).Set(context.Background(), "key", Item{Value: []byte("value")})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	err = createClient(t, Options{Url: ts.URL, ApiKey: "reader-key"}).Set(context.Background(), "key", Item{Value: []byte("value")})
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	err = createClient(t, Options{Url: ts.URL, ApiKey: "writer-key"}).Set(context.Background(), "key", Item{Value: []byte("much too large for the quota")})
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestRegistryRouting(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "a key", r.URL.Query().Get("key"))
+		w.Write([]byte(`{"message": "Success", "url": "` + ts.URL + `"}`))
+	}))
+	defer registry.Close()
+	c := createClient(t, Options{Registry: registry.URL})
+
+	assert.Nil(t, c.Set(context.Background(), "a key", Item{Value: []byte("value")}))
+}
+
+func TestTagsAndInvalidate(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+
+	for _, c := range []codec.Codec{codec.Json, codec.Msgpack, codec.Cbor} {
+		cl := createClient(t, Options{Url: ts.URL, Codec: c}).Namespace(c.ContentType()[len("application/"):])
+		assert.Nil(t, cl.Set(context.Background(), "a", Item{Value: []byte("a"), Tags: []string{"product:1"}}))
+		assert.Nil(t, cl.Set(context.Background(), "b", Item{Value: []byte("b"), Tags: []string{"product:2"}}))
+		item, _ := cl.Get(context.Background(), "a")
+		assert.Equal(t, []string{"product:1"}, item.Tags)
+
+		deleted, err := cl.Invalidate(context.Background(), "product:1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, deleted)
+		_, err = cl.Get(context.Background(), "a")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = cl.Get(context.Background(), "b")
+		assert.Nil(t, err)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+	loads := 0
+	c.AddLoader("user:*", 0, time.Minute, func(ctx context.Context, key string) (Item, error) {
+		loads++
+		if key == "user:missing" {
+			return Item{}, ErrNotFound
+		}
+		return Item{Value: []byte("loaded " + key)}, nil
+	})
+
+	item, err := c.Get(context.Background(), "user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("loaded user:1"), item.Value)
+	item, err = c.Get(context.Background(), "user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("loaded user:1"), item.Value)
+	assert.Equal(t, 1, loads)
+
+	resp, err := http.Get(ts.URL + "/v1/keys/user:1")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "60", resp.Header.Get(server.TTL_HEADER))
+
+	_, err = c.Get(context.Background(), "user:missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Get(context.Background(), "session:1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 2, loads)
+}
+
+func TestLoaderRefreshesStaleItems(t *testing.T) {
+	sets := make(chan http.Header, 1)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/set" {
+			sets <- r.Header
+			w.Write([]byte(`{"message": "Value set successfully"}`))
+			return
+		}
+		w.Header().Set("ETag", `"5"`)
+		w.Write([]byte(`{"message": "Value found", "data": "b2xk", "stale": true}`))
+	}))
+	t.Cleanup(node.Close)
+	c := createClient(t, Options{Url: node.URL, Codec: codec.Json})
+	var loads atomic.Int32
+	release := make(chan struct{})
+	c.AddLoader("*", time.Minute, time.Hour, func(ctx context.Context, key string) (Item, error) {
+		loads.Add(1)
+		<-release
+		return Item{Value: []byte("new")}, nil
+	})
+
+	// every read gets the stale item while one refresh runs
+	for range 3 {
+		item, err := c.Get(context.Background(), "key")
+		assert.Nil(t, err)
+		assert.True(t, item.Stale)
+		assert.Equal(t, []byte("old"), item.Value)
+	}
+	close(release)
+
+	header := <-sets
+	assert.Equal(t, `"5"`, header.Get("If-Match"))
+	assert.Equal(t, "60", header.Get(SOFT_TTL_HEADER))
+	assert.Equal(t, "3600", header.Get(TTL_HEADER))
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestCostlyItemsAreRefreshedEarly(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createClient(t, Options{Url: ts.URL})
+	// a cost of decades on a minute's ttl puts every Get inside the head start
+	c.Set(context.Background(), "costly", Item{Value: []byte("v"), TTL: time.Minute, Cost: 1000000 * time.Hour})
+	c.Set(context.Background(), "free", Item{Value: []byte("v"), TTL: time.Minute})
+
+	costly, err := c.Get(context.Background(), "costly")
+	assert.Nil(t, err)
+	assert.True(t, costly.Refresh)
+	free, err := c.Get(context.Background(), "free")
+	assert.Nil(t, err)
+	assert.False(t, free.Refresh)
+}
+
+func TestLoaderRecordsItsCost(t *testing.T) {
+	sets := make(chan http.Header, 1)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/set" {
+			sets <- r.Header
+			w.Write([]byte(`{"message": "Value set successfully"}`))
+			return
+		}
+		w.Header().Set("ETag", `"5"`)
+		w.Write([]byte(`{"message": "Value found", "data": "b2xk", "refresh": true}`))
+	}))
+	t.Cleanup(node.Close)
+	c := createClient(t, Options{Url: node.URL, Codec: codec.Json})
+	c.AddLoader("*", 0, time.Hour, func(ctx context.Context, key string) (Item, error) {
+		time.Sleep(5 * time.Millisecond)
+		return Item{Value: []byte("new")}, nil
+	})
+
+	item, err := c.Get(context.Background(), "key")
+	assert.Nil(t, err)
+	assert.True(t, item.Refresh)
+	assert.Equal(t, []byte("old"), item.Value)
+
+	header := <-sets
+	assert.Equal(t, `"5"`, header.Get("If-Match"))
+	cost, _ := strconv.Atoi(header.Get(COST_HEADER))
+	assert.GreaterOrEqual(t, cost, 5)
+}
+
+func TestNewNeedsANode(t *testing.T) {
+	_, err := New(Options{})
+
+	assert.ErrorIs(t, err, ErrNoNode)
+}
+
+func TestCodecs(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+
+	for _, c := range []codec.Codec{codec.Json, codec.Msgpack, codec.Cbor} {
+		cl := createClient(t, Options{Url: ts.URL, Codec: c})
+
+		assert.Nil(t, cl.Set(context.Background(), c.ContentType(), Item{Value: []byte{0, 255}}))
+		item, err := cl.Get(context.Background(), c.ContentType())
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{0, 255}, item.Value)
+
+		_, err = cl.Get(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+}
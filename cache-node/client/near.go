@@ -0,0 +1,266 @@
+package client
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	TRACKING_HEADER = "X-Cache-Tracking"
+
+	// the wait before reconnecting a tracking stream that ended, doubled each time it fails
+	// to connect again
+	DEFAULT_TRACKING_RETRY     = time.Second
+	DEFAULT_MAX_TRACKING_RETRY = 30 * time.Second
+)
+
+// what the node's tracking stream sends
+type trackingEvent struct {
+	Type      string `json:"type"`
+	Id        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// items Get keeps in process, least recently used first out. each node a kept item came from
+// has a tracking stream open, and pushes an invalidation on it the first time the item changes
+type nearCache struct {
+	size int
+	// 0 keeps items until they're invalidated or their ttl runs out
+	ttl time.Duration
+	// without a timeout, tracking streams stay open
+	http       *http.Client
+	setHeaders func(r *http.Request)
+	entries    map[loop.Key]*list.Element
+	// front is the most recently used
+	lru *list.List
+	// the Get filling each key, an invalidation drops it so a value read before the change
+	// isn't kept after it
+	fills    map[loop.Key]uint64
+	lastFill uint64
+	sessions map[string]*session
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mux      sync.Mutex
+}
+
+type nearEntry struct {
+	key  loop.Key
+	node string
+	item Item
+	// the zero time doesn't expire
+	expiresAt time.Time
+}
+
+// one node's tracking stream
+type session struct {
+	// empty until the stream is open
+	id         string
+	connecting bool
+	retryAt    time.Time
+	retry      time.Duration
+}
+
+func newNearCache(size int, ttl time.Duration, httpClient *http.Client, setHeaders func(r *http.Request)) *nearCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &nearCache{
+		size:       size,
+		ttl:        ttl,
+		http:       &http.Client{Transport: httpClient.Transport},
+		setHeaders: setHeaders,
+		entries:    map[loop.Key]*list.Element{},
+		lru:        list.New(),
+		fills:      map[loop.Key]uint64{},
+		sessions:   map[string]*session{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+func (n *nearCache) get(key loop.Key) (Item, bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	element, ok := n.entries[key]
+	if !ok {
+		return Item{}, false
+	}
+	entry := element.Value.(*nearEntry)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		n.remove(element)
+		return Item{}, false
+	}
+
+	n.lru.MoveToFront(element)
+	return entry.item, true
+}
+
+// called before reading key from a node, the token is handed to fill
+func (n *nearCache) startFill(key loop.Key) uint64 {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.lastFill++
+	n.fills[key] = n.lastFill
+	return n.lastFill
+}
+
+// keeps what a read returned, unless the key changed since the read started or the node's
+// stream it was tracked on has ended. lifetime is 0 when it doesn't expire
+func (n *nearCache) fill(key loop.Key, token uint64, node string, id string, item Item, lifetime time.Duration) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.fills[key] != token {
+		return
+	}
+	delete(n.fills, key)
+	if s, ok := n.sessions[node]; !ok || s.id != id {
+		return
+	}
+
+	if n.ttl > 0 && (lifetime == 0 || n.ttl < lifetime) {
+		lifetime = n.ttl
+	}
+	entry := &nearEntry{key: key, node: node, item: item}
+	if lifetime > 0 {
+		entry.expiresAt = time.Now().Add(lifetime)
+	}
+	if element, ok := n.entries[key]; ok {
+		n.remove(element)
+	}
+	n.entries[key] = n.lru.PushFront(entry)
+	for n.lru.Len() > n.size {
+		n.remove(n.lru.Back())
+	}
+}
+
+func (n *nearCache) invalidate(key loop.Key) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	delete(n.fills, key)
+	if element, ok := n.entries[key]; ok {
+		n.remove(element)
+	}
+}
+
+func (n *nearCache) remove(element *list.Element) {
+	n.lru.Remove(element)
+	delete(n.entries, element.Value.(*nearEntry).key)
+}
+
+// the id reads from node are tracked with, empty while its stream isn't open. the first call
+// for a node opens one, and a stream that ended is reopened once its retry wait has passed
+func (n *nearCache) trackingId(node string) string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	s, ok := n.sessions[node]
+	if !ok {
+		s = &session{retry: DEFAULT_TRACKING_RETRY}
+		n.sessions[node] = s
+	}
+	if s.id == "" && !s.connecting && !time.Now().Before(s.retryAt) && n.ctx.Err() == nil {
+		s.connecting = true
+		go n.track(node, s)
+	}
+
+	return s.id
+}
+
+func (n *nearCache) track(node string, s *session) {
+	opened := n.stream(node, s)
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	// what was kept from the node can't be invalidated any more. reads tracked on the stream
+	// aren't kept either, fill checks their id
+	for _, element := range n.entries {
+		if element.Value.(*nearEntry).node == node {
+			n.remove(element)
+		}
+	}
+	s.id = ""
+	s.connecting = false
+	if opened {
+		s.retry = DEFAULT_TRACKING_RETRY
+	}
+	s.retryAt = time.Now().Add(s.retry)
+	s.retry = min(s.retry*2, DEFAULT_MAX_TRACKING_RETRY)
+}
+
+// reads the node's tracking stream until it ends, opened is whether it got an id
+func (n *nearCache) stream(node string, s *session) (opened bool) {
+	r, err := http.NewRequestWithContext(n.ctx, http.MethodGet, nodeUrl(node, "/track"), nil)
+	if err != nil {
+		return false
+	}
+	n.setHeaders(r)
+	resp, err := n.http.Do(r)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event trackingEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return opened
+		}
+
+		switch event.Type {
+		case "tracking":
+			n.mux.Lock()
+			s.id = event.Id
+			n.mux.Unlock()
+			opened = true
+		case "invalidate":
+			n.invalidate(loop.Key{Namespace: event.Namespace, Key: event.Key})
+		}
+	}
+
+	return opened
+}
+
+// stops every tracking stream
+func (n *nearCache) close() {
+	n.cancel()
+}
+
+// how long a read's value can be kept, ok is false when it's about to expire or go stale.
+// the node rounds both up to whole seconds, so a second comes off
+func keepFor(header http.Header) (lifetime time.Duration, ok bool) {
+	for _, name := range []string{TTL_HEADER, SOFT_TTL_HEADER} {
+		seconds, err := strconv.Atoi(header.Get(name))
+		if err != nil {
+			continue
+		}
+		if seconds <= 1 {
+			return 0, false
+		}
+		left := time.Duration(seconds-1) * time.Second
+		if lifetime == 0 || left < lifetime {
+			lifetime = left
+		}
+	}
+
+	return lifetime, true
+}
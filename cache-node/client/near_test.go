@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func createNearClient(t *testing.T, options Options) *Client {
+	c := createClient(t, options)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Gets until the item is served from the near cache, the first ones open the tracking stream
+func waitUntilKept(t *testing.T, c *Client, key string) {
+	assert.Eventually(t, func() bool {
+		c.Get(context.Background(), key)
+		_, ok := c.near.get(c.nearKey(key))
+		return ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestNearCacheIsInvalidatedByTheNode(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createNearClient(t, Options{Url: ts.URL, NearCacheSize: 10})
+	other := createClient(t, Options{Url: ts.URL})
+	other.Set(context.Background(), "key", Item{Value: []byte("1")})
+	waitUntilKept(t, c, "key")
+
+	other.Set(context.Background(), "key", Item{Value: []byte("2")})
+
+	assert.Eventually(t, func() bool {
+		_, ok := c.near.get(c.nearKey("key"))
+		return !ok
+	}, time.Second, time.Millisecond)
+	item, err := c.Get(context.Background(), "key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), item.Value)
+}
+
+func TestNearCacheIsInvalidatedByANamespaceFlush(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createNearClient(t, Options{Url: ts.URL, NearCacheSize: 10}).Namespace("sessions")
+	c.Set(context.Background(), "key", Item{Value: []byte("1")})
+	waitUntilKept(t, c, "key")
+
+	resp, err := http.Post(ts.URL+"/admin/namespaces/sessions/flush", "application/json", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Eventually(t, func() bool {
+		_, ok := c.near.get(c.nearKey("key"))
+		return !ok
+	}, time.Second, time.Millisecond)
+	_, err = c.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNearCacheReadsItsOwnWrites(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createNearClient(t, Options{Url: ts.URL, NearCacheSize: 10})
+	c.Set(context.Background(), "key", Item{Value: []byte("1")})
+	waitUntilKept(t, c, "key")
+
+	c.Set(context.Background(), "key", Item{Value: []byte("2")})
+	item, _ := c.Get(context.Background(), "key")
+	assert.Equal(t, []byte("2"), item.Value)
+
+	c.Delete(context.Background(), "key")
+	_, err := c.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNearCacheIsDroppedWhenTheStreamEnds(t *testing.T) {
+	_, ts := createNode(t, data.Options{})
+	c := createNearClient(t, Options{Url: ts.URL, NearCacheSize: 10})
+	c.Set(context.Background(), "key", Item{Value: []byte("1")})
+	waitUntilKept(t, c, "key")
+
+	ts.CloseClientConnections()
+
+	assert.Eventually(t, func() bool {
+		_, ok := c.near.get(c.nearKey("key"))
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestNearCacheEvictsTheLeastRecentlyUsed(t *testing.T) {
+	near := newNearCache(2, 0, http.DefaultClient, func(r *http.Request) {})
+	near.sessions["node"] = &session{id: "id"}
+	keep := func(k string) {
+		key := loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: k}
+		near.fill(key, near.startFill(key), "node", "id", Item{Value: []byte(k)}, 0)
+	}
+
+	keep("a")
+	keep("b")
+	near.get(loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: "a"})
+	keep("c")
+
+	_, ok := near.get(loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: "b"})
+	assert.False(t, ok)
+	_, ok = near.get(loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: "a"})
+	assert.True(t, ok)
+}
+
+func TestNearCacheSkipsReadsInvalidatedInFlight(t *testing.T) {
+	near := newNearCache(2, 0, http.DefaultClient, func(r *http.Request) {})
+	near.sessions["node"] = &session{id: "id"}
+	key := loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: "a"}
+
+	fill := near.startFill(key)
+	near.invalidate(key)
+	near.fill(key, fill, "node", "id", Item{Value: []byte("old")}, 0)
+	_, ok := near.get(key)
+	assert.False(t, ok)
+
+	// nor reads tracked on a stream that has since ended
+	near.fill(key, near.startFill(key), "node", "ended", Item{Value: []byte("old")}, 0)
+	_, ok = near.get(key)
+	assert.False(t, ok)
+}
+
+func TestKeepFor(t *testing.T) {
+	lifetime, ok := keepFor(http.Header{TTL_HEADER: {"60"}, SOFT_TTL_HEADER: {"10"}})
+	assert.True(t, ok)
+	assert.Equal(t, 9*time.Second, lifetime)
+
+	lifetime, ok = keepFor(http.Header{})
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), lifetime)
+
+	_, ok = keepFor(http.Header{TTL_HEADER: {"1"}})
+	assert.False(t, ok)
+}
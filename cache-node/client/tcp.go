@@ -0,0 +1,251 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+)
+
+var ErrClosed = fmt.Errorf("connection closed")
+
+type TCPOptions struct {
+	// host:port of a node's wire protocol listener
+	Addr string
+	// the default namespace when empty
+	Namespace string
+	ApiKey    string
+	// dials with tls when set
+	TLSConfig *tls.Config
+	// defaults to DEFAULT_TIMEOUT
+	DialTimeout time.Duration
+}
+
+// a single connection to one node, safe to share. concurrent requests are pipelined
+// over it and answered in whatever order the node finishes them
+type TCPClient struct {
+	conn      net.Conn
+	namespace string
+
+	writer *bufio.Writer
+	// writers waiting on writeMux, the last one flushes for everyone before it
+	waiting  atomic.Int64
+	writeMux sync.Mutex
+
+	nextID  uint32
+	pending map[uint32]chan wire.Response
+	// set once the connection fails, every later request returns it
+	err error
+	mux sync.Mutex
+}
+
+func DialTCP(ctx context.Context, options TCPOptions) (*TCPClient, error) {
+	timeout := options.DialTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+
+	var conn net.Conn
+	var err error
+	if options.TLSConfig != nil {
+		dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: options.TLSConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", options.Addr)
+	} else {
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err = dialer.DialContext(ctx, "tcp", options.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TCPClient{
+		conn:      conn,
+		namespace: options.Namespace,
+		writer:    bufio.NewWriter(conn),
+		pending:   map[uint32]chan wire.Response{},
+	}
+	go c.readResponses()
+
+	if options.ApiKey != "" {
+		if _, err := c.Do(ctx, wire.Request{Op: wire.OP_AUTH, Value: []byte(options.ApiKey)}); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *TCPClient) Close() error {
+	c.fail(ErrClosed)
+	return c.conn.Close()
+}
+
+// a missing key returns ErrNotFound
+func (c *TCPClient) Get(ctx context.Context, key string) (Item, error) {
+	resp, err := c.Do(ctx, wire.Request{Op: wire.OP_GET, Key: key})
+	if err != nil {
+		return Item{}, err
+	}
+
+	return Item{Value: resp.Value, ContentType: resp.ContentType, Flags: resp.Flags}, nil
+}
+
+func (c *TCPClient) Set(ctx context.Context, key string, item Item) error {
+	_, err := c.Do(ctx, wire.Request{
+		Op:          wire.OP_SET,
+		Key:         key,
+		Value:       item.Value,
+		ContentType: item.ContentType,
+		Flags:       item.Flags,
+	})
+	return err
+}
+
+func (c *TCPClient) Delete(ctx context.Context, key string) error {
+	_, err := c.Do(ctx, wire.Request{Op: wire.OP_DELETE, Key: key})
+	return err
+}
+
+// sends every request in one frame, each gets its own response or error back in the same order
+func (c *TCPClient) Batch(ctx context.Context, requests []wire.Request) ([]wire.Response, []error, error) {
+	resp, err := c.Do(ctx, wire.Request{Op: wire.OP_BATCH, Batch: requests})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Batch) != len(requests) {
+		return nil, nil, fmt.Errorf("sent %d requests but got %d responses", len(requests), len(resp.Batch))
+	}
+
+	errs := make([]error, len(resp.Batch))
+	for i, r := range resp.Batch {
+		errs[i] = responseError(r)
+	}
+	return resp.Batch, errs, nil
+}
+
+// sends one request and waits for its response, requests without a namespace use the client's
+func (c *TCPClient) Do(ctx context.Context, req wire.Request) (wire.Response, error) {
+	if req.Namespace == "" {
+		req.Namespace = c.namespace
+	}
+	// the caller's requests are left alone
+	req.Batch = slices.Clone(req.Batch)
+	for i := range req.Batch {
+		if req.Batch[i].Namespace == "" {
+			req.Batch[i].Namespace = c.namespace
+		}
+	}
+
+	id, respChan, err := c.register()
+	if err != nil {
+		return wire.Response{}, err
+	}
+	if err := c.write(id, req); err != nil {
+		c.unregister(id)
+		return wire.Response{}, err
+	}
+
+	select {
+	case resp, ok := <-respChan:
+		if !ok {
+			return wire.Response{}, c.failure()
+		}
+		return resp, responseError(resp)
+	case <-ctx.Done():
+		c.unregister(id)
+		return wire.Response{}, ctx.Err()
+	}
+}
+
+func (c *TCPClient) register() (uint32, chan wire.Response, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.nextID++
+	respChan := make(chan wire.Response, 1)
+	c.pending[c.nextID] = respChan
+	return c.nextID, respChan, nil
+}
+
+func (c *TCPClient) unregister(id uint32) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *TCPClient) write(id uint32, req wire.Request) error {
+	c.waiting.Add(1)
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+
+	err := wire.WriteRequest(c.writer, id, req)
+	if c.waiting.Add(-1) == 0 || err != nil {
+		if flushErr := c.writer.Flush(); flushErr != nil {
+			c.fail(flushErr)
+			return flushErr
+		}
+	}
+	return err
+}
+
+func (c *TCPClient) readResponses() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		id, resp, err := wire.ReadResponse(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mux.Lock()
+		respChan, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mux.Unlock()
+
+		// cancelled requests aren't pending anymore
+		if ok {
+			respChan <- resp
+		}
+	}
+}
+
+// closes every pending request's channel, the first error is the one kept
+func (c *TCPClient) fail(err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	for id, respChan := range c.pending {
+		close(respChan)
+		delete(c.pending, id)
+	}
+}
+
+func (c *TCPClient) failure() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.err
+}
+
+func responseError(resp wire.Response) error {
+	if resp.Code == "" {
+		return nil
+	}
+
+	return loop.ErrorFromCode(resp.Code, resp.Error)
+}
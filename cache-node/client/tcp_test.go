@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/server"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveTCP(t testing.TB, node *server.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ServeTCP(l)
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().String()
+}
+
+func createTCPClient(t testing.TB, options TCPOptions) *TCPClient {
+	c, err := DialTCP(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTCPSetAndGet(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node)})
+
+	assert.Nil(t, c.Set(context.Background(), "key", Item{Value: []byte{0, 255}, ContentType: "image/png", Flags: 3}))
+	item, err := c.Get(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Item{Value: []byte{0, 255}, ContentType: "image/png", Flags: 3}, item)
+
+	assert.Nil(t, c.Delete(context.Background(), "key"))
+	_, err = c.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTCPSharesKeysWithHTTP(t *testing.T) {
+	node, ts := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node), Namespace: "a"})
+
+	c.Set(context.Background(), "key", Item{Value: []byte("value")})
+	item, err := createClient(t, Options{Url: ts.URL, Namespace: "a"}).Get(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), item.Value)
+}
+
+func TestTCPPipelining(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			assert.Nil(t, c.Set(context.Background(), key, Item{Value: []byte(key)}))
+			item, err := c.Get(context.Background(), key)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(key), item.Value)
+		}(fmt.Sprint("key", i))
+	}
+	wg.Wait()
+}
+
+func TestTCPBatch(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node)})
+
+	responses, errs, err := c.Batch(context.Background(), []wire.Request{
+		{Op: wire.OP_SET, Key: "key", Value: []byte("value")},
+		{Op: wire.OP_GET, Key: "key"},
+		{Op: wire.OP_GET, Key: "missing"},
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, []byte("value"), responses[1].Value)
+	assert.ErrorIs(t, errs[2], ErrNotFound)
+}
+
+func TestTCPVersions(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node)})
+
+	set, err := c.Do(context.Background(), wire.Request{Op: wire.OP_SET, Key: "key", Value: []byte("a")})
+	assert.Nil(t, err)
+
+	_, err = c.Do(context.Background(), wire.Request{Op: wire.OP_SET, Key: "key", Value: []byte("b"), Version: set.Version + 1})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	_, err = c.Do(context.Background(), wire.Request{Op: wire.OP_SET, Key: "key", Value: []byte("b"), Version: set.Version})
+	assert.Nil(t, err)
+}
+
+func TestTCPAuth(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	node.SetACL(auth.New([]auth.Credential{
+		{Name: "reader", Key: "reader-key", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}},
+	}))
+	addr := serveTCP(t, node)
+
+	_, err := DialTCP(context.Background(), TCPOptions{Addr: addr, ApiKey: "wrong"})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	err = createTCPClient(t, TCPOptions{Addr: addr}).Set(context.Background(), "key", Item{})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	err = createTCPClient(t, TCPOptions{Addr: addr, ApiKey: "reader-key"}).Set(context.Background(), "key", Item{})
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestTCPClosed(t *testing.T) {
+	node, _ := createNode(t, data.Options{})
+	c := createTCPClient(t, TCPOptions{Addr: serveTCP(t, node)})
+	c.Close()
+
+	_, err := c.Get(context.Background(), "key")
+
+	assert.ErrorIs(t, err, ErrClosed)
+}
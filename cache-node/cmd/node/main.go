@@ -2,31 +2,168 @@ package main
 
 import (
 	"flag"
-	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/config"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/load"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/server"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
 )
 
 var (
+	configFlag   = flag.String("config", "", "path to a yaml config file, CACHE_* environment variables override it")
 	portFlag     = flag.Int("port", 8080, "port for server to listen on")
 	hostnameFlag = flag.String("hostname", "localhost", "hostname for the server")
+	registryFlag = flag.String("registry", config.DEFAULT_REGISTRY, "comma separated urls of the registry nodes")
 )
 
+var logLevel = new(slog.LevelVar)
+
 func main() {
 	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := conf.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%s", err)
+	}
 
-	inMemoryCache := data.NewInMemoryCache[string, loop.CacheEntry](data.Options{})
-	cache := adapter.NewInMemoryCacheAdapter(inMemoryCache)
+	namespaces := data.NewNamespaces[string, loop.Entry](conf.DataOptions(), adapter.SizeOf)
+	cache := adapter.NewInMemoryCacheAdapter(namespaces)
 	eventLoop := loop.NewEventLoop(cache)
+	eventLoop.SetEarlyRefreshBeta(conf.Cache.EarlyRefreshBeta)
+	hub := watch.NewHub()
+	tracking := track.NewTable()
+	eventLoop.SetPublisher(loop.Publishers{hub, tracking})
+	if conf.Store.Path != "" {
+		file, err := store.OpenFile(conf.Store.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		writer, err := store.NewWriter(file, conf.StoreOptions())
+		if err != nil {
+			log.Fatal(err)
+		}
+		// write-behind applies what it still has queued once the server has stopped
+		defer writer.Close()
+		eventLoop.SetBackingStore(writer)
+	}
+	server := server.New(eventLoop, conf.Listen, conf.Registry)
+	server.SetWatchHub(hub)
+	server.SetTrackingTable(tracking)
+	for _, loader := range conf.Loaders {
+		namespace := loader.Namespace
+		if namespace == "" {
+			namespace = loop.DEFAULT_NAMESPACE
+		}
+		// not the server's client, that one sends the node token
+		server.AddLoader(namespace, loader.Pattern, loader.SoftTTL, loader.TTL, load.HTTP(http.DefaultClient, loader.Url))
+	}
 
-	host := fmt.Sprintf("%s:%d", *hostnameFlag, *portFlag)
+	apply := func(conf config.Config) {
+		level, _ := conf.Level()
+		logLevel.Set(level)
+		namespaces.SetQuotas(conf.DefaultQuota(), conf.Quotas())
+		server.SetACL(conf.ACL())
+		namespaceRate, namespaceRates := conf.NamespaceRates()
+		server.SetRateLimits(conf.ClientRate(), namespaceRate, namespaceRates)
+		server.SetShedQueueDepth(conf.Limits.ShedQueueDepth)
+		server.SetMaxEntryBytes(conf.Limits.MaxEntryBytes)
+	}
+	apply(conf)
+	reloader := config.NewReloader(conf, loadConfig, apply)
+	go reloadOnSignal(reloader)
+	go stopOnSignal(server)
 
-	registryUrl := "http://localhost:8081"
+	server.SetReloader(reloader)
+	server.SetNodeToken(conf.Auth.NodeToken)
+	if conf.TLS.CertFile != "" || conf.TLS.CaFile != "" {
+		c, err := certs.New(conf.CertOptions())
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.UseTLS(c, conf.TLS.RequireClientCert)
+	}
 
-	server := server.New(eventLoop, host, registryUrl)
+	if conf.TCPListen != "" {
+		server.ListenTCP(conf.TCPListen)
+	}
+	if conf.GRPCListen != "" {
+		server.ListenGRPC(conf.GRPCListen)
+	}
+	if conf.RESPListen != "" {
+		server.ListenRESP(conf.RESPListen)
+	}
 
 	server.Run()
 }
+
+// lets Run return, so queued writes reach the store before the process exits
+func stopOnSignal(s *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+	// a second one kills the process if stopping hangs
+	signal.Stop(signals)
+	s.Stop()
+}
+
+func reloadOnSignal(reloader *config.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		result, err := reloader.Reload()
+		if err != nil {
+			slog.Error("Config reload failed, keeping the running config", "err", err)
+			continue
+		}
+		slog.Info("Config reloaded", "applied", result.Applied, "restart_required", result.RestartRequired)
+	}
+}
+
+// flags given on the command line win over the file and environment
+func loadConfig() (config.Config, error) {
+	conf, err := config.Load(*configFlag)
+	if err != nil {
+		return conf, err
+	}
+
+	host, port, err := net.SplitHostPort(conf.Listen)
+	if err != nil {
+		host, port = conf.Listen, ""
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			port = strconv.Itoa(*portFlag)
+		case "hostname":
+			host = *hostnameFlag
+		case "registry":
+			conf.Registry = config.SplitList(*registryFlag)
+		}
+	})
+	if port != "" {
+		conf.Listen = net.JoinHostPort(host, port)
+	}
+
+	return conf, nil
+}
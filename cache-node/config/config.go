@@ -0,0 +1,601 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/limit"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_LISTEN    = "localhost:8080"
+	DEFAULT_REGISTRY  = "http://localhost:8081"
+	DEFAULT_LOG_LEVEL = "info"
+
+	DEFAULT_MAX_ENTRY_BYTES = 1 << 20
+
+	ENV_PREFIX = "CACHE_"
+)
+
+type Config struct {
+	Listen string `yaml:"listen"`
+	// host:port for the binary wire protocol, empty turns it off
+	TCPListen string `yaml:"tcp_listen"`
+	// host:port for grpc, empty turns it off
+	GRPCListen string `yaml:"grpc_listen"`
+	// host:port for redis pub/sub clients, empty turns it off
+	RESPListen string `yaml:"resp_listen"`
+	// debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// every member of the registry group, tried in order
+	Registry []string `yaml:"registry"`
+	Cache    Cache    `yaml:"cache"`
+	Limits   Limits   `yaml:"limits"`
+	TLS      TLS      `yaml:"tls"`
+	Auth     Auth     `yaml:"auth"`
+	// reads of missing keys matching a loader's pattern are fetched from its url
+	Loaders []Loader `yaml:"loaders"`
+	Store   Store    `yaml:"store"`
+}
+
+type Cache struct {
+	Capacity          uint32  `yaml:"capacity"`
+	ResizeThreshold   float32 `yaml:"resize_threshold"`
+	ResizeCoefficient uint32  `yaml:"resize_coefficient"`
+	// the quota for every namespace without its own
+	Eviction Eviction `yaml:"eviction"`
+	// per namespace quotas, keyed by namespace
+	Namespaces map[string]Quota `yaml:"namespaces"`
+	// how early reads refresh entries that have a cost, higher is earlier and 0 turns it off
+	EarlyRefreshBeta float64 `yaml:"early_refresh_beta"`
+}
+
+type Eviction struct {
+	// 0 means no limit
+	MaxEntries int `yaml:"max_entries"`
+	MaxBytes   int `yaml:"max_bytes"`
+}
+
+// 0 means no limit, the eviction defaults don't fill in for it
+type Quota struct {
+	MaxEntries int `yaml:"max_entries"`
+	MaxBytes   int `yaml:"max_bytes"`
+}
+
+type Limits struct {
+	// for each client, keyed by credential name or by address without an acl
+	Client Limit `yaml:"client"`
+	// for each namespace without its own
+	Namespace  Limit            `yaml:"namespace"`
+	Namespaces map[string]Limit `yaml:"namespaces"`
+	// client requests get a 503 once this many events are queued, 0 turns it off
+	ShedQueueDepth int `yaml:"shed_queue_depth"`
+	// the largest value a PUT can send, 0 means no limit
+	MaxEntryBytes int `yaml:"max_entry_bytes"`
+}
+
+// requests per second, a rate of 0 means no limit and burst defaults to one second's worth
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// verifies the registry and other nodes, the system roots are used when empty
+	CaFile string `yaml:"ca_file"`
+	// only nodes with a certificate signed by ca_file can send migrations and transfers
+	RequireClientCert bool `yaml:"require_client_cert"`
+}
+
+// url can contain {namespace} and {key}, an empty namespace is the default one
+type Loader struct {
+	Namespace string `yaml:"namespace"`
+	Pattern   string `yaml:"pattern"`
+	Url       string `yaml:"url"`
+	// 0 never expires
+	TTL time.Duration `yaml:"ttl"`
+	// past it reads get the stale value while it's loaded again, 0 never goes stale
+	SoftTTL time.Duration `yaml:"soft_ttl"`
+}
+
+// client sets and deletes are written to a file store at path, off without one
+type Store struct {
+	Path string `yaml:"path"`
+	// write-through or write-behind
+	Mode string `yaml:"mode"`
+	// 0 uses the store package's defaults for the rest
+	Attempts      int           `yaml:"attempts"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	QueueSize     int           `yaml:"queue_size"`
+	// writes that ran out of attempts are appended here
+	DeadLetter string `yaml:"dead_letter"`
+}
+
+type Auth struct {
+	// clients need one of these once any are set
+	Credentials []Credential `yaml:"credentials"`
+	// shared by the cache nodes and registries for their calls to each other
+	NodeToken string `yaml:"node_token"`
+}
+
+type Credential struct {
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// an empty prefix covers every key, an empty namespace every namespace
+type Rule struct {
+	Namespace string   `yaml:"namespace"`
+	Prefix    string   `yaml:"prefix"`
+	Ops       []string `yaml:"ops"`
+}
+
+func Default() Config {
+	return Config{
+		Listen:   DEFAULT_LISTEN,
+		LogLevel: DEFAULT_LOG_LEVEL,
+		Registry: []string{DEFAULT_REGISTRY},
+		Store:    Store{Mode: store.WRITE_THROUGH},
+		Limits:   Limits{MaxEntryBytes: DEFAULT_MAX_ENTRY_BYTES},
+		Cache: Cache{
+			Capacity:          data.DefaultCapacity,
+			ResizeThreshold:   data.DefaultResizeThreshold,
+			ResizeCoefficient: data.DefaultResizeCoefficient,
+			EarlyRefreshBeta:  loop.DEFAULT_EARLY_REFRESH_BETA,
+		},
+	}
+}
+
+// defaults, then the file if there is one, then the environment
+func Load(path string) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer file.Close()
+
+		// misspelled settings fail instead of silently falling back to the default
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	env := func(name string, apply func(value string) error) {
+		value, ok := lookup(ENV_PREFIX + name)
+		if !ok {
+			return
+		}
+		if err := apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", ENV_PREFIX, name, err))
+		}
+	}
+
+	env("LISTEN", func(value string) error {
+		c.Listen = value
+		return nil
+	})
+	env("TCP_LISTEN", func(value string) error {
+		c.TCPListen = value
+		return nil
+	})
+	env("GRPC_LISTEN", func(value string) error {
+		c.GRPCListen = value
+		return nil
+	})
+	env("RESP_LISTEN", func(value string) error {
+		c.RESPListen = value
+		return nil
+	})
+	env("LOG_LEVEL", func(value string) error {
+		c.LogLevel = value
+		return nil
+	})
+	env("REGISTRY", func(value string) error {
+		c.Registry = SplitList(value)
+		return nil
+	})
+	env("CAPACITY", func(value string) error {
+		capacity, err := strconv.ParseUint(value, 10, 32)
+		c.Cache.Capacity = uint32(capacity)
+		return err
+	})
+	env("RESIZE_THRESHOLD", func(value string) error {
+		threshold, err := strconv.ParseFloat(value, 32)
+		c.Cache.ResizeThreshold = float32(threshold)
+		return err
+	})
+	env("RESIZE_COEFFICIENT", func(value string) error {
+		coefficient, err := strconv.ParseUint(value, 10, 32)
+		c.Cache.ResizeCoefficient = uint32(coefficient)
+		return err
+	})
+	env("MAX_ENTRIES", func(value string) error {
+		maxEntries, err := strconv.Atoi(value)
+		c.Cache.Eviction.MaxEntries = maxEntries
+		return err
+	})
+	env("MAX_BYTES", func(value string) error {
+		maxBytes, err := strconv.Atoi(value)
+		c.Cache.Eviction.MaxBytes = maxBytes
+		return err
+	})
+	env("EARLY_REFRESH_BETA", func(value string) error {
+		beta, err := strconv.ParseFloat(value, 64)
+		c.Cache.EarlyRefreshBeta = beta
+		return err
+	})
+	env("CLIENT_RATE", func(value string) error {
+		rate, err := strconv.ParseFloat(value, 64)
+		c.Limits.Client.Rate = rate
+		return err
+	})
+	env("CLIENT_BURST", func(value string) error {
+		burst, err := strconv.Atoi(value)
+		c.Limits.Client.Burst = burst
+		return err
+	})
+	env("NAMESPACE_RATE", func(value string) error {
+		rate, err := strconv.ParseFloat(value, 64)
+		c.Limits.Namespace.Rate = rate
+		return err
+	})
+	env("NAMESPACE_BURST", func(value string) error {
+		burst, err := strconv.Atoi(value)
+		c.Limits.Namespace.Burst = burst
+		return err
+	})
+	env("SHED_QUEUE_DEPTH", func(value string) error {
+		depth, err := strconv.Atoi(value)
+		c.Limits.ShedQueueDepth = depth
+		return err
+	})
+	env("MAX_ENTRY_BYTES", func(value string) error {
+		size, err := strconv.Atoi(value)
+		c.Limits.MaxEntryBytes = size
+		return err
+	})
+	env("STORE_PATH", func(value string) error {
+		c.Store.Path = value
+		return nil
+	})
+	env("STORE_MODE", func(value string) error {
+		c.Store.Mode = value
+		return nil
+	})
+	env("STORE_DEAD_LETTER", func(value string) error {
+		c.Store.DeadLetter = value
+		return nil
+	})
+	env("NODE_TOKEN", func(value string) error {
+		c.Auth.NodeToken = value
+		return nil
+	})
+	env("TLS_CERT_FILE", func(value string) error {
+		c.TLS.CertFile = value
+		return nil
+	})
+	env("TLS_KEY_FILE", func(value string) error {
+		c.TLS.KeyFile = value
+		return nil
+	})
+	env("TLS_CA_FILE", func(value string) error {
+		c.TLS.CaFile = value
+		return nil
+	})
+	env("TLS_REQUIRE_CLIENT_CERT", func(value string) error {
+		required, err := strconv.ParseBool(value)
+		c.TLS.RequireClientCert = required
+		return err
+	})
+
+	return errors.Join(errs...)
+}
+
+// reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen must be 'host:port', got '%s'", c.Listen))
+	}
+	if _, _, err := net.SplitHostPort(c.TCPListen); c.TCPListen != "" && err != nil {
+		errs = append(errs, fmt.Errorf("tcp_listen must be 'host:port', got '%s'", c.TCPListen))
+	}
+	if _, _, err := net.SplitHostPort(c.GRPCListen); c.GRPCListen != "" && err != nil {
+		errs = append(errs, fmt.Errorf("grpc_listen must be 'host:port', got '%s'", c.GRPCListen))
+	}
+	if _, _, err := net.SplitHostPort(c.RESPListen); c.RESPListen != "" && err != nil {
+		errs = append(errs, fmt.Errorf("resp_listen must be 'host:port', got '%s'", c.RESPListen))
+	}
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error, got '%s'", c.LogLevel))
+	}
+	if len(c.Registry) == 0 {
+		errs = append(errs, fmt.Errorf("registry needs at least one url"))
+	}
+	for _, url := range c.Registry {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			errs = append(errs, fmt.Errorf("registry url '%s' must start with http:// or https://", url))
+		}
+	}
+
+	// probing only reaches every slot when the capacity is a power of two
+	if c.Cache.Capacity == 0 || c.Cache.Capacity&(c.Cache.Capacity-1) != 0 {
+		errs = append(errs, fmt.Errorf("cache.capacity must be a power of two, got %d", c.Cache.Capacity))
+	}
+	if c.Cache.ResizeThreshold <= 0 || c.Cache.ResizeThreshold > 1 {
+		errs = append(errs, fmt.Errorf("cache.resize_threshold must be in (0, 1], got %v", c.Cache.ResizeThreshold))
+	}
+	if c.Cache.ResizeCoefficient < 2 || c.Cache.ResizeCoefficient&(c.Cache.ResizeCoefficient-1) != 0 {
+		errs = append(errs, fmt.Errorf("cache.resize_coefficient must be a power of two of at least 2, got %d", c.Cache.ResizeCoefficient))
+	}
+	if c.Cache.Eviction.MaxEntries < 0 {
+		errs = append(errs, fmt.Errorf("cache.eviction.max_entries can't be negative, got %d", c.Cache.Eviction.MaxEntries))
+	}
+	if c.Cache.Eviction.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("cache.eviction.max_bytes can't be negative, got %d", c.Cache.Eviction.MaxBytes))
+	}
+	if c.Cache.EarlyRefreshBeta < 0 {
+		errs = append(errs, fmt.Errorf("cache.early_refresh_beta can't be negative, got %v", c.Cache.EarlyRefreshBeta))
+	}
+	for name, quota := range c.Cache.Namespaces {
+		if err := loop.ValidateNamespace(name); err != nil {
+			errs = append(errs, fmt.Errorf("cache.namespaces '%s': %w", name, err))
+		}
+		if quota.MaxEntries < 0 || quota.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("cache.namespaces '%s' quotas can't be negative", name))
+		}
+	}
+
+	errs = append(errs, c.Limits.validate()...)
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.RequireClientCert && (c.TLS.CertFile == "" || c.TLS.CaFile == "") {
+		errs = append(errs, fmt.Errorf("tls.require_client_cert needs tls.cert_file and tls.ca_file"))
+	}
+
+	errs = append(errs, c.Auth.validate()...)
+
+	for i, loader := range c.Loaders {
+		if loader.Namespace != "" {
+			if err := loop.ValidateNamespace(loader.Namespace); err != nil {
+				errs = append(errs, fmt.Errorf("loaders[%d]: %w", i, err))
+			}
+		}
+		if loader.Pattern == "" {
+			errs = append(errs, fmt.Errorf("loaders[%d] needs a pattern", i))
+		}
+		if !strings.HasPrefix(loader.Url, "http://") && !strings.HasPrefix(loader.Url, "https://") {
+			errs = append(errs, fmt.Errorf("loaders[%d] url '%s' must start with http:// or https://", i, loader.Url))
+		}
+		if loader.TTL < 0 {
+			errs = append(errs, fmt.Errorf("loaders[%d] ttl can't be negative, got %v", i, loader.TTL))
+		}
+		if loader.SoftTTL < 0 || (loader.TTL > 0 && loader.SoftTTL >= loader.TTL) {
+			errs = append(errs, fmt.Errorf("loaders[%d] soft_ttl must be positive and shorter than ttl, got %v", i, loader.SoftTTL))
+		}
+	}
+
+	errs = append(errs, c.Store.validate()...)
+
+	return errors.Join(errs...)
+}
+
+func (s Store) validate() []error {
+	var errs []error
+
+	if s.Mode != store.WRITE_THROUGH && s.Mode != store.WRITE_BEHIND {
+		errs = append(errs, fmt.Errorf("store.mode must be %s or %s, got '%s'", store.WRITE_THROUGH, store.WRITE_BEHIND, s.Mode))
+	}
+	if s.Attempts < 0 || s.RetryBackoff < 0 || s.BatchSize < 0 || s.FlushInterval < 0 || s.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("store settings can't be negative"))
+	}
+	if s.Path == "" && s.DeadLetter != "" {
+		errs = append(errs, fmt.Errorf("store.dead_letter needs store.path"))
+	}
+
+	return errs
+}
+
+func (l Limits) validate() []error {
+	var errs []error
+
+	if err := l.Client.validate("limits.client"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := l.Namespace.validate("limits.namespace"); err != nil {
+		errs = append(errs, err)
+	}
+	for name, limit := range l.Namespaces {
+		if err := loop.ValidateNamespace(name); err != nil {
+			errs = append(errs, fmt.Errorf("limits.namespaces '%s': %w", name, err))
+		}
+		if err := limit.validate(fmt.Sprintf("limits.namespaces '%s'", name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// the queue can't hold more than this, a deeper threshold would never shed
+	if l.ShedQueueDepth < 0 || l.ShedQueueDepth > loop.DEFAULT_EVENTS_CHANNEL_CAP {
+		errs = append(errs, fmt.Errorf("limits.shed_queue_depth must be between 0 and %d, got %d", loop.DEFAULT_EVENTS_CHANNEL_CAP, l.ShedQueueDepth))
+	}
+	if l.MaxEntryBytes < 0 {
+		errs = append(errs, fmt.Errorf("limits.max_entry_bytes can't be negative, got %d", l.MaxEntryBytes))
+	}
+
+	return errs
+}
+
+func (l Limit) validate(name string) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("%s rate and burst can't be negative", name)
+	}
+
+	return nil
+}
+
+func (a Auth) validate() []error {
+	var errs []error
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, credential := range a.Credentials {
+		if credential.Name == "" {
+			errs = append(errs, fmt.Errorf("auth.credentials[%d] needs a name", i))
+		} else if names[credential.Name] {
+			errs = append(errs, fmt.Errorf("auth.credentials name '%s' is used twice", credential.Name))
+		}
+		names[credential.Name] = true
+
+		if credential.Key == "" {
+			errs = append(errs, fmt.Errorf("auth.credentials '%s' needs a key", credential.Name))
+		} else if keys[credential.Key] {
+			errs = append(errs, fmt.Errorf("auth.credentials '%s' reuses another credential's key", credential.Name))
+		}
+		keys[credential.Key] = true
+
+		if len(credential.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("auth.credentials '%s' needs at least one rule", credential.Name))
+		}
+		for _, rule := range credential.Rules {
+			if rule.Namespace != "" && loop.ValidateNamespace(rule.Namespace) != nil {
+				errs = append(errs, fmt.Errorf("auth.credentials '%s' has invalid namespace '%s'", credential.Name, rule.Namespace))
+			}
+			for _, op := range rule.Ops {
+				if !auth.IsValidOp(op) {
+					errs = append(errs, fmt.Errorf("auth.credentials '%s' has unknown op '%s', use read, write, delete, admin, publish or subscribe", credential.Name, op))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+func (c Config) CertOptions() certs.Options {
+	return certs.Options{
+		CertFile: c.TLS.CertFile,
+		KeyFile:  c.TLS.KeyFile,
+		CaFile:   c.TLS.CaFile,
+	}
+}
+
+// nil when no credentials are configured, which leaves the node open
+func (c Config) ACL() *auth.ACL {
+	if len(c.Auth.Credentials) == 0 {
+		return nil
+	}
+
+	credentials := make([]auth.Credential, 0, len(c.Auth.Credentials))
+	for _, credential := range c.Auth.Credentials {
+		rules := make([]auth.Rule, 0, len(credential.Rules))
+		for _, rule := range credential.Rules {
+			rules = append(rules, auth.Rule{Namespace: rule.Namespace, Prefix: rule.Prefix, Ops: rule.Ops})
+		}
+		credentials = append(credentials, auth.Credential{Name: credential.Name, Key: credential.Key, Rules: rules})
+	}
+
+	return auth.New(credentials)
+}
+
+func (c Config) DataOptions() data.Options {
+	return data.Options{
+		Capacity:          c.Cache.Capacity,
+		ResizeThreshold:   c.Cache.ResizeThreshold,
+		ResizeCoefficient: c.Cache.ResizeCoefficient,
+		MaxEntries:        c.Cache.Eviction.MaxEntries,
+		MaxBytes:          c.Cache.Eviction.MaxBytes,
+	}
+}
+
+func (c Config) StoreOptions() store.Options {
+	return store.Options{
+		Mode:          c.Store.Mode,
+		Attempts:      c.Store.Attempts,
+		RetryBackoff:  c.Store.RetryBackoff,
+		BatchSize:     c.Store.BatchSize,
+		FlushInterval: c.Store.FlushInterval,
+		QueueSize:     c.Store.QueueSize,
+		DeadLetter:    c.Store.DeadLetter,
+	}
+}
+
+func (c Config) DefaultQuota() data.Quota {
+	return data.Quota{MaxEntries: c.Cache.Eviction.MaxEntries, MaxBytes: c.Cache.Eviction.MaxBytes}
+}
+
+func (c Config) Quotas() map[string]data.Quota {
+	quotas := make(map[string]data.Quota, len(c.Cache.Namespaces))
+	for name, quota := range c.Cache.Namespaces {
+		quotas[name] = data.Quota{MaxEntries: quota.MaxEntries, MaxBytes: quota.MaxBytes}
+	}
+
+	return quotas
+}
+
+func (c Config) ClientRate() limit.Rate {
+	return c.Limits.Client.rate()
+}
+
+func (c Config) NamespaceRates() (limit.Rate, map[string]limit.Rate) {
+	rates := make(map[string]limit.Rate, len(c.Limits.Namespaces))
+	for name, l := range c.Limits.Namespaces {
+		rates[name] = l.rate()
+	}
+
+	return c.Limits.Namespace.rate(), rates
+}
+
+func (l Limit) rate() limit.Rate {
+	return limit.Rate{PerSecond: l.Rate, Burst: l.Burst}
+}
+
+func SplitList(value string) []string {
+	list := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+
+	return list
+}
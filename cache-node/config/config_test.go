@@ -0,0 +1,321 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/limit"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Default(), config)
+	assert.Nil(t, config.Validate())
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+listen: 0.0.0.0:9000
+registry:
+  - http://registry-1:8081
+  - http://registry-2:8081
+cache:
+  capacity: 4096
+  eviction:
+    max_entries: 1000
+`)
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:9000", config.Listen)
+	assert.Equal(t, []string{"http://registry-1:8081", "http://registry-2:8081"}, config.Registry)
+	assert.Equal(t, uint32(4096), config.Cache.Capacity)
+	assert.Equal(t, 1000, config.Cache.Eviction.MaxEntries)
+	// unset fields keep their defaults
+	assert.Equal(t, Default().Cache.ResizeThreshold, config.Cache.ResizeThreshold)
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	path := writeConfig(t, "cache:\n  capasity: 4096\n")
+
+	_, err := Load(path)
+
+	assert.NotNil(t, err)
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "listen: 0.0.0.0:9000\n")
+	t.Setenv("CACHE_LISTEN", "0.0.0.0:9001")
+	t.Setenv("CACHE_REGISTRY", "http://a:8081, http://b:8081")
+	t.Setenv("CACHE_CAPACITY", "2048")
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:9001", config.Listen)
+	assert.Equal(t, []string{"http://a:8081", "http://b:8081"}, config.Registry)
+	assert.Equal(t, uint32(2048), config.Cache.Capacity)
+}
+
+func TestEnvParseError(t *testing.T) {
+	t.Setenv("CACHE_CAPACITY", "lots")
+
+	_, err := Load("")
+
+	assert.ErrorContains(t, err, "CACHE_CAPACITY")
+}
+
+func TestValidate(t *testing.T) {
+	config := Default()
+	config.Listen = "8080"
+	config.TCPListen = "9090"
+	config.GRPCListen = "9091"
+	config.RESPListen = "6379"
+	config.Registry = []string{"localhost:8081"}
+	config.Cache.Capacity = 1000
+	config.Cache.ResizeThreshold = 1.5
+	config.Cache.ResizeCoefficient = 1
+	config.TLS.CertFile = "cert.pem"
+	config.TLS.RequireClientCert = true
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "listen")
+	assert.ErrorContains(t, err, "tcp_listen")
+	assert.ErrorContains(t, err, "grpc_listen")
+	assert.ErrorContains(t, err, "resp_listen")
+	assert.ErrorContains(t, err, "registry url 'localhost:8081'")
+	assert.ErrorContains(t, err, "cache.capacity")
+	assert.ErrorContains(t, err, "cache.resize_threshold")
+	assert.ErrorContains(t, err, "cache.resize_coefficient")
+	assert.ErrorContains(t, err, "tls.cert_file")
+	assert.ErrorContains(t, err, "tls.require_client_cert")
+}
+
+func TestLoadAuth(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  node_token: node-secret
+  credentials:
+    - name: sessions
+      key: session-secret
+      rules:
+        - prefix: "session:"
+          ops: [read, write]
+`)
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, "node-secret", config.Auth.NodeToken)
+	assert.Nil(t, config.ACL().Authorize("session-secret", "write", "", "session:1"))
+	assert.NotNil(t, config.ACL().Authorize("session-secret", "delete", "", "session:1"))
+}
+
+func TestLoadPubSubAuth(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  credentials:
+    - name: events
+      key: events-secret
+      rules:
+        - prefix: "orders."
+          ops: [publish, subscribe]
+`)
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Nil(t, config.ACL().Authorize("events-secret", "publish", "", "orders.created"))
+	assert.Nil(t, config.ACL().Authorize("events-secret", "subscribe", "", "orders.created"))
+	assert.NotNil(t, config.ACL().Authorize("events-secret", "read", "", "orders.created"))
+}
+
+func TestNoCredentialsLeavesNodeOpen(t *testing.T) {
+	assert.Nil(t, Default().ACL())
+}
+
+func TestValidateAuth(t *testing.T) {
+	config := Default()
+	config.Auth.Credentials = []Credential{
+		{Name: "a", Key: "same", Rules: []Rule{{Ops: []string{"read"}}}},
+		{Name: "a", Key: "same", Rules: []Rule{{Ops: []string{"everything"}}}},
+		{Name: "b"},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "name 'a' is used twice")
+	assert.ErrorContains(t, err, "reuses another credential's key")
+	assert.ErrorContains(t, err, "unknown op 'everything'")
+	assert.ErrorContains(t, err, "'b' needs a key")
+	assert.ErrorContains(t, err, "'b' needs at least one rule")
+}
+
+func TestLoadNamespaces(t *testing.T) {
+	path := writeConfig(t, `
+cache:
+  eviction:
+    max_bytes: 1048576
+  namespaces:
+    sessions:
+      max_entries: 100
+    images:
+      max_bytes: 4096
+`)
+	t.Setenv("CACHE_MAX_BYTES", "2097152")
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, data.Quota{MaxBytes: 2097152}, config.DefaultQuota())
+	assert.Equal(t, map[string]data.Quota{
+		"sessions": {MaxEntries: 100},
+		"images":   {MaxBytes: 4096},
+	}, config.Quotas())
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	config := Default()
+	config.Cache.Eviction.MaxBytes = -1
+	config.Cache.Namespaces = map[string]Quota{"bad name": {}, "ok": {MaxEntries: -1}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "cache.eviction.max_bytes")
+	assert.ErrorContains(t, err, "cache.namespaces 'bad name'")
+	assert.ErrorContains(t, err, "cache.namespaces 'ok' quotas can't be negative")
+}
+
+func TestLoadEarlyRefreshBeta(t *testing.T) {
+	path := writeConfig(t, `
+cache:
+  early_refresh_beta: 2
+`)
+
+	config, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, config.Cache.EarlyRefreshBeta)
+
+	t.Setenv("CACHE_EARLY_REFRESH_BETA", "0")
+	config, err = Load(path)
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, 0.0, config.Cache.EarlyRefreshBeta)
+
+	config.Cache.EarlyRefreshBeta = -1
+	assert.ErrorContains(t, config.Validate(), "cache.early_refresh_beta")
+}
+
+func TestLoadLimits(t *testing.T) {
+	path := writeConfig(t, `
+limits:
+  client:
+    rate: 100
+    burst: 200
+  namespaces:
+    sessions:
+      rate: 10
+  shed_queue_depth: 40
+`)
+	t.Setenv("CACHE_NAMESPACE_RATE", "50")
+	t.Setenv("CACHE_MAX_ENTRY_BYTES", "4096")
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, limit.Rate{PerSecond: 100, Burst: 200}, config.ClientRate())
+	namespaceRate, namespaceRates := config.NamespaceRates()
+	assert.Equal(t, limit.Rate{PerSecond: 50}, namespaceRate)
+	assert.Equal(t, map[string]limit.Rate{"sessions": {PerSecond: 10}}, namespaceRates)
+	assert.Equal(t, 4096, config.Limits.MaxEntryBytes)
+}
+
+func TestValidateLimits(t *testing.T) {
+	config := Default()
+	config.Limits.Client.Rate = -1
+	config.Limits.ShedQueueDepth = 1000
+	config.Limits.MaxEntryBytes = -1
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "limits.client")
+	assert.ErrorContains(t, err, "limits.shed_queue_depth")
+	assert.ErrorContains(t, err, "limits.max_entry_bytes")
+}
+
+func TestLoadLoaders(t *testing.T) {
+	path := writeConfig(t, `
+loaders:
+  - pattern: "user:*"
+    url: http://users.internal/users/{key}
+    ttl: 5m
+    soft_ttl: 1m
+`)
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, []Loader{{Pattern: "user:*", Url: "http://users.internal/users/{key}", TTL: 5 * time.Minute, SoftTTL: time.Minute}}, config.Loaders)
+}
+
+func TestValidateLoaders(t *testing.T) {
+	config := Default()
+	config.Loaders = []Loader{{Namespace: "bad name", Url: "users.internal", TTL: -1}, {Pattern: "*", Url: "http://users.internal", TTL: time.Minute, SoftTTL: time.Minute}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "loaders[0]: namespace")
+	assert.ErrorContains(t, err, "loaders[0] needs a pattern")
+	assert.ErrorContains(t, err, "loaders[0] url")
+	assert.ErrorContains(t, err, "loaders[0] ttl")
+	assert.ErrorContains(t, err, "loaders[1] soft_ttl")
+}
+
+func TestLoadStore(t *testing.T) {
+	path := writeConfig(t, `
+store:
+  path: /var/lib/cache/store
+  mode: write-behind
+  flush_interval: 500ms
+`)
+	t.Setenv("CACHE_STORE_DEAD_LETTER", "/var/lib/cache/dead")
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, store.Options{Mode: store.WRITE_BEHIND, FlushInterval: 500 * time.Millisecond, DeadLetter: "/var/lib/cache/dead"}, config.StoreOptions())
+}
+
+func TestValidateStore(t *testing.T) {
+	config := Default()
+	config.Store = Store{Mode: "write-around", BatchSize: -1, DeadLetter: "dead"}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "store.mode must be write-through or write-behind")
+	assert.ErrorContains(t, err, "store settings can't be negative")
+	assert.ErrorContains(t, err, "store.dead_letter needs store.path")
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	// changed, but only read at startup
+	RestartRequired []string `json:"restart_required"`
+}
+
+// re-reads the config and applies the settings that are safe to change while the node runs
+type Reloader struct {
+	current Config
+	load    func() (Config, error)
+	apply   func(Config)
+	mux     sync.Mutex
+}
+
+func NewReloader(current Config, load func() (Config, error), apply func(Config)) *Reloader {
+	return &Reloader{
+		current: current,
+		load:    load,
+		apply:   apply,
+	}
+}
+
+// an invalid config is rejected whole and the running one is left in place
+func (r *Reloader) Reload() (ReloadResult, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	if err := next.Validate(); err != nil {
+		return ReloadResult{}, err
+	}
+
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, field := range Changes(r.current, next) {
+		if isReloadable(field) {
+			result.Applied = append(result.Applied, field)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, field)
+		}
+	}
+
+	r.current.LogLevel = next.LogLevel
+	r.current.Cache.Eviction = next.Cache.Eviction
+	r.current.Cache.Namespaces = next.Cache.Namespaces
+	r.current.Limits = next.Limits
+	r.current.Auth.Credentials = next.Auth.Credentials
+	r.apply(r.current)
+
+	return result, nil
+}
+
+func isReloadable(field string) bool {
+	switch field {
+	case "log_level", "cache.eviction.max_entries", "cache.eviction.max_bytes", "cache.namespaces", "auth.credentials":
+		return true
+	default:
+		return strings.HasPrefix(field, "limits.")
+	}
+}
+
+// the yaml names of every setting that differs
+func Changes(before Config, after Config) []string {
+	return changes("", reflect.ValueOf(before), reflect.ValueOf(after))
+}
+
+func changes(prefix string, before reflect.Value, after reflect.Value) []string {
+	fields := []string{}
+	for i := 0; i < before.NumField(); i++ {
+		field := before.Type().Field(i)
+		name := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, changes(name+".", before.Field(i), after.Field(i))...)
+		} else if !reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	before := Default()
+	after := Default()
+	after.LogLevel = "debug"
+	after.Cache.Eviction.MaxEntries = 100
+	after.Registry = []string{"http://other:8081"}
+
+	assert.Equal(t, []string{"log_level", "registry", "cache.eviction.max_entries"}, Changes(before, after))
+	assert.Empty(t, Changes(before, before))
+}
+
+func TestReload(t *testing.T) {
+	next := Default()
+	next.LogLevel = "debug"
+	next.Cache.Eviction.MaxEntries = 100
+	next.Listen = "localhost:9000"
+
+	var applied Config
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { applied = c })
+
+	result, err := reloader.Reload()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"log_level", "cache.eviction.max_entries"}, result.Applied)
+	assert.Equal(t, []string{"listen"}, result.RestartRequired)
+	assert.Equal(t, "debug", applied.LogLevel)
+	assert.Equal(t, 100, applied.Cache.Eviction.MaxEntries)
+	// settings that need a restart keep their running value
+	assert.Equal(t, DEFAULT_LISTEN, applied.Listen)
+}
+
+func TestReloadInvalid(t *testing.T) {
+	next := Default()
+	next.LogLevel = "loud"
+
+	called := false
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { called = true })
+
+	_, err := reloader.Reload()
+
+	assert.ErrorContains(t, err, "log_level")
+	assert.False(t, called)
+}
+
+func TestReloadReportsOnlyNewChanges(t *testing.T) {
+	next := Default()
+	next.LogLevel = "warn"
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) {})
+
+	reloader.Reload()
+	result, err := reloader.Reload()
+
+	assert.Nil(t, err)
+	assert.Empty(t, result.Applied)
+}
+
+func TestReloadQuotas(t *testing.T) {
+	next := Default()
+	next.Cache.Eviction.MaxBytes = 1 << 20
+	next.Cache.Namespaces = map[string]Quota{"sessions": {MaxEntries: 10}}
+
+	var applied Config
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { applied = c })
+
+	result, err := reloader.Reload()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cache.eviction.max_bytes", "cache.namespaces"}, result.Applied)
+	assert.Empty(t, result.RestartRequired)
+	assert.Equal(t, next.Cache.Namespaces, applied.Cache.Namespaces)
+}
+
+func TestReloadLimits(t *testing.T) {
+	next := Default()
+	next.Limits.Client.Rate = 10
+	next.Limits.ShedQueueDepth = 40
+
+	var applied Config
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { applied = c })
+
+	result, err := reloader.Reload()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"limits.client.rate", "limits.shed_queue_depth"}, result.Applied)
+	assert.Equal(t, next.Limits, applied.Limits)
+}
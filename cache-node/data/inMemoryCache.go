@@ -2,17 +2,23 @@ package data
 
 import (
 	"bytes"
+	"container/list"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"sync"
 )
 
+var ErrEntryTooLarge = fmt.Errorf("entry is larger than the memory quota")
+
 type cacheEntry[K comparable, V any] struct {
 	Key              K
 	Val              V
 	InitialHashIndex uint32
 	Deleted          bool
+	recency          *list.Element
+	size             int
 }
 
 type InMemoryCache[K comparable, V any] struct {
@@ -21,13 +27,39 @@ type InMemoryCache[K comparable, V any] struct {
 	capacity          uint32
 	resizeThreshold   float32
 	resizeCoefficient uint32
-	mux               sync.RWMutex
+	maxEntries        int
+	maxBytes          int
+	// the memory an entry is charged against maxBytes
+	sizeOf func(key K, val V) int
+	bytes  int
+	// the tags an entry is indexed under, nil leaves tags unindexed
+	tagsOf func(val V) []string
+	// tag to the live keys carrying it
+	tags map[string]map[K]struct{}
+	// deleted entries still take up a slot until the next resize
+	deleted int
+	// most recently used at the front
+	recency *list.List
+	stats   Stats
+	mux     sync.RWMutex
 }
 
 type Options struct {
 	Capacity          uint32
 	ResizeThreshold   float32
 	ResizeCoefficient uint32
+	// least recently used entries are evicted past these, 0 means no limit
+	MaxEntries int
+	// only enforced for caches that know how to size their entries, see Namespaces
+	MaxBytes int
+}
+
+type Stats struct {
+	Entries   int
+	Bytes     int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
 }
 
 const DefaultCapacity = 1024
@@ -62,6 +94,11 @@ func buildCache[K comparable, V any](options Options) *InMemoryCache[K, V] {
 		capacity:          options.Capacity,
 		resizeThreshold:   options.ResizeThreshold,
 		resizeCoefficient: options.ResizeCoefficient,
+		maxEntries:        options.MaxEntries,
+		maxBytes:          options.MaxBytes,
+		sizeOf:            func(K, V) int { return 0 },
+		tags:              make(map[string]map[K]struct{}),
+		recency:           list.New(),
 	}
 }
 
@@ -74,37 +111,53 @@ func (c *InMemoryCache[K, V]) Insert(key K, val V) error {
 		return err
 	}
 
-	// find the next open spot in the cache
-	initialHashIndex := index
-	index = c.findNextEmptySpotInCache(key, index)
-	entry := c.cache[index]
+	size := c.sizeOf(key, val)
+	if c.tooLarge(size) {
+		return ErrEntryTooLarge
+	}
 
-	if entry != nil {
-		c.updateCacheEntry(entry, val)
-	} else {
-		c.insertNewCacheEntry(index, c.createNewCacheEntry(key, val, initialHashIndex))
+	// update the live entry if there is one
+	if entry := c.findValueInCache(key, index); entry != nil {
+		c.updateCacheEntry(entry, val, size)
+		for c.overMaxBytes(0) {
+			c.evictLeastRecentlyUsed()
+		}
+		return nil
 	}
 
+	// a lowered limit can leave several entries to evict
+	for c.atMaxEntries() || c.overMaxBytes(size) {
+		c.evictLeastRecentlyUsed()
+	}
+
+	// find the next open spot in the cache
+	initialHashIndex := index
+	index = c.findNextEmptySpotInCache(index)
+	c.insertNewCacheEntry(index, c.createNewCacheEntry(key, val, initialHashIndex, size))
+
 	return nil
 }
 
 func (c *InMemoryCache[K, V]) checkCacheSize() {
-	if c.Size/int(c.capacity) >= int(c.resizeThreshold) {
-		c.increaseCacheSize()
+	if float32(c.Size)/float32(c.capacity) >= c.resizeThreshold {
+		c.rebuild(c.resizeCoefficient * c.capacity)
+	} else if float32(c.Size+c.deleted)/float32(c.capacity) >= c.resizeThreshold {
+		// mostly deleted entries, clearing them out is enough
+		c.rebuild(c.capacity)
 	}
 }
 
-func (c *InMemoryCache[K, V]) increaseCacheSize() {
-	newCache := c.createLargerCache()
-	newCache = c.copyValuesToLargerCache(newCache)
-	c.setCache(newCache)
+func (c *InMemoryCache[K, V]) rebuild(capacity uint32) {
+	newCache := c.createLargerCache(capacity)
+	newCache = c.copyValuesToLargerCache(newCache, capacity)
+	c.setCache(newCache, capacity)
 }
 
-func (c *InMemoryCache[K, V]) createLargerCache() []*cacheEntry[K, V] {
-	return make([]*cacheEntry[K, V], c.resizeCoefficient*c.capacity)
+func (c *InMemoryCache[K, V]) createLargerCache(capacity uint32) []*cacheEntry[K, V] {
+	return make([]*cacheEntry[K, V], capacity)
 }
 
-func (c *InMemoryCache[K, V]) copyValuesToLargerCache(newCache []*cacheEntry[K, V]) []*cacheEntry[K, V] {
+func (c *InMemoryCache[K, V]) copyValuesToLargerCache(newCache []*cacheEntry[K, V], capacity uint32) []*cacheEntry[K, V] {
 	c.mux.RLock()
 	defer c.mux.RUnlock()
 	// add the old values to the new cache
@@ -114,12 +167,15 @@ func (c *InMemoryCache[K, V]) copyValuesToLargerCache(newCache []*cacheEntry[K,
 			continue
 		}
 
-		index := oldCacheEntry.InitialHashIndex
+		// the hash index depends on the capacity, so it moves with the resize.
+		// the key was hashed on insert so this can't fail
+		index, _ := c.hashWithCapacity(oldCacheEntry.Key, capacity)
+		oldCacheEntry.InitialHashIndex = index
 
 		// find the location in the new cache
 		var x uint32 = 1
 		for entry := newCache[index]; entry != nil; entry = newCache[index] {
-			index = (index + c.probing(x)) % c.capacity
+			index = (index + c.probing(x)) % capacity
 			x += 1
 		}
 
@@ -129,17 +185,20 @@ func (c *InMemoryCache[K, V]) copyValuesToLargerCache(newCache []*cacheEntry[K,
 	return newCache
 }
 
-func (c *InMemoryCache[K, V]) setCache(newCache []*cacheEntry[K, V]) {
+func (c *InMemoryCache[K, V]) setCache(newCache []*cacheEntry[K, V], capacity uint32) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 	c.cache = newCache
+	c.capacity = capacity
+	c.deleted = 0
 }
 
-func (c *InMemoryCache[K, V]) findNextEmptySpotInCache(key K, index uint32) uint32 {
+func (c *InMemoryCache[K, V]) findNextEmptySpotInCache(index uint32) uint32 {
 	var x uint32 = 1
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	for entry := c.cache[index]; entry != nil && entry.Key != key && !entry.Deleted; entry = c.cache[index] {
+	// deleted entries can be reused
+	for entry := c.cache[index]; entry != nil && !entry.Deleted; entry = c.cache[index] {
 		c.checkCapacity(x)
 
 		// find the next index
@@ -150,28 +209,39 @@ func (c *InMemoryCache[K, V]) findNextEmptySpotInCache(key K, index uint32) uint
 	return index
 }
 
-func (c *InMemoryCache[K, V]) updateCacheEntry(entry *cacheEntry[K, V], val V) {
+func (c *InMemoryCache[K, V]) updateCacheEntry(entry *cacheEntry[K, V], val V, size int) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 	// update the existing entry
+	c.unindex(entry)
 	entry.Val = val
-	entry.Deleted = false
+	c.index(entry)
+	c.bytes += size - entry.size
+	entry.size = size
+	c.recency.MoveToFront(entry.recency)
 }
 
 func (c *InMemoryCache[K, V]) insertNewCacheEntry(index uint32, entry *cacheEntry[K, V]) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	// insert the new entry
+	// insert the new entry, reusing a deleted one's slot
+	if c.cache[index] != nil {
+		c.deleted -= 1
+	}
 	c.cache[index] = entry
+	c.index(entry)
+	entry.recency = c.recency.PushFront(entry)
 	c.Size += 1
+	c.bytes += entry.size
 }
 
-func (c *InMemoryCache[K, V]) createNewCacheEntry(key K, val V, initialHashIndex uint32) *cacheEntry[K, V] {
+func (c *InMemoryCache[K, V]) createNewCacheEntry(key K, val V, initialHashIndex uint32, size int) *cacheEntry[K, V] {
 	return &cacheEntry[K, V]{
 		Key:              key,
 		Val:              val,
 		InitialHashIndex: initialHashIndex,
 		Deleted:          false,
+		size:             size,
 	}
 }
 
@@ -185,11 +255,29 @@ func (c *InMemoryCache[K, V]) Read(key K) (V, bool) {
 	entry := c.findValueInCache(key, index)
 
 	if entry != nil {
+		c.touch(entry)
 		return entry.Val, true
 	} else {
+		c.countMiss()
+		var noop V
+		return noop, false
+	}
+}
+
+// like Read, but leaves the entry's recency and the hit and miss counts alone
+func (c *InMemoryCache[K, V]) Peek(key K) (V, bool) {
+	index, err := c.hash(key)
+	if err != nil {
+		panic(err)
+	}
+
+	entry := c.findValueInCache(key, index)
+	if entry == nil {
 		var noop V
 		return noop, false
 	}
+
+	return entry.Val, true
 }
 
 func (c *InMemoryCache[K, V]) Remove(key K) error {
@@ -214,7 +302,8 @@ func (c *InMemoryCache[K, V]) findValueInCache(key K, index uint32) *cacheEntry[
 	c.mux.RLock()
 	defer c.mux.RUnlock()
 
-	for entry := c.cache[index]; entry == nil || entry.Key != key; entry = c.cache[index] {
+	// deleted entries are skipped, they can sit in front of the live one
+	for entry := c.cache[index]; entry == nil || entry.Key != key || entry.Deleted; entry = c.cache[index] {
 		c.checkCapacity(x)
 
 		// found a nil entry before the key
@@ -230,11 +319,140 @@ func (c *InMemoryCache[K, V]) findValueInCache(key K, index uint32) *cacheEntry[
 	return c.cache[index]
 }
 
+func (c *InMemoryCache[K, V]) Keys() []K {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	keys := make([]K, 0, c.Size)
+	for _, entry := range c.cache {
+		if entry == nil || entry.Deleted {
+			continue
+		}
+		keys = append(keys, entry.Key)
+	}
+
+	return keys
+}
+
+// the live keys whose entries carry tag
+func (c *InMemoryCache[K, V]) Tagged(tag string) []K {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	keys := make([]K, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// callers hold the lock
+func (c *InMemoryCache[K, V]) index(entry *cacheEntry[K, V]) {
+	if c.tagsOf == nil {
+		return
+	}
+	for _, tag := range c.tagsOf(entry.Val) {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			c.tags[tag] = keys
+		}
+		keys[entry.Key] = struct{}{}
+	}
+}
+
+func (c *InMemoryCache[K, V]) unindex(entry *cacheEntry[K, V]) {
+	if c.tagsOf == nil {
+		return
+	}
+	for _, tag := range c.tagsOf(entry.Val) {
+		delete(c.tags[tag], entry.Key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
 func (c *InMemoryCache[K, V]) deleteEntry(entry *cacheEntry[K, V]) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 	entry.Deleted = true
+	c.unindex(entry)
+	c.recency.Remove(entry.recency)
 	c.Size -= 1
+	c.bytes -= entry.size
+	c.deleted += 1
+}
+
+// takes effect on the next insert, entries aren't evicted until then
+func (c *InMemoryCache[K, V]) SetMaxEntries(maxEntries int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.maxEntries = maxEntries
+}
+
+// takes effect on the next insert, like SetMaxEntries
+func (c *InMemoryCache[K, V]) SetMaxBytes(maxBytes int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.maxBytes = maxBytes
+}
+
+func (c *InMemoryCache[K, V]) tooLarge(size int) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.maxBytes > 0 && size > c.maxBytes
+}
+
+// true when adding size more bytes would go past the quota
+func (c *InMemoryCache[K, V]) overMaxBytes(size int) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.maxBytes > 0 && c.Size > 0 && c.bytes+size > c.maxBytes
+}
+
+func (c *InMemoryCache[K, V]) Stats() Stats {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	stats := c.stats
+	stats.Entries = c.Size
+	stats.Bytes = c.bytes
+	return stats
+}
+
+func (c *InMemoryCache[K, V]) countMiss() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.stats.Misses += 1
+}
+
+func (c *InMemoryCache[K, V]) atMaxEntries() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.maxEntries > 0 && c.Size >= c.maxEntries
+}
+
+// counts the hit as well
+func (c *InMemoryCache[K, V]) touch(entry *cacheEntry[K, V]) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.recency.MoveToFront(entry.recency)
+	c.stats.Hits += 1
+}
+
+func (c *InMemoryCache[K, V]) evictLeastRecentlyUsed() {
+	c.mux.RLock()
+	oldest := c.recency.Back()
+	c.mux.RUnlock()
+
+	if oldest != nil {
+		c.deleteEntry(oldest.Value.(*cacheEntry[K, V]))
+		c.mux.Lock()
+		c.stats.Evictions += 1
+		c.mux.Unlock()
+	}
 }
 
 func (c *InMemoryCache[K, V]) checkCapacity(x uint32) {
@@ -256,6 +474,10 @@ func (c *InMemoryCache[K, V]) encode(key K) ([]byte, error) {
 }
 
 func (c *InMemoryCache[K, V]) hash(key K) (uint32, error) {
+	return c.hashWithCapacity(key, c.capacity)
+}
+
+func (c *InMemoryCache[K, V]) hashWithCapacity(key K, capacity uint32) (uint32, error) {
 	encoded, err := c.encode(key)
 	if err != nil {
 		return 0, err
@@ -264,7 +486,7 @@ func (c *InMemoryCache[K, V]) hash(key K) (uint32, error) {
 	h := sha256.New()
 	h.Write(encoded)
 	hash := h.Sum(nil)
-	index := binary.BigEndian.Uint32(hash) % c.capacity
+	index := binary.BigEndian.Uint32(hash) % capacity
 	h.Reset() // don't know if this is needed
 
 	return index, nil
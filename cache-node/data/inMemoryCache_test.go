@@ -2,6 +2,8 @@ package data
 
 import (
 	"math/rand"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -71,6 +73,24 @@ func TestRead(t *testing.T) {
 	}
 }
 
+func TestPeekLeavesStatsAlone(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{})
+	if err := cache.Insert(123, "hello world"); err != nil {
+		t.Fatalf("TestPeekLeavesStatsAlone: failed on insert with err: %s\n", err)
+	}
+
+	if val, ok := cache.Peek(123); !ok || val != "hello world" {
+		t.Fatalf("TestPeekLeavesStatsAlone: peek returned (%s, %t)\n", val, ok)
+	}
+	if _, ok := cache.Peek(234); ok {
+		t.Fatalf("TestPeekLeavesStatsAlone: peek found a missing key\n")
+	}
+
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("TestPeekLeavesStatsAlone: peeks counted %d hits and %d misses\n", stats.Hits, stats.Misses)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	cache := NewInMemoryCache[int, string](Options{})
 
@@ -97,3 +117,216 @@ func TestDelete(t *testing.T) {
 	}
 
 }
+
+func TestReadAfterDelete(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{})
+
+	if err := cache.Insert(123, "hello world"); err != nil {
+		t.Fatalf("TestReadAfterDelete: failed on insert with err: %s\n", err)
+	}
+	if err := cache.Remove(123); err != nil {
+		t.Fatalf("TestReadAfterDelete: failed on remove with err: %s\n", err)
+	}
+
+	if _, ok := cache.Read(123); ok {
+		t.Fatal("TestReadAfterDelete: read a deleted value")
+	}
+
+	if err := cache.Remove(123); err != nil {
+		t.Fatalf("TestReadAfterDelete: failed on second remove with err: %s\n", err)
+	}
+	if cache.Size != 0 {
+		t.Fatalf("TestReadAfterDelete: cache size (%d) != 0 after removing twice\n", cache.Size)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{})
+
+	kvs := make(map[int]string)
+	kvs[123] = "hello world 1"
+	kvs[234] = "hello world 2"
+	kvs[345] = "hello world 3"
+
+	for k, v := range kvs {
+		if err := cache.Insert(k, v); err != nil {
+			t.Fatalf("TestKeys: failed on insert with err: %s\n", err)
+		}
+	}
+	if err := cache.Remove(234); err != nil {
+		t.Fatalf("TestKeys: failed on remove with err: %s\n", err)
+	}
+
+	keys := cache.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("TestKeys: expected 2 keys, got %d\n", len(keys))
+	}
+	for _, k := range keys {
+		if k == 234 {
+			t.Fatal("TestKeys: deleted key returned")
+		}
+	}
+}
+
+func TestInsertPastCapacityResizes(t *testing.T) {
+	cache := NewInMemoryCache[int, int](Options{Capacity: 8})
+
+	for i := 0; i < 100; i++ {
+		if err := cache.Insert(i, i); err != nil {
+			t.Fatalf("TestInsertPastCapacityResizes: failed on insert with err: %s\n", err)
+		}
+	}
+
+	if cache.capacity <= 100 {
+		t.Fatalf("TestInsertPastCapacityResizes: capacity (%d) didn't grow\n", cache.capacity)
+	}
+	for i := 0; i < 100; i++ {
+		val, ok := cache.Read(i)
+		if !ok || val != i {
+			t.Fatalf("TestInsertPastCapacityResizes: lost key %d after resize\n", i)
+		}
+	}
+}
+
+func TestResizeWaitsForThreshold(t *testing.T) {
+	cache := NewInMemoryCache[int, int](Options{Capacity: 16, ResizeThreshold: 0.5})
+
+	for i := 0; i < 8; i++ {
+		cache.Insert(i, i)
+	}
+	if cache.capacity != 16 {
+		t.Fatalf("TestResizeWaitsForThreshold: resized early to %d\n", cache.capacity)
+	}
+
+	cache.Insert(8, 8)
+	if cache.capacity != 32 {
+		t.Fatalf("TestResizeWaitsForThreshold: capacity (%d) != 32 past the threshold\n", cache.capacity)
+	}
+}
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{MaxEntries: 2})
+
+	cache.Insert(1, "one")
+	cache.Insert(2, "two")
+	cache.Read(1)
+	cache.Insert(3, "three")
+
+	if cache.Size != 2 {
+		t.Fatalf("TestMaxEntriesEvictsLeastRecentlyUsed: cache size (%d) != 2\n", cache.Size)
+	}
+	if _, ok := cache.Read(2); ok {
+		t.Fatal("TestMaxEntriesEvictsLeastRecentlyUsed: least recently used key wasn't evicted")
+	}
+	if _, ok := cache.Read(1); !ok {
+		t.Fatal("TestMaxEntriesEvictsLeastRecentlyUsed: recently read key was evicted")
+	}
+}
+
+func TestUpdateDoesNotEvict(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{MaxEntries: 2})
+
+	cache.Insert(1, "one")
+	cache.Insert(2, "two")
+	cache.Insert(2, "two again")
+
+	if _, ok := cache.Read(1); !ok {
+		t.Fatal("TestUpdateDoesNotEvict: updating a key evicted another")
+	}
+}
+
+func TestSetMaxEntries(t *testing.T) {
+	cache := NewInMemoryCache[int, int](Options{})
+	for i := 0; i < 10; i++ {
+		cache.Insert(i, i)
+	}
+
+	cache.SetMaxEntries(4)
+	cache.Insert(10, 10)
+
+	if cache.Size != 4 {
+		t.Fatalf("TestSetMaxEntries: cache size (%d) != 4\n", cache.Size)
+	}
+	for i := 7; i <= 10; i++ {
+		if _, ok := cache.Read(i); !ok {
+			t.Fatalf("TestSetMaxEntries: most recent key %d was evicted\n", i)
+		}
+	}
+}
+
+func TestMaxBytesEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{MaxBytes: 10})
+	cache.sizeOf = func(_ int, val string) int { return len(val) }
+
+	cache.Insert(1, "aaaa")
+	cache.Insert(2, "bbbb")
+	cache.Insert(3, "cccc")
+
+	if stats := cache.Stats(); stats.Bytes != 8 || stats.Evictions != 1 {
+		t.Fatalf("TestMaxBytesEvictsLeastRecentlyUsed: unexpected stats %+v\n", stats)
+	}
+	if _, ok := cache.Read(1); ok {
+		t.Fatal("TestMaxBytesEvictsLeastRecentlyUsed: least recently used key wasn't evicted")
+	}
+	if err := cache.Insert(4, "too large to fit"); err != ErrEntryTooLarge {
+		t.Fatalf("TestMaxBytesEvictsLeastRecentlyUsed: err (%v) != ErrEntryTooLarge\n", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache := NewInMemoryCache[int, int](Options{})
+	cache.Insert(1, 1)
+	cache.Read(1)
+	cache.Read(2)
+
+	stats := cache.Stats()
+
+	if stats != (Stats{Entries: 1, Hits: 1, Misses: 1}) {
+		t.Fatalf("TestStats: unexpected stats %+v\n", stats)
+	}
+}
+
+func TestEvictionChurnReusesDeletedSlots(t *testing.T) {
+	cache := NewInMemoryCache[int, int](Options{Capacity: 8, MaxEntries: 2})
+
+	// without clearing out deleted entries the table fills up and lookups never hit an empty slot
+	for i := 0; i < 100; i++ {
+		cache.Insert(i, i)
+	}
+
+	if _, ok := cache.Read(1000); ok {
+		t.Fatal("TestEvictionChurnReusesDeletedSlots: read a key that was never inserted")
+	}
+	if cache.capacity != 8 {
+		t.Fatalf("TestEvictionChurnReusesDeletedSlots: capacity (%d) != 8\n", cache.capacity)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	cache := NewInMemoryCache[int, string](Options{MaxEntries: 3})
+	cache.tagsOf = func(val string) []string { return strings.Split(val, ",") }
+
+	cache.Insert(1, "red,blue")
+	cache.Insert(2, "red")
+	cache.Insert(3, "blue")
+	cache.Insert(2, "green")
+	cache.Remove(3)
+
+	if keys := cache.Tagged("red"); !slices.Equal(keys, []int{1}) {
+		t.Fatalf("TestTagged: red keys (%v) != [1]\n", keys)
+	}
+	if keys := cache.Tagged("blue"); !slices.Equal(keys, []int{1}) {
+		t.Fatalf("TestTagged: blue keys (%v) != [1]\n", keys)
+	}
+
+	cache.Insert(4, "green")
+	cache.Insert(5, "green")
+	keys := cache.Tagged("green")
+	slices.Sort(keys)
+	if !slices.Equal(keys, []int{2, 4, 5}) {
+		t.Fatalf("TestTagged: green keys (%v) != [2 4 5]\n", keys)
+	}
+	if _, ok := cache.tags["red"]; ok {
+		t.Fatal("TestTagged: evicted key left its tag behind")
+	}
+}
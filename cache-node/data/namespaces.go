@@ -0,0 +1,142 @@
+package data
+
+import (
+	"sort"
+	"sync"
+)
+
+// 0 means no limit, like the Options it overrides
+type Quota struct {
+	MaxEntries int
+	MaxBytes   int
+}
+
+// a separate cache per namespace, so quotas, eviction and stats don't leak between them
+type Namespaces[K comparable, V any] struct {
+	caches  map[string]*InMemoryCache[K, V]
+	options Options
+	quotas  map[string]Quota
+	sizeOf  func(key K, val V) int
+	tagsOf  func(val V) []string
+	mux     sync.RWMutex
+}
+
+// options.MaxEntries and options.MaxBytes are the quota for namespaces without their own
+func NewNamespaces[K comparable, V any](options Options, sizeOf func(key K, val V) int) *Namespaces[K, V] {
+	return &Namespaces[K, V]{
+		caches:  make(map[string]*InMemoryCache[K, V]),
+		options: options,
+		quotas:  make(map[string]Quota),
+		sizeOf:  sizeOf,
+	}
+}
+
+// the namespace's cache, created on first use
+func (n *Namespaces[K, V]) Get(namespace string) *InMemoryCache[K, V] {
+	if cache, ok := n.Lookup(namespace); ok {
+		return cache
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if cache, ok := n.caches[namespace]; ok {
+		return cache
+	}
+
+	options := n.options
+	quota := n.quota(namespace)
+	options.MaxEntries = quota.MaxEntries
+	options.MaxBytes = quota.MaxBytes
+
+	cache := NewInMemoryCache[K, V](options)
+	if n.sizeOf != nil {
+		cache.sizeOf = n.sizeOf
+	}
+	cache.tagsOf = n.tagsOf
+	n.caches[namespace] = cache
+
+	return cache
+}
+
+// indexes entries under the tags tagsOf returns so Tagged can find them,
+// must be called before the first namespace is created
+func (n *Namespaces[K, V]) IndexTags(tagsOf func(val V) []string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.tagsOf = tagsOf
+}
+
+// doesn't create the namespace, reads don't need one
+func (n *Namespaces[K, V]) Lookup(namespace string) (*InMemoryCache[K, V], bool) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	cache, ok := n.caches[namespace]
+	return cache, ok
+}
+
+// ErrEntryTooLarge when the namespace's cache would refuse the entry, without creating the namespace
+func (n *Namespaces[K, V]) Fits(namespace string, key K, val V) error {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	maxBytes := n.quota(namespace).MaxBytes
+	if maxBytes > 0 && n.sizeOf != nil && n.sizeOf(key, val) > maxBytes {
+		return ErrEntryTooLarge
+	}
+
+	return nil
+}
+
+func (n *Namespaces[K, V]) Names() []string {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	names := make([]string, 0, len(n.caches))
+	for name := range n.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (n *Namespaces[K, V]) Stats() map[string]Stats {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	stats := make(map[string]Stats, len(n.caches))
+	for name, cache := range n.caches {
+		stats[name] = cache.Stats()
+	}
+
+	return stats
+}
+
+// applies to existing namespaces as well, they evict down to the new quota on their next insert
+func (n *Namespaces[K, V]) SetQuotas(defaultQuota Quota, quotas map[string]Quota) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.options.MaxEntries = defaultQuota.MaxEntries
+	n.options.MaxBytes = defaultQuota.MaxBytes
+	n.quotas = make(map[string]Quota, len(quotas))
+	for name, quota := range quotas {
+		n.quotas[name] = quota
+	}
+
+	for name, cache := range n.caches {
+		quota := n.quota(name)
+		cache.SetMaxEntries(quota.MaxEntries)
+		cache.SetMaxBytes(quota.MaxBytes)
+	}
+}
+
+func (n *Namespaces[K, V]) quota(namespace string) Quota {
+	if quota, ok := n.quotas[namespace]; ok {
+		return quota
+	}
+
+	return Quota{MaxEntries: n.options.MaxEntries, MaxBytes: n.options.MaxBytes}
+}
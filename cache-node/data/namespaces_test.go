@@ -0,0 +1,70 @@
+package data
+
+import "testing"
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	namespaces := NewNamespaces[string, string](Options{}, nil)
+
+	namespaces.Get("a").Insert("key", "a")
+	namespaces.Get("b").Insert("key", "b")
+
+	if val, _ := namespaces.Get("a").Read("key"); val != "a" {
+		t.Fatalf("TestNamespacesAreIsolated: val (%s) != a\n", val)
+	}
+	if val, _ := namespaces.Get("b").Read("key"); val != "b" {
+		t.Fatalf("TestNamespacesAreIsolated: val (%s) != b\n", val)
+	}
+}
+
+func TestNamespacesFits(t *testing.T) {
+	sizeOf := func(_ string, val string) int { return len(val) }
+	namespaces := NewNamespaces[string, string](Options{}, sizeOf)
+	namespaces.SetQuotas(Quota{}, map[string]Quota{"small": {MaxBytes: 4}})
+
+	if err := namespaces.Fits("small", "key", "too long"); err != ErrEntryTooLarge {
+		t.Fatalf("TestNamespacesFits: err (%v) != ErrEntryTooLarge\n", err)
+	}
+	if err := namespaces.Fits("small", "key", "fits"); err != nil {
+		t.Fatalf("TestNamespacesFits: entry within the quota failed with %s\n", err)
+	}
+	if err := namespaces.Fits("other", "key", "too long"); err != nil {
+		t.Fatalf("TestNamespacesFits: namespace without a limit failed with %s\n", err)
+	}
+	if _, ok := namespaces.Lookup("small"); ok {
+		t.Fatal("TestNamespacesFits: checking created the namespace")
+	}
+}
+
+func TestNamespacesQuotas(t *testing.T) {
+	sizeOf := func(_ string, val string) int { return len(val) }
+	namespaces := NewNamespaces[string, string](Options{MaxEntries: 1}, sizeOf)
+	namespaces.SetQuotas(Quota{MaxEntries: 1}, map[string]Quota{"big": {MaxBytes: 8}})
+
+	namespaces.Get("small").Insert("1", "aaaa")
+	namespaces.Get("small").Insert("2", "bbbb")
+	namespaces.Get("big").Insert("1", "aaaa")
+	namespaces.Get("big").Insert("2", "bbbb")
+	namespaces.Get("big").Insert("3", "cccc")
+
+	stats := namespaces.Stats()
+	if stats["small"].Entries != 1 {
+		t.Fatalf("TestNamespacesQuotas: small entries (%d) != 1\n", stats["small"].Entries)
+	}
+	if stats["big"].Entries != 2 || stats["big"].Bytes != 8 {
+		t.Fatalf("TestNamespacesQuotas: unexpected big stats %+v\n", stats["big"])
+	}
+}
+
+func TestNamespacesSetQuotasAppliesToExisting(t *testing.T) {
+	namespaces := NewNamespaces[int, int](Options{}, nil)
+	for i := 0; i < 5; i++ {
+		namespaces.Get("a").Insert(i, i)
+	}
+
+	namespaces.SetQuotas(Quota{}, map[string]Quota{"a": {MaxEntries: 2}})
+	namespaces.Get("a").Insert(5, 5)
+
+	if size := namespaces.Get("a").Size; size != 2 {
+		t.Fatalf("TestNamespacesSetQuotasAppliesToExisting: size (%d) != 2\n", size)
+	}
+}
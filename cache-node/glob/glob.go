@@ -0,0 +1,115 @@
+package glob
+
+import "strings"
+
+// the special characters, a backslash makes the next character literal
+const META = `*?[\`
+
+// redis style patterns: * matches any run of characters, ? any one character, [abc], [a-z]
+// and [^a] a class of characters. an unterminated class matches its '[' literally
+func Match(pattern string, s string) bool {
+	// where to go back to when a later part fails after a *
+	star, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starS = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, s[i]); next > 0 {
+					if ok {
+						p = next
+						i++
+						continue
+					}
+					break
+				}
+				if s[i] == '[' {
+					p++
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+		starS++
+		p, i = star+1, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// returns where the class starting at p ends and whether c is in it, or 0 when it isn't terminated
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	p++
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+	for first := true; p < len(pattern); first = false {
+		if pattern[p] == ']' && !first {
+			return p + 1, matched != negate
+		}
+
+		lo := pattern[p]
+		if lo == '\\' && p+1 < len(pattern) {
+			p++
+			lo = pattern[p]
+		}
+		hi := lo
+		if p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']' {
+			hi = pattern[p+2]
+			p += 2
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		p++
+	}
+
+	return 0, false
+}
+
+// the part of the pattern before its first special character, every match starts with it
+func Literal(pattern string) string {
+	if i := strings.IndexAny(pattern, META); i >= 0 {
+		return pattern[:i]
+	}
+
+	return pattern
+}
+
+// whether the pattern matches anything other than itself
+func IsPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, META)
+}
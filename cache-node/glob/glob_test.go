@@ -0,0 +1,48 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"news.sports", "news.sports", true},
+		{"news.sports", "news.sport", false},
+		{"news.*", "news.sports", true},
+		{"news.*", "news.", true},
+		{"news.*", "weather", false},
+		{"*", "", true},
+		{"*.*.done", "a.b.c.done", true},
+		{"*.done", "a.b.c.don", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a[b", "a[b", true},
+		{"a/*", "a/b/c", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.match, Match(c.pattern, c.s), "%q against %q", c.pattern, c.s)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	assert.Equal(t, "news.", Literal("news.*"))
+	assert.Equal(t, "user:", Literal("user:[0-9]"))
+	assert.Equal(t, "exact", Literal("exact"))
+	assert.True(t, IsPattern("a?"))
+	assert.False(t, IsPattern("a.b"))
+}
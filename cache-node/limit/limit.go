@@ -0,0 +1,110 @@
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// buckets are only swept for idle ones past this many
+const PRUNE_THRESHOLD = 1024
+
+// PerSecond of 0 means no limit, Burst defaults to one second's worth
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// token buckets keyed by client or namespace, each key refills on its own
+type Limiter struct {
+	rate      Rate
+	overrides map[string]Rate
+	buckets   map[string]*bucket
+	now       func() time.Time
+	mux       sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(rate Rate, overrides map[string]Rate) *Limiter {
+	l := &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	l.SetRates(rate, overrides)
+
+	return l
+}
+
+// buckets keep their tokens, capped at the new burst
+func (l *Limiter) SetRates(rate Rate, overrides map[string]Rate) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.rate = rate
+	l.overrides = make(map[string]Rate, len(overrides))
+	for key, override := range overrides {
+		l.overrides[key] = override
+	}
+}
+
+// takes a token for key, otherwise reports how long until one is available
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	rate := l.rateFor(key)
+	if rate.PerSecond <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= PRUNE_THRESHOLD {
+			l.prune(now)
+		}
+		b = &bucket{tokens: rate.burst(), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(rate.burst(), b.tokens+now.Sub(b.last).Seconds()*rate.PerSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / rate.PerSecond
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *Limiter) rateFor(key string) Rate {
+	if rate, ok := l.overrides[key]; ok {
+		return rate
+	}
+
+	return l.rate
+}
+
+// a bucket that has refilled is the same as a new one
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		rate := l.rateFor(key)
+		if rate.PerSecond <= 0 || b.tokens+now.Sub(b.last).Seconds()*rate.PerSecond >= rate.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+
+	return math.Max(1, math.Ceil(r.PerSecond))
+}
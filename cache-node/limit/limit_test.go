@@ -0,0 +1,85 @@
+package limit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createLimiter(rate Rate, overrides map[string]Rate) (*Limiter, *time.Time) {
+	now := time.Unix(0, 0)
+	l := New(rate, overrides)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllowUpToBurst(t *testing.T) {
+	l, _ := createLimiter(Rate{PerSecond: 1, Burst: 3}, nil)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("client")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("client")
+
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+}
+
+func TestAllowRefills(t *testing.T) {
+	l, now := createLimiter(Rate{PerSecond: 2, Burst: 1}, nil)
+	l.Allow("client")
+
+	ok, wait := l.Allow("client")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	*now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("client")
+	assert.True(t, ok)
+}
+
+func TestKeysHaveSeparateBuckets(t *testing.T) {
+	l, _ := createLimiter(Rate{PerSecond: 1, Burst: 1}, nil)
+	l.Allow("a")
+
+	ok, _ := l.Allow("b")
+
+	assert.True(t, ok)
+}
+
+func TestNoLimit(t *testing.T) {
+	l, _ := createLimiter(Rate{}, map[string]Rate{"limited": {PerSecond: 1, Burst: 1}})
+
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("open")
+		assert.True(t, ok)
+	}
+	l.Allow("limited")
+	ok, _ := l.Allow("limited")
+	assert.False(t, ok)
+}
+
+func TestSetRates(t *testing.T) {
+	l, _ := createLimiter(Rate{PerSecond: 1, Burst: 1}, nil)
+	l.Allow("client")
+
+	l.SetRates(Rate{}, nil)
+	ok, _ := l.Allow("client")
+
+	assert.True(t, ok)
+}
+
+func TestPruneDropsRefilledBuckets(t *testing.T) {
+	l, now := createLimiter(Rate{PerSecond: 1, Burst: 1}, nil)
+	for i := 0; i < PRUNE_THRESHOLD; i++ {
+		l.Allow(fmt.Sprint(i))
+	}
+
+	*now = now.Add(time.Second)
+	l.Allow("new")
+
+	assert.Len(t, l.buckets, 1)
+}
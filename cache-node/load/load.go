@@ -0,0 +1,156 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/glob"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+// what callers sharing a call get when it panicked, the panic carries on in the caller that made it
+var ErrPanicked = fmt.Errorf("load panicked")
+
+// coalesces concurrent calls for the same key into one, the zero value is ready to use
+type Group[T any] struct {
+	calls map[string]*call[T]
+	mux   sync.Mutex
+}
+
+type call[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+	// callers waiting on it
+	dups int
+}
+
+// callers that arrive while fn runs for key wait for it and get its result, the next call
+// after it returns runs fn again
+func (g *Group[T]) Do(key string, fn func() (T, error)) (T, error) {
+	g.mux.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mux.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := g.add(key)
+	g.mux.Unlock()
+
+	g.run(key, c, fn)
+	return c.val, c.err
+}
+
+// runs fn for key in the background unless a call for key is already running, reports
+// whether it started one. callers of Do for key meanwhile wait for it
+func (g *Group[T]) Go(key string, fn func() (T, error)) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if _, ok := g.calls[key]; ok {
+		return false
+	}
+	c := g.add(key)
+	go g.run(key, c, fn)
+
+	return true
+}
+
+// must hold mux
+func (g *Group[T]) add(key string) *call[T] {
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	c := &call[T]{done: make(chan struct{}), err: ErrPanicked}
+	g.calls[key] = c
+
+	return c
+}
+
+func (g *Group[T]) run(key string, c *call[T], fn func() (T, error)) {
+	defer func() {
+		g.mux.Lock()
+		delete(g.calls, key)
+		g.mux.Unlock()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn()
+}
+
+// glob patterns with a value each, the zero value is ready to use
+type Patterns[T any] struct {
+	patterns []pattern[T]
+	mux      sync.RWMutex
+}
+
+type pattern[T any] struct {
+	pattern string
+	val     T
+}
+
+// adding a pattern again replaces its value
+func (p *Patterns[T]) Add(pat string, val T) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for i := range p.patterns {
+		if p.patterns[i].pattern == pat {
+			p.patterns[i].val = val
+			return
+		}
+	}
+	p.patterns = append(p.patterns, pattern[T]{pattern: pat, val: val})
+}
+
+// the first pattern added that matches key wins
+func (p *Patterns[T]) Match(key string) (T, bool) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	for _, pat := range p.patterns {
+		if glob.Match(pat.pattern, key) {
+			return pat.val, true
+		}
+	}
+
+	var noop T
+	return noop, false
+}
+
+// a loader that GETs urlTemplate with {namespace} and {key} filled in. the body and its
+// Content-Type are what's loaded, a 404 is loop.ErrNotFound
+func HTTP(client *http.Client, urlTemplate string) func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+	return func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		target := strings.NewReplacer("{namespace}", url.PathEscape(namespace), "{key}", url.PathEscape(key)).Replace(urlTemplate)
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return loop.Entry{}, err
+		}
+
+		resp, err := client.Do(r)
+		if err != nil {
+			return loop.Entry{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return loop.Entry{}, loop.ErrNotFound
+		}
+		if resp.StatusCode != http.StatusOK {
+			return loop.Entry{}, fmt.Errorf("unexpected status %d from '%s'", resp.StatusCode, target)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return loop.Entry{}, err
+		}
+
+		return loop.Entry{Value: body, ContentType: resp.Header.Get("Content-Type")}, nil
+	}
+}
@@ -0,0 +1,126 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCoalescesConcurrentCalls(t *testing.T) {
+	var g Group[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = g.Do("key", func() (int, error) {
+			close(started)
+			<-release
+			return int(calls.Add(1)), nil
+		})
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = g.Do("key", func() (int, error) { return int(calls.Add(1)), nil })
+		}()
+	}
+	for waiting(&g, "key") < len(results)-1 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	for _, result := range results {
+		assert.Equal(t, 1, result)
+	}
+
+	val, _ := g.Do("key", func() (int, error) { return 42, nil })
+	assert.Equal(t, 42, val)
+}
+
+func waiting(g *Group[int], key string) int {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.calls[key].dups
+}
+
+func TestGroupSharesErrors(t *testing.T) {
+	var g Group[int]
+	_, err := g.Do("key", func() (int, error) { return 0, fmt.Errorf("failed") })
+
+	assert.EqualError(t, err, "failed")
+}
+
+func TestGroupGoRunsOncePerKey(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+
+	assert.True(t, g.Go("key", func() (int, error) {
+		<-release
+		return 1, nil
+	}))
+	assert.False(t, g.Go("key", func() (int, error) { return 2, nil }))
+	close(release)
+	// Do joins the background call when it's still running
+	val, _ := g.Do("key", func() (int, error) { return 1, nil })
+	assert.Equal(t, 1, val)
+}
+
+func TestPatterns(t *testing.T) {
+	var p Patterns[string]
+	p.Add("user:*", "users")
+	p.Add("*", "everything")
+	p.Add("user:*", "replaced")
+
+	val, ok := p.Match("user:1")
+	assert.True(t, ok)
+	assert.Equal(t, "replaced", val)
+
+	val, _ = p.Match("session:1")
+	assert.Equal(t, "everything", val)
+
+	_, ok = (&Patterns[string]{}).Match("a")
+	assert.False(t, ok)
+}
+
+func TestHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/users/ns/a%2Fb":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name": "a"}`))
+		case "/users/ns/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+	loader := HTTP(origin.Client(), origin.URL+"/users/{namespace}/{key}")
+
+	entry, err := loader(context.Background(), "ns", "a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, loop.Entry{Value: loop.CacheEntry(`{"name": "a"}`), ContentType: "application/json"}, entry)
+
+	_, err = loader(context.Background(), "ns", "missing")
+	assert.ErrorIs(t, err, loop.ErrNotFound)
+
+	_, err = loader(context.Background(), "ns", "broken")
+	assert.ErrorContains(t, err, "unexpected status 502")
+}
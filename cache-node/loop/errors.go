@@ -0,0 +1,90 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+)
+
+// stable codes sent to clients, messages can change but these can't
+const (
+	BAD_REQUEST_CODE            = "bad_request"
+	UNAUTHENTICATED_CODE        = "unauthenticated"
+	FORBIDDEN_CODE              = "forbidden"
+	NOT_FOUND_CODE              = "not_found"
+	CONFLICT_CODE               = "conflict"
+	PRECONDITION_FAILED_CODE    = "precondition_failed"
+	TOO_LARGE_CODE              = "too_large"
+	UNSUPPORTED_MEDIA_TYPE_CODE = "unsupported_media_type"
+	RATE_LIMITED_CODE           = "rate_limited"
+	OVERLOADED_CODE             = "overloaded"
+	INTERNAL_CODE               = "internal"
+)
+
+var (
+	ErrBadRequest           = fmt.Errorf("bad request")
+	ErrUnauthenticated      = fmt.Errorf("unauthenticated")
+	ErrForbidden            = fmt.Errorf("forbidden")
+	ErrNotFound             = fmt.Errorf("not found")
+	ErrConflict             = fmt.Errorf("conflict")
+	ErrPreconditionFailed   = fmt.Errorf("precondition failed")
+	ErrTooLarge             = fmt.Errorf("too large")
+	ErrUnsupportedMediaType = fmt.Errorf("unsupported media type")
+	ErrRateLimited          = fmt.Errorf("rate limited")
+	ErrOverloaded           = fmt.Errorf("overloaded")
+	ErrInternal             = fmt.Errorf("internal error")
+)
+
+var sentinels = map[string]error{
+	BAD_REQUEST_CODE:            ErrBadRequest,
+	UNAUTHENTICATED_CODE:        ErrUnauthenticated,
+	FORBIDDEN_CODE:              ErrForbidden,
+	NOT_FOUND_CODE:              ErrNotFound,
+	CONFLICT_CODE:               ErrConflict,
+	PRECONDITION_FAILED_CODE:    ErrPreconditionFailed,
+	TOO_LARGE_CODE:              ErrTooLarge,
+	UNSUPPORTED_MEDIA_TYPE_CODE: ErrUnsupportedMediaType,
+	RATE_LIMITED_CODE:           ErrRateLimited,
+	OVERLOADED_CODE:             ErrOverloaded,
+	INTERNAL_CODE:               ErrInternal,
+}
+
+// matches both the sentinel for its code and the error it wraps
+type Error struct {
+	Code string
+	Err  error
+}
+
+func WithCode(code string, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// rebuilds an error sent by a node, unknown codes are treated as internal
+func ErrorFromCode(code string, message string) error {
+	if _, ok := sentinels[code]; !ok {
+		code = INTERNAL_CODE
+	}
+
+	return &Error{Code: code, Err: errors.New(message)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == sentinels[e.Code]
+}
+
+// the code of the first coded error in the chain
+func CodeOf(err error) (string, bool) {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code, true
+	}
+
+	return "", false
+}
@@ -1,15 +1,48 @@
 package loop
 
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
 const (
-	GET_EVENT_KEY    = "get"
-	SET_EVENT_KEY    = "set"
-	DELETE_EVENT_KEY = "delete"
+	GET_EVENT_KEY           = "get"
+	SET_EVENT_KEY           = "set"
+	DELETE_EVENT_KEY        = "delete"
+	KEYS_EVENT_KEY          = "keys"
+	SET_IF_ABSENT_EVENT_KEY = "setIfAbsent"
+	STATS_EVENT_KEY         = "stats"
+	INVALIDATE_EVENT_KEY    = "invalidate"
+	DELETE_KEYS_EVENT_KEY   = "deleteKeys"
+	REFRESH_EVENT_KEY       = "refresh"
+	PEEK_EVENT_KEY          = "peek"
 )
 
+var ErrVersionMismatch = WithCode(PRECONDITION_FAILED_CODE, fmt.Errorf("entry doesn't match the expected version"))
+
 type CacheEvent struct {
-	Type         string
-	Key          string
-	Val          CacheEntry
+	Type      string
+	Namespace string
+	Key       string
+	Val       CacheEntry
+	// only used by sets
+	ContentType string
+	Flags       uint32
+	Tags        []string
+	// 0 never expires
+	TTL time.Duration
+	// 0 never goes stale
+	SoftTTL time.Duration
+	// how long the value took to compute
+	Cost time.Duration
+	// only used by invalidations
+	Tag string
+	// only used by bulk deletes, which skip entries written after MaxVersion
+	Keys       []Key
+	MaxVersion uint64
+	// sets and deletes fail with ErrVersionMismatch when it doesn't hold
+	Precondition Precondition
 	ResponseChan chan CacheEventResponse
 	ErrorChan    chan error
 }
@@ -17,25 +50,110 @@ type CacheEvent struct {
 type CacheEventResponse struct {
 	Ok    bool
 	Value CacheEntry
+	// the entry that was read or written
+	ContentType string
+	Flags       uint32
+	Tags        []string
+	Version     uint64
+	ExpiresAt   time.Time
+	StaleAt     time.Time
+	Cost        time.Duration
+	// the entry read is past StaleAt
+	Stale bool
+	// the read was picked to refresh the entry before it goes stale or expires
+	Refresh bool
+	Keys    []Key
+	// how many keys an invalidation or bulk delete removed
+	Count int
+	Stats []NamespaceStats
+}
+
+// the zero value always holds
+type Precondition struct {
+	// the entry has to exist with one of these versions
+	Versions []uint64
+	// the entry has to exist
+	Exists bool
+	// the entry can't exist
+	Absent bool
+}
+
+func (p Precondition) holds(entry Entry, ok bool) bool {
+	if p.Absent && ok {
+		return false
+	}
+	if (p.Exists || len(p.Versions) > 0) && !ok {
+		return false
+	}
+	if len(p.Versions) > 0 && !slices.Contains(p.Versions, entry.Version) {
+		return false
+	}
+
+	return true
+}
+
+func (p Precondition) isSet() bool {
+	return p.Exists || p.Absent || len(p.Versions) > 0
+}
+
+func CreateGetEvent(namespace string, key string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(GET_EVENT_KEY, namespace, key, nil)
+}
+
+// a read for the node's own use, like migrating the key. it leaves stats, recency and early
+// refreshes alone
+func CreatePeekEvent(namespace string, key string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(PEEK_EVENT_KEY, namespace, key, nil)
+}
+
+func CreateSetEvent(namespace string, key string, value CacheEntry) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(SET_EVENT_KEY, namespace, key, value)
 }
 
-func CreateGetEvent(key string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
-	return newEvent(GET_EVENT_KEY, key, nil)
+func CreateDeleteEvent(namespace string, key string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(DELETE_EVENT_KEY, namespace, key, nil)
 }
 
-func CreateSetEvent(key string, value CacheEntry) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
-	return newEvent(SET_EVENT_KEY, key, value)
+func CreateKeysEvent() (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(KEYS_EVENT_KEY, "", "", nil)
 }
 
-func CreateDeleteEvent(key string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
-	return newEvent(DELETE_EVENT_KEY, key, nil)
+func CreateSetIfAbsentEvent(namespace string, key string, value CacheEntry) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(SET_IF_ABSENT_EVENT_KEY, namespace, key, value)
 }
 
-func newEvent(eventType string, key string, value CacheEntry) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+// replaces a stale entry with a fresh value, as long as it's still at version. Ok is false
+// when it was written or removed in the meantime
+func CreateRefreshEvent(namespace string, key string, value CacheEntry, version uint64) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	event, responseChan, errorChan = newEvent(REFRESH_EVENT_KEY, namespace, key, value)
+	event.Precondition = Precondition{Versions: []uint64{version}}
+	return event, responseChan, errorChan
+}
+
+func CreateInvalidateEvent(namespace string, tag string) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	event, responseChan, errorChan = newEvent(INVALIDATE_EVENT_KEY, namespace, "", nil)
+	event.Tag = tag
+	return event, responseChan, errorChan
+}
+
+// the keys can be in any namespace
+func CreateDeleteKeysEvent(keys []Key, maxVersion uint64) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	event, responseChan, errorChan = newEvent(DELETE_KEYS_EVENT_KEY, "", "", nil)
+	event.Keys = keys
+	event.MaxVersion = maxVersion
+	return event, responseChan, errorChan
+}
+
+func CreateStatsEvent() (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
+	return newEvent(STATS_EVENT_KEY, "", "", nil)
+}
+
+func newEvent(eventType string, namespace string, key string, value CacheEntry) (event *CacheEvent, responseChan chan CacheEventResponse, errorChan chan error) {
 	responseChan = make(chan CacheEventResponse, 1)
 	errorChan = make(chan error, 1)
 	event = &CacheEvent{
 		Type:         eventType,
+		Namespace:    namespace,
 		Key:          key,
 		Val:          value,
 		ResponseChan: responseChan,
@@ -52,6 +170,34 @@ func createEventResponse(ok bool, value CacheEntry) CacheEventResponse {
 	}
 }
 
+func createEntryResponse(ok bool, entry Entry) CacheEventResponse {
+	return CacheEventResponse{
+		Ok:          ok,
+		Value:       entry.Value,
+		ContentType: entry.ContentType,
+		Flags:       entry.Flags,
+		Tags:        entry.Tags,
+		Version:     entry.Version,
+		ExpiresAt:   entry.ExpiresAt,
+		StaleAt:     entry.StaleAt,
+		Cost:        entry.Cost,
+	}
+}
+
+// the value isn't sent back to the writer
+func createWriteResponse(entry Entry) CacheEventResponse {
+	return CacheEventResponse{
+		Ok:          true,
+		ContentType: entry.ContentType,
+		Flags:       entry.Flags,
+		Tags:        entry.Tags,
+		Version:     entry.Version,
+		ExpiresAt:   entry.ExpiresAt,
+		StaleAt:     entry.StaleAt,
+		Cost:        entry.Cost,
+	}
+}
+
 func (event *CacheEvent) sendResponse(resp CacheEventResponse) {
 	event.ResponseChan <- resp
 }
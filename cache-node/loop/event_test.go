@@ -2,6 +2,7 @@ package loop
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,20 +12,22 @@ func TestGetEvent(t *testing.T) {
 	expectedType := GET_EVENT_KEY
 	expectedKey := "key"
 
-	event, _, _ := CreateGetEvent(expectedKey)
+	event, _, _ := CreateGetEvent(DEFAULT_NAMESPACE, expectedKey)
 
 	assert.Equal(t, expectedType, event.Type)
+	assert.Equal(t, DEFAULT_NAMESPACE, event.Namespace)
 	assert.Equal(t, expectedKey, event.Key)
 }
 
 func TestSetEvent(t *testing.T) {
 	expectedType := SET_EVENT_KEY
 	expectedKey := "key"
-	var expectedValue CacheEntry = "value"
+	expectedValue := CacheEntry("value")
 
-	event, _, _ := CreateSetEvent(expectedKey, expectedValue)
+	event, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, expectedKey, expectedValue)
 
 	assert.Equal(t, expectedType, event.Type)
+	assert.Equal(t, DEFAULT_NAMESPACE, event.Namespace)
 	assert.Equal(t, expectedKey, event.Key)
 	assert.Equal(t, expectedValue, event.Val)
 }
@@ -33,14 +36,15 @@ func TestDeleteEvent(t *testing.T) {
 	expectedType := DELETE_EVENT_KEY
 	expectedKey := "key"
 
-	event, _, _ := CreateDeleteEvent(expectedKey)
+	event, _, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, expectedKey)
 
 	assert.Equal(t, expectedType, event.Type)
+	assert.Equal(t, DEFAULT_NAMESPACE, event.Namespace)
 	assert.Equal(t, expectedKey, event.Key)
 }
 
 func TestSendResponse(t *testing.T) {
-	event, respChan, errChan := newEvent(GET_EVENT_KEY, "test", "value")
+	event, respChan, errChan := newEvent(GET_EVENT_KEY, DEFAULT_NAMESPACE, "test", CacheEntry("value"))
 
 	event.sendResponse(CacheEventResponse{
 		Ok: true,
@@ -59,7 +63,7 @@ func TestSendResponse(t *testing.T) {
 }
 
 func TestSendError(t *testing.T) {
-	event, respChan, errChan := newEvent(GET_EVENT_KEY, "test", "value")
+	event, respChan, errChan := newEvent(GET_EVENT_KEY, DEFAULT_NAMESPACE, "test", CacheEntry("value"))
 
 	event.sendError(fmt.Errorf("test error"))
 
@@ -78,9 +82,36 @@ func TestSendError(t *testing.T) {
 }
 
 func TestCreateEventResponse(t *testing.T) {
-	var expectedVal CacheEntry = "test"
+	expectedVal := CacheEntry("test")
 	eventResp := createEventResponse(true, expectedVal)
 
 	assert.True(t, eventResp.Ok)
 	assert.Equal(t, expectedVal, eventResp.Value)
 }
+
+func TestValidateNamespace(t *testing.T) {
+	assert.Nil(t, ValidateNamespace("tenant-a.sessions_1"))
+	assert.ErrorIs(t, ValidateNamespace(""), ErrInvalidNamespace)
+	assert.ErrorIs(t, ValidateNamespace("a/b"), ErrInvalidNamespace)
+	assert.ErrorIs(t, ValidateNamespace(strings.Repeat("a", 65)), ErrInvalidNamespace)
+}
+
+func TestWithCodeMatchesSentinelAndCause(t *testing.T) {
+	err := WithCode(NOT_FOUND_CODE, ErrInvalidNamespace)
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, ErrInvalidNamespace)
+	assert.NotErrorIs(t, err, ErrConflict)
+
+	code, ok := CodeOf(fmt.Errorf("wrapped: %w", err))
+	assert.True(t, ok)
+	assert.Equal(t, NOT_FOUND_CODE, code)
+}
+
+func TestErrorFromCode(t *testing.T) {
+	err := ErrorFromCode(RATE_LIMITED_CODE, "slow down")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, "slow down", err.Error())
+
+	assert.ErrorIs(t, ErrorFromCode("made_up", "?"), ErrInternal)
+}
@@ -1,9 +1,102 @@
 package loop
 
-type CacheEntry any
+import "time"
+
+// opaque bytes, the cache never looks inside them
+type CacheEntry []byte
+
+// what the cache keeps for each key
+type Entry struct {
+	Value       CacheEntry
+	ContentType string
+	// for clients to tag values with, the cache doesn't use them
+	Flags uint32
+	// changes on every write, clients see it as the ETag
+	Version uint64
+	// invalidating a tag deletes every key carrying it
+	Tags []string
+	// the zero time never expires
+	ExpiresAt time.Time
+	// from here until ExpiresAt the entry is still served, marked stale, so it can be refreshed
+	// before it's gone. the zero time never goes stale
+	StaleAt time.Time
+	// how long the value took to compute, reads refresh costlier entries earlier. 0 never
+	// refreshes early
+	Cost time.Duration
+}
+
+// costs are kept and sent between nodes in whole milliseconds, the unit of the cost header.
+// rounded up so a cost under a millisecond still counts
+func CostMillis(cost time.Duration) int64 {
+	return int64((cost + time.Millisecond - 1) / time.Millisecond)
+}
+
+func CostOfMillis(millis int64) time.Duration {
+	return time.Duration(millis) * time.Millisecond
+}
+
+// keys are only unique within their namespace
+type Key struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+type NamespaceStats struct {
+	Namespace string `json:"namespace"`
+	Entries   int    `json:"entries"`
+	Bytes     int    `json:"bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+const (
+	SET_CHANGE    = "set"
+	DELETE_CHANGE = "delete"
+	EXPIRE_CHANGE = "expire"
+)
+
+// what happened to a key, numbered in the order the loop made the changes
+type Change struct {
+	Seq       uint64
+	Type      string
+	Namespace string
+	Key       string
+	// the entry that was written, empty for deletes and expiries
+	Entry Entry
+}
+
+// called from the loop, so it can't block
+type Publisher interface {
+	Publish(change Change)
+}
+
+// hands each change to every one of them in order
+type Publishers []Publisher
+
+func (publishers Publishers) Publish(change Change) {
+	for _, publisher := range publishers {
+		publisher.Publish(change)
+	}
+}
+
+// the system of record behind the cache, told about the sets and deletes clients make before
+// the cache changes. evictions, expiries and invalidations only touch the cache. called from
+// the loop, an error fails the write and leaves the cache as it was
+type BackingStore interface {
+	Write(change Change) error
+}
 
 type Cache interface {
-	Get(key string) (CacheEntry, bool)
-	Set(key string, val CacheEntry) error
-	Delete(key string) error
-}
\ No newline at end of file
+	Get(namespace string, key string) (Entry, bool)
+	// reads without counting a hit or miss or making the entry recently used
+	Peek(namespace string, key string) (Entry, bool)
+	Set(namespace string, key string, entry Entry) error
+	// the error Set would fail with, so a write the cache refuses never reaches the store
+	Fits(namespace string, key string, entry Entry) error
+	Delete(namespace string, key string) error
+	Keys() []Key
+	Stats() []NamespaceStats
+	// the keys in the namespace whose entries carry tag
+	Tagged(namespace string, tag string) []string
+}
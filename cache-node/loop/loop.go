@@ -1,8 +1,19 @@
 package loop
 
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
 const (
 	DEFAULT_EVENTS_CHANNEL_CAP = 50
 	DEFAULT_QUIT_CHANNEL_CAP   = 1
+	// how eagerly reads refresh entries early, higher is earlier and 0 turns it off
+	DEFAULT_EARLY_REFRESH_BETA = 1.0
+	// expired entries nobody reads are removed this often, a batch at a time
+	DEFAULT_EXPIRY_SWEEP_INTERVAL = time.Second
+	DEFAULT_EXPIRY_SWEEP_BATCH    = 1024
 
 	PROCESSED_EVENT_CODE = 0
 	KILL_CODE            = 1
@@ -12,6 +23,24 @@ type EventLoopImpl struct {
 	cache  Cache
 	events chan *CacheEvent
 	quit   chan int
+	// the version of the last write
+	version uint64
+	now     func() time.Time
+	// told about every change when set
+	publisher Publisher
+	// the sequence number of the last change
+	seq uint64
+	// written to on client sets and deletes when set
+	store BackingStore
+	beta  float64
+	// in (0, 1]
+	random func() float64
+	// 0 turns the sweep off
+	sweepInterval time.Duration
+	// ticks while Run is running
+	sweep <-chan time.Time
+	// what's left of the keys the current sweep is checking
+	sweepKeys []Key
 }
 
 func NewEventLoop(cache Cache) *EventLoopImpl {
@@ -19,18 +48,61 @@ func NewEventLoop(cache Cache) *EventLoopImpl {
 		cache:  cache,
 		events: make(chan *CacheEvent, DEFAULT_EVENTS_CHANNEL_CAP),
 		quit:   make(chan int, DEFAULT_QUIT_CHANNEL_CAP),
+		// starting from the clock keeps versions rising across restarts, so a client
+		// can't mistake a new entry for one it saw before
+		version: uint64(time.Now().UnixNano()),
+		// the same for changes, so a watcher resuming from before a restart finds out it missed
+		// some. microseconds keep sequence numbers exact as javascript numbers
+		seq:  uint64(time.Now().UnixMicro()),
+		now:  time.Now,
+		beta: DEFAULT_EARLY_REFRESH_BETA,
+		random: func() float64 {
+			return 1 - rand.Float64()
+		},
+		sweepInterval: DEFAULT_EXPIRY_SWEEP_INTERVAL,
 	}
 }
 
+// must be called before Run
+func (eventLoop *EventLoopImpl) SetEarlyRefreshBeta(beta float64) {
+	eventLoop.beta = beta
+}
+
+// must be called before Run
+func (eventLoop *EventLoopImpl) SetExpirySweepInterval(interval time.Duration) {
+	eventLoop.sweepInterval = interval
+}
+
+// must be called before Run
+func (eventLoop *EventLoopImpl) SetPublisher(publisher Publisher) {
+	eventLoop.publisher = publisher
+}
+
+// must be called before Run
+func (eventLoop *EventLoopImpl) SetBackingStore(store BackingStore) {
+	eventLoop.store = store
+}
+
 func (eventLoop *EventLoopImpl) Send(event *CacheEvent) {
 	eventLoop.events <- event
 }
 
+// events waiting to be handled
+func (eventLoop *EventLoopImpl) Pending() int {
+	return len(eventLoop.events)
+}
+
 func (eventLoop *EventLoopImpl) Stop() {
 	eventLoop.quit <- 1
 }
 
 func (eventLoop *EventLoopImpl) Run() {
+	if eventLoop.sweepInterval > 0 {
+		ticker := time.NewTicker(eventLoop.sweepInterval)
+		defer ticker.Stop()
+		eventLoop.sweep = ticker.C
+	}
+
 	for {
 		code := eventLoop.multiplexChannels()
 
@@ -45,11 +117,31 @@ func (eventLoop *EventLoopImpl) multiplexChannels() int {
 	case event := <-eventLoop.events:
 		eventLoop.handleEvent(event)
 		return PROCESSED_EVENT_CODE
+	case <-eventLoop.sweep:
+		eventLoop.sweepExpired()
+		return PROCESSED_EVENT_CODE
 	case <-eventLoop.quit:
 		return KILL_CODE
 	}
 }
 
+// removes expired entries that haven't been read, so watchers hear about them without a read.
+// each tick checks the next batch of keys, and a new pass starts once they've all been checked
+func (eventLoop *EventLoopImpl) sweepExpired() {
+	if len(eventLoop.sweepKeys) == 0 {
+		eventLoop.sweepKeys = eventLoop.cache.Keys()
+	}
+
+	batch := eventLoop.sweepKeys[:min(len(eventLoop.sweepKeys), DEFAULT_EXPIRY_SWEEP_BATCH)]
+	eventLoop.sweepKeys = eventLoop.sweepKeys[len(batch):]
+	for _, key := range batch {
+		entry, ok := eventLoop.cache.Peek(key.Namespace, key.Key)
+		if ok && eventLoop.expired(entry) {
+			eventLoop.expire(key.Namespace, key.Key)
+		}
+	}
+}
+
 func (eventLoop *EventLoopImpl) isKillCode(code int) bool {
 	return code == KILL_CODE
 }
@@ -62,30 +154,260 @@ func (eventLoop *EventLoopImpl) handleEvent(event *CacheEvent) {
 		eventLoop.handleSetEvent(event)
 	case DELETE_EVENT_KEY:
 		eventLoop.handleDeleteEvent(event)
+	case KEYS_EVENT_KEY:
+		eventLoop.handleKeysEvent(event)
+	case SET_IF_ABSENT_EVENT_KEY:
+		eventLoop.handleSetIfAbsentEvent(event)
+	case STATS_EVENT_KEY:
+		eventLoop.handleStatsEvent(event)
+	case INVALIDATE_EVENT_KEY:
+		eventLoop.handleInvalidateEvent(event)
+	case DELETE_KEYS_EVENT_KEY:
+		eventLoop.handleDeleteKeysEvent(event)
+	case REFRESH_EVENT_KEY:
+		eventLoop.handleRefreshEvent(event)
+	case PEEK_EVENT_KEY:
+		eventLoop.handlePeekEvent(event)
 	default:
 		panic("unknown event type")
 	}
 }
 
 func (eventLoop *EventLoopImpl) handleGetEvent(event *CacheEvent) {
-	value, ok := eventLoop.cache.Get(event.Key)
-	event.sendResponse(createEventResponse(ok, value))
+	entry, ok := eventLoop.lookup(event.Namespace, event.Key)
+	resp := createEntryResponse(ok, entry)
+	resp.Stale = ok && !entry.StaleAt.IsZero() && !eventLoop.now().Before(entry.StaleAt)
+	resp.Refresh = ok && !resp.Stale && eventLoop.refreshEarly(entry)
+	event.sendResponse(resp)
+}
+
+// an expired entry reads as missing, it's left for a read or the expiry sweep to remove
+func (eventLoop *EventLoopImpl) handlePeekEvent(event *CacheEvent) {
+	entry, ok := eventLoop.cache.Peek(event.Namespace, event.Key)
+	if ok && eventLoop.expired(entry) {
+		ok = false
+	}
+	event.sendResponse(createEntryResponse(ok, entry))
+}
+
+// XFetch: each read draws a random head start that grows with the entry's cost, and is picked
+// to refresh the entry when the head start reaches the time it goes stale, or expires without a
+// soft ttl. the closer that time and the costlier the value the likelier a read is picked, so
+// refreshes are spread out instead of every reader finding the entry gone at once
+func (eventLoop *EventLoopImpl) refreshEarly(entry Entry) bool {
+	deadline := entry.StaleAt
+	if deadline.IsZero() {
+		deadline = entry.ExpiresAt
+	}
+	if deadline.IsZero() || entry.Cost <= 0 || eventLoop.beta <= 0 {
+		return false
+	}
+
+	// compared as floats, a big enough cost would overflow a duration
+	headStart := float64(entry.Cost) * eventLoop.beta * -math.Log(eventLoop.random())
+	return headStart >= float64(deadline.Sub(eventLoop.now()))
 }
 
 func (eventLoop *EventLoopImpl) handleSetEvent(event *CacheEvent) {
-	err := eventLoop.cache.Set(event.Key, event.Val)
+	if event.Precondition.isSet() {
+		current, ok := eventLoop.lookup(event.Namespace, event.Key)
+		if !event.Precondition.holds(current, ok) {
+			event.sendError(ErrVersionMismatch)
+			return
+		}
+	}
+
+	entry, err := eventLoop.write(event)
 	if err != nil {
 		event.sendError(err)
 		return
 	}
-	event.sendResponse(createEventResponse(true, nil))
+	event.sendResponse(createWriteResponse(entry))
 }
 
+// Ok is false when there was nothing to delete
 func (eventLoop *EventLoopImpl) handleDeleteEvent(event *CacheEvent) {
-	err := eventLoop.cache.Delete(event.Key)
+	current, ok := eventLoop.lookup(event.Namespace, event.Key)
+	if !event.Precondition.holds(current, ok) {
+		event.sendError(ErrVersionMismatch)
+		return
+	}
+	// the store can hold keys the cache has evicted, so it's told either way
+	if err := eventLoop.persist(DELETE_CHANGE, event.Namespace, event.Key, Entry{}); err != nil {
+		event.sendError(err)
+		return
+	}
+
+	err := eventLoop.cache.Delete(event.Namespace, event.Key)
 	if err != nil {
 		event.sendError(err)
 		return
 	}
-	event.sendResponse(createEventResponse(true, nil))
+	if ok {
+		eventLoop.publish(DELETE_CHANGE, event.Namespace, event.Key, Entry{})
+	}
+	event.sendResponse(createEventResponse(ok, nil))
+}
+
+// Version is the last write's, every key listed was written at or before it
+func (eventLoop *EventLoopImpl) handleKeysEvent(event *CacheEvent) {
+	event.sendResponse(CacheEventResponse{
+		Ok:      true,
+		Keys:    eventLoop.cache.Keys(),
+		Version: eventLoop.version,
+	})
+}
+
+// Ok is false when the key was already present and left untouched
+func (eventLoop *EventLoopImpl) handleSetIfAbsentEvent(event *CacheEvent) {
+	if _, ok := eventLoop.lookup(event.Namespace, event.Key); ok {
+		event.sendResponse(createEventResponse(false, nil))
+		return
+	}
+
+	entry, err := eventLoop.write(event)
+	if err != nil {
+		event.sendError(err)
+		return
+	}
+	event.sendResponse(createWriteResponse(entry))
+}
+
+// a refresh is a loaded value, so unlike a set it isn't written to the backing store
+func (eventLoop *EventLoopImpl) handleRefreshEvent(event *CacheEvent) {
+	current, ok := eventLoop.lookup(event.Namespace, event.Key)
+	if !event.Precondition.holds(current, ok) {
+		event.sendResponse(createEventResponse(false, nil))
+		return
+	}
+
+	entry, err := eventLoop.write(event)
+	if err != nil {
+		event.sendError(err)
+		return
+	}
+	event.sendResponse(createWriteResponse(entry))
+}
+
+func (eventLoop *EventLoopImpl) handleStatsEvent(event *CacheEvent) {
+	event.sendResponse(CacheEventResponse{
+		Ok:    true,
+		Stats: eventLoop.cache.Stats(),
+	})
+}
+
+// Count is how many keys carried the tag, each one is deleted like a delete event would
+func (eventLoop *EventLoopImpl) handleInvalidateEvent(event *CacheEvent) {
+	count := 0
+	for _, key := range eventLoop.cache.Tagged(event.Namespace, event.Tag) {
+		if err := eventLoop.cache.Delete(event.Namespace, key); err != nil {
+			event.sendError(err)
+			return
+		}
+		eventLoop.publish(DELETE_CHANGE, event.Namespace, key, Entry{})
+		count++
+	}
+
+	event.sendResponse(CacheEventResponse{Ok: true, Count: count})
+}
+
+// Count leaves out keys that were already gone and ones written after MaxVersion
+func (eventLoop *EventLoopImpl) handleDeleteKeysEvent(event *CacheEvent) {
+	count := 0
+	for _, key := range event.Keys {
+		entry, ok := eventLoop.lookup(key.Namespace, key.Key)
+		if !ok || entry.Version > event.MaxVersion {
+			continue
+		}
+		if err := eventLoop.cache.Delete(key.Namespace, key.Key); err != nil {
+			event.sendError(err)
+			return
+		}
+		eventLoop.publish(DELETE_CHANGE, key.Namespace, key.Key, Entry{})
+		count++
+	}
+
+	event.sendResponse(CacheEventResponse{Ok: true, Count: count})
+}
+
+// expired entries are removed the first time they're read
+func (eventLoop *EventLoopImpl) lookup(namespace string, key string) (Entry, bool) {
+	entry, ok := eventLoop.cache.Get(namespace, key)
+	if ok && eventLoop.expired(entry) {
+		eventLoop.expire(namespace, key)
+		return Entry{}, false
+	}
+
+	return entry, ok
+}
+
+func (eventLoop *EventLoopImpl) expired(entry Entry) bool {
+	return !entry.ExpiresAt.IsZero() && !eventLoop.now().Before(entry.ExpiresAt)
+}
+
+func (eventLoop *EventLoopImpl) expire(namespace string, key string) {
+	eventLoop.cache.Delete(namespace, key)
+	eventLoop.publish(EXPIRE_CHANGE, namespace, key, Entry{})
+}
+
+func (eventLoop *EventLoopImpl) write(event *CacheEvent) (Entry, error) {
+	eventLoop.version++
+	entry := Entry{
+		Value:       event.Val,
+		ContentType: event.ContentType,
+		Flags:       event.Flags,
+		Tags:        event.Tags,
+		Version:     eventLoop.version,
+		Cost:        event.Cost,
+	}
+	if event.TTL > 0 {
+		entry.ExpiresAt = eventLoop.now().Add(event.TTL)
+	}
+	if event.SoftTTL > 0 {
+		entry.StaleAt = eventLoop.now().Add(event.SoftTTL)
+	}
+	// loaded and migrated entries came from a store or another node, only client sets are written back
+	if event.Type == SET_EVENT_KEY {
+		if err := eventLoop.cache.Fits(event.Namespace, event.Key, entry); err != nil {
+			return entry, err
+		}
+		if err := eventLoop.persist(SET_CHANGE, event.Namespace, event.Key, entry); err != nil {
+			return entry, err
+		}
+	}
+
+	if err := eventLoop.cache.Set(event.Namespace, event.Key, entry); err != nil {
+		return entry, err
+	}
+	eventLoop.publish(SET_CHANGE, event.Namespace, event.Key, entry)
+
+	return entry, nil
+}
+
+func (eventLoop *EventLoopImpl) persist(changeType string, namespace string, key string, entry Entry) error {
+	if eventLoop.store == nil {
+		return nil
+	}
+
+	return eventLoop.store.Write(Change{
+		Type:      changeType,
+		Namespace: namespace,
+		Key:       key,
+		Entry:     entry,
+	})
+}
+
+func (eventLoop *EventLoopImpl) publish(changeType string, namespace string, key string, entry Entry) {
+	if eventLoop.publisher == nil {
+		return
+	}
+
+	eventLoop.seq++
+	eventLoop.publisher.Publish(Change{
+		Seq:       eventLoop.seq,
+		Type:      changeType,
+		Namespace: namespace,
+		Key:       key,
+		Entry:     entry,
+	})
 }
@@ -2,40 +2,87 @@ package loop
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type MockCache struct {
-	cache map[string]CacheEntry
+	cache map[Key]Entry
 }
 
-func (mc *MockCache) Get(key string) (CacheEntry, bool) {
+func (mc *MockCache) Get(namespace string, key string) (Entry, bool) {
 	if key == "miss" {
-		return nil, false
+		return Entry{}, false
 	}
 
-	val, ok := mc.cache[key]
-	return val, ok
+	entry, ok := mc.cache[Key{namespace, key}]
+	return entry, ok
 }
 
-func (mc *MockCache) Set(key string, val CacheEntry) error {
+func (mc *MockCache) Peek(namespace string, key string) (Entry, bool) {
+	entry, ok := mc.cache[Key{namespace, key}]
+	return entry, ok
+}
+
+func (mc *MockCache) Set(namespace string, key string, entry Entry) error {
 	if key == "error" {
 		return fmt.Errorf("error setting value")
 	}
-	mc.cache[key] = val
+	mc.cache[Key{namespace, key}] = entry
+	return nil
+}
+
+func (mc *MockCache) Fits(namespace string, key string, entry Entry) error {
+	if key == "too large" {
+		return fmt.Errorf("entry is too large")
+	}
 	return nil
 }
 
-func (mc *MockCache) Delete(key string) error {
+func (mc *MockCache) Delete(namespace string, key string) error {
 	if key == "error" {
 		return fmt.Errorf("error deleting value")
 	}
-	mc.cache[key] = nil
+	delete(mc.cache, Key{namespace, key})
 	return nil
 }
 
+func (mc *MockCache) Keys() []Key {
+	keys := []Key{}
+	for key := range mc.cache {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (mc *MockCache) Stats() []NamespaceStats {
+	entries := map[string]int{}
+	for key := range mc.cache {
+		entries[key.Namespace] += 1
+	}
+
+	stats := []NamespaceStats{}
+	for namespace, count := range entries {
+		stats = append(stats, NamespaceStats{Namespace: namespace, Entries: count})
+	}
+	return stats
+}
+
+func (mc *MockCache) Tagged(namespace string, tag string) []string {
+	keys := []string{}
+	for key, entry := range mc.cache {
+		if key.Namespace == namespace && slices.Contains(entry.Tags, tag) {
+			keys = append(keys, key.Key)
+		}
+	}
+	return keys
+}
+
 func TestNewEventLoopMethodReturnsEventLoop(t *testing.T) {
 	eventLoop := createEmptyEventLoop()
 
@@ -106,10 +153,18 @@ func TestSendMethodPushesEventToEventsChannel(t *testing.T) {
 	assert.Equal(t, expectedType, event.Type)
 }
 
+func TestPendingCountsQueuedEvents(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	eventLoop.Send(&CacheEvent{Type: GET_EVENT_KEY})
+	eventLoop.Send(&CacheEvent{Type: GET_EVENT_KEY})
+
+	assert.Equal(t, 2, eventLoop.Pending())
+}
+
 func TestSendingEventCallsHandleEvent(t *testing.T) {
 	key := "test"
-	var val CacheEntry = "my value"
-	event, _, _ := CreateSetEvent(key, val)
+	val := CacheEntry("my value")
+	event, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, key, val)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.Send(event)
@@ -120,8 +175,8 @@ func TestSendingEventCallsHandleEvent(t *testing.T) {
 
 func TestHandleGetEventSendsValueInResponseChan(t *testing.T) {
 	key := "test"
-	var expectedValue CacheEntry = "my value"
-	event, responseChan, errorChan := CreateGetEvent(key)
+	expectedValue := CacheEntry("my value")
+	event, responseChan, errorChan := CreateGetEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 	setCacheValue(eventLoop, key, expectedValue)
 
@@ -142,7 +197,7 @@ func TestHandleGetEventSendsValueInResponseChan(t *testing.T) {
 
 func TestHandleGetEventSendsNonOkayResponseOnMiss(t *testing.T) {
 	key := "miss"
-	event, responseChan, errorChan := CreateGetEvent(key)
+	event, responseChan, errorChan := CreateGetEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleGetEvent(event)
@@ -162,22 +217,20 @@ func TestHandleGetEventSendsNonOkayResponseOnMiss(t *testing.T) {
 
 func TestHandleSetEventUpdatesCache(t *testing.T) {
 	expectedKey := "test"
-	var expectedValue CacheEntry = "my value"
-	event, _, _ := CreateSetEvent(expectedKey, expectedValue)
+	expectedValue := CacheEntry("my value")
+	event, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, expectedKey, expectedValue)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleSetEvent(event)
 
-	err := eventLoop.cache.Set(expectedKey, expectedValue)
 	cacheValue := getCacheValue(eventLoop, expectedKey)
-	assert.Nil(t, err)
 	assert.Equal(t, expectedValue, cacheValue)
 }
 
 func TestHandleSetEventSendsSuccessResponse(t *testing.T) {
 	key := "test"
-	var val CacheEntry = "val"
-	event, responseChan, errorChan := CreateSetEvent(key, val)
+	val := CacheEntry("val")
+	event, responseChan, errorChan := CreateSetEvent(DEFAULT_NAMESPACE, key, val)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleSetEvent(event)
@@ -198,8 +251,8 @@ func TestHandleSetEventSendsSuccessResponse(t *testing.T) {
 
 func TestHandleSetEventSendErrorResponse(t *testing.T) {
 	key := "error"
-	var val CacheEntry = "val"
-	event, responseChan, errorChan := CreateSetEvent(key, val)
+	val := CacheEntry("val")
+	event, responseChan, errorChan := CreateSetEvent(DEFAULT_NAMESPACE, key, val)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleSetEvent(event)
@@ -218,8 +271,8 @@ func TestHandleSetEventSendErrorResponse(t *testing.T) {
 
 func TestHandleDeleteEventDeletesValueFromCache(t *testing.T) {
 	key := "test"
-	var value CacheEntry = "my value"
-	event, _, _ := CreateDeleteEvent(key)
+	value := CacheEntry("my value")
+	event, _, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 	setCacheValue(eventLoop, key, value)
 
@@ -230,8 +283,8 @@ func TestHandleDeleteEventDeletesValueFromCache(t *testing.T) {
 
 func TestHandleDeleteEventSendResponse(t *testing.T) {
 	key := "test"
-	var value CacheEntry = "my value"
-	event, responseChan, errorChan := CreateDeleteEvent(key)
+	value := CacheEntry("my value")
+	event, responseChan, errorChan := CreateDeleteEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 	setCacheValue(eventLoop, key, value)
 
@@ -253,7 +306,7 @@ func TestHandleDeleteEventSendResponse(t *testing.T) {
 
 func TestHandleDeleteEventSendsError(t *testing.T) {
 	key := "error"
-	event, responseChan, errorChan := CreateDeleteEvent(key)
+	event, responseChan, errorChan := CreateDeleteEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleDeleteEvent(event)
@@ -272,8 +325,8 @@ func TestHandleDeleteEventSendsError(t *testing.T) {
 
 func TestCallingHandleEventWithGetEventCallsHandleGetEvent(t *testing.T) {
 	key := "test"
-	var expectedValue CacheEntry = "my value"
-	event, responseChan, errorChan := CreateGetEvent(key)
+	expectedValue := CacheEntry("my value")
+	event, responseChan, errorChan := CreateGetEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 	setCacheValue(eventLoop, key, expectedValue)
 
@@ -294,8 +347,8 @@ func TestCallingHandleEventWithGetEventCallsHandleGetEvent(t *testing.T) {
 
 func TestCallingHandleEventWithSetEventCallsHandleSetEvent(t *testing.T) {
 	key := "test"
-	var val CacheEntry = "val"
-	event, responseChan, errorChan := CreateSetEvent(key, val)
+	val := CacheEntry("val")
+	event, responseChan, errorChan := CreateSetEvent(DEFAULT_NAMESPACE, key, val)
 	eventLoop := createEmptyEventLoop()
 
 	eventLoop.handleEvent(event)
@@ -316,8 +369,8 @@ func TestCallingHandleEventWithSetEventCallsHandleSetEvent(t *testing.T) {
 
 func TestCallingHandleEventWithDeleteEventCallsHandleDeleteEvent(t *testing.T) {
 	key := "test"
-	var value CacheEntry = "my value"
-	event, responseChan, errorChan := CreateDeleteEvent(key)
+	value := CacheEntry("my value")
+	event, responseChan, errorChan := CreateDeleteEvent(DEFAULT_NAMESPACE, key)
 	eventLoop := createEmptyEventLoop()
 	setCacheValue(eventLoop, key, value)
 
@@ -350,19 +403,483 @@ func TestCallingHandleEventWithRandomEventPanics(t *testing.T) {
 	t.Fatal("Method call didn't panic")
 }
 
+func TestHandleKeysEventSendsKeys(t *testing.T) {
+	event, responseChan, errorChan := CreateKeysEvent()
+	eventLoop := createEmptyEventLoop()
+	setCacheValue(eventLoop, "a", CacheEntry("value a"))
+	setCacheValue(eventLoop, "b", CacheEntry("value b"))
+
+	eventLoop.handleEvent(event)
+
+	select {
+	case resp := <-responseChan:
+		assert.True(t, resp.Ok)
+		assert.ElementsMatch(t, []Key{{DEFAULT_NAMESPACE, "a"}, {DEFAULT_NAMESPACE, "b"}}, resp.Keys)
+		break
+	case err := <-errorChan:
+		handleError(t, err)
+		break
+	default:
+		handleDefault(t, "responseChan")
+	}
+}
+
+func TestHandleSetIfAbsentEventSetsMissingKey(t *testing.T) {
+	key := "test"
+	value := CacheEntry("my value")
+	event, responseChan, errorChan := CreateSetIfAbsentEvent(DEFAULT_NAMESPACE, key, value)
+	eventLoop := createEmptyEventLoop()
+
+	eventLoop.handleEvent(event)
+
+	select {
+	case resp := <-responseChan:
+		assert.True(t, resp.Ok)
+		assert.Equal(t, value, getCacheValue(eventLoop, key))
+		break
+	case err := <-errorChan:
+		handleError(t, err)
+		break
+	default:
+		handleDefault(t, "responseChan")
+	}
+}
+
+func TestHandleSetIfAbsentEventKeepsExistingKey(t *testing.T) {
+	key := "test"
+	existing := CacheEntry("existing")
+	event, responseChan, errorChan := CreateSetIfAbsentEvent(DEFAULT_NAMESPACE, key, CacheEntry("my value"))
+	eventLoop := createEmptyEventLoop()
+	setCacheValue(eventLoop, key, existing)
+
+	eventLoop.handleEvent(event)
+
+	select {
+	case resp := <-responseChan:
+		assert.False(t, resp.Ok)
+		assert.Equal(t, existing, getCacheValue(eventLoop, key))
+		break
+	case err := <-errorChan:
+		handleError(t, err)
+		break
+	default:
+		handleDefault(t, "responseChan")
+	}
+}
+
+func TestHandleSetEventKeepsNamespacesApart(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	setCacheValue(eventLoop, "key", CacheEntry("default value"))
+	event, _, _ := CreateSetEvent("other", "key", CacheEntry("other value"))
+
+	eventLoop.handleEvent(event)
+
+	assert.Equal(t, CacheEntry("default value"), getCacheValue(eventLoop, "key"))
+	assert.Equal(t, CacheEntry("other value"), eventLoop.cache.(*MockCache).cache[Key{"other", "key"}].Value)
+}
+
+func TestHandleStatsEventSendsStats(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	setCacheValue(eventLoop, "key", CacheEntry("value"))
+	event, responseChan, errorChan := CreateStatsEvent()
+
+	eventLoop.handleEvent(event)
+
+	select {
+	case resp := <-responseChan:
+		assert.True(t, resp.Ok)
+		assert.Equal(t, []NamespaceStats{{Namespace: DEFAULT_NAMESPACE, Entries: 1}}, resp.Stats)
+		break
+	case err := <-errorChan:
+		handleError(t, err)
+		break
+	default:
+		handleDefault(t, "responseChan")
+	}
+}
+
+func TestHandleSetEventBumpsVersion(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	first, r1, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("first"))
+	second, r2, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("second"))
+
+	eventLoop.handleEvent(first)
+	eventLoop.handleEvent(second)
+
+	v1, v2 := (<-r1).Version, (<-r2).Version
+	assert.Greater(t, v2, v1)
+	assert.Equal(t, v2, eventLoop.cache.(*MockCache).cache[Key{DEFAULT_NAMESPACE, "key"}].Version)
+}
+
+func TestHandleSetEventChecksPrecondition(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	eventLoop.cache.Set(DEFAULT_NAMESPACE, "key", Entry{Value: CacheEntry("existing"), Version: 5})
+
+	stale, _, errorChan := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("stale"))
+	stale.Precondition = Precondition{Versions: []uint64{4}}
+	eventLoop.handleEvent(stale)
+	assert.ErrorIs(t, <-errorChan, ErrPreconditionFailed)
+	assert.Equal(t, CacheEntry("existing"), getCacheValue(eventLoop, "key"))
+
+	current, responseChan, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("current"))
+	current.Precondition = Precondition{Versions: []uint64{4, 5}}
+	eventLoop.handleEvent(current)
+	assert.True(t, (<-responseChan).Ok)
+	assert.Equal(t, CacheEntry("current"), getCacheValue(eventLoop, "key"))
+
+	absent, _, errorChan := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("absent"))
+	absent.Precondition = Precondition{Absent: true}
+	eventLoop.handleEvent(absent)
+	assert.ErrorIs(t, <-errorChan, ErrPreconditionFailed)
+}
+
+func TestHandleDeleteEventChecksPrecondition(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+
+	missing, _, errorChan := CreateDeleteEvent(DEFAULT_NAMESPACE, "key")
+	missing.Precondition = Precondition{Exists: true}
+	eventLoop.handleEvent(missing)
+
+	assert.ErrorIs(t, <-errorChan, ErrVersionMismatch)
+}
+
+func TestHandleDeleteEventReportsMissingKey(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	event, responseChan, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, "key")
+
+	eventLoop.handleEvent(event)
+
+	assert.False(t, (<-responseChan).Ok)
+}
+
+func TestExpiredEntriesAreMisses(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	set, setResponse, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	set.TTL = time.Second
+	eventLoop.handleEvent(set)
+	assert.Equal(t, now.Add(time.Second), (<-setResponse).ExpiresAt)
+
+	now = now.Add(time.Second)
+	get, getResponse, _ := CreateGetEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(get)
+
+	assert.False(t, (<-getResponse).Ok)
+	assert.Empty(t, eventLoop.cache.Keys())
+}
+
+func TestPeekedExpiredEntriesAreMissesButStay(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	set, setResponse, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	set.TTL = time.Second
+	eventLoop.handleEvent(set)
+	version := (<-setResponse).Version
+
+	peek, peekResponse, _ := CreatePeekEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(peek)
+	resp := <-peekResponse
+	assert.True(t, resp.Ok)
+	assert.Equal(t, version, resp.Version)
+
+	now = now.Add(time.Second)
+	peek, peekResponse, _ = CreatePeekEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(peek)
+
+	assert.False(t, (<-peekResponse).Ok)
+	assert.Len(t, eventLoop.cache.Keys(), 1)
+}
+
+func TestSweepRemovesExpiredEntriesWithoutARead(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	publisher := &MockPublisher{}
+	eventLoop.SetPublisher(publisher)
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	ticks := make(chan time.Time, 1)
+	eventLoop.sweep = ticks
+	expiring, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "expiring", CacheEntry("value"))
+	expiring.TTL = time.Second
+	eventLoop.handleEvent(expiring)
+	kept, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "kept", CacheEntry("value"))
+	eventLoop.handleEvent(kept)
+
+	now = now.Add(time.Second)
+	ticks <- now
+	code := eventLoop.multiplexChannels()
+
+	assert.Equal(t, PROCESSED_EVENT_CODE, code)
+	assert.Equal(t, []Key{{DEFAULT_NAMESPACE, "kept"}}, eventLoop.cache.Keys())
+	last := publisher.changes[len(publisher.changes)-1]
+	assert.Equal(t, EXPIRE_CHANGE, last.Type)
+	assert.Equal(t, "expiring", last.Key)
+}
+
+func TestSweepChecksABatchPerTick(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	for i := 0; i < DEFAULT_EXPIRY_SWEEP_BATCH+1; i++ {
+		set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, strconv.Itoa(i), CacheEntry("value"))
+		set.TTL = time.Second
+		eventLoop.handleEvent(set)
+	}
+
+	now = now.Add(time.Second)
+	eventLoop.sweepExpired()
+	assert.Len(t, eventLoop.cache.Keys(), 1)
+	eventLoop.sweepExpired()
+	assert.Empty(t, eventLoop.cache.Keys())
+}
+
+func TestHandleInvalidateEventDeletesTaggedKeys(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	publisher := &MockPublisher{}
+	eventLoop.SetPublisher(publisher)
+	for _, key := range []string{"a", "b", "c"} {
+		set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, key, CacheEntry("value"))
+		if key != "c" {
+			set.Tags = []string{"product:1"}
+		}
+		eventLoop.handleEvent(set)
+	}
+	eventLoop.cache.Set("other", "a", Entry{Value: CacheEntry("other"), Tags: []string{"product:1"}})
+
+	event, responseChan, _ := CreateInvalidateEvent(DEFAULT_NAMESPACE, "product:1")
+	eventLoop.handleEvent(event)
+
+	assert.Equal(t, 2, (<-responseChan).Count)
+	assert.ElementsMatch(t, []Key{{DEFAULT_NAMESPACE, "c"}, {"other", "a"}}, eventLoop.cache.Keys())
+	assert.Equal(t, DELETE_CHANGE, publisher.changes[len(publisher.changes)-1].Type)
+}
+
+func TestHandleDeleteKeysEventSkipsNewerEntries(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	old, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "old", CacheEntry("value"))
+	eventLoop.handleEvent(old)
+	keys, keysResponse, _ := CreateKeysEvent()
+	eventLoop.handleEvent(keys)
+	snapshot := <-keysResponse
+	newer, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "new", CacheEntry("value"))
+	eventLoop.handleEvent(newer)
+
+	event, responseChan, _ := CreateDeleteKeysEvent([]Key{{DEFAULT_NAMESPACE, "old"}, {DEFAULT_NAMESPACE, "new"}, {DEFAULT_NAMESPACE, "gone"}}, snapshot.Version)
+	eventLoop.handleEvent(event)
+
+	assert.Equal(t, 1, (<-responseChan).Count)
+	assert.Equal(t, []Key{{DEFAULT_NAMESPACE, "new"}}, eventLoop.cache.Keys())
+}
+
+type MockPublisher struct {
+	changes []Change
+}
+
+func (p *MockPublisher) Publish(change Change) {
+	p.changes = append(p.changes, change)
+}
+
+func TestChangesArePublished(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	publisher := &MockPublisher{}
+	eventLoop.SetPublisher(publisher)
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	first := eventLoop.seq + 1
+
+	set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	eventLoop.handleEvent(set)
+	del, _, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(del)
+	missing, _, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(missing)
+	expiring, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	expiring.TTL = time.Second
+	eventLoop.handleEvent(expiring)
+	now = now.Add(time.Second)
+	get, _, _ := CreateGetEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(get)
+
+	types := []string{}
+	for i, change := range publisher.changes {
+		assert.Equal(t, first+uint64(i), change.Seq)
+		types = append(types, change.Type)
+	}
+	assert.Equal(t, []string{SET_CHANGE, DELETE_CHANGE, SET_CHANGE, EXPIRE_CHANGE}, types)
+	assert.Equal(t, CacheEntry("value"), publisher.changes[0].Entry.Value)
+}
+
+func TestStaleEntriesAreServedUntilTheyExpire(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	get := func() CacheEventResponse {
+		event, responseChan, _ := CreateGetEvent(DEFAULT_NAMESPACE, "key")
+		eventLoop.handleEvent(event)
+		return <-responseChan
+	}
+
+	set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	set.SoftTTL = time.Second
+	set.TTL = 2 * time.Second
+	eventLoop.handleEvent(set)
+
+	assert.False(t, get().Stale)
+	now = now.Add(time.Second)
+	resp := get()
+	assert.True(t, resp.Ok)
+	assert.True(t, resp.Stale)
+	now = now.Add(time.Second)
+	assert.False(t, get().Ok)
+}
+
+func TestReadsRefreshCostlyEntriesEarly(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	// a head start of exactly the cost
+	eventLoop.random = func() float64 { return 1 / math.E }
+	get := func(key string) CacheEventResponse {
+		event, responseChan, _ := CreateGetEvent(DEFAULT_NAMESPACE, key)
+		eventLoop.handleEvent(event)
+		return <-responseChan
+	}
+
+	costly, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "costly", CacheEntry("value"))
+	costly.TTL = 10 * time.Second
+	costly.Cost = time.Second
+	eventLoop.handleEvent(costly)
+	free, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "free", CacheEntry("value"))
+	free.TTL = 10 * time.Second
+	eventLoop.handleEvent(free)
+
+	now = now.Add(8500 * time.Millisecond)
+	assert.False(t, get("costly").Refresh)
+	now = now.Add(time.Second)
+	assert.True(t, get("costly").Refresh)
+	assert.False(t, get("free").Refresh)
+
+	eventLoop.SetEarlyRefreshBeta(0)
+	assert.False(t, get("costly").Refresh)
+}
+
+func TestEarlyRefreshesAimForTheSoftTTL(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	now := time.Unix(0, 0)
+	eventLoop.now = func() time.Time { return now }
+	eventLoop.random = func() float64 { return 1 / math.E }
+
+	set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	set.SoftTTL = 5 * time.Second
+	set.TTL = 10 * time.Second
+	set.Cost = time.Second
+	eventLoop.handleEvent(set)
+
+	now = now.Add(4500 * time.Millisecond)
+	event, responseChan, _ := CreateGetEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(event)
+	resp := <-responseChan
+	assert.True(t, resp.Refresh)
+	assert.False(t, resp.Stale)
+	assert.Equal(t, time.Second, resp.Cost)
+}
+
+func TestRefreshOnlyReplacesTheVersionItRead(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	store := &MockBackingStore{}
+	eventLoop.SetBackingStore(store)
+	set, setResponse, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("stale"))
+	eventLoop.handleEvent(set)
+	stale := (<-setResponse).Version
+
+	refresh, refreshResponse, _ := CreateRefreshEvent(DEFAULT_NAMESPACE, "key", CacheEntry("fresh"), stale)
+	eventLoop.handleEvent(refresh)
+	assert.True(t, (<-refreshResponse).Ok)
+	assert.Equal(t, CacheEntry("fresh"), getCacheValue(eventLoop, "key"))
+
+	again, againResponse, _ := CreateRefreshEvent(DEFAULT_NAMESPACE, "key", CacheEntry("older"), stale)
+	eventLoop.handleEvent(again)
+	assert.False(t, (<-againResponse).Ok)
+	assert.Equal(t, CacheEntry("fresh"), getCacheValue(eventLoop, "key"))
+	// only the client's set reached the store
+	assert.Len(t, store.changes, 1)
+}
+
+type MockBackingStore struct {
+	changes []Change
+	err     error
+}
+
+func (s *MockBackingStore) Write(change Change) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.changes = append(s.changes, change)
+	return nil
+}
+
+func TestClientWritesReachTheBackingStore(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	store := &MockBackingStore{}
+	eventLoop.SetBackingStore(store)
+
+	set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	eventLoop.handleEvent(set)
+	loaded, _, _ := CreateSetIfAbsentEvent(DEFAULT_NAMESPACE, "loaded", CacheEntry("value"))
+	eventLoop.handleEvent(loaded)
+	// deletes of keys the cache doesn't have still reach the store
+	del, _, _ := CreateDeleteEvent(DEFAULT_NAMESPACE, "evicted")
+	eventLoop.handleEvent(del)
+
+	assert.Len(t, store.changes, 2)
+	assert.Equal(t, SET_CHANGE, store.changes[0].Type)
+	assert.Equal(t, CacheEntry("value"), store.changes[0].Entry.Value)
+	assert.NotZero(t, store.changes[0].Entry.Version)
+	assert.Equal(t, Change{Type: DELETE_CHANGE, Namespace: DEFAULT_NAMESPACE, Key: "evicted"}, store.changes[1])
+}
+
+func TestFailedStoreWritesLeaveTheCache(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	setCacheValue(eventLoop, "key", CacheEntry("old"))
+	eventLoop.SetBackingStore(&MockBackingStore{err: fmt.Errorf("store down")})
+
+	set, _, setErr := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("new"))
+	eventLoop.handleEvent(set)
+	del, _, delErr := CreateDeleteEvent(DEFAULT_NAMESPACE, "key")
+	eventLoop.handleEvent(del)
+
+	assert.EqualError(t, <-setErr, "store down")
+	assert.EqualError(t, <-delErr, "store down")
+	assert.Equal(t, CacheEntry("old"), getCacheValue(eventLoop, "key"))
+}
+
+func TestWritesTheCacheRefusesDontReachTheStore(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	store := &MockBackingStore{}
+	eventLoop.SetBackingStore(store)
+
+	set, _, setErr := CreateSetEvent(DEFAULT_NAMESPACE, "too large", CacheEntry("value"))
+	eventLoop.handleEvent(set)
+
+	assert.EqualError(t, <-setErr, "entry is too large")
+	assert.Empty(t, store.changes)
+	assert.Empty(t, eventLoop.cache.Keys())
+}
+
 func createEmptyEventLoop() *EventLoopImpl {
 	eventLoop := NewEventLoop(&MockCache{
-		cache: make(map[string]CacheEntry),
+		cache: make(map[Key]Entry),
 	})
 	return eventLoop
 }
 
 func setCacheValue(eventLoop *EventLoopImpl, key string, value CacheEntry) {
-	eventLoop.cache.(*MockCache).cache[key] = value
+	eventLoop.cache.(*MockCache).cache[Key{DEFAULT_NAMESPACE, key}] = Entry{Value: value}
 }
 
 func getCacheValue(eventLoop *EventLoopImpl, key string) CacheEntry {
-	return eventLoop.cache.(*MockCache).cache[key]
+	return eventLoop.cache.(*MockCache).cache[Key{DEFAULT_NAMESPACE, key}].Value
 }
 
 func handleError(t *testing.T, err error) {
@@ -372,3 +889,15 @@ func handleError(t *testing.T, err error) {
 func handleDefault(t *testing.T, expectedChan string) {
 	t.Fatalf("no value present in %s", expectedChan)
 }
+
+func TestPublishersAllGetEveryChange(t *testing.T) {
+	eventLoop := createEmptyEventLoop()
+	a, b := &MockPublisher{}, &MockPublisher{}
+	eventLoop.SetPublisher(Publishers{a, b})
+
+	set, _, _ := CreateSetEvent(DEFAULT_NAMESPACE, "key", CacheEntry("value"))
+	eventLoop.handleEvent(set)
+
+	assert.Len(t, a.changes, 1)
+	assert.Equal(t, a.changes, b.changes)
+}
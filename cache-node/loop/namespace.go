@@ -0,0 +1,21 @@
+package loop
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// used when a request doesn't name one
+const DEFAULT_NAMESPACE = "default"
+
+var ErrInvalidNamespace = fmt.Errorf("namespace must be 1-64 letters, digits, '.', '_' or '-'")
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+
+	return nil
+}
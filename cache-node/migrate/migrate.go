@@ -0,0 +1,443 @@
+package migrate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	STATUS_RUNNING = "running"
+	STATUS_DONE    = "done"
+	STATUS_FAILED  = "failed"
+
+	TRANSFER_PATH = "/internal/transfer"
+)
+
+const DefaultBatchSize = 100
+const DefaultRate = 1000
+const DefaultMaxRetries = 5
+const DefaultRetryDelay = 500 * time.Millisecond
+
+var (
+	ErrMigrationNotFound = fmt.Errorf("migration not found")
+	ErrMigrationRunning  = fmt.Errorf("migration is already running")
+)
+
+type Options struct {
+	BatchSize int
+	// entries per second
+	Rate       int
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// keys with a hash in (Start, End], wrapping past zero when Start >= End
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+type Entry struct {
+	Namespace   string          `json:"namespace"`
+	Key         string          `json:"key"`
+	Value       loop.CacheEntry `json:"value"`
+	ContentType string          `json:"content_type,omitempty"`
+	Flags       uint32          `json:"flags,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	// what's left of the entry's ttl when it was read, 0 never expires
+	TTL time.Duration `json:"ttl,omitempty"`
+	// the same for when it goes stale
+	SoftTTL time.Duration `json:"soft_ttl,omitempty"`
+	// how long the value took to compute, in whole milliseconds
+	CostMs int64 `json:"cost_ms,omitempty"`
+	// the source's version when it was read, only the source uses it
+	Version uint64 `json:"-"`
+}
+
+type TransferBody struct {
+	MigrationId string  `json:"migration_id"`
+	Source      string  `json:"source"`
+	Entries     []Entry `json:"entries"`
+}
+
+type Migration struct {
+	Id          string    `json:"id"`
+	Target      string    `json:"target"`
+	Ranges      []Range   `json:"ranges"`
+	Status      string    `json:"status"`
+	Total       int       `json:"total"`
+	Transferred int       `json:"transferred"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	keys []loop.Key
+}
+
+type Transfer struct {
+	MigrationId string    `json:"migration_id"`
+	Source      string    `json:"source"`
+	Received    int       `json:"received"`
+	Accepted    int       `json:"accepted"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// the cache being migrated, reads and deletes go through the event loop
+type Source interface {
+	Keys() ([]loop.Key, error)
+	Get(namespace string, key string) (Entry, bool, error)
+	// leaves the key alone when it was written after version
+	Delete(namespace string, key string, version uint64) error
+}
+
+type Migrator struct {
+	source   Source
+	self     string
+	options  Options
+	client   *http.Client
+	outgoing map[string]*Migration
+	incoming map[string]*Transfer
+	running  sync.WaitGroup
+	mux      sync.Mutex
+}
+
+func New(source Source, self string, client *http.Client, options Options) *Migrator {
+	return &Migrator{
+		source:   source,
+		self:     self,
+		options:  assignDefaultOptions(options),
+		client:   client,
+		outgoing: make(map[string]*Migration),
+		incoming: make(map[string]*Transfer),
+	}
+}
+
+func assignDefaultOptions(options Options) Options {
+	if options.BatchSize == 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	if options.Rate == 0 {
+		options.Rate = DefaultRate
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultMaxRetries
+	}
+	if options.RetryDelay == 0 {
+		options.RetryDelay = DefaultRetryDelay
+	}
+
+	return options
+}
+
+// must match the registry ring hash so migrated ranges line up with keys
+func HashKey(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (r Range) Contains(hash uint32) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+func (m *Migrator) Start(target string, ranges []Range) Migration {
+	now := time.Now()
+	migration := &Migration{
+		Id:        newMigrationId(),
+		Target:    target,
+		Ranges:    ranges,
+		Status:    STATUS_RUNNING,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+
+	m.mux.Lock()
+	m.outgoing[migration.Id] = migration
+	snapshot := *migration
+	m.mux.Unlock()
+
+	m.running.Add(1)
+	go m.run(migration)
+
+	return snapshot
+}
+
+// picks a failed migration back up from the last batch the target acknowledged
+func (m *Migrator) Resume(id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	migration, ok := m.outgoing[id]
+	if !ok {
+		return ErrMigrationNotFound
+	}
+	if migration.Status == STATUS_RUNNING {
+		return ErrMigrationRunning
+	}
+
+	migration.Status = STATUS_RUNNING
+	migration.Error = ""
+	migration.UpdatedAt = time.Now()
+
+	m.running.Add(1)
+	go m.run(migration)
+
+	return nil
+}
+
+func (m *Migrator) Receive(body TransferBody, accepted int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	transfer, ok := m.incoming[body.MigrationId]
+	if !ok {
+		transfer = &Transfer{
+			MigrationId: body.MigrationId,
+			Source:      body.Source,
+		}
+		m.incoming[body.MigrationId] = transfer
+	}
+
+	transfer.Received += len(body.Entries)
+	transfer.Accepted += accepted
+	transfer.UpdatedAt = time.Now()
+}
+
+func (m *Migrator) Outgoing() []Migration {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	migrations := make([]Migration, 0, len(m.outgoing))
+	for _, migration := range m.outgoing {
+		migrations = append(migrations, *migration)
+	}
+
+	return migrations
+}
+
+func (m *Migrator) Incoming() []Transfer {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	transfers := make([]Transfer, 0, len(m.incoming))
+	for _, transfer := range m.incoming {
+		transfers = append(transfers, *transfer)
+	}
+
+	return transfers
+}
+
+// Wait blocks until the running migrations finish, returning false on timeout
+func (m *Migrator) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (m *Migrator) run(migration *Migration) {
+	defer m.running.Done()
+
+	if migration.keys == nil {
+		keys, err := m.collectKeys(migration.Ranges)
+		if err != nil {
+			m.fail(migration, err)
+			return
+		}
+		m.update(migration, func() {
+			migration.keys = keys
+			migration.Total = len(keys)
+		})
+	}
+
+	for {
+		batch := m.nextBatch(migration)
+		if len(batch) == 0 {
+			break
+		}
+
+		start := time.Now()
+		if err := m.transferWithRetry(migration, batch); err != nil {
+			m.fail(migration, err)
+			return
+		}
+		m.update(migration, func() {
+			migration.Transferred += len(batch)
+		})
+
+		m.throttle(len(batch), time.Since(start))
+	}
+
+	m.update(migration, func() {
+		migration.Status = STATUS_DONE
+	})
+	log.Printf("Migration %s to '%s' finished, %d keys moved", migration.Id, migration.Target, migration.Total)
+}
+
+// the ring places keys by name alone, so a key moves with its namesakes in other namespaces
+func (m *Migrator) collectKeys(ranges []Range) ([]loop.Key, error) {
+	keys, err := m.source.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []loop.Key{}
+	for _, key := range keys {
+		if inRanges(ranges, HashKey(key.Key)) {
+			matching = append(matching, key)
+		}
+	}
+
+	return matching, nil
+}
+
+func inRanges(ranges []Range, hash uint32) bool {
+	for _, r := range ranges {
+		if r.Contains(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Migrator) nextBatch(migration *Migration) []loop.Key {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	start := migration.Transferred
+	end := min(start+m.options.BatchSize, len(migration.keys))
+
+	return migration.keys[start:end]
+}
+
+func (m *Migrator) transferWithRetry(migration *Migration, keys []loop.Key) error {
+	entries, err := m.readEntries(keys)
+	if err != nil {
+		return err
+	}
+
+	delay := m.options.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = m.sendBatch(migration, entries)
+		if err == nil {
+			break
+		}
+		if attempt >= m.options.MaxRetries {
+			return err
+		}
+
+		log.Printf("Migration %s transfer failed, retrying in %v: %s", migration.Id, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	// the target owns these keys now
+	for _, entry := range entries {
+		if err := m.source.Delete(entry.Namespace, entry.Key, entry.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keys removed since the migration started are skipped
+func (m *Migrator) readEntries(keys []loop.Key) ([]Entry, error) {
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		entry, ok, err := m.source.Get(key.Namespace, key.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (m *Migrator) sendBatch(migration *Migration, entries []Entry) error {
+	buf, err := json.Marshal(TransferBody{
+		MigrationId: migration.Id,
+		Source:      m.self,
+		Entries:     entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.Post(NodeUrl(migration.Target, TRANSFER_PATH), "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from '%s'", resp.StatusCode, migration.Target)
+	}
+
+	return nil
+}
+
+func (m *Migrator) throttle(sent int, elapsed time.Duration) {
+	budget := time.Duration(sent) * time.Second / time.Duration(m.options.Rate)
+	if elapsed < budget {
+		time.Sleep(budget - elapsed)
+	}
+}
+
+func (m *Migrator) fail(migration *Migration, err error) {
+	log.Printf("Migration %s to '%s' failed: %s", migration.Id, migration.Target, err)
+	m.update(migration, func() {
+		migration.Status = STATUS_FAILED
+		migration.Error = err.Error()
+	})
+}
+
+func (m *Migrator) update(migration *Migration, change func()) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	change()
+	migration.UpdatedAt = time.Now()
+}
+
+func newMigrationId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// registered urls only carry a scheme when the node serves https
+func NodeUrl(node string, path string) string {
+	if strings.HasPrefix(node, "http://") || strings.HasPrefix(node, "https://") {
+		return node + path
+	}
+
+	return "http://" + node + path
+}
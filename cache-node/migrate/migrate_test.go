@@ -0,0 +1,235 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockSource struct {
+	cache map[loop.Key]loop.CacheEntry
+	mux   sync.Mutex
+}
+
+func (ms *MockSource) Keys() ([]loop.Key, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	keys := []loop.Key{}
+	for key := range ms.cache {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (ms *MockSource) Get(namespace string, key string) (Entry, bool, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	val, ok := ms.cache[loop.Key{Namespace: namespace, Key: key}]
+	return Entry{Namespace: namespace, Key: key, Value: val}, ok, nil
+}
+
+func (ms *MockSource) Delete(namespace string, key string, version uint64) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	delete(ms.cache, loop.Key{Namespace: namespace, Key: key})
+	return nil
+}
+
+type MockTarget struct {
+	received map[loop.Key]loop.CacheEntry
+	failures int
+	mux      sync.Mutex
+}
+
+func (mt *MockTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+
+	if mt.failures > 0 {
+		mt.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var body TransferBody
+	json.NewDecoder(r.Body).Decode(&body)
+	for _, entry := range body.Entries {
+		mt.received[loop.Key{Namespace: entry.Namespace, Key: entry.Key}] = entry.Value
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	r := Range{Start: 10, End: 20}
+
+	assert.False(t, r.Contains(10))
+	assert.True(t, r.Contains(15))
+	assert.True(t, r.Contains(20))
+	assert.False(t, r.Contains(21))
+}
+
+func TestRangeContainsWraps(t *testing.T) {
+	r := Range{Start: 20, End: 10}
+
+	assert.True(t, r.Contains(5))
+	assert.True(t, r.Contains(25))
+	assert.False(t, r.Contains(15))
+}
+
+func TestRangeFullRing(t *testing.T) {
+	r := Range{Start: 10, End: 10}
+
+	assert.True(t, r.Contains(0))
+	assert.True(t, r.Contains(10))
+}
+
+func TestMigrationMovesKeysInRange(t *testing.T) {
+	source := createSource(50)
+	target, server := createTarget(0)
+	defer server.Close()
+	migrator := createMigrator(source, server)
+
+	ranges := []Range{{Start: 0, End: 1 << 31}}
+	migration := migrator.Start(server.URL, ranges)
+	assert.True(t, migrator.Wait(time.Second))
+
+	moved := 0
+	for i := 0; i < 50; i++ {
+		key := loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: fmt.Sprintf("key-%d", i)}
+		_, onTarget := target.received[key]
+		_, onSource := source.cache[key]
+		assert.Equal(t, inRanges(ranges, HashKey(key.Key)), onTarget, key)
+		assert.NotEqual(t, onTarget, onSource, key)
+		if onTarget {
+			moved++
+		}
+	}
+
+	outgoing := migrator.Outgoing()
+	assert.Len(t, outgoing, 1)
+	assert.Equal(t, migration.Id, outgoing[0].Id)
+	assert.Equal(t, STATUS_DONE, outgoing[0].Status)
+	assert.Equal(t, moved, outgoing[0].Total)
+	assert.Equal(t, moved, outgoing[0].Transferred)
+}
+
+func TestMigrationRetriesFailedBatch(t *testing.T) {
+	source := createSource(10)
+	target, server := createTarget(2)
+	defer server.Close()
+	migrator := createMigrator(source, server)
+
+	migrator.Start(server.URL, []Range{{Start: 0, End: 0}})
+	assert.True(t, migrator.Wait(time.Second))
+
+	assert.Len(t, target.received, 10)
+	assert.Empty(t, source.cache)
+}
+
+func TestFailedMigrationResumes(t *testing.T) {
+	source := createSource(10)
+	target, server := createTarget(100)
+	defer server.Close()
+	migrator := createMigrator(source, server)
+
+	migration := migrator.Start(server.URL, []Range{{Start: 0, End: 0}})
+	assert.True(t, migrator.Wait(time.Second))
+	assert.Equal(t, STATUS_FAILED, migrator.Outgoing()[0].Status)
+	assert.Len(t, source.cache, 10)
+
+	target.failures = 0
+	err := migrator.Resume(migration.Id)
+	assert.Nil(t, err)
+	assert.True(t, migrator.Wait(time.Second))
+
+	assert.Equal(t, STATUS_DONE, migrator.Outgoing()[0].Status)
+	assert.Len(t, target.received, 10)
+	assert.Empty(t, source.cache)
+}
+
+func TestMigrationKeepsNamespaces(t *testing.T) {
+	source := createSource(0)
+	source.cache[loop.Key{Namespace: "a", Key: "key"}] = loop.CacheEntry("a")
+	source.cache[loop.Key{Namespace: "b", Key: "key"}] = loop.CacheEntry("b")
+	target, server := createTarget(0)
+	defer server.Close()
+	migrator := createMigrator(source, server)
+
+	migrator.Start(server.URL, []Range{{Start: 0, End: 0}})
+	assert.True(t, migrator.Wait(time.Second))
+
+	assert.Equal(t, map[loop.Key]loop.CacheEntry{
+		{Namespace: "a", Key: "key"}: loop.CacheEntry("a"),
+		{Namespace: "b", Key: "key"}: loop.CacheEntry("b"),
+	}, target.received)
+	assert.Empty(t, source.cache)
+}
+
+func TestResumeUnknownMigration(t *testing.T) {
+	migrator := New(createSource(0), "self", http.DefaultClient, Options{})
+
+	err := migrator.Resume("missing")
+
+	assert.ErrorIs(t, err, ErrMigrationNotFound)
+}
+
+func TestReceiveTracksIncomingProgress(t *testing.T) {
+	migrator := New(createSource(0), "self", http.DefaultClient, Options{})
+	body := TransferBody{
+		MigrationId: "id",
+		Source:      "other",
+		Entries:     []Entry{{Key: "a"}, {Key: "b"}},
+	}
+
+	migrator.Receive(body, 2)
+	migrator.Receive(body, 1)
+
+	incoming := migrator.Incoming()
+	assert.Len(t, incoming, 1)
+	assert.Equal(t, "other", incoming[0].Source)
+	assert.Equal(t, 4, incoming[0].Received)
+	assert.Equal(t, 3, incoming[0].Accepted)
+}
+
+func TestDefaultOptions(t *testing.T) {
+	options := assignDefaultOptions(Options{})
+
+	assert.Equal(t, DefaultBatchSize, options.BatchSize)
+	assert.Equal(t, DefaultRate, options.Rate)
+	assert.Equal(t, DefaultMaxRetries, options.MaxRetries)
+	assert.Equal(t, DefaultRetryDelay, options.RetryDelay)
+}
+
+func createSource(size int) *MockSource {
+	source := &MockSource{cache: make(map[loop.Key]loop.CacheEntry)}
+	for i := 0; i < size; i++ {
+		source.cache[loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: fmt.Sprintf("key-%d", i)}] = loop.CacheEntry(fmt.Sprintf("value-%d", i))
+	}
+	return source
+}
+
+func createTarget(failures int) (*MockTarget, *httptest.Server) {
+	target := &MockTarget{
+		received: make(map[loop.Key]loop.CacheEntry),
+		failures: failures,
+	}
+	return target, httptest.NewServer(target)
+}
+
+func createMigrator(source Source, server *httptest.Server) *Migrator {
+	return New(source, "self", server.Client(), Options{
+		BatchSize:  3,
+		Rate:       100000,
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+	})
+}
@@ -0,0 +1,213 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/glob"
+)
+
+// messages a subscriber can fall behind by before it's dropped
+const DEFAULT_BUFFER = 256
+
+var (
+	ErrSlowConsumer = fmt.Errorf("subscriber fell more than its buffer behind")
+	ErrClosed       = fmt.Errorf("subscription closed")
+)
+
+// Pattern is the pattern that matched, empty when the subscriber named the channel itself
+type Message struct {
+	Channel string
+	Pattern string
+	Payload []byte
+}
+
+// delivers messages to the subscribers of a channel or of a pattern matching it. a subscriber
+// on both gets the message once for each, like redis
+type Broker struct {
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+	mux      sync.Mutex
+}
+
+// one subscriber's channels and patterns, read from Messages
+type Subscription struct {
+	broker   *Broker
+	messages chan Message
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	closed   bool
+	// why the subscription stopped, set before messages is closed
+	err error
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: map[string]map[*Subscription]struct{}{},
+		patterns: map[string]map[*Subscription]struct{}{},
+	}
+}
+
+// never blocks, a subscriber with a full buffer is dropped with ErrSlowConsumer. returns
+// how many subscribers the message reached
+func (b *Broker) Publish(channel string, payload []byte) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	receivers := 0
+	for s := range b.channels[channel] {
+		if b.deliver(s, Message{Channel: channel, Payload: payload}) {
+			receivers++
+		}
+	}
+	for pattern, subscribers := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		for s := range subscribers {
+			if b.deliver(s, Message{Channel: channel, Pattern: pattern, Payload: payload}) {
+				receivers++
+			}
+		}
+	}
+
+	return receivers
+}
+
+func (b *Broker) deliver(s *Subscription, message Message) bool {
+	select {
+	case s.messages <- message:
+		return true
+	default:
+		b.remove(s, ErrSlowConsumer)
+		return false
+	}
+}
+
+// buffer defaults to DEFAULT_BUFFER when it's 0, the subscription starts with nothing in it
+func (b *Broker) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DEFAULT_BUFFER
+	}
+
+	return &Subscription{
+		broker:   b,
+		messages: make(chan Message, buffer),
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+	}
+}
+
+func (b *Broker) remove(s *Subscription, err error) {
+	if s.closed {
+		return
+	}
+
+	for channel := range s.channels {
+		unlink(b.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		unlink(b.patterns, pattern, s)
+	}
+	clear(s.channels)
+	clear(s.patterns)
+	s.closed = true
+	s.err = err
+	close(s.messages)
+}
+
+func link(index map[string]map[*Subscription]struct{}, name string, s *Subscription) {
+	if index[name] == nil {
+		index[name] = map[*Subscription]struct{}{}
+	}
+	index[name][s] = struct{}{}
+}
+
+func unlink(index map[string]map[*Subscription]struct{}, name string, s *Subscription) {
+	delete(index[name], s)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
+
+// every subscribe and unsubscribe returns how many channels and patterns the subscription
+// has afterwards, they do nothing once it's closed
+func (s *Subscription) Subscribe(channel string) int {
+	return s.update(func(b *Broker) {
+		s.channels[channel] = struct{}{}
+		link(b.channels, channel, s)
+	})
+}
+
+func (s *Subscription) PSubscribe(pattern string) int {
+	return s.update(func(b *Broker) {
+		s.patterns[pattern] = struct{}{}
+		link(b.patterns, pattern, s)
+	})
+}
+
+func (s *Subscription) Unsubscribe(channel string) int {
+	return s.update(func(b *Broker) {
+		delete(s.channels, channel)
+		unlink(b.channels, channel, s)
+	})
+}
+
+func (s *Subscription) PUnsubscribe(pattern string) int {
+	return s.update(func(b *Broker) {
+		delete(s.patterns, pattern)
+		unlink(b.patterns, pattern, s)
+	})
+}
+
+func (s *Subscription) update(apply func(b *Broker)) int {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+
+	if !s.closed {
+		apply(s.broker)
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// sorted
+func (s *Subscription) Channels() []string {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	return sortedKeys(s.channels)
+}
+
+// sorted
+func (s *Subscription) Patterns() []string {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	return sortedKeys(s.patterns)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closed once the subscription stops, Err says why
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// only meaningful once Messages is closed
+func (s *Subscription) Err() error {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	s.broker.remove(s, ErrClosed)
+}
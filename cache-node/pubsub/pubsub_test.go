@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishToChannelsAndPatterns(t *testing.T) {
+	broker := NewBroker()
+	exact := broker.Subscribe(0)
+	assert.Equal(t, 1, exact.Subscribe("news.sports"))
+	pattern := broker.Subscribe(0)
+	assert.Equal(t, 1, pattern.PSubscribe("news.*"))
+
+	assert.Equal(t, 2, broker.Publish("news.sports", []byte("goal")))
+	assert.Equal(t, 1, broker.Publish("news.weather", []byte("rain")))
+	assert.Equal(t, 0, broker.Publish("other", []byte("ignored")))
+
+	assert.Equal(t, Message{Channel: "news.sports", Payload: []byte("goal")}, <-exact.Messages())
+	assert.Empty(t, exact.Messages())
+	assert.Equal(t, Message{Channel: "news.sports", Pattern: "news.*", Payload: []byte("goal")}, <-pattern.Messages())
+	assert.Equal(t, "news.weather", (<-pattern.Messages()).Channel)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	broker := NewBroker()
+	s := broker.Subscribe(0)
+	s.Subscribe("a")
+	s.Subscribe("b")
+	s.PSubscribe("c*")
+	assert.Equal(t, []string{"a", "b"}, s.Channels())
+
+	assert.Equal(t, 2, s.Unsubscribe("a"))
+	assert.Equal(t, 1, s.PUnsubscribe("c*"))
+	assert.Equal(t, 0, broker.Publish("a", nil))
+	assert.Equal(t, 0, broker.Publish("cat", nil))
+	assert.Equal(t, 1, broker.Publish("b", nil))
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	broker := NewBroker()
+	s := broker.Subscribe(1)
+	s.Subscribe("a")
+
+	assert.Equal(t, 1, broker.Publish("a", []byte("1")))
+	assert.Equal(t, 0, broker.Publish("a", []byte("2")))
+
+	<-s.Messages()
+	_, ok := <-s.Messages()
+	assert.False(t, ok)
+	assert.ErrorIs(t, s.Err(), ErrSlowConsumer)
+	assert.Empty(t, broker.channels)
+	assert.Equal(t, 0, s.Subscribe("b"))
+}
+
+func TestClose(t *testing.T) {
+	broker := NewBroker()
+	s := broker.Subscribe(0)
+	s.PSubscribe("*")
+
+	s.Close()
+	s.Close()
+
+	assert.Equal(t, 0, broker.Publish("a", nil))
+	assert.ErrorIs(t, s.Err(), ErrClosed)
+}
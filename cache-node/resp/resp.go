@@ -0,0 +1,147 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// the subset of RESP2 clients use to send commands: an array of bulk strings
+//
+//	*<count>\r\n $<length>\r\n <bytes>\r\n ...
+//
+// or an inline command, one line of arguments separated by spaces
+const (
+	MAX_ARGS      = 1 << 16
+	MAX_BULK_SIZE = 64 << 20
+	// inline commands and every header line
+	MAX_LINE_SIZE = 64 << 10
+)
+
+var (
+	// the connection can't carry on, the next byte may be anywhere in a command
+	ErrProtocol = fmt.Errorf("protocol error")
+	ErrTooLarge = fmt.Errorf("command is larger than the limits")
+)
+
+// an empty command is a blank inline line, callers skip it
+func ReadCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	count, err := parseLength(line[1:], MAX_ARGS)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, count)
+	for range count {
+		arg, err := readBulk(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+func readBulk(r *bufio.Reader) ([]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+	}
+	size, err := parseLength(line[1:], MAX_BULK_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk string isn't followed by CRLF", ErrProtocol)
+	}
+
+	return buf[:size], nil
+}
+
+// without the CRLF, a lone LF ends an inline line too
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > MAX_LINE_SIZE {
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		if len(line) > 0 {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, err
+	}
+
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return bytes.Clone(line), nil
+}
+
+func parseLength(b []byte, limit int) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, b)
+	}
+	if n > limit {
+		return 0, ErrTooLarge
+	}
+
+	return n, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func WriteSimple(w *bufio.Writer, s string) error {
+	_, err := w.WriteString("+" + s + "\r\n")
+	return err
+}
+
+// message should start with an error prefix like ERR
+func WriteError(w *bufio.Writer, message string) error {
+	_, err := w.WriteString("-" + message + "\r\n")
+	return err
+}
+
+func WriteInteger(w *bufio.Writer, n int64) error {
+	_, err := w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+	return err
+}
+
+func WriteBulk(w *bufio.Writer, b []byte) error {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+func WriteNull(w *bufio.Writer) error {
+	_, err := w.WriteString("$-1\r\n")
+	return err
+}
+
+// the n elements follow with their own writes
+func WriteArray(w *bufio.Writer, n int) error {
+	_, err := w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+	return err
+}
@@ -0,0 +1,66 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func read(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestReadCommand(t *testing.T) {
+	r := read("*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\na\r\nb\r\nPING hello\r\n\r\n")
+
+	args, err := ReadCommand(r)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("SUBSCRIBE"), []byte("a\r\nb")}, args)
+
+	args, err = ReadCommand(r)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("PING"), []byte("hello")}, args)
+
+	args, err = ReadCommand(r)
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+
+	_, err = ReadCommand(r)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	_, err := ReadCommand(read("*1\r\n:1\r\n"))
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = ReadCommand(read("*1\r\n$3\r\nabcd\r\n"))
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = ReadCommand(read("*x\r\n"))
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = ReadCommand(read("*99999999\r\n"))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = ReadCommand(read("*1\r\n$5\r\nab"))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	WriteArray(w, 3)
+	WriteBulk(w, []byte("message"))
+	WriteSimple(w, "OK")
+	WriteInteger(w, -2)
+	WriteError(w, "ERR bad")
+	WriteNull(w)
+	w.Flush()
+
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n+OK\r\n:-2\r\n-ERR bad\r\n$-1\r\n", buf.String())
+}
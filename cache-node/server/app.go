@@ -4,48 +4,173 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/limit"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/load"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/migrate"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/pubsub"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	*http.Server
-	eventLoop   EventLoop
-	registryUrl string
+	eventLoop    EventLoop
+	registryUrls []string
+	client       *http.Client
+	transport    *nodeTransport
+	migrator     *migrate.Migrator
+	reloader     Reloader
+	// the http server fills in TLSConfig itself once it serves, so this can't be derived from it
+	useTLS bool
+	// only nodes with a verified client certificate can reach the internal routes
+	requireClientCert bool
+	// clients need a credential once an acl is set
+	acl       atomic.Pointer[auth.ACL]
+	nodeToken string
+	// checked before an event is queued
+	clientLimiter    *limit.Limiter
+	namespaceLimiter *limit.Limiter
+	// past this many queued events client requests get a 503, 0 turns shedding off
+	shedQueueDepth atomic.Int64
+	// PUT bodies past this many bytes are refused before they're read, 0 means no limit
+	maxEntryBytes atomic.Int64
+	// the wire protocol is off when this is empty
+	tcpAddr  string
+	tcpConns connSet
+	// grpc is off when this is empty
+	grpcAddr    string
+	grpcServers []*grpc.Server
+	grpcClosed  bool
+	grpcMux     sync.Mutex
+	// the pub/sub listener is off when this is empty
+	respAddr  string
+	respConns connSet
+	// watchers are refused without one
+	hub    *watch.Hub
+	broker *pubsub.Broker
+	// tracking streams are refused without one
+	tracking *track.Table
+	// read-through loaders by namespace
+	loaders map[string]*load.Patterns[registeredLoader]
+	loads   load.Group[loop.CacheEventResponse]
+
+	epoch      string
+	registered bool
+	done       chan struct{}
+	shutdown   sync.Once
+	mux        sync.Mutex
 }
 
-func New(loop EventLoop, addr string, registryUrl string) *Server {
+func New(loop EventLoop, addr string, registryUrls []string) *Server {
 	handler := http.NewServeMux()
+	transport := &nodeTransport{base: http.DefaultTransport}
 
 	server := &Server{
 		Server: &http.Server{
 			Addr:    addr,
 			Handler: handler,
 		},
-		eventLoop:   loop,
-		registryUrl: registryUrl,
+		eventLoop:    loop,
+		registryUrls: registryUrls,
+		client:       &http.Client{Timeout: DEFAULT_CLIENT_TIMEOUT, Transport: transport},
+		transport:    transport,
+		broker:       pubsub.NewBroker(),
+		done:         make(chan struct{}),
+
+		clientLimiter:    limit.New(limit.Rate{}, nil),
+		namespaceLimiter: limit.New(limit.Rate{}, nil),
 	}
+	server.migrator = migrate.New(&loopSource{server: server}, addr, server.client, migrate.Options{})
+
+	handler.HandleFunc("POST /get", server.admit(server.GetHandler))
+	handler.HandleFunc("POST /set", server.admit(server.SetHandler))
+	handler.HandleFunc("POST /delete", server.admit(server.DeleteHandler))
+
+	handler.HandleFunc("GET /v1/keys/{key...}", server.admit(server.GetKeyHandler))
+	handler.HandleFunc("HEAD /v1/keys/{key...}", server.admit(server.GetKeyHandler))
+	handler.HandleFunc("PUT /v1/keys/{key...}", server.admit(server.PutKeyHandler))
+	handler.HandleFunc("DELETE /v1/keys/{key...}", server.admit(server.DeleteKeyHandler))
+
+	handler.HandleFunc("POST /invalidate", server.admit(server.InvalidateHandler))
+	handler.HandleFunc("POST /delete-matching", server.admit(server.DeleteMatchingHandler))
+	handler.HandleFunc("POST /flush", server.admit(server.FlushHandler))
+
+	handler.HandleFunc("GET /watch", server.WatchHandler)
+	handler.HandleFunc("GET /track", server.TrackHandler)
+
+	handler.HandleFunc("POST /publish", server.PublishHandler)
+	handler.HandleFunc("GET /subscribe", server.SubscribeHandler)
+	handler.HandleFunc("GET /subscribe/ws", server.SubscribeSocketHandler)
 
-	handler.HandleFunc("POST /get", server.GetHandler)
-	handler.HandleFunc("POST /set", server.SetHandler)
-	handler.HandleFunc("POST /delete", server.DeleteHandler)
+	handler.HandleFunc("POST /internal/migrate", server.requireNode(server.MigrateHandler))
+	handler.HandleFunc("POST "+migrate.TRANSFER_PATH, server.requireNode(server.TransferHandler))
+	handler.HandleFunc("POST "+INTERNAL_INVALIDATE_PATH, server.requireNode(server.InternalInvalidateHandler))
+
+	handler.HandleFunc("GET /ready", server.ReadyHandler)
+
+	handler.HandleFunc("GET /admin/migrations", server.requireAdmin(server.MigrationsHandler))
+	handler.HandleFunc("POST /admin/migrations/{id}/resume", server.requireAdmin(server.ResumeMigrationHandler))
+	handler.HandleFunc("POST /admin/reload", server.requireAdmin(server.ReloadHandler))
+	handler.HandleFunc("GET /admin/namespaces", server.requireAdmin(server.NamespacesHandler))
+	handler.HandleFunc("POST /admin/namespaces/{namespace}/flush", server.requireAdmin(server.FlushNamespaceHandler))
 
 	return server
 }
 
 func (s *Server) Run() {
-	if ok := s.registerServer(); ok {
-		log.Println("Successfully registered server")
-	} else {
-		log.Println("Unable to register server with registry node. Continuing with cache start")
-	}
-
 	go s.eventLoop.Run()
+	go s.maintainRegistration()
+	if s.tcpAddr != "" {
+		go s.runTCP()
+	}
+	if s.grpcAddr != "" {
+		go s.runGRPC()
+	}
+	if s.respAddr != "" {
+		go s.runRESP()
+	}
 
 	defer func() {
 		s.handleShutdown()
 	}()
 
 	log.Printf("Server listening on '%v'", s.Server.Addr)
-	s.Server.ListenAndServe()
+	if s.useTLS {
+		s.Server.ListenAndServeTLS("", "")
+	} else {
+		s.Server.ListenAndServe()
+	}
+}
+
+// calls to the registry and other nodes present this node's certificate. with a certificate
+// the node also serves https and registers under an https url, must be called before Run
+func (s *Server) UseTLS(c *certs.Certs, requireClientCert bool) {
+	s.transport.base = &http.Transport{TLSClientConfig: c.ClientConfig()}
+	if c.HasCertificate() {
+		s.Server.TLSConfig = c.ServerConfig()
+		s.useTLS = true
+	}
+	s.requireClientCert = requireClientCert
+}
+
+func (s *Server) SetReloader(reloader Reloader) {
+	s.reloader = reloader
+}
+
+// the url the registry hands out for this node
+func (s *Server) advertisedUrl() string {
+	if s.useTLS {
+		return "https://" + s.Addr
+	}
+
+	return s.Addr
 }
 
 func (s *Server) Stop() {
@@ -53,7 +178,20 @@ func (s *Server) Stop() {
 }
 
 func (s *Server) handleShutdown() {
-	s.eventLoop.Stop()
-	s.unregisterServer()
-	s.Server.Shutdown(context.Background())
+	s.shutdown.Do(func() {
+		// stop the heartbeat first so it can't register the node again
+		close(s.done)
+
+		// unregistering hands this node's keys to the rest of the ring, the loop has to run until they're sent
+		s.unregisterServer()
+		if ok := s.migrator.Wait(DEFAULT_DRAIN_TIMEOUT); !ok {
+			log.Println("Timed out waiting for migrations to finish")
+		}
+
+		s.closeTCP()
+		s.closeGRPC()
+		s.closeRESP()
+		s.eventLoop.Stop()
+		s.Server.Shutdown(context.Background())
+	})
 }
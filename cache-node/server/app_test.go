@@ -1,6 +1,8 @@
 package server
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
@@ -12,12 +14,35 @@ func TestNew(t *testing.T) {
 		events: make(chan *loop.CacheEvent),
 	}
 	expectedAddr := ":8080"
-	expectedReg := "asdf"
+	expectedReg := []string{"asdf", "qwer"}
 
 	server := New(el, expectedAddr, expectedReg)
 
 	assert.NotNil(t, server.eventLoop)
 	assert.NotNil(t, server.Server)
 	assert.Equal(t, expectedAddr, server.Server.Addr)
-	assert.Equal(t, expectedReg, server.registryUrl)
+	assert.Equal(t, expectedReg, server.registryUrls)
+}
+
+func TestRequireNodeCert(t *testing.T) {
+	server := createServerWithEventLoop()
+	server.requireClientCert = true
+	called := false
+	handler := server.requireNode(func(w http.ResponseWriter, r *http.Request) { called = true })
+	w := httptest.NewRecorder()
+
+	handler(w, httptest.NewRequest(http.MethodPost, "/internal/transfer", nil))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, called)
+}
+
+func TestRequireNodeNotRequired(t *testing.T) {
+	server := createServerWithEventLoop()
+	called := false
+	handler := server.requireNode(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/transfer", nil))
+
+	assert.True(t, called)
 }
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+)
+
+var (
+	ErrClientCertRequired = loop.WithCode(loop.FORBIDDEN_CODE, fmt.Errorf("a client certificate signed by the node ca is required"))
+	ErrNodeTokenRequired  = loop.WithCode(loop.UNAUTHENTICATED_CODE, fmt.Errorf("a node credential is required"))
+)
+
+// nil turns authentication off, can be swapped while the node runs
+func (s *Server) SetACL(acl *auth.ACL) {
+	s.acl.Store(acl)
+}
+
+// required on the internal routes, and sent on every call to the registry and other nodes
+func (s *Server) SetNodeToken(token string) {
+	s.nodeToken = token
+	s.transport.token = token
+}
+
+// namespace and key are empty for node wide admin routes
+func (s *Server) authorize(r *http.Request, op string, namespace string, key string) error {
+	acl := s.acl.Load()
+	if acl == nil {
+		return nil
+	}
+
+	return acl.Authorize(auth.KeyFromRequest(r), op, namespace, key)
+}
+
+// routes with a {namespace} only need admin on that namespace, the rest need it node wide
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r, auth.ADMIN_OP, r.PathValue("namespace"), ""); err != nil {
+			writeErrorResponse(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// the internal routes are only for the registry and other cache nodes
+func (s *Server) requireNode(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.requireClientCert && !certs.Verified(r.TLS) {
+			writeErrorResponse(w, r, ErrClientCertRequired)
+			return
+		}
+		if s.nodeToken != "" && !auth.TokenMatches(auth.BearerToken(r), s.nodeToken) {
+			writeErrorResponse(w, r, ErrNodeTokenRequired)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type nodeTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *nodeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(r)
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
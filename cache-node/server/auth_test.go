@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func createServerWithACL() *Server {
+	server := New(createMockEventLoop(), ":8080", nil)
+	server.SetACL(auth.New([]auth.Credential{
+		{
+			Name:  "reader",
+			Key:   "reader-key",
+			Rules: []auth.Rule{{Prefix: "public:", Ops: []string{auth.READ_OP}}},
+		},
+	}))
+	return server
+}
+
+func createAuthRequest(t *testing.T, key string, cacheKey string) *http.Request {
+	body, err := createReqBody(cacheKey, nil)
+	if err != nil {
+		handleError(t, err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/get", body)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	return r
+}
+
+func TestGetHandlerUnauthenticated(t *testing.T) {
+	server := createServerWithACL()
+	w := httptest.NewRecorder()
+
+	server.GetHandler(w, createAuthRequest(t, "", "public:a"))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetHandlerOutsidePrefix(t *testing.T) {
+	server := createServerWithACL()
+	w := httptest.NewRecorder()
+
+	server.GetHandler(w, createAuthRequest(t, "reader-key", "private:a"))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestDeleteHandlerWithoutDeleteOp(t *testing.T) {
+	server := createServerWithACL()
+	w := httptest.NewRecorder()
+
+	server.DeleteHandler(w, createAuthRequest(t, "reader-key", "public:a"))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRequireAdmin(t *testing.T) {
+	server := createServerWithACL()
+	called := false
+	handler := server.requireAdmin(func(w http.ResponseWriter, r *http.Request) { called = true })
+	w := httptest.NewRecorder()
+
+	handler(w, createAuthRequest(t, "reader-key", ""))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, called)
+}
+
+func TestRequireNodeToken(t *testing.T) {
+	server := New(createMockEventLoop(), ":8080", nil)
+	server.SetNodeToken("node-token")
+	handler := server.requireNode(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	handler(w, createAuthRequest(t, "wrong", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, createAuthRequest(t, "node-token", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestClientSendsNodeToken(t *testing.T) {
+	var header string
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+	}))
+	defer peer.Close()
+	server := New(createMockEventLoop(), ":8080", nil)
+	server.SetNodeToken("node-token")
+
+	resp, err := server.client.Post(peer.URL, "application/json", nil)
+
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer node-token", header)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	DEFAULT_BACKOFF_BASE = 500 * time.Millisecond
+	DEFAULT_BACKOFF_MAX  = 30 * time.Second
+)
+
+// exponential backoff with full jitter, so nodes restarted together don't retry in lockstep
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(base time.Duration, max time.Duration) *backoff {
+	return &backoff{
+		base: base,
+		max:  max,
+	}
+}
+
+func (b *backoff) next() time.Duration {
+	ceiling := b.max
+	if b.attempt < 32 {
+		ceiling = min(b.base<<b.attempt, b.max)
+	}
+	b.attempt++
+
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/glob"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	FLUSHED_MSG          = "Keys flushed"
+	DELETED_MATCHING_MSG = "Matching keys deleted"
+
+	// keys deleted per event, other events get their turn between batches
+	BULK_DELETE_BATCH = 256
+)
+
+var ErrMissingPattern = badRequest(fmt.Errorf("pattern can't be empty"))
+
+type DeleteMatchingRequest struct {
+	// falls back to the X-Cache-Namespace header, then the default namespace
+	Namespace string `json:"namespace,omitempty"`
+	Pattern   string `json:"pattern"`
+}
+
+// deletes every key in ?namespace=, or on the whole node without one. the value is how many
+// keys were deleted, keys written after the flush started are kept
+func (s *Server) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	s.flush(w, r, r.URL.Query().Get("namespace"))
+}
+
+// an empty namespace flushes every key on the node
+func (s *Server) flush(w http.ResponseWriter, r *http.Request, namespace string) {
+	if namespace != "" {
+		if err := loop.ValidateNamespace(namespace); err != nil {
+			writeErrorResponse(w, r, err)
+			return
+		}
+	}
+	if err := s.authorize(r, auth.ADMIN_OP, namespace, ""); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	count, err := s.deleteMatching(func(key loop.Key) bool {
+		return namespace == "" || key.Namespace == namespace
+	})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{Message: FLUSHED_MSG, Value: count})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// deletes the keys in the namespace matching a glob pattern, the same patterns as psubscribe.
+// it needs delete on everything before the pattern's first special character
+func (s *Server) DeleteMatchingHandler(w http.ResponseWriter, r *http.Request) {
+	var body DeleteMatchingRequest
+	if err := decode(r, &body); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	namespace, err := namespaceFor(r, RequestBody{Namespace: body.Namespace})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if body.Pattern == "" {
+		writeErrorResponse(w, r, ErrMissingPattern)
+		return
+	}
+	if err := s.authorize(r, auth.DELETE_OP, namespace, glob.Literal(body.Pattern)); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+
+	count, err := s.deleteMatching(func(key loop.Key) bool {
+		return key.Namespace == namespace && glob.Match(body.Pattern, key.Key)
+	})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{Message: DELETED_MATCHING_MSG, Value: count})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// lists the keys once, then deletes the matching ones a batch per event so the loop keeps
+// serving in between. keys rewritten since the listing are left alone
+func (s *Server) deleteMatching(match func(key loop.Key) bool) (int, error) {
+	event, r, e := loop.CreateKeysEvent()
+	resp, err := s.sendEvent(event, r, e)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	batch := make([]loop.Key, 0, BULK_DELETE_BATCH)
+	for _, key := range resp.Keys {
+		if !match(key) {
+			continue
+		}
+		batch = append(batch, key)
+		if len(batch) < BULK_DELETE_BATCH {
+			continue
+		}
+
+		deleted, err := s.deleteKeys(batch, resp.Version)
+		count += deleted
+		if err != nil {
+			return count, err
+		}
+		batch = batch[:0]
+	}
+	if len(batch) == 0 {
+		return count, nil
+	}
+
+	deleted, err := s.deleteKeys(batch, resp.Version)
+	return count + deleted, err
+}
+
+func (s *Server) deleteKeys(keys []loop.Key, maxVersion uint64) (int, error) {
+	event, r, e := loop.CreateDeleteKeysEvent(keys, maxVersion)
+	resp, err := s.sendEvent(event, r, e)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Count, nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func putKeys(server *Server, namespace string, keys ...string) {
+	for _, key := range keys {
+		sendRequest(server, http.MethodPut, "/v1/keys/"+key, "v", http.Header{NAMESPACE_HEADER: {namespace}})
+	}
+}
+
+func TestDeleteMatching(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	putKeys(server, "default", "user:1", "user:2", "user:10", "session:1")
+	putKeys(server, "other", "user:1")
+
+	w := sendRequest(server, http.MethodPost, "/delete-matching", `{"pattern": "user:?"}`, nil)
+
+	assert.JSONEq(t, `{"message": "Matching keys deleted", "value": 2}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, sendRequest(server, http.MethodGet, "/v1/keys/user:10", "", nil).Code)
+	assert.Equal(t, http.StatusOK, sendRequest(server, http.MethodGet, "/v1/keys/user:1", "", http.Header{NAMESPACE_HEADER: {"other"}}).Code)
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodPost, "/delete-matching", `{}`, nil).Code)
+}
+
+func TestDeleteMatchingRunsInBatches(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	keys := make([]string, 2*BULK_DELETE_BATCH+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+	putKeys(server, "default", keys...)
+
+	w := sendRequest(server, http.MethodPost, "/delete-matching", `{"pattern": "k*"}`, nil)
+
+	assert.JSONEq(t, fmt.Sprintf(`{"message": "Matching keys deleted", "value": %d}`, len(keys)), w.Body.String())
+}
+
+func TestDeleteMatchingACL(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetACL(auth.New([]auth.Credential{
+		{Name: "users", Key: "users-key", Rules: []auth.Rule{{Prefix: "user:", Ops: []string{auth.DELETE_OP}}}},
+	}))
+	header := http.Header{auth.API_KEY_HEADER: {"users-key"}}
+
+	assert.Equal(t, http.StatusOK, sendRequest(server, http.MethodPost, "/delete-matching", `{"pattern": "user:*"}`, header).Code)
+	assert.Equal(t, http.StatusForbidden, sendRequest(server, http.MethodPost, "/delete-matching", `{"pattern": "*"}`, header).Code)
+}
+
+func TestFlush(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	putKeys(server, "a", "1", "2")
+	putKeys(server, "b", "1")
+
+	w := sendRequest(server, http.MethodPost, "/flush?namespace=a", "", nil)
+	assert.JSONEq(t, `{"message": "Keys flushed", "value": 2}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, sendRequest(server, http.MethodGet, "/v1/keys/1", "", http.Header{NAMESPACE_HEADER: {"b"}}).Code)
+
+	putKeys(server, "a", "3")
+	w = sendRequest(server, http.MethodPost, "/flush", "", nil)
+	assert.JSONEq(t, `{"message": "Keys flushed", "value": 2}`, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodPost, "/flush?namespace=no%20spaces", "", nil).Code)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// the listeners and open connections of one of the tcp protocols, closed together at shutdown
+type connSet struct {
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	mux       sync.Mutex
+}
+
+// handles every connection from l on its own goroutine until the set is closed
+func (c *connSet) serve(l net.Listener, handle func(conn net.Conn)) error {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	if c.listeners == nil {
+		c.listeners = map[net.Listener]struct{}{}
+		c.conns = map[net.Conn]struct{}{}
+	}
+	c.listeners[l] = struct{}{}
+	c.mux.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !c.add(conn) {
+			conn.Close()
+			return net.ErrClosed
+		}
+		go func() {
+			defer c.remove(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// false once the set is closed
+func (c *connSet) add(conn net.Conn) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *connSet) remove(conn net.Conn) {
+	conn.Close()
+	c.mux.Lock()
+	delete(c.conns, conn)
+	c.mux.Unlock()
+}
+
+func (c *connSet) close() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.closed = true
+	for l := range c.listeners {
+		l.Close()
+	}
+	for conn := range c.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/migrate"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
+)
+
+// errors from packages that don't know about codes, errors made here carry their own
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{loop.ErrInvalidNamespace, loop.BAD_REQUEST_CODE},
+	{codec.ErrUnsupportedMediaType, loop.UNSUPPORTED_MEDIA_TYPE_CODE},
+	{auth.ErrUnauthenticated, loop.UNAUTHENTICATED_CODE},
+	{auth.ErrForbidden, loop.FORBIDDEN_CODE},
+	{data.ErrEntryTooLarge, loop.TOO_LARGE_CODE},
+	{migrate.ErrMigrationNotFound, loop.NOT_FOUND_CODE},
+	{migrate.ErrMigrationRunning, loop.CONFLICT_CODE},
+	{watch.ErrSlowConsumer, loop.OVERLOADED_CODE},
+	{track.ErrSlowConsumer, loop.OVERLOADED_CODE},
+	{store.ErrQueueFull, loop.OVERLOADED_CODE},
+}
+
+var statuses = map[string]int{
+	loop.BAD_REQUEST_CODE:            http.StatusBadRequest,
+	loop.UNAUTHENTICATED_CODE:        http.StatusUnauthorized,
+	loop.FORBIDDEN_CODE:              http.StatusForbidden,
+	loop.NOT_FOUND_CODE:              http.StatusNotFound,
+	loop.CONFLICT_CODE:               http.StatusConflict,
+	loop.PRECONDITION_FAILED_CODE:    http.StatusPreconditionFailed,
+	loop.TOO_LARGE_CODE:              http.StatusRequestEntityTooLarge,
+	loop.UNSUPPORTED_MEDIA_TYPE_CODE: http.StatusUnsupportedMediaType,
+	loop.RATE_LIMITED_CODE:           http.StatusTooManyRequests,
+	loop.OVERLOADED_CODE:             http.StatusServiceUnavailable,
+	loop.INTERNAL_CODE:               http.StatusInternalServerError,
+}
+
+func codeFor(err error) string {
+	if code, ok := loop.CodeOf(err); ok {
+		return code
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return loop.INTERNAL_CODE
+}
+
+func badRequest(err error) error {
+	return loop.WithCode(loop.BAD_REQUEST_CODE, err)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeFor(t *testing.T) {
+	assert.Equal(t, loop.CONFLICT_CODE, codeFor(fmt.Errorf("resume: %w", migrate.ErrMigrationRunning)))
+	assert.Equal(t, loop.RATE_LIMITED_CODE, codeFor(ErrRateLimited))
+	assert.Equal(t, loop.INTERNAL_CODE, codeFor(fmt.Errorf("something else")))
+}
+
+func TestMalformedBodyIsBadRequest(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	w := sendRequest(server, http.MethodPost, "/set", "{not json", nil)
+
+	var resp Response
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, loop.BAD_REQUEST_CODE, resp.Code)
+}
+
+func TestMissIsNotFound(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	w := sendRequest(server, http.MethodPost, "/get", `{"key": "missing"}`, nil)
+
+	var resp Response
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, loop.NOT_FOUND_CODE, resp.Code)
+}
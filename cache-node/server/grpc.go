@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/cachepb"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// the ErrorInfo domain on failed calls, the reason is the error code
+const GRPC_ERROR_DOMAIN = "cache"
+
+var ErrEmptyOperation = badRequest(fmt.Errorf("every operation needs a get, set or delete"))
+
+var grpcCodes = map[string]codes.Code{
+	loop.BAD_REQUEST_CODE:            codes.InvalidArgument,
+	loop.UNAUTHENTICATED_CODE:        codes.Unauthenticated,
+	loop.FORBIDDEN_CODE:              codes.PermissionDenied,
+	loop.NOT_FOUND_CODE:              codes.NotFound,
+	loop.CONFLICT_CODE:               codes.Aborted,
+	loop.PRECONDITION_FAILED_CODE:    codes.FailedPrecondition,
+	loop.TOO_LARGE_CODE:              codes.ResourceExhausted,
+	loop.UNSUPPORTED_MEDIA_TYPE_CODE: codes.InvalidArgument,
+	loop.RATE_LIMITED_CODE:           codes.ResourceExhausted,
+	loop.OVERLOADED_CODE:             codes.Unavailable,
+	loop.INTERNAL_CODE:               codes.Internal,
+}
+
+var watchChangeTypes = map[string]cachepb.WatchEvent_Type{
+	loop.SET_CHANGE:    cachepb.WatchEvent_TYPE_SET,
+	loop.DELETE_CHANGE: cachepb.WatchEvent_TYPE_DELETE,
+	loop.EXPIRE_CHANGE: cachepb.WatchEvent_TYPE_EXPIRE,
+}
+
+// each call becomes a wire request, so grpc shares the wire protocol's checks and mapping onto events
+type grpcCache struct {
+	cachepb.UnimplementedCacheServer
+	server *Server
+}
+
+// serves grpc on addr next to http, must be called before Run
+func (s *Server) ListenGRPC(addr string) {
+	s.grpcAddr = addr
+}
+
+func (s *Server) runGRPC() {
+	listener, err := net.Listen("tcp", s.grpcAddr)
+	if err != nil {
+		log.Printf("Couldn't listen on '%s': %s", s.grpcAddr, err)
+		return
+	}
+
+	log.Printf("gRPC listening on '%v'", s.grpcAddr)
+	s.ServeGRPC(listener)
+}
+
+// serves the Cache service on connections from l until the server shuts down
+func (s *Server) ServeGRPC(l net.Listener) error {
+	options := []grpc.ServerOption{}
+	if s.useTLS {
+		options = append(options, grpc.Creds(credentials.NewTLS(grpcTLSConfig(s.Server.TLSConfig))))
+	}
+	g := grpc.NewServer(options...)
+	cachepb.RegisterCacheServer(g, &grpcCache{server: s})
+
+	s.grpcMux.Lock()
+	if s.grpcClosed {
+		s.grpcMux.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.grpcServers = append(s.grpcServers, g)
+	s.grpcMux.Unlock()
+
+	return g.Serve(l)
+}
+
+// watch streams never finish on their own, so there's nothing to wait for
+func (s *Server) closeGRPC() {
+	s.grpcMux.Lock()
+	defer s.grpcMux.Unlock()
+
+	s.grpcClosed = true
+	for _, g := range s.grpcServers {
+		g.Stop()
+	}
+}
+
+// the node's config picks a certificate per connection, grpc also needs h2 offered on each
+func grpcTLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	if base.GetConfigForClient == nil {
+		return config
+	}
+
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := base.GetConfigForClient(hello)
+		if c == nil || err != nil {
+			return c, err
+		}
+		c = c.Clone()
+		c.NextProtos = []string{"h2"}
+		return c, nil
+	}
+	return config
+}
+
+func (g *grpcCache) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	resp := g.do(ctx, wire.Request{Op: wire.OP_GET, Namespace: req.GetNamespace(), Key: req.GetKey()})
+	if resp.Code != "" {
+		return nil, grpcError(resp.Code, resp.Error)
+	}
+
+	return &cachepb.GetResponse{Item: grpcItem(resp)}, nil
+}
+
+func (g *grpcCache) Set(ctx context.Context, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+	resp := g.do(ctx, wireSet(req))
+	if resp.Code != "" {
+		return nil, grpcError(resp.Code, resp.Error)
+	}
+
+	return &cachepb.SetResponse{Version: resp.Version}, nil
+}
+
+func (g *grpcCache) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	resp := g.do(ctx, wireDelete(req))
+	if resp.Code != "" {
+		return nil, grpcError(resp.Code, resp.Error)
+	}
+
+	return &cachepb.DeleteResponse{}, nil
+}
+
+func (g *grpcCache) Batch(ctx context.Context, req *cachepb.BatchRequest) (*cachepb.BatchResponse, error) {
+	if len(req.GetOperations()) > wire.MAX_BATCH_SIZE {
+		return nil, grpcError(loop.BAD_REQUEST_CODE, wire.ErrBatchTooLarge.Error())
+	}
+
+	batch := wire.Request{Op: wire.OP_BATCH, Batch: make([]wire.Request, 0, len(req.GetOperations()))}
+	for _, op := range req.GetOperations() {
+		switch {
+		case op.GetGet() != nil:
+			batch.Batch = append(batch.Batch, wire.Request{Op: wire.OP_GET, Namespace: op.GetGet().GetNamespace(), Key: op.GetGet().GetKey()})
+		case op.GetSet() != nil:
+			batch.Batch = append(batch.Batch, wireSet(op.GetSet()))
+		case op.GetDelete() != nil:
+			batch.Batch = append(batch.Batch, wireDelete(op.GetDelete()))
+		default:
+			return nil, grpcError(loop.BAD_REQUEST_CODE, ErrEmptyOperation.Error())
+		}
+	}
+
+	resp := g.do(ctx, batch)
+	results := make([]*cachepb.Result, len(resp.Batch))
+	for i, r := range resp.Batch {
+		results[i] = grpcResult(r)
+	}
+	return &cachepb.BatchResponse{Results: results}, nil
+}
+
+func (g *grpcCache) Watch(req *cachepb.WatchRequest, stream cachepb.Cache_WatchServer) error {
+	ctx := stream.Context()
+	filter := watch.Filter{Namespace: req.GetNamespace(), Key: req.GetKey(), Prefix: req.GetPrefix()}
+	watcher, complete, err := g.server.watch(grpcApiKey(ctx), grpcRemoteAddr(ctx), filter, req.GetAfterSeq())
+	if err != nil {
+		return grpcError(codeFor(err), err.Error())
+	}
+	defer watcher.Close()
+	// tells the client every change from here on will reach it
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	if !complete {
+		if err := stream.Send(&cachepb.WatchEvent{Seq: watcher.Start(), Type: cachepb.WatchEvent_TYPE_RESET}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case change, ok := <-watcher.Changes():
+			if !ok {
+				err := watcher.Err()
+				return grpcError(codeFor(err), err.Error())
+			}
+			if err := stream.Send(grpcWatchEvent(change)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (g *grpcCache) do(ctx context.Context, req wire.Request) wire.Response {
+	return g.server.handleWire(grpcApiKey(ctx), grpcRemoteAddr(ctx), req)
+}
+
+func wireSet(req *cachepb.SetRequest) wire.Request {
+	return wire.Request{
+		Op:          wire.OP_SET,
+		Namespace:   req.GetNamespace(),
+		Key:         req.GetKey(),
+		Value:       req.GetValue(),
+		ContentType: req.GetContentType(),
+		Flags:       req.GetFlags(),
+		TTL:         req.GetTtlSeconds(),
+		Version:     req.GetIfVersion(),
+	}
+}
+
+func wireDelete(req *cachepb.DeleteRequest) wire.Request {
+	return wire.Request{Op: wire.OP_DELETE, Namespace: req.GetNamespace(), Key: req.GetKey(), Version: req.GetIfVersion()}
+}
+
+func grpcItem(resp wire.Response) *cachepb.Item {
+	return &cachepb.Item{
+		Value:       resp.Value,
+		ContentType: resp.ContentType,
+		Flags:       resp.Flags,
+		Version:     resp.Version,
+	}
+}
+
+func grpcResult(resp wire.Response) *cachepb.Result {
+	if resp.Code != "" {
+		return &cachepb.Result{Result: &cachepb.Result_Error{Error: &cachepb.Error{Code: resp.Code, Message: resp.Error}}}
+	}
+
+	switch resp.Op {
+	case wire.OP_GET:
+		return &cachepb.Result{Result: &cachepb.Result_Get{Get: &cachepb.GetResponse{Item: grpcItem(resp)}}}
+	case wire.OP_SET:
+		return &cachepb.Result{Result: &cachepb.Result_Set{Set: &cachepb.SetResponse{Version: resp.Version}}}
+	default:
+		return &cachepb.Result{Result: &cachepb.Result_Delete{Delete: &cachepb.DeleteResponse{}}}
+	}
+}
+
+func grpcWatchEvent(change loop.Change) *cachepb.WatchEvent {
+	event := &cachepb.WatchEvent{
+		Seq:       change.Seq,
+		Type:      watchChangeTypes[change.Type],
+		Namespace: change.Namespace,
+		Key:       change.Key,
+	}
+	if change.Type == loop.SET_CHANGE {
+		event.Item = &cachepb.Item{
+			Value:       change.Entry.Value,
+			ContentType: change.Entry.ContentType,
+			Flags:       change.Entry.Flags,
+			Version:     change.Entry.Version,
+		}
+	}
+
+	return event
+}
+
+// the status code loses detail, so the cache's own code goes along as the ErrorInfo reason
+func grpcError(code string, message string) error {
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Internal
+	}
+
+	st := status.New(c, message)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: GRPC_ERROR_DOMAIN}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// the same credentials as the http headers, as metadata
+func grpcApiKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(strings.ToLower(auth.API_KEY_HEADER)); len(keys) > 0 && keys[0] != "" {
+		return keys[0]
+	}
+	for _, value := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(value, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	return ""
+}
+
+func grpcRemoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+
+	return ""
+}
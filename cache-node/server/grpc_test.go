@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/cachepb"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func createGRPCClient(t *testing.T) (*Server, cachepb.CacheClient) {
+	namespaces := data.NewNamespaces[string, loop.Entry](data.Options{}, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	hub := watch.NewHub()
+	eventLoop.SetPublisher(hub)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+
+	server := New(eventLoop, ":8080", nil)
+	server.SetWatchHub(hub)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeGRPC(l)
+	t.Cleanup(server.closeGRPC)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, cachepb.NewCacheClient(conn)
+}
+
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestGRPCSetAndGet(t *testing.T) {
+	_, c := createGRPCClient(t)
+	ctx := context.Background()
+
+	set, err := c.Set(ctx, &cachepb.SetRequest{Namespace: "ns", Key: "key", Value: []byte{0, 255}, Flags: 3})
+	assert.Nil(t, err)
+
+	get, err := c.Get(ctx, &cachepb.GetRequest{Namespace: "ns", Key: "key"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 255}, get.Item.Value)
+	assert.Equal(t, DEFAULT_CONTENT_TYPE, get.Item.ContentType)
+	assert.Equal(t, set.Version, get.Item.Version)
+
+	_, err = c.Delete(ctx, &cachepb.DeleteRequest{Namespace: "ns", Key: "key"})
+	assert.Nil(t, err)
+	_, err = c.Get(ctx, &cachepb.GetRequest{Namespace: "ns", Key: "key"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, loop.NOT_FOUND_CODE, errorReason(err))
+}
+
+func TestGRPCBatch(t *testing.T) {
+	_, c := createGRPCClient(t)
+
+	resp, err := c.Batch(context.Background(), &cachepb.BatchRequest{Operations: []*cachepb.Operation{
+		{Op: &cachepb.Operation_Set{Set: &cachepb.SetRequest{Key: "key", Value: []byte("value")}}},
+		{Op: &cachepb.Operation_Get{Get: &cachepb.GetRequest{Key: "key"}}},
+		{Op: &cachepb.Operation_Get{Get: &cachepb.GetRequest{Key: "missing"}}},
+	}})
+
+	assert.Nil(t, err)
+	assert.NotZero(t, resp.Results[0].GetSet().Version)
+	assert.Equal(t, []byte("value"), resp.Results[1].GetGet().Item.Value)
+	assert.Equal(t, loop.NOT_FOUND_CODE, resp.Results[2].GetError().Code)
+
+	_, err = c.Batch(context.Background(), &cachepb.BatchRequest{Operations: []*cachepb.Operation{{}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCAuth(t *testing.T) {
+	server, c := createGRPCClient(t)
+	server.SetACL(auth.New([]auth.Credential{
+		{Name: "reader", Key: "reader-key", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}},
+	}))
+
+	_, err := c.Get(context.Background(), &cachepb.GetRequest{Key: "key"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer reader-key")
+	_, err = c.Set(ctx, &cachepb.SetRequest{Key: "key"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "reader-key")
+	_, err = c.Get(ctx, &cachepb.GetRequest{Key: "key"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCWatch(t *testing.T) {
+	_, c := createGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Watch(ctx, &cachepb.WatchRequest{Prefix: "user:"})
+	assert.Nil(t, err)
+	// the watcher is registered once the stream has its headers
+	_, err = stream.Header()
+	assert.Nil(t, err)
+
+	c.Set(ctx, &cachepb.SetRequest{Key: "session:1", Value: []byte("ignored")})
+	c.Set(ctx, &cachepb.SetRequest{Key: "user:1", Value: []byte("value")})
+	c.Delete(ctx, &cachepb.DeleteRequest{Key: "user:1"})
+
+	set, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, cachepb.WatchEvent_TYPE_SET, set.Type)
+	assert.Equal(t, "user:1", set.Key)
+	assert.Equal(t, []byte("value"), set.Item.Value)
+
+	del, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, cachepb.WatchEvent_TYPE_DELETE, del.Type)
+	assert.Greater(t, del.Seq, set.Seq)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/brendenehlers/go-distributed-cache/cache-node/config"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
 )
 
@@ -8,4 +9,10 @@ type EventLoop interface {
 	Run()
 	Send(event *loop.CacheEvent)
 	Stop()
+	// events queued and not yet handled
+	Pending() int
+}
+
+type Reloader interface {
+	Reload() (config.ReloadResult, error)
 }
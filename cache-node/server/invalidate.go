@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/migrate"
+)
+
+const (
+	INVALIDATED_MSG = "Tag invalidated"
+
+	INTERNAL_INVALIDATE_PATH = "/internal/invalidate"
+)
+
+var ErrMissingTag = badRequest(fmt.Errorf("tag can't be empty"))
+
+// Failed lists the nodes that couldn't be reached, their keys still carry the tag
+type InvalidateResult struct {
+	Deleted int      `json:"deleted"`
+	Nodes   int      `json:"nodes"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+type invalidateResponse struct {
+	Message string `json:"message"`
+	Value   int    `json:"value"`
+}
+
+// deletes the keys carrying ?tag= in the request's namespace on every node in the registry
+func (s *Server) InvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, err := namespaceFor(r, RequestBody{})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	// a tag can cover any key, so it takes delete on the whole namespace
+	if err := s.authorize(r, auth.DELETE_OP, namespace, ""); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		writeErrorResponse(w, r, ErrMissingTag)
+		return
+	}
+
+	result, err := s.invalidateEverywhere(namespace, tag)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{Message: INVALIDATED_MSG, Value: result})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// the fanned out half of InvalidateHandler, only deletes this node's keys
+func (s *Server) InternalInvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	if err := loop.ValidateNamespace(namespace); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	tag := query.Get("tag")
+	if tag == "" {
+		writeErrorResponse(w, r, ErrMissingTag)
+		return
+	}
+
+	count, err := s.invalidate(namespace, tag)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{Message: INVALIDATED_MSG, Value: count})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// a node that can't be reached is reported rather than failing the others,
+// only losing the registry fails the whole invalidation
+func (s *Server) invalidateEverywhere(namespace string, tag string) (InvalidateResult, error) {
+	var peers []string
+	if len(s.registryUrls) > 0 {
+		nodes, err := s.listNodes()
+		if err != nil {
+			return InvalidateResult{}, err
+		}
+		self := s.advertisedUrl()
+		for _, node := range nodes {
+			if node != self {
+				peers = append(peers, node)
+			}
+		}
+	}
+
+	deleted, err := s.invalidate(namespace, tag)
+	if err != nil {
+		return InvalidateResult{}, err
+	}
+	result := InvalidateResult{Deleted: deleted, Nodes: 1 + len(peers)}
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count, err := s.invalidatePeer(peer, namespace, tag)
+
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				log.Printf("Couldn't invalidate tag '%s' on '%s': %s", tag, peer, err)
+				result.Failed = append(result.Failed, peer)
+				return
+			}
+			result.Deleted += count
+		}()
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+func (s *Server) invalidate(namespace string, tag string) (int, error) {
+	event, r, e := loop.CreateInvalidateEvent(namespace, tag)
+	resp, err := s.sendEvent(event, r, e)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Count, nil
+}
+
+func (s *Server) invalidatePeer(node string, namespace string, tag string) (int, error) {
+	query := url.Values{"namespace": {namespace}, "tag": {tag}}
+	resp, err := s.client.Post(migrate.NodeUrl(node, INTERNAL_INVALIDATE_PATH+"?"+query.Encode()), "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var body invalidateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+
+	return body.Value, nil
+}
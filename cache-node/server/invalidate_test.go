@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func tagged(tags string) http.Header {
+	return http.Header{TAGS_HEADER: {tags}}
+}
+
+func TestTagsRoundTrip(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	put := sendRequest(server, http.MethodPut, "/v1/keys/k", "v", tagged("product:1, list"))
+	assert.Equal(t, http.StatusNoContent, put.Code)
+	assert.Equal(t, "product:1,list", put.Header().Get(TAGS_HEADER))
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, nil)
+	assert.Contains(t, get.Body.String(), `"tags":["product:1","list"]`)
+
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodPut, "/v1/keys/k", "v", tagged("a,,b")).Code)
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodPost, "/set", `{"key": "k", "value": 1, "tags": ["a,b"]}`, nil).Code)
+}
+
+func TestInvalidateFansOut(t *testing.T) {
+	local := createServerWithCache(t, data.Options{})
+	peer := createServerWithCache(t, data.Options{})
+	ts := httptest.NewServer(peer.Handler)
+	t.Cleanup(ts.Close)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the local node is skipped, the unreachable one is reported
+		json.NewEncoder(w).Encode(nodesResponse{Urls: []string{local.advertisedUrl(), ts.URL, "127.0.0.1:1"}})
+	}))
+	t.Cleanup(registry.Close)
+	local.registryUrls = []string{registry.URL}
+
+	for _, server := range []*Server{local, peer} {
+		sendRequest(server, http.MethodPut, "/v1/keys/a", "v", tagged("product:1"))
+		sendRequest(server, http.MethodPut, "/v1/keys/b", "v", tagged("product:2,product:1"))
+		sendRequest(server, http.MethodPut, "/v1/keys/c", "v", tagged("product:2"))
+	}
+
+	w := sendRequest(local, http.MethodPost, "/invalidate?tag=product:1", "", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message": "Tag invalidated", "value": {"deleted": 4, "nodes": 3, "failed": ["127.0.0.1:1"]}}`, w.Body.String())
+	for _, server := range []*Server{local, peer} {
+		assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/b", "", nil).Code)
+		assert.Equal(t, http.StatusOK, sendRequest(server, http.MethodGet, "/v1/keys/c", "", nil).Code)
+	}
+}
+
+func TestInvalidateWithoutRegistryStaysLocal(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPut, "/v1/keys/a", "v", tagged("t"))
+
+	w := sendRequest(server, http.MethodPost, "/invalidate?tag=t", "", nil)
+
+	assert.JSONEq(t, `{"message": "Tag invalidated", "value": {"deleted": 1, "nodes": 1}}`, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodPost, "/invalidate", "", nil).Code)
+}
+
+func TestInvalidateFailsWithoutRegistry(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.registryUrls = []string{"http://127.0.0.1:1"}
+
+	w := sendRequest(server, http.MethodPost, "/invalidate?tag=t", "", nil)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	// whole seconds, on writes it sets the ttl and on reads it's what's left of it
+	TTL_HEADER = "X-Cache-Ttl"
+	// the same for when the entry goes stale, it has to be shorter than the ttl
+	SOFT_TTL_HEADER = "X-Cache-Soft-Ttl"
+	// true on reads of an entry that's past its soft ttl
+	STALE_HEADER = "X-Cache-Stale"
+	// whole milliseconds the value took to compute, set on writes so reads refresh it early
+	COST_HEADER = "X-Cache-Cost"
+	// true on reads picked to refresh the entry before it goes stale or expires
+	REFRESH_HEADER = "X-Cache-Refresh"
+	FLAGS_HEADER   = "X-Cache-Flags"
+	// comma separated
+	TAGS_HEADER = "X-Cache-Tags"
+
+	DEFAULT_CONTENT_TYPE = "application/octet-stream"
+	JSON_CONTENT_TYPE    = "application/json"
+)
+
+var (
+	ErrInvalidTTL     = badRequest(fmt.Errorf("%s must be a positive number of seconds", TTL_HEADER))
+	ErrInvalidSoftTTL = badRequest(fmt.Errorf("%s must be a positive number of seconds shorter than %s", SOFT_TTL_HEADER, TTL_HEADER))
+	ErrInvalidCost    = badRequest(fmt.Errorf("%s must be a positive number of milliseconds", COST_HEADER))
+	ErrInvalidFlags   = badRequest(fmt.Errorf("%s must be an unsigned 32 bit integer", FLAGS_HEADER))
+	ErrMissingKey     = badRequest(fmt.Errorf("key can't be empty"))
+	ErrInvalidTag     = badRequest(fmt.Errorf("tags can't be empty or contain commas"))
+	ErrBodyTooLarge   = loop.WithCode(loop.TOO_LARGE_CODE, fmt.Errorf("body is larger than the max entry size"))
+)
+
+// handles HEAD too. the body is the value exactly as it was set
+func (s *Server) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, key, err := keyFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.READ_OP, namespace, key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+
+	s.trackRead(w, r, namespace, key)
+	resp, err := s.handleGetEvent(namespace, key)
+	if err == nil {
+		resp, err = s.readThrough(namespace, key, resp)
+	}
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !resp.Ok {
+		writeErrorResponse(w, r, ErrValueNotFound)
+		return
+	}
+
+	writeEntryHeaders(w, resp)
+	if matchesAny(r.Header.Get("If-None-Match"), resp.Version, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	contentType := resp.ContentType
+	if contentType == "" {
+		contentType = DEFAULT_CONTENT_TYPE
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Value)))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(resp.Value)
+}
+
+// stores the body as is. json bodies have to be valid so /get can return them as json
+func (s *Server) PutKeyHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	namespace, key, err := keyFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.WRITE_OP, namespace, key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+
+	ttl, softTTL, err := ttlsFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	cost, err := costFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	flags, err := flagsFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	tags, err := tagsFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	precondition, err := preconditionFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	if limit := s.maxEntryBytes.Load(); limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorResponse(w, r, ErrBodyTooLarge)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, r, badRequest(err))
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = DEFAULT_CONTENT_TYPE
+	}
+	if isJSON(contentType) && !json.Valid(body) {
+		writeErrorResponse(w, r, badRequest(fmt.Errorf("body isn't valid json")))
+		return
+	}
+
+	event, respChan, errChan := loop.CreateSetEvent(namespace, key, body)
+	event.ContentType = contentType
+	event.Flags = flags
+	event.Tags = tags
+	event.TTL = ttl
+	event.SoftTTL = softTTL
+	event.Cost = cost
+	event.Precondition = precondition
+	resp, err := s.sendEvent(event, respChan, errChan)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	writeEntryHeaders(w, resp)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) DeleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, key, err := keyFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.DELETE_OP, namespace, key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+
+	precondition, err := preconditionFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	event, respChan, errChan := loop.CreateDeleteEvent(namespace, key)
+	event.Precondition = precondition
+	resp, err := s.sendEvent(event, respChan, errChan)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !resp.Ok {
+		writeErrorResponse(w, r, ErrValueNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the key is the rest of the path, so it can contain slashes
+func keyFor(r *http.Request) (string, string, error) {
+	namespace, err := namespaceFor(r, RequestBody{})
+	if err != nil {
+		return "", "", err
+	}
+	key := r.PathValue("key")
+	if key == "" {
+		return "", "", ErrMissingKey
+	}
+
+	return namespace, key, nil
+}
+
+// the ttl and soft ttl, either is 0 when it isn't set
+func ttlsFor(r *http.Request) (time.Duration, time.Duration, error) {
+	ttl, ok := secondsFor(r, TTL_HEADER)
+	if !ok {
+		return 0, 0, ErrInvalidTTL
+	}
+	softTTL, ok := secondsFor(r, SOFT_TTL_HEADER)
+	if !ok || (ttl > 0 && softTTL >= ttl) {
+		return 0, 0, ErrInvalidSoftTTL
+	}
+
+	return ttl, softTTL, nil
+}
+
+func secondsFor(r *http.Request, name string) (time.Duration, bool) {
+	header := r.Header.Get(name)
+	if header == "" {
+		return 0, true
+	}
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+func costFor(r *http.Request) (time.Duration, error) {
+	header := r.Header.Get(COST_HEADER)
+	if header == "" {
+		return 0, nil
+	}
+	millis, err := strconv.Atoi(header)
+	if err != nil || millis <= 0 || millis > math.MaxInt64/int(time.Millisecond) {
+		return 0, ErrInvalidCost
+	}
+
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+func flagsFor(r *http.Request) (uint32, error) {
+	header := r.Header.Get(FLAGS_HEADER)
+	if header == "" {
+		return 0, nil
+	}
+	flags, err := strconv.ParseUint(header, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidFlags
+	}
+
+	return uint32(flags), nil
+}
+
+func tagsFor(r *http.Request) ([]string, error) {
+	header := r.Header.Get(TAGS_HEADER)
+	if header == "" {
+		return nil, nil
+	}
+	tags := strings.Split(header, ",")
+	for i := range tags {
+		tags[i] = strings.TrimSpace(tags[i])
+	}
+
+	return tags, validateTags(tags)
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || strings.Contains(tag, ",") {
+			return ErrInvalidTag
+		}
+	}
+
+	return nil
+}
+
+// If-Match takes * or a list of etags. If-None-Match only takes *, to create a key
+// that doesn't exist yet
+func preconditionFor(r *http.Request) (loop.Precondition, error) {
+	var precondition loop.Precondition
+	if r.Header.Get("If-None-Match") == "*" {
+		precondition.Absent = true
+	}
+
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return precondition, nil
+	}
+	if match == "*" {
+		precondition.Exists = true
+		return precondition, nil
+	}
+	for _, tag := range strings.Split(match, ",") {
+		// If-Match only uses strong comparison, so weak tags never match
+		if version, ok := parseETag(tag, false); ok {
+			precondition.Versions = append(precondition.Versions, version)
+		}
+	}
+	if len(precondition.Versions) == 0 {
+		// none of the tags came from this node
+		return precondition, loop.ErrVersionMismatch
+	}
+
+	return precondition, nil
+}
+
+func matchesAny(header string, version uint64, weak bool) bool {
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if v, ok := parseETag(tag, weak); ok && v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseETag(tag string, weak bool) (uint64, bool) {
+	tag = strings.TrimSpace(tag)
+	if strings.HasPrefix(tag, "W/") {
+		if !weak {
+			return 0, false
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+	}
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+func writeEntryHeaders(w http.ResponseWriter, resp loop.CacheEventResponse) {
+	w.Header().Set("ETag", etag(resp.Version))
+	if resp.Flags != 0 {
+		w.Header().Set(FLAGS_HEADER, strconv.FormatUint(uint64(resp.Flags), 10))
+	}
+	if len(resp.Tags) > 0 {
+		w.Header().Set(TAGS_HEADER, strings.Join(resp.Tags, ","))
+	}
+	if !resp.ExpiresAt.IsZero() {
+		w.Header().Set(TTL_HEADER, secondsUntil(resp.ExpiresAt))
+	}
+	if resp.Stale {
+		w.Header().Set(STALE_HEADER, "true")
+	} else if !resp.StaleAt.IsZero() {
+		w.Header().Set(SOFT_TTL_HEADER, secondsUntil(resp.StaleAt))
+	}
+	if resp.Refresh {
+		w.Header().Set(REFRESH_HEADER, "true")
+	}
+}
+
+// rounded up so the header never reads 0 before the time has passed
+func secondsUntil(t time.Time) string {
+	return strconv.Itoa(max(1, int(math.Ceil(time.Until(t).Seconds()))))
+}
+
+// a json value goes in a response's value only when the encoder writes it back unchanged,
+// anything it would compact goes in data instead so it's still returned byte for byte
+func jsonValue(contentType string, value []byte) (json.RawMessage, bool) {
+	if !isJSON(contentType) || !json.Valid(value) {
+		return nil, false
+	}
+
+	compact := bytes.NewBuffer(make([]byte, 0, len(value)))
+	if err := json.Compact(compact, value); err != nil || !bytes.Equal(compact.Bytes(), value) {
+		return nil, false
+	}
+
+	return json.RawMessage(value), true
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == JSON_CONTENT_TYPE || strings.HasSuffix(mediaType, "+json")
+}
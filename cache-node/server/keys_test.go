@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutAndGetKey(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	put := sendRequest(server, http.MethodPut, "/v1/keys/a/b", "raw\x00bytes", http.Header{"Content-Type": {"text/plain"}})
+	assert.Equal(t, http.StatusNoContent, put.Code)
+	assert.NotEmpty(t, put.Header().Get("ETag"))
+
+	get := sendRequest(server, http.MethodGet, "/v1/keys/a/b", "", nil)
+	assert.Equal(t, http.StatusOK, get.Code)
+	assert.Equal(t, "raw\x00bytes", get.Body.String())
+	assert.Equal(t, "text/plain", get.Header().Get("Content-Type"))
+	assert.Equal(t, put.Header().Get("ETag"), get.Header().Get("ETag"))
+
+	head := sendRequest(server, http.MethodHead, "/v1/keys/a/b", "", nil)
+	assert.Equal(t, http.StatusOK, head.Code)
+	assert.Equal(t, "9", head.Header().Get("Content-Length"))
+	assert.Empty(t, head.Body.String())
+}
+
+func TestGetKeySetThroughRPC(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPost, "/set", `{"key": "key", "value": {"a": 1}}`, nil)
+
+	get := sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil)
+
+	assert.Equal(t, `{"a": 1}`, get.Body.String())
+	assert.Equal(t, JSON_CONTENT_TYPE, get.Header().Get("Content-Type"))
+}
+
+func TestPutJSONKeyIsReadableThroughRPC(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPut, "/v1/keys/key", `{"a":1}`, http.Header{"Content-Type": {JSON_CONTENT_TYPE}})
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "key"}`, nil)
+
+	assert.JSONEq(t, `{"message": "Value found", "value": {"a": 1}, "content_type": "application/json"}`, get.Body.String())
+
+	invalid := sendRequest(server, http.MethodPut, "/v1/keys/key", `{not json`, http.Header{"Content-Type": {JSON_CONTENT_TYPE}})
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestJSONKeysAreReturnedByteForByte(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	header := http.Header{"Content-Type": {JSON_CONTENT_TYPE}}
+	sendRequest(server, http.MethodPut, "/v1/keys/compact", `{"a":"<b> & </b>"}`, header)
+	sendRequest(server, http.MethodPut, "/v1/keys/spaced", "{\n  \"a\": \"<b> & </b>\"\n}", header)
+
+	compact := sendRequest(server, http.MethodPost, "/get", `{"key": "compact"}`, nil)
+	spaced := sendRequest(server, http.MethodPost, "/get", `{"key": "spaced"}`, nil)
+
+	assert.Contains(t, compact.Body.String(), `"value":{"a":"<b> & </b>"}`)
+	var resp Response
+	assert.Nil(t, json.Unmarshal(spaced.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Value)
+	assert.Equal(t, "{\n  \"a\": \"<b> & </b>\"\n}", string(resp.Data))
+}
+
+func TestGetMissingKey(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/missing", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodDelete, "/v1/keys/missing", "", nil).Code)
+}
+
+func TestIfMatch(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	put := sendRequest(server, http.MethodPut, "/v1/keys/key", "first", nil)
+	tag := put.Header().Get("ETag")
+
+	stale := sendRequest(server, http.MethodPut, "/v1/keys/key", "second", http.Header{"If-Match": {`"1"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, stale.Code)
+
+	foreign := sendRequest(server, http.MethodPut, "/v1/keys/key", "second", http.Header{"If-Match": {`"not ours"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, foreign.Code)
+
+	weak := sendRequest(server, http.MethodPut, "/v1/keys/key", "second", http.Header{"If-Match": {"W/" + tag}})
+	assert.Equal(t, http.StatusPreconditionFailed, weak.Code)
+
+	current := sendRequest(server, http.MethodPut, "/v1/keys/key", "second", http.Header{"If-Match": {`"1", ` + tag}})
+	assert.Equal(t, http.StatusNoContent, current.Code)
+	assert.NotEqual(t, tag, current.Header().Get("ETag"))
+
+	del := sendRequest(server, http.MethodDelete, "/v1/keys/key", "", http.Header{"If-Match": {tag}})
+	assert.Equal(t, http.StatusPreconditionFailed, del.Code)
+
+	del = sendRequest(server, http.MethodDelete, "/v1/keys/key", "", http.Header{"If-Match": {current.Header().Get("ETag")}})
+	assert.Equal(t, http.StatusNoContent, del.Code)
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	create := http.Header{"If-None-Match": {"*"}}
+
+	assert.Equal(t, http.StatusNoContent, sendRequest(server, http.MethodPut, "/v1/keys/key", "first", create).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, sendRequest(server, http.MethodPut, "/v1/keys/key", "second", create).Code)
+
+	tag := sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil).Header().Get("ETag")
+	get := sendRequest(server, http.MethodGet, "/v1/keys/key", "", http.Header{"If-None-Match": {"W/" + tag}})
+	assert.Equal(t, http.StatusNotModified, get.Code)
+	assert.Empty(t, get.Body.String())
+}
+
+func TestTTLHeader(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	put := sendRequest(server, http.MethodPut, "/v1/keys/key", "value", http.Header{TTL_HEADER: {"60"}})
+	assert.Equal(t, "60", put.Header().Get(TTL_HEADER))
+
+	get := sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil)
+	assert.Equal(t, "60", get.Header().Get(TTL_HEADER))
+
+	invalid := sendRequest(server, http.MethodPut, "/v1/keys/key", "value", http.Header{TTL_HEADER: {"-1"}})
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestSoftTTLHeader(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	put := sendRequest(server, http.MethodPut, "/v1/keys/key", "value", http.Header{TTL_HEADER: {"60"}, SOFT_TTL_HEADER: {"10"}})
+	assert.Equal(t, "10", put.Header().Get(SOFT_TTL_HEADER))
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "key"}`, nil)
+	assert.Equal(t, "10", get.Header().Get(SOFT_TTL_HEADER))
+	assert.Equal(t, "", get.Header().Get(STALE_HEADER))
+
+	invalid := sendRequest(server, http.MethodPost, "/set", `{"key": "key", "data": ""}`, http.Header{TTL_HEADER: {"10"}, SOFT_TTL_HEADER: {"10"}})
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestCostHeader(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	// a cost of decades on a minute's ttl puts every read inside the head start
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "value", http.Header{TTL_HEADER: {"60"}, COST_HEADER: {"1000000000000"}})
+	sendRequest(server, http.MethodPut, "/v1/keys/free", "value", http.Header{TTL_HEADER: {"60"}})
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "key"}`, nil)
+	assert.Equal(t, "true", get.Header().Get(REFRESH_HEADER))
+	assert.Contains(t, get.Body.String(), `"refresh":true`)
+	free := sendRequest(server, http.MethodGet, "/v1/keys/free", "", nil)
+	assert.Equal(t, "", free.Header().Get(REFRESH_HEADER))
+
+	invalid := sendRequest(server, http.MethodPost, "/set", `{"key": "key", "data": ""}`, http.Header{COST_HEADER: {"-1"}})
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestSetIfMatch(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	etag := sendRequest(server, http.MethodPut, "/v1/keys/key", "a", nil).Header().Get("ETag")
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "b", nil)
+
+	stale := sendRequest(server, http.MethodPost, "/set", `{"key": "key", "data": "Yw=="}`, http.Header{"If-Match": {etag}})
+
+	assert.Equal(t, http.StatusPreconditionFailed, stale.Code)
+	assert.Equal(t, "b", sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil).Body.String())
+}
+
+func TestKeysUseNamespaceHeader(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "a", http.Header{NAMESPACE_HEADER: {"a"}})
+
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil).Code)
+	assert.Equal(t, "a", sendRequest(server, http.MethodGet, "/v1/keys/key", "", http.Header{NAMESPACE_HEADER: {"a"}}).Body.String())
+}
+
+func TestValuesComeBackUntouched(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPost, "/set", `{"key": "number", "value": 12345678901234567890}`, nil)
+	sendRequest(server, http.MethodPost, "/set", `{"key": "bytes", "data": "AP8=", "content_type": "image/png", "flags": 9}`, nil)
+
+	number := sendRequest(server, http.MethodPost, "/get", `{"key": "number"}`, nil)
+	assert.Contains(t, number.Body.String(), `"value":12345678901234567890,`)
+
+	raw := sendRequest(server, http.MethodGet, "/v1/keys/bytes", "", nil)
+	assert.Equal(t, "\x00\xff", raw.Body.String())
+	assert.Equal(t, "image/png", raw.Header().Get("Content-Type"))
+	assert.Equal(t, "9", raw.Header().Get(FLAGS_HEADER))
+
+	sendRequest(server, http.MethodPut, "/v1/keys/bytes", "\x01", http.Header{FLAGS_HEADER: {"4"}})
+	encoded := sendRequest(server, http.MethodPost, "/get", `{"key": "bytes"}`, nil)
+	assert.JSONEq(t, `{"message": "Value found", "data": "AQ==", "content_type": "application/octet-stream", "flags": 4}`, encoded.Body.String())
+}
+
+func TestPutPastMaxEntryBytes(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetMaxEntryBytes(4)
+
+	w := sendRequest(server, http.MethodPut, "/v1/keys/key", "longer than the limit", nil)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"too_large"`)
+	assert.Equal(t, http.StatusNoContent, sendRequest(server, http.MethodPut, "/v1/keys/key", "fits", nil).Code)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/limit"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const DEFAULT_SHED_RETRY_AFTER = time.Second
+
+var (
+	ErrRateLimited = loop.WithCode(loop.RATE_LIMITED_CODE, fmt.Errorf("rate limit exceeded"))
+	ErrOverloaded  = loop.WithCode(loop.OVERLOADED_CODE, fmt.Errorf("node is overloaded"))
+)
+
+// client limits are keyed by credential name, or by address when there's no acl.
+// can be changed while the node runs
+func (s *Server) SetRateLimits(client limit.Rate, namespace limit.Rate, namespaces map[string]limit.Rate) {
+	s.clientLimiter.SetRates(client, nil)
+	s.namespaceLimiter.SetRates(namespace, namespaces)
+}
+
+func (s *Server) SetShedQueueDepth(depth int) {
+	s.shedQueueDepth.Store(int64(depth))
+}
+
+func (s *Server) SetMaxEntryBytes(size int) {
+	s.maxEntryBytes.Store(int64(size))
+}
+
+// turns requests away before they can queue an event, the namespace limit is checked
+// by the handlers once they've read the body
+func (s *Server) admit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.overloaded() {
+			writeRetryAfter(w, r, ErrOverloaded, DEFAULT_SHED_RETRY_AFTER)
+			return
+		}
+		if ok, wait := s.clientLimiter.Allow(s.clientIdentity(auth.KeyFromRequest(r), r.RemoteAddr)); !ok {
+			writeRetryAfter(w, r, ErrRateLimited, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// client requests get ErrOverloaded once too many events are queued
+func (s *Server) overloaded() bool {
+	depth := s.shedQueueDepth.Load()
+	return depth > 0 && int64(s.eventLoop.Pending()) >= depth
+}
+
+// writes the 429 itself when the namespace is over its limit
+func (s *Server) admitNamespace(w http.ResponseWriter, r *http.Request, namespace string) bool {
+	ok, wait := s.namespaceLimiter.Allow(namespace)
+	if !ok {
+		writeRetryAfter(w, r, ErrRateLimited, wait)
+	}
+
+	return ok
+}
+
+// unknown keys fall back to the address, the handler rejects them afterwards
+func (s *Server) clientIdentity(key string, remoteAddr string) string {
+	if acl := s.acl.Load(); acl != nil {
+		if credential, ok := acl.Authenticate(key); ok {
+			return "credential:" + credential.Name
+		}
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	return "addr:" + host
+}
+
+// Retry-After is in whole seconds, rounded up so clients don't come back early
+func writeRetryAfter(w http.ResponseWriter, r *http.Request, err error, wait time.Duration) {
+	seconds := max(1, int(math.Ceil(wait.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeErrorResponse(w, r, err)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetRateLimits(limit.Rate{PerSecond: 1, Burst: 2}, limit.Rate{}, nil)
+
+	for i := 0; i < 2; i++ {
+		w := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+	w := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, nil)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestClientRateLimitByCredential(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetACL(auth.New([]auth.Credential{
+		{Name: "a", Key: "a-key", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}},
+		{Name: "b", Key: "b-key", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}},
+	}))
+	server.SetRateLimits(limit.Rate{PerSecond: 1, Burst: 1}, limit.Rate{}, nil)
+
+	sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, http.Header{auth.API_KEY_HEADER: {"a-key"}})
+	limited := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, http.Header{auth.API_KEY_HEADER: {"a-key"}})
+	other := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, http.Header{auth.API_KEY_HEADER: {"b-key"}})
+
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, http.StatusNotFound, other.Code)
+}
+
+func TestNamespaceRateLimit(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetRateLimits(limit.Rate{}, limit.Rate{}, map[string]limit.Rate{"busy": {PerSecond: 1, Burst: 1}})
+
+	sendRequest(server, http.MethodPost, "/get", `{"namespace": "busy", "key": "k"}`, nil)
+	limited := sendRequest(server, http.MethodPost, "/get", `{"namespace": "busy", "key": "k"}`, nil)
+	other := sendRequest(server, http.MethodPost, "/get", `{"namespace": "quiet", "key": "k"}`, nil)
+
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, http.StatusNotFound, other.Code)
+}
+
+func TestShedLoadPastQueueDepth(t *testing.T) {
+	eventLoop := createMockEventLoop()
+	eventLoop.pending = 10
+	server := New(eventLoop, ":8080", nil)
+	server.SetShedQueueDepth(10)
+
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/get", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestFlushIsRateLimited(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetRateLimits(limit.Rate{PerSecond: 1, Burst: 1}, limit.Rate{}, nil)
+
+	first := sendRequest(server, http.MethodPost, "/flush", "", nil)
+	limited := sendRequest(server, http.MethodPost, "/flush", "", nil)
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+}
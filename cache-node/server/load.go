@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/load"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+// a load shared by several requests isn't cut short when one of them gives up
+const DEFAULT_LOAD_TIMEOUT = 10 * time.Second
+
+var ErrLoadFailed = fmt.Errorf("loader failed")
+
+// fetches a missing key from where it really lives. an error matching loop.ErrNotFound
+// leaves the key missing
+type Loader func(ctx context.Context, namespace string, key string) (loop.Entry, error)
+
+type registeredLoader struct {
+	load Loader
+	// 0 never goes stale
+	softTTL time.Duration
+	// 0 never expires
+	ttl time.Duration
+}
+
+// reads of missing keys in the namespace matching pattern go to loader, and what it returns
+// is stored with ttl. past softTTL reads get the stale value while loader refreshes it in the
+// background. the first pattern added for a namespace that matches wins, must be called before Run
+func (s *Server) AddLoader(namespace string, pattern string, softTTL time.Duration, ttl time.Duration, loader Loader) {
+	if s.loaders == nil {
+		s.loaders = make(map[string]*load.Patterns[registeredLoader])
+	}
+	patterns, ok := s.loaders[namespace]
+	if !ok {
+		patterns = &load.Patterns[registeredLoader]{}
+		s.loaders[namespace] = patterns
+	}
+	patterns.Add(pattern, registeredLoader{load: loader, softTTL: softTTL, ttl: ttl})
+}
+
+// turns a miss into a load, and a stale hit or one picked to refresh early into a background
+// refresh, when a loader covers the key. a key only has one load or refresh running at a time
+func (s *Server) readThrough(namespace string, key string, resp loop.CacheEventResponse) (loop.CacheEventResponse, error) {
+	if resp.Ok && !resp.Stale && !resp.Refresh {
+		return resp, nil
+	}
+	patterns, ok := s.loaders[namespace]
+	if !ok {
+		return resp, nil
+	}
+	loader, ok := patterns.Match(key)
+	if !ok {
+		return resp, nil
+	}
+
+	// namespaces can't contain a slash
+	id := namespace + "/" + key
+	if resp.Ok {
+		version := resp.Version
+		s.loads.Go(id, func() (loop.CacheEventResponse, error) {
+			resp, err := s.load(namespace, key, version, loader)
+			if err != nil {
+				log.Printf("Couldn't refresh '%s' in '%s': %s", key, namespace, err)
+			}
+			return resp, err
+		})
+		return resp, nil
+	}
+
+	return s.loads.Do(id, func() (loop.CacheEventResponse, error) {
+		return s.load(namespace, key, 0, loader)
+	})
+}
+
+// version is the stale entry's that's being refreshed, 0 for a miss. either way the answer is
+// what a get would see afterwards, misses can be waiting on a refresh
+func (s *Server) load(namespace string, key string, version uint64, loader registeredLoader) (loop.CacheEventResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_LOAD_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	entry, err := loader.load(ctx, namespace, key)
+	// what the load cost is what reads weigh refreshing it early by
+	cost := time.Since(start)
+	if errors.Is(err, loop.ErrNotFound) {
+		return loop.CacheEventResponse{}, nil
+	}
+	if err != nil {
+		return loop.CacheEventResponse{}, fmt.Errorf("%w for '%s': %s", ErrLoadFailed, key, err)
+	}
+	if entry.ContentType == "" {
+		entry.ContentType = DEFAULT_CONTENT_TYPE
+	}
+
+	// a write that landed during the load is newer than what was loaded, so a miss only fills
+	// a key that's still missing and a refresh only replaces the version it read
+	event, r, e := loop.CreateSetIfAbsentEvent(namespace, key, entry.Value)
+	if version != 0 {
+		event, r, e = loop.CreateRefreshEvent(namespace, key, entry.Value, version)
+	}
+	event.ContentType = entry.ContentType
+	event.Flags = entry.Flags
+	event.Tags = entry.Tags
+	event.TTL = loader.ttl
+	event.SoftTTL = loader.softTTL
+	event.Cost = cost
+	resp, err := s.sendEvent(event, r, e)
+	if err != nil {
+		return loop.CacheEventResponse{}, err
+	}
+	if !resp.Ok {
+		return s.handleGetEvent(namespace, key)
+	}
+
+	resp.Value = entry.Value
+	return resp, nil
+}
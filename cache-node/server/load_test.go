@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderCoalescesMisses(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	var loads atomic.Int32
+	release := make(chan struct{})
+	server.AddLoader(loop.DEFAULT_NAMESPACE, "user:*", 0, time.Minute, func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		loads.Add(1)
+		<-release
+		return loop.Entry{Value: loop.CacheEntry("loaded " + key), ContentType: "text/plain"}, nil
+	})
+
+	var wg sync.WaitGroup
+	responses := make(chan int, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := sendRequest(server, http.MethodGet, "/v1/keys/user:1", "", nil)
+			assert.Equal(t, "loaded user:1", w.Body.String())
+			responses <- w.Code
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(responses)
+
+	for code := range responses {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, int32(1), loads.Load())
+
+	// stored with the loader's ttl, so the next read doesn't load
+	w := sendRequest(server, http.MethodGet, "/v1/keys/user:1", "", nil)
+	assert.Equal(t, "60", w.Header().Get(TTL_HEADER))
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestLoaderMisses(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.AddLoader(loop.DEFAULT_NAMESPACE, "missing:*", 0, 0, func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		return loop.Entry{}, loop.ErrNotFound
+	})
+	server.AddLoader(loop.DEFAULT_NAMESPACE, "broken:*", 0, 0, func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		return loop.Entry{}, fmt.Errorf("database is down")
+	})
+
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/missing:1", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/other", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, sendRequest(server, http.MethodGet, "/v1/keys/missing:1", "", http.Header{NAMESPACE_HEADER: {"other"}}).Code)
+	w := sendRequest(server, http.MethodPost, "/get", `{"key": "broken:1"}`, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "database is down")
+}
+
+func TestLoaderRefreshesStaleEntries(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	var loads atomic.Int32
+	release := make(chan struct{})
+	server.AddLoader(loop.DEFAULT_NAMESPACE, "user:*", 20*time.Millisecond, time.Minute, func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		n := loads.Add(1)
+		if n > 1 {
+			<-release
+		}
+		return loop.Entry{Value: loop.CacheEntry(fmt.Sprintf("v%d", n))}, nil
+	})
+
+	w := sendRequest(server, http.MethodGet, "/v1/keys/user:1", "", nil)
+	assert.Equal(t, "v1", w.Body.String())
+	assert.Equal(t, "", w.Header().Get(STALE_HEADER))
+	time.Sleep(30 * time.Millisecond)
+
+	// reads get the stale value while a single refresh runs
+	for range 3 {
+		w = sendRequest(server, http.MethodPost, "/get", `{"key": "user:1"}`, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"stale":true`)
+		assert.Equal(t, "true", w.Header().Get(STALE_HEADER))
+		assert.Eventually(t, func() bool { return loads.Load() == 2 }, time.Second, time.Millisecond)
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return sendRequest(server, http.MethodGet, "/v1/keys/user:1", "", nil).Body.String() == "v2"
+	}, time.Second, time.Millisecond)
+}
+
+func TestLoaderRefreshesEntriesPickedToRefreshEarly(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	loaded := make(chan struct{})
+	server.AddLoader(loop.DEFAULT_NAMESPACE, "*", 0, time.Minute, func(ctx context.Context, namespace string, key string) (loop.Entry, error) {
+		close(loaded)
+		return loop.Entry{Value: loop.CacheEntry("fresh")}, nil
+	})
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "old", nil)
+	resp, _ := server.handleGetEvent(loop.DEFAULT_NAMESPACE, "key")
+	resp.Refresh = true
+
+	resp, err := server.readThrough(loop.DEFAULT_NAMESPACE, "key", resp)
+
+	assert.Nil(t, err)
+	assert.Equal(t, loop.CacheEntry("old"), resp.Value)
+	<-loaded
+	assert.Eventually(t, func() bool {
+		return sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil).Body.String() == "fresh"
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/migrate"
+)
+
+const (
+	MIGRATION_STARTED_MSG = "Migration started"
+	MIGRATION_RESUMED_MSG = "Migration resumed"
+	TRANSFER_RECEIVED_MSG = "Transfer received"
+
+	DEFAULT_DRAIN_TIMEOUT = 30 * time.Second
+)
+
+type MigrateRequestBody struct {
+	To     string          `json:"to"`
+	Ranges []migrate.Range `json:"ranges"`
+}
+
+type MigrationsResponse struct {
+	Outgoing []migrate.Migration `json:"outgoing"`
+	Incoming []migrate.Transfer  `json:"incoming"`
+}
+
+// sent by the registry when this node loses ranges of the ring
+func (s *Server) MigrateHandler(w http.ResponseWriter, r *http.Request) {
+	var data MigrateRequestBody
+	if err := decode(r, &data); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	migration := s.migrator.Start(data.To, data.Ranges)
+
+	buf, err := encodeResponse(w, r, ValueResponse{
+		Message: MIGRATION_STARTED_MSG,
+		Value:   migration.Id,
+	})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) TransferHandler(w http.ResponseWriter, r *http.Request) {
+	var data migrate.TransferBody
+	if err := decode(r, &data); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	accepted := 0
+	for _, entry := range data.Entries {
+		// writes that reached this node after the ring changed are newer, keep them
+		namespace := entry.Namespace
+		if namespace == "" {
+			namespace = loop.DEFAULT_NAMESPACE
+		}
+		resp, err := s.handleSetIfAbsentEvent(namespace, entry)
+		if err != nil {
+			writeErrorResponse(w, r, err)
+			return
+		}
+		if resp.Ok {
+			accepted++
+		}
+	}
+	s.migrator.Receive(data, accepted)
+
+	buf, err := encodeResponse(w, r, Response{Message: TRANSFER_RECEIVED_MSG})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) MigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := encodeResponse(w, r, MigrationsResponse{
+		Outgoing: s.migrator.Outgoing(),
+		Incoming: s.migrator.Incoming(),
+	})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) ResumeMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.migrator.Resume(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, Response{Message: MIGRATION_RESUMED_MSG})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) handleSetIfAbsentEvent(namespace string, entry migrate.Entry) (loop.CacheEventResponse, error) {
+	event, r, e := loop.CreateSetIfAbsentEvent(namespace, entry.Key, entry.Value)
+	event.ContentType = entry.ContentType
+	event.Flags = entry.Flags
+	event.Tags = entry.Tags
+	event.TTL = entry.TTL
+	event.SoftTTL = entry.SoftTTL
+	event.Cost = loop.CostOfMillis(entry.CostMs)
+	return s.sendEvent(event, r, e)
+}
+
+// lets the migrator read and delete through the event loop
+type loopSource struct {
+	server *Server
+}
+
+func (src *loopSource) Keys() ([]loop.Key, error) {
+	event, r, e := loop.CreateKeysEvent()
+	resp, err := src.server.sendEvent(event, r, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Keys, nil
+}
+
+func (src *loopSource) Get(namespace string, key string) (migrate.Entry, bool, error) {
+	event, r, e := loop.CreatePeekEvent(namespace, key)
+	resp, err := src.server.sendEvent(event, r, e)
+	if err != nil || !resp.Ok {
+		return migrate.Entry{}, false, err
+	}
+
+	entry := migrate.Entry{
+		Namespace:   namespace,
+		Key:         key,
+		Value:       resp.Value,
+		ContentType: resp.ContentType,
+		Flags:       resp.Flags,
+		Tags:        resp.Tags,
+		CostMs:      loop.CostMillis(resp.Cost),
+		Version:     resp.Version,
+	}
+	if !resp.ExpiresAt.IsZero() {
+		// an entry that expires in transit is still sent, the target drops it on its first read
+		entry.TTL = max(time.Until(resp.ExpiresAt), time.Millisecond)
+	}
+	if !resp.StaleAt.IsZero() {
+		entry.SoftTTL = max(time.Until(resp.StaleAt), time.Millisecond)
+	}
+
+	return entry, true, nil
+}
+
+// moved keys only leave this node's cache, the backing store keeps them for the node that owns
+// them now. they're still published as deleted, so readers tracking them here drop them. a key
+// written since it was read never reached the target, so it stays
+func (src *loopSource) Delete(namespace string, key string, version uint64) error {
+	_, err := src.server.deleteKeys([]loop.Key{{Namespace: namespace, Key: key}}, version)
+	return err
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigratedKeysStayInTheBackingStore(t *testing.T) {
+	file, err := store.OpenFile(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	writer, err := store.NewWriter(file, store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := data.NewNamespaces[string, loop.Entry](data.Options{}, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	eventLoop.SetBackingStore(writer)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+	source := New(eventLoop, ":8080", nil)
+
+	target := createServerWithCache(t, data.Options{})
+	ts := httptest.NewServer(target.Handler)
+	t.Cleanup(ts.Close)
+
+	sendRequest(source, http.MethodPost, "/set", `{"key": "key", "value": "value"}`, nil)
+	w := sendRequest(source, http.MethodPost, "/internal/migrate", fmt.Sprintf(`{"to": %q, "ranges": [{"start": 0, "end": 0}]}`, ts.URL), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Eventually(t, func() bool {
+		moved := sendRequest(target, http.MethodGet, "/v1/keys/key", "", nil).Code == http.StatusOK
+		left := sendRequest(source, http.MethodGet, "/v1/keys/key", "", nil).Code == http.StatusNotFound
+		return moved && left
+	}, 2*time.Second, 10*time.Millisecond)
+
+	entry, ok := file.Get(loop.DEFAULT_NAMESPACE, "key")
+	assert.True(t, ok)
+	assert.Equal(t, loop.CacheEntry(`"value"`), entry.Value)
+}
+
+func TestMigratedEntriesCarryTheirCostInMilliseconds(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "value", http.Header{COST_HEADER: {"1500"}})
+
+	entry, ok, err := (&loopSource{server: server}).Get(loop.DEFAULT_NAMESPACE, "key")
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	buf, _ := json.Marshal(entry)
+	assert.Contains(t, string(buf), `"cost_ms":1500`)
+}
+
+func TestMigrationReadsArentCountedAsHits(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "value", nil)
+
+	_, ok, err := (&loopSource{server: server}).Get(loop.DEFAULT_NAMESPACE, "key")
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	event, r, e := loop.CreateStatsEvent()
+	resp, err := server.sendEvent(event, r, e)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), resp.Stats[0].Hits)
+}
+
+func TestMigrationKeepsKeysWrittenAfterTheRead(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	source := &loopSource{server: server}
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "old", nil)
+
+	entry, _, err := source.Get(loop.DEFAULT_NAMESPACE, "key")
+	assert.Nil(t, err)
+	sendRequest(server, http.MethodPut, "/v1/keys/key", "new", nil)
+	assert.Nil(t, source.Delete(entry.Namespace, entry.Key, entry.Version))
+
+	w := sendRequest(server, http.MethodGet, "/v1/keys/key", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "new", w.Body.String())
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const NAMESPACE_HEADER = "X-Cache-Namespace"
+
+type NamespacesResponse struct {
+	Namespaces []loop.NamespaceStats `json:"namespaces"`
+}
+
+// the body wins over the header, requests naming neither use the default namespace
+func namespaceFor(r *http.Request, body RequestBody) (string, error) {
+	namespace := body.Namespace
+	if namespace == "" {
+		namespace = r.Header.Get(NAMESPACE_HEADER)
+	}
+	if namespace == "" {
+		return loop.DEFAULT_NAMESPACE, nil
+	}
+
+	return namespace, loop.ValidateNamespace(namespace)
+}
+
+func (s *Server) NamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	event, respChan, errChan := loop.CreateStatsEvent()
+	resp, err := s.sendEvent(event, respChan, errChan)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, NamespacesResponse{Namespaces: resp.Stats})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+// admin rules scoped to the namespace are enough to flush it. it's the same flush as
+// /flush?namespace=
+func (s *Server) FlushNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	s.flush(w, r, r.PathValue("namespace"))
+}
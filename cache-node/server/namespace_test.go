@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func createServerWithCache(t *testing.T, options data.Options) *Server {
+	namespaces := data.NewNamespaces[string, loop.Entry](options, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+
+	return New(eventLoop, ":8080", nil)
+}
+
+func sendRequest(server *Server, method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestNamespaceFor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/get", nil)
+	namespace, err := namespaceFor(r, RequestBody{})
+	assert.Nil(t, err)
+	assert.Equal(t, loop.DEFAULT_NAMESPACE, namespace)
+
+	r.Header.Set(NAMESPACE_HEADER, "header")
+	namespace, _ = namespaceFor(r, RequestBody{})
+	assert.Equal(t, "header", namespace)
+
+	namespace, _ = namespaceFor(r, RequestBody{Namespace: "body"})
+	assert.Equal(t, "body", namespace)
+
+	_, err = namespaceFor(r, RequestBody{Namespace: "no spaces"})
+	assert.ErrorIs(t, err, loop.ErrInvalidNamespace)
+}
+
+func TestNamespacesAreSeparate(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	sendRequest(server, http.MethodPost, "/set", `{"namespace": "a", "key": "k", "value": "a"}`, nil)
+	w := sendRequest(server, http.MethodPost, "/get", `{"key": "k"}`, http.Header{NAMESPACE_HEADER: {"b"}})
+
+	var resp Response
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, VALUE_NOT_FOUND_MSG, resp.Message)
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	w := sendRequest(server, http.MethodPost, "/set", `{"namespace": "a/b", "key": "k", "value": "a"}`, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetPastByteQuota(t *testing.T) {
+	server := createServerWithCache(t, data.Options{MaxBytes: 8})
+
+	w := sendRequest(server, http.MethodPost, "/set", `{"key": "k", "value": "longer than the quota"}`, nil)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestWritesPastByteQuotaSkipTheStore(t *testing.T) {
+	file, err := store.OpenFile(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	writer, err := store.NewWriter(file, store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := data.NewNamespaces[string, loop.Entry](data.Options{MaxBytes: 8}, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	eventLoop.SetBackingStore(writer)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+	server := New(eventLoop, ":8080", nil)
+
+	w := sendRequest(server, http.MethodPut, "/v1/keys/k", "longer than the quota", nil)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	_, ok := file.Get(loop.DEFAULT_NAMESPACE, "k")
+	assert.False(t, ok)
+}
+
+func TestFlushNamespace(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	sendRequest(server, http.MethodPost, "/set", `{"namespace": "a", "key": "k", "value": "a"}`, nil)
+	sendRequest(server, http.MethodPost, "/set", `{"namespace": "b", "key": "k", "value": "b"}`, nil)
+
+	w := sendRequest(server, http.MethodPost, "/admin/namespaces/a/flush", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message": "Keys flushed", "value": 1}`, w.Body.String())
+
+	w = sendRequest(server, http.MethodGet, "/admin/namespaces", "", nil)
+	var resp NamespacesResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	entries := map[string]int{}
+	for _, stats := range resp.Namespaces {
+		entries[stats.Namespace] = stats.Entries
+	}
+	assert.Equal(t, map[string]int{"a": 0, "b": 1}, entries)
+}
+
+func TestFlushNamespaceScopedAdmin(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetACL(auth.New([]auth.Credential{
+		{
+			Name:  "tenant",
+			Key:   "tenant-key",
+			Rules: []auth.Rule{{Namespace: "a", Ops: []string{auth.ADMIN_OP}}},
+		},
+	}))
+	header := http.Header{auth.API_KEY_HEADER: {"tenant-key"}}
+
+	w := sendRequest(server, http.MethodPost, "/admin/namespaces/a/flush", "", header)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = sendRequest(server, http.MethodPost, "/admin/namespaces/b/flush", "", header)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = sendRequest(server, http.MethodGet, "/admin/namespaces", "", header)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
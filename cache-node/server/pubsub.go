@@ -0,0 +1,308 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/glob"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/pubsub"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	PUBLISHED_MSG = "Message published"
+
+	MESSAGE_EVENT = "message"
+
+	SUBSCRIBE_ACTION    = "subscribe"
+	UNSUBSCRIBE_ACTION  = "unsubscribe"
+	PSUBSCRIBE_ACTION   = "psubscribe"
+	PUNSUBSCRIBE_ACTION = "punsubscribe"
+	PUBLISH_ACTION      = "publish"
+)
+
+var (
+	ErrMissingChannel = badRequest(fmt.Errorf("channel can't be empty"))
+	ErrUnknownAction  = badRequest(fmt.Errorf("action must be subscribe, unsubscribe, psubscribe, punsubscribe or publish"))
+)
+
+type PublishRequest struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+// Pattern is the pattern that matched, empty when the channel was subscribed to itself
+type PubSubMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+// what websocket clients send
+type PubSubAction struct {
+	Action string `json:"action"`
+	// channels, or patterns for psubscribe and punsubscribe. empty unsubscribes from all of them
+	Channels []string `json:"channels,omitempty"`
+	// for publish
+	Channel string `json:"channel,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// answers an action, Count is the subscriptions left or for publish the subscribers reached
+type PubSubReply struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Count   int    `json:"count"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// the body's message goes to every subscriber on this node, the value is how many it reached
+func (s *Server) PublishHandler(w http.ResponseWriter, r *http.Request) {
+	var body PublishRequest
+	if err := decode(r, &body); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	receivers, err := s.publish(auth.KeyFromRequest(r), r.RemoteAddr, body.Channel, []byte(body.Message))
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{Message: PUBLISHED_MSG, Value: receivers})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// streams the messages on ?channel= and ?pattern=, both can be repeated, as server-sent events
+func (s *Server) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.subscribeQuery(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(WATCH_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case message, ok := <-sub.Messages():
+			if !ok {
+				err := sub.Err()
+				writeServerSentEvent(w, 0, ERROR_EVENT, createErrorResponse(err, codeFor(err)))
+				rc.Flush()
+				return
+			}
+			writeServerSentEvent(w, 0, MESSAGE_EVENT, createPubSubMessage(message))
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// the same query as SubscribeHandler to start with, then PubSubActions change the subscriptions
+// and publish. every message and reply is a json text frame
+func (s *Server) SubscribeSocketHandler(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.subscribeQuery(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	// no origin check, a browser can't send the api key on a websocket anyway
+	handler := websocket.Server{Handler: func(ws *websocket.Conn) {
+		s.serveSocket(ws, sub, auth.KeyFromRequest(r), r.RemoteAddr)
+	}}
+	handler.ServeHTTP(w, r)
+}
+
+func (s *Server) serveSocket(ws *websocket.Conn, sub *pubsub.Subscription, key string, remoteAddr string) {
+	var sendMux sync.Mutex
+	send := func(v any) error {
+		sendMux.Lock()
+		defer sendMux.Unlock()
+		return websocket.JSON.Send(ws, v)
+	}
+
+	// the reader stops when the client hangs up or the connection is closed below
+	hungUp := make(chan struct{})
+	go func() {
+		defer close(hungUp)
+		for {
+			var action PubSubAction
+			if err := websocket.JSON.Receive(ws, &action); err != nil {
+				return
+			}
+			for _, reply := range s.handleAction(key, remoteAddr, sub, action) {
+				if err := send(reply); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	defer ws.Close()
+	for {
+		select {
+		case message, ok := <-sub.Messages():
+			if !ok {
+				err := sub.Err()
+				send(PubSubReply{Type: ERROR_EVENT, Error: err.Error(), Code: codeFor(err)})
+				return
+			}
+			if err := send(createPubSubMessage(message)); err != nil {
+				return
+			}
+		case <-hungUp:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) handleAction(key string, remoteAddr string, sub *pubsub.Subscription, action PubSubAction) []PubSubReply {
+	fail := func(err error) []PubSubReply {
+		return []PubSubReply{{Type: action.Action, Error: err.Error(), Code: codeFor(err)}}
+	}
+
+	var replies []PubSubReply
+	switch action.Action {
+	case PUBLISH_ACTION:
+		receivers, err := s.publish(key, remoteAddr, action.Channel, []byte(action.Message))
+		if err != nil {
+			return fail(err)
+		}
+		return []PubSubReply{{Type: PUBLISH_ACTION, Channel: action.Channel, Count: receivers}}
+	case SUBSCRIBE_ACTION, PSUBSCRIBE_ACTION:
+		pattern := action.Action == PSUBSCRIBE_ACTION
+		for _, channel := range action.Channels {
+			count, err := s.subscribe(key, sub, channel, pattern)
+			if err != nil {
+				return append(replies, fail(err)...)
+			}
+			replies = append(replies, PubSubReply{Type: action.Action, Channel: channel, Count: count})
+		}
+	case UNSUBSCRIBE_ACTION:
+		channels := action.Channels
+		if len(channels) == 0 {
+			channels = sub.Channels()
+		}
+		for _, channel := range channels {
+			replies = append(replies, PubSubReply{Type: action.Action, Channel: channel, Count: sub.Unsubscribe(channel)})
+		}
+	case PUNSUBSCRIBE_ACTION:
+		patterns := action.Channels
+		if len(patterns) == 0 {
+			patterns = sub.Patterns()
+		}
+		for _, pattern := range patterns {
+			replies = append(replies, PubSubReply{Type: action.Action, Channel: pattern, Count: sub.PUnsubscribe(pattern)})
+		}
+	default:
+		return fail(ErrUnknownAction)
+	}
+
+	return replies
+}
+
+func (s *Server) subscribeQuery(r *http.Request) (*pubsub.Subscription, error) {
+	key := auth.KeyFromRequest(r)
+	if ok, _ := s.clientLimiter.Allow(s.clientIdentity(key, r.RemoteAddr)); !ok {
+		return nil, ErrRateLimited
+	}
+
+	sub := s.broker.Subscribe(0)
+	query := r.URL.Query()
+	for _, channel := range query["channel"] {
+		if _, err := s.subscribe(key, sub, channel, false); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+	for _, pattern := range query["pattern"] {
+		if _, err := s.subscribe(key, sub, pattern, true); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+
+	return sub, nil
+}
+
+// a pattern needs subscribe access to everything it could match, so to its literal prefix
+func (s *Server) subscribe(key string, sub *pubsub.Subscription, channel string, pattern bool) (int, error) {
+	if channel == "" {
+		return 0, ErrMissingChannel
+	}
+	if err := s.authorizeChannel(key, auth.SUBSCRIBE_OP, channel, pattern); err != nil {
+		return 0, err
+	}
+
+	if pattern {
+		return sub.PSubscribe(channel), nil
+	}
+	return sub.Subscribe(channel), nil
+}
+
+func (s *Server) publish(key string, remoteAddr string, channel string, message []byte) (int, error) {
+	if ok, _ := s.clientLimiter.Allow(s.clientIdentity(key, remoteAddr)); !ok {
+		return 0, ErrRateLimited
+	}
+	if channel == "" {
+		return 0, ErrMissingChannel
+	}
+	if err := s.authorizeChannel(key, auth.PUBLISH_OP, channel, false); err != nil {
+		return 0, err
+	}
+
+	return s.broker.Publish(channel, message), nil
+}
+
+// channels aren't in a namespace, so only acl rules without one cover them
+func (s *Server) authorizeChannel(key string, op string, channel string, pattern bool) error {
+	acl := s.acl.Load()
+	if acl == nil {
+		return nil
+	}
+	if !pattern {
+		return acl.Authorize(key, op, "", channel)
+	}
+
+	return acl.Authorize(key, op, "", glob.Literal(channel))
+}
+
+func createPubSubMessage(message pubsub.Message) PubSubMessage {
+	return PubSubMessage{
+		Type:    MESSAGE_EVENT,
+		Channel: message.Channel,
+		Pattern: message.Pattern,
+		Message: string(message.Payload),
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func readMessage(t *testing.T, reader *bufio.Reader) (string, PubSubMessage) {
+	var event string
+	var message PubSubMessage
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event, message
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			assert.Nil(t, json.Unmarshal([]byte(value), &message))
+		}
+	}
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	server, ts := createWatchServer(t)
+	resp, err := http.Get(ts.URL + "/subscribe?channel=news.sports&pattern=news.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	published := sendRequest(server, http.MethodPost, "/publish", `{"channel": "news.sports", "message": "goal"}`, nil)
+	assert.JSONEq(t, `{"message": "Message published", "value": 2}`, published.Body.String())
+
+	reader := bufio.NewReader(resp.Body)
+	event, exact := readMessage(t, reader)
+	assert.Equal(t, MESSAGE_EVENT, event)
+	_, pattern := readMessage(t, reader)
+	assert.Equal(t, PubSubMessage{Type: MESSAGE_EVENT, Channel: "news.sports", Message: "goal"}, exact)
+	assert.Equal(t, "news.*", pattern.Pattern)
+}
+
+func TestPublishRequiresAChannel(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	resp := sendRequest(server, http.MethodPost, "/publish", `{"message": "lost"}`, nil)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestSubscribeACL(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetACL(auth.New([]auth.Credential{
+		{Name: "news", Key: "news-key", Rules: []auth.Rule{{Prefix: "news.", Ops: []string{auth.SUBSCRIBE_OP}}}},
+		{Name: "scoped", Key: "scoped-key", Rules: []auth.Rule{{Namespace: "ns", Ops: []string{auth.SUBSCRIBE_OP, auth.PUBLISH_OP}}}},
+	}))
+	header := func(key string) http.Header { return http.Header{auth.API_KEY_HEADER: {key}} }
+
+	assert.Equal(t, http.StatusForbidden, sendRequest(server, http.MethodGet, "/subscribe?pattern=*", "", header("news-key")).Code)
+	assert.Equal(t, http.StatusForbidden, sendRequest(server, http.MethodGet, "/subscribe?channel=weather", "", header("news-key")).Code)
+	assert.Equal(t, http.StatusForbidden, sendRequest(server, http.MethodPost, "/publish", `{"channel": "news.a"}`, header("news-key")).Code)
+	// rules scoped to a namespace don't cover channels
+	assert.Equal(t, http.StatusForbidden, sendRequest(server, http.MethodPost, "/publish", `{"channel": "a"}`, header("scoped-key")).Code)
+}
+
+func TestSubscribeOverWebSocket(t *testing.T) {
+	_, ts := createWatchServer(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/subscribe/ws?channel=a"
+	ws, err := websocket.Dial(url, "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, PubSubAction{Action: PSUBSCRIBE_ACTION, Channels: []string{"b*"}})
+	var reply PubSubReply
+	assert.Nil(t, websocket.JSON.Receive(ws, &reply))
+	assert.Equal(t, PubSubReply{Type: PSUBSCRIBE_ACTION, Channel: "b*", Count: 2}, reply)
+
+	websocket.JSON.Send(ws, PubSubAction{Action: PUBLISH_ACTION, Channel: "bees", Message: "buzz"})
+	var first, second map[string]any
+	assert.Nil(t, websocket.JSON.Receive(ws, &first))
+	assert.Nil(t, websocket.JSON.Receive(ws, &second))
+	// the message can beat the publish reply
+	if first["type"] == MESSAGE_EVENT {
+		first, second = second, first
+	}
+	assert.Equal(t, map[string]any{"type": PUBLISH_ACTION, "channel": "bees", "count": float64(1)}, first)
+	assert.Equal(t, map[string]any{"type": MESSAGE_EVENT, "channel": "bees", "pattern": "b*", "message": "buzz"}, second)
+
+	websocket.JSON.Send(ws, PubSubAction{Action: "shout"})
+	assert.Nil(t, websocket.JSON.Receive(ws, &reply))
+	assert.Equal(t, loop.BAD_REQUEST_CODE, reply.Code)
+}
@@ -6,6 +6,30 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
+)
+
+const (
+	DEFAULT_CLIENT_TIMEOUT     = 5 * time.Second
+	DEFAULT_HEARTBEAT_INTERVAL = 5 * time.Second
+)
+
+type registerResponse struct {
+	Epoch string `json:"epoch"`
+}
+
+type heartbeatResponse struct {
+	Epoch      string `json:"epoch"`
+	Registered bool   `json:"registered"`
+}
+
+type nodesResponse struct {
+	Urls []string `json:"urls"`
+}
+
+var (
+	ErrNoRegistry          = fmt.Errorf("no registry urls configured")
+	ErrRegistryUnavailable = fmt.Errorf("couldn't list the nodes from any registry")
 )
 
 func (s *Server) registerServer() bool {
@@ -16,7 +40,118 @@ func (s *Server) registerServer() bool {
 	}
 	defer resp.Body.Close()
 
-	return isStatusOk(resp.StatusCode)
+	if !isStatusOk(resp.StatusCode) {
+		return false
+	}
+
+	var body registerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		log.Println(err)
+		return false
+	}
+	s.setEpoch(body.Epoch)
+
+	return true
+}
+
+// keeps the node registered for as long as it runs, registering again whenever the registry loses it
+func (s *Server) maintainRegistration() {
+	for {
+		if ok := s.registerWithBackoff(); !ok {
+			return
+		}
+		if ok := s.heartbeatUntilLost(); !ok {
+			return
+		}
+	}
+}
+
+// returns false if the server stopped before registering
+func (s *Server) registerWithBackoff() bool {
+	b := newBackoff(DEFAULT_BACKOFF_BASE, DEFAULT_BACKOFF_MAX)
+
+	for {
+		if ok := s.registerServer(); ok {
+			s.setRegistered(true)
+			log.Println("Successfully registered server")
+			return true
+		}
+
+		delay := b.next()
+		log.Printf("Unable to register server with registry node, retrying in %v", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// returns true when the registration is lost and false if the server stopped
+func (s *Server) heartbeatUntilLost() bool {
+	ticker := time.NewTicker(DEFAULT_HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if ok := s.checkRegistration(); !ok {
+				s.setRegistered(false)
+				return true
+			}
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// a registry that restarted without its state comes back with a new epoch and doesn't know this node.
+// an unreachable registry isn't treated as a lost registration
+func (s *Server) checkRegistration() bool {
+	resp, err := s.createAndSendPostRequest("/heartbeat")
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	defer resp.Body.Close()
+
+	var body heartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		log.Println(err)
+		return true
+	}
+
+	if body.Registered && body.Epoch == s.getEpoch() {
+		return true
+	}
+
+	log.Printf("Registration lost, registry epoch '%s' (registered under '%s')", body.Epoch, s.getEpoch())
+	return false
+}
+
+func (s *Server) setRegistered(registered bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.registered = registered
+}
+
+func (s *Server) isRegistered() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.registered
+}
+
+func (s *Server) setEpoch(epoch string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.epoch = epoch
+}
+
+func (s *Server) getEpoch() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.epoch
 }
 
 func (s *Server) unregisterServer() bool {
@@ -30,25 +165,60 @@ func (s *Server) unregisterServer() bool {
 	return isStatusOk(resp.StatusCode)
 }
 
+// followers in a replicated registry redirect writes to their leader, unreachable registries are skipped
 func (s *Server) createAndSendPostRequest(context string) (*http.Response, error) {
 	type body struct {
 		Url string `json:"url"`
 	}
 
 	jsonData, err := json.Marshal(body{
-		Url: s.Addr,
+		Url: s.advertisedUrl(),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	r := bytes.NewBuffer(jsonData)
+	err = ErrNoRegistry
+	for _, registryUrl := range s.registryUrls {
+		var resp *http.Response
+		resp, err = s.client.Post(formatRegistryUrl(registryUrl, context), "application/json", bytes.NewReader(jsonData))
+		if err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+			// a member that doesn't know the leader yet
+			resp.Body.Close()
+			err = fmt.Errorf("registry '%s' has no leader", registryUrl)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		log.Printf("Registry '%s' unavailable: %s", registryUrl, err)
+	}
+
+	return nil, err
+}
+
+// every node the registry knows, any member can answer so the first one that does is used
+func (s *Server) listNodes() ([]string, error) {
+	for _, registryUrl := range s.registryUrls {
+		resp, err := s.client.Get(formatRegistryUrl(registryUrl, "/nodes"))
+		if err != nil {
+			log.Printf("Registry '%s' unavailable: %s", registryUrl, err)
+			continue
+		}
+
+		var body nodesResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err == nil && isStatusOk(resp.StatusCode) {
+			return body.Urls, nil
+		}
+		log.Printf("Registry '%s' couldn't list nodes: status %d", registryUrl, resp.StatusCode)
+	}
 
-	return http.Post(s.formatRegistryUrl(context), "application/json", r)
+	return nil, ErrRegistryUnavailable
 }
 
-func (s *Server) formatRegistryUrl(context string) string {
-	return fmt.Sprintf("%s%s", s.registryUrl, context)
+func formatRegistryUrl(registryUrl string, context string) string {
+	return fmt.Sprintf("%s%s", registryUrl, context)
 }
 
 func isStatusOk(status int) bool {
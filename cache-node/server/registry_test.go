@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockRegistry struct {
+	failures   int
+	epoch      string
+	registered map[string]bool
+	mux        sync.Mutex
+}
+
+func (mr *MockRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mr.mux.Lock()
+	defer mr.mux.Unlock()
+
+	var body struct {
+		Url string `json:"url"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch r.URL.Path {
+	case "/register":
+		if mr.failures > 0 {
+			mr.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mr.registered[body.Url] = true
+		json.NewEncoder(w).Encode(registerResponse{Epoch: mr.epoch})
+	case "/heartbeat":
+		json.NewEncoder(w).Encode(heartbeatResponse{Epoch: mr.epoch, Registered: mr.registered[body.Url]})
+	}
+}
+
+func TestBackoffGrowsToMax(t *testing.T) {
+	b := newBackoff(time.Millisecond, 8*time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		delay := b.next()
+		assert.Greater(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, min(time.Millisecond<<i, 8*time.Millisecond))
+	}
+}
+
+func TestReadyHandlerNotRegistered(t *testing.T) {
+	server := createServerWithEventLoop()
+	w := httptest.NewRecorder()
+
+	server.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), NOT_READY_MSG)
+}
+
+func TestReadyHandlerRegistered(t *testing.T) {
+	server := createServerWithEventLoop()
+	server.setRegistered(true)
+	w := httptest.NewRecorder()
+
+	server.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), READY_MSG)
+}
+
+func TestRegisterWithBackoffRetries(t *testing.T) {
+	registry, server := createServerWithRegistry(1)
+
+	ok := server.registerWithBackoff()
+
+	assert.True(t, ok)
+	assert.True(t, server.isRegistered())
+	assert.Equal(t, registry.epoch, server.getEpoch())
+}
+
+func TestRegisterWithBackoffStops(t *testing.T) {
+	_, server := createServerWithRegistry(100)
+	close(server.done)
+
+	ok := server.registerWithBackoff()
+
+	assert.False(t, ok)
+	assert.False(t, server.isRegistered())
+}
+
+func TestCheckRegistration(t *testing.T) {
+	registry, server := createServerWithRegistry(0)
+	server.registerWithBackoff()
+
+	assert.True(t, server.checkRegistration())
+
+	registry.epoch = "restarted"
+	registry.registered = make(map[string]bool)
+	assert.False(t, server.checkRegistration())
+}
+
+func TestCheckRegistrationIgnoresUnreachableRegistry(t *testing.T) {
+	server := New(createMockEventLoop(), "localhost:0", []string{"http://127.0.0.1:1"})
+
+	assert.True(t, server.checkRegistration())
+}
+
+func createServerWithRegistry(failures int) (*MockRegistry, *Server) {
+	registry := &MockRegistry{
+		failures:   failures,
+		epoch:      "epoch",
+		registered: make(map[string]bool),
+	}
+	ts := httptest.NewServer(registry)
+
+	server := New(createMockEventLoop(), "localhost:0", []string{ts.URL})
+	return registry, server
+}
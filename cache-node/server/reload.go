@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const RELOADED_MSG = "Config reloaded"
+
+var ErrReloadUnavailable = loop.WithCode(loop.NOT_FOUND_CODE, fmt.Errorf("config reload isn't enabled on this node"))
+
+func (s *Server) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		writeErrorResponse(w, r, ErrReloadUnavailable)
+		return
+	}
+
+	// the running config is kept when the new one doesn't load or validate
+	result, err := s.reloader.Reload()
+	if err != nil {
+		writeErrorResponse(w, r, badRequest(err))
+		return
+	}
+
+	buf, err := encodeResponse(w, r, ValueResponse{
+		Message: RELOADED_MSG,
+		Value:   result,
+	})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
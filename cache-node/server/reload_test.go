@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockReloader struct {
+	result config.ReloadResult
+	err    error
+}
+
+func (mr *MockReloader) Reload() (config.ReloadResult, error) {
+	return mr.result, mr.err
+}
+
+func TestReloadHandler(t *testing.T) {
+	server := createServerWithEventLoop()
+	server.SetReloader(&MockReloader{result: config.ReloadResult{
+		Applied:         []string{"log_level"},
+		RestartRequired: []string{"listen"},
+	}})
+	w := httptest.NewRecorder()
+
+	server.ReloadHandler(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), RELOADED_MSG)
+	assert.Contains(t, w.Body.String(), `"applied":["log_level"]`)
+	assert.Contains(t, w.Body.String(), `"restart_required":["listen"]`)
+}
+
+func TestReloadHandlerInvalidConfig(t *testing.T) {
+	server := createServerWithEventLoop()
+	server.SetReloader(&MockReloader{err: fmt.Errorf("log_level must be debug, info, warn or error")})
+	w := httptest.NewRecorder()
+
+	server.ReloadHandler(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "log_level")
+}
+
+func TestReloadHandlerNotEnabled(t *testing.T) {
+	server := createServerWithEventLoop()
+	w := httptest.NewRecorder()
+
+	server.ReloadHandler(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/pubsub"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/resp"
+)
+
+// redis error prefixes clients recognise
+var respErrorPrefixes = map[string]string{
+	loop.UNAUTHENTICATED_CODE: "NOAUTH",
+	loop.FORBIDDEN_CODE:       "NOPERM",
+}
+
+// one client connection, replies and pushed messages share the writer
+type respConn struct {
+	server     *Server
+	conn       net.Conn
+	remoteAddr string
+	key        string
+	sub        *pubsub.Subscription
+	writer     *bufio.Writer
+	mux        sync.Mutex
+}
+
+// serves redis pub/sub commands on addr next to http, must be called before Run
+func (s *Server) ListenRESP(addr string) {
+	s.respAddr = addr
+}
+
+func (s *Server) runRESP() {
+	listener, err := net.Listen("tcp", s.respAddr)
+	if err != nil {
+		log.Printf("Couldn't listen on '%s': %s", s.respAddr, err)
+		return
+	}
+	if s.useTLS {
+		listener = tls.NewListener(listener, s.Server.TLSConfig)
+	}
+
+	log.Printf("RESP listening on '%v'", s.respAddr)
+	s.ServeRESP(listener)
+}
+
+// serves PUBLISH, SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, PUNSUBSCRIBE, PING, AUTH and QUIT on
+// connections from l until the server shuts down
+func (s *Server) ServeRESP(l net.Listener) error {
+	return s.respConns.serve(l, s.serveRESPConn)
+}
+
+func (s *Server) closeRESP() {
+	s.respConns.close()
+}
+
+func (s *Server) serveRESPConn(conn net.Conn) {
+	c := &respConn{
+		server:     s,
+		conn:       conn,
+		remoteAddr: conn.RemoteAddr().String(),
+		writer:     bufio.NewWriter(conn),
+	}
+	defer func() {
+		if c.sub != nil {
+			c.sub.Close()
+		}
+	}()
+
+	reader := bufio.NewReaderSize(conn, resp.MAX_LINE_SIZE)
+	for {
+		args, err := resp.ReadCommand(reader)
+		if errors.Is(err, resp.ErrProtocol) || errors.Is(err, resp.ErrTooLarge) {
+			c.reply(func(w *bufio.Writer) { resp.WriteError(w, "ERR "+err.Error()) })
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("Closing RESP connection", "addr", c.remoteAddr, "err", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if !c.handle(strings.ToUpper(string(args[0])), args[1:]) {
+			return
+		}
+	}
+}
+
+// false once the connection should close
+func (c *respConn) handle(command string, args [][]byte) bool {
+	subscribed := c.sub != nil && len(c.sub.Channels())+len(c.sub.Patterns()) > 0
+
+	switch command {
+	case "PING":
+		c.reply(func(w *bufio.Writer) {
+			switch {
+			case subscribed:
+				resp.WriteArray(w, 2)
+				resp.WriteBulk(w, []byte("pong"))
+				resp.WriteBulk(w, firstArg(args))
+			case len(args) > 0:
+				resp.WriteBulk(w, args[0])
+			default:
+				resp.WriteSimple(w, "PONG")
+			}
+		})
+	case "QUIT":
+		c.reply(func(w *bufio.Writer) { resp.WriteSimple(w, "OK") })
+		return false
+	case "AUTH":
+		c.auth(args)
+	case "PUBLISH":
+		if subscribed {
+			c.replyError("ERR only SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, PUNSUBSCRIBE, PING and QUIT are allowed while subscribed")
+			break
+		}
+		if len(args) != 2 {
+			c.replyError("ERR wrong number of arguments for 'publish' command")
+			break
+		}
+		receivers, err := c.server.publish(c.key, c.remoteAddr, string(args[0]), args[1])
+		if err != nil {
+			c.replyErr(err)
+			break
+		}
+		c.reply(func(w *bufio.Writer) { resp.WriteInteger(w, int64(receivers)) })
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			c.replyError("ERR wrong number of arguments for '" + strings.ToLower(command) + "' command")
+			break
+		}
+		c.subscribe(command == "PSUBSCRIBE", args)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		c.unsubscribe(command == "PUNSUBSCRIBE", args)
+	default:
+		c.replyError("ERR unknown command '" + command + "'")
+	}
+
+	return true
+}
+
+func (c *respConn) auth(args [][]byte) {
+	if len(args) == 0 || len(args) > 2 {
+		c.replyError("ERR wrong number of arguments for 'auth' command")
+		return
+	}
+
+	// AUTH <username> <password> names the credential too, only the password is checked
+	key := string(args[len(args)-1])
+	if acl := c.server.acl.Load(); acl != nil {
+		if _, ok := acl.Authenticate(key); !ok {
+			c.replyError("WRONGPASS " + auth.ErrUnauthenticated.Error())
+			return
+		}
+	}
+
+	c.key = key
+	c.reply(func(w *bufio.Writer) { resp.WriteSimple(w, "OK") })
+}
+
+// the first subscribe starts pushing messages, a subscriber that falls behind is disconnected
+func (c *respConn) subscribe(pattern bool, channels [][]byte) {
+	if c.sub == nil {
+		c.sub = c.server.broker.Subscribe(0)
+		go c.push(c.sub)
+	}
+
+	kind := "subscribe"
+	if pattern {
+		kind = "psubscribe"
+	}
+	for _, channel := range channels {
+		count, err := c.server.subscribe(c.key, c.sub, string(channel), pattern)
+		if err != nil {
+			c.replyErr(err)
+			continue
+		}
+		c.reply(func(w *bufio.Writer) { writeSubscriptionReply(w, kind, channel, count) })
+	}
+}
+
+// with no channels it leaves every channel, or every pattern
+func (c *respConn) unsubscribe(pattern bool, channels [][]byte) {
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+	if len(channels) == 0 && c.sub != nil {
+		names := c.sub.Channels()
+		if pattern {
+			names = c.sub.Patterns()
+		}
+		for _, name := range names {
+			channels = append(channels, []byte(name))
+		}
+	}
+	if len(channels) == 0 {
+		c.reply(func(w *bufio.Writer) { writeSubscriptionReply(w, kind, nil, 0) })
+		return
+	}
+
+	for _, channel := range channels {
+		count := 0
+		if c.sub != nil && pattern {
+			count = c.sub.PUnsubscribe(string(channel))
+		} else if c.sub != nil {
+			count = c.sub.Unsubscribe(string(channel))
+		}
+		c.reply(func(w *bufio.Writer) { writeSubscriptionReply(w, kind, channel, count) })
+	}
+}
+
+// flushes whenever it catches up, so a burst of messages shares a write
+func (c *respConn) push(sub *pubsub.Subscription) {
+	for message := range sub.Messages() {
+		c.mux.Lock()
+		if message.Pattern != "" {
+			resp.WriteArray(c.writer, 4)
+			resp.WriteBulk(c.writer, []byte("pmessage"))
+			resp.WriteBulk(c.writer, []byte(message.Pattern))
+		} else {
+			resp.WriteArray(c.writer, 3)
+			resp.WriteBulk(c.writer, []byte("message"))
+		}
+		resp.WriteBulk(c.writer, []byte(message.Channel))
+		resp.WriteBulk(c.writer, message.Payload)
+		var err error
+		if len(sub.Messages()) == 0 {
+			err = c.writer.Flush()
+		}
+		c.mux.Unlock()
+
+		if err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+
+	if errors.Is(sub.Err(), pubsub.ErrSlowConsumer) {
+		slog.Debug("Disconnecting slow subscriber", "addr", c.remoteAddr)
+		c.replyError("ERR " + pubsub.ErrSlowConsumer.Error())
+		c.conn.Close()
+	}
+}
+
+func (c *respConn) reply(write func(w *bufio.Writer)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	write(c.writer)
+	if err := c.writer.Flush(); err != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *respConn) replyError(message string) {
+	c.reply(func(w *bufio.Writer) { resp.WriteError(w, message) })
+}
+
+func (c *respConn) replyErr(err error) {
+	prefix, ok := respErrorPrefixes[codeFor(err)]
+	if !ok {
+		prefix = "ERR"
+	}
+	c.replyError(prefix + " " + err.Error())
+}
+
+// a nil channel is written as a null bulk string
+func writeSubscriptionReply(w *bufio.Writer, kind string, channel []byte, count int) {
+	resp.WriteArray(w, 3)
+	resp.WriteBulk(w, []byte(kind))
+	if channel == nil {
+		resp.WriteNull(w)
+	} else {
+		resp.WriteBulk(w, channel)
+	}
+	resp.WriteInteger(w, int64(count))
+}
+
+func firstArg(args [][]byte) []byte {
+	if len(args) == 0 {
+		return []byte{}
+	}
+	return args[0]
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/resp"
+	"github.com/stretchr/testify/assert"
+)
+
+type respClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dialRESP(t *testing.T, server *Server) *respClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeRESP(l)
+	t.Cleanup(server.closeRESP)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+}
+
+func (c *respClient) send(args ...string) {
+	resp.WriteArray(c.writer, len(args))
+	for _, arg := range args {
+		resp.WriteBulk(c.writer, []byte(arg))
+	}
+	c.writer.Flush()
+}
+
+// replies are read back as lines, enough to compare against what redis sends
+func (c *respClient) expect(t *testing.T, lines ...string) {
+	for _, want := range lines {
+		line, err := c.reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, want+"\r\n", line)
+	}
+}
+
+func TestRESPPubSub(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	subscriber := dialRESP(t, server)
+	publisher := dialRESP(t, server)
+
+	subscriber.send("SUBSCRIBE", "a")
+	subscriber.expect(t, "*3", "$9", "subscribe", "$1", "a", ":1")
+	subscriber.send("PSUBSCRIBE", "b*")
+	subscriber.expect(t, "*3", "$10", "psubscribe", "$2", "b*", ":2")
+
+	publisher.send("PUBLISH", "a", "hello")
+	publisher.expect(t, ":1")
+	subscriber.expect(t, "*3", "$7", "message", "$1", "a", "$5", "hello")
+
+	publisher.send("PUBLISH", "bee", "buzz")
+	publisher.expect(t, ":1")
+	subscriber.expect(t, "*4", "$8", "pmessage", "$2", "b*", "$3", "bee", "$4", "buzz")
+
+	subscriber.send("PUBLISH", "a", "no")
+	line, _ := subscriber.reader.ReadString('\n')
+	assert.Contains(t, line, "-ERR only SUBSCRIBE")
+
+	subscriber.send("UNSUBSCRIBE")
+	subscriber.expect(t, "*3", "$11", "unsubscribe", "$1", "a", ":1")
+	subscriber.send("PING")
+	subscriber.expect(t, "*2", "$4", "pong", "$0", "")
+}
+
+func TestRESPAuth(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	server.SetACL(auth.New([]auth.Credential{
+		{Name: "publisher", Key: "secret", Rules: []auth.Rule{{Ops: []string{auth.PUBLISH_OP}}}},
+	}))
+	c := dialRESP(t, server)
+
+	c.send("PUBLISH", "a", "hello")
+	line, _ := c.reader.ReadString('\n')
+	assert.Contains(t, line, "-NOAUTH")
+
+	c.send("AUTH", "wrong")
+	line, _ = c.reader.ReadString('\n')
+	assert.Contains(t, line, "-WRONGPASS")
+
+	c.send("AUTH", "default", "secret")
+	c.expect(t, "+OK")
+	c.send("PUBLISH", "a", "hello")
+	c.expect(t, ":0")
+	c.send("SUBSCRIBE", "a")
+	line, _ = c.reader.ReadString('\n')
+	assert.Contains(t, line, "-NOPERM")
+}
+
+func TestRESPDisconnectsSlowSubscribers(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	subscriber := dialRESP(t, server)
+	subscriber.send("SUBSCRIBE", "a")
+	subscriber.expect(t, "*3", "$9", "subscribe", "$1", "a", ":1")
+
+	// the subscriber never reads, so its socket buffers and then its subscription fill up
+	payload := string(make([]byte, 64<<10))
+	dropped := false
+	for range 10000 {
+		if server.broker.Publish("a", []byte(payload)) == 0 {
+			dropped = true
+			break
+		}
+	}
+	assert.True(t, dropped)
+}
+
+func TestRESPInline(t *testing.T) {
+	c := dialRESP(t, createServerWithCache(t, data.Options{}))
+
+	c.writer.WriteString("PING hi\r\nNOPE\r\nQUIT\r\n")
+	c.writer.Flush()
+
+	c.expect(t, "$2", "hi", "-ERR unknown command 'NOPE'", "+OK")
+	_, err := c.reader.ReadString('\n')
+	assert.NotNil(t, err)
+}
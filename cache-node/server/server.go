@@ -3,11 +3,14 @@ package server
 import (
 	"bytes"
 	"encoding/json"
-	"io"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
 )
 
 const (
@@ -16,43 +19,98 @@ const (
 	VALUE_NOT_FOUND_MSG = "Value not found"
 	VALUE_SET_MSG       = "Value set successfully"
 	VALUE_DELETED_MSG   = "Value deleted successfully"
+	READY_MSG           = "Ready"
+	NOT_READY_MSG       = "Not registered with the registry"
+)
+
+var (
+	ErrValueNotFound = loop.WithCode(loop.NOT_FOUND_CODE, fmt.Errorf("value not found"))
+	ErrMissingValue  = badRequest(fmt.Errorf("set exactly one of value or data"))
 )
 
 type RequestBody struct {
-	Key   string          `json:"key"`
-	Value loop.CacheEntry `json:"value"`
+	// falls back to the X-Cache-Namespace header, then the default namespace
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	// any json value, stored as its json text
+	Value json.RawMessage `json:"value,omitempty"`
+	// any bytes, base64 in json
+	Data []byte `json:"data,omitempty"`
+	// defaults to application/json for a value and application/octet-stream for data
+	ContentType string `json:"content_type,omitempty"`
+	Flags       uint32 `json:"flags,omitempty"`
+	// POST /invalidate?tag= deletes every key carrying the tag
+	Tags []string `json:"tags,omitempty"`
 }
 
 type Response struct {
-	Error   string          `json:"error,omitempty"`
-	Message string          `json:"message"`
-	Value   loop.CacheEntry `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+	// one of the loop error codes, set whenever Error is
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	// compact json values come back in value and everything else in data, both byte for byte
+	Value       json.RawMessage `json:"value,omitempty"`
+	Data        []byte          `json:"data,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	Flags       uint32          `json:"flags,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	// the value is past its soft ttl and due to be refreshed
+	Stale bool `json:"stale,omitempty"`
+	// the read was picked to refresh the value before it goes stale or expires
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+// for routes that answer with something other than a cache value
+type ValueResponse struct {
+	Message string `json:"message"`
+	Value   any    `json:"value"`
 }
 
 func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := decodeRequestBody(r.Body)
+	data, err := decodeRequestBody(r)
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
+		return
+	}
+	namespace, err := namespaceFor(r, data)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.READ_OP, namespace, data.Key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
 		return
 	}
 
-	cacheData, err := s.handleGetEvent(data.Key)
+	s.trackRead(w, r, namespace, data.Key)
+	cacheData, err := s.handleGetEvent(namespace, data.Key)
+	if err == nil {
+		cacheData, err = s.readThrough(namespace, data.Key, cacheData)
+	}
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
-	buf, err := encodeResponse(createGetResponse(cacheData.Ok, cacheData.Value))
+	buf, err := encodeResponse(w, r, createGetResponse(cacheData))
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
+	if cacheData.Ok {
+		writeEntryHeaders(w, cacheData)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
 	w.Write(buf.Bytes())
 }
 
-func (s *Server) handleGetEvent(key string) (loop.CacheEventResponse, error) {
-	event, r, e := loop.CreateGetEvent(key)
+func (s *Server) handleGetEvent(namespace string, key string) (loop.CacheEventResponse, error) {
+	event, r, e := loop.CreateGetEvent(namespace, key)
 
 	resp, err := s.sendEvent(event, r, e)
 	if err != nil {
@@ -62,43 +120,94 @@ func (s *Server) handleGetEvent(key string) (loop.CacheEventResponse, error) {
 	return resp, nil
 }
 
-func createGetResponse(ok bool, value loop.CacheEntry) Response {
-	if ok {
-		return Response{
-			Message: VALUE_FOUND_MSG,
-			Value:   value,
+func createGetResponse(cacheData loop.CacheEventResponse) Response {
+	if cacheData.Ok {
+		resp := Response{
+			Message:     VALUE_FOUND_MSG,
+			ContentType: cacheData.ContentType,
+			Flags:       cacheData.Flags,
+			Tags:        cacheData.Tags,
+			Stale:       cacheData.Stale,
+			Refresh:     cacheData.Refresh,
 		}
+		if value, ok := jsonValue(cacheData.ContentType, cacheData.Value); ok {
+			resp.Value = value
+		} else {
+			resp.Data = cacheData.Value
+		}
+		return resp
 	} else {
 		return Response{
+			Error:   ErrValueNotFound.Error(),
+			Code:    loop.NOT_FOUND_CODE,
 			Message: VALUE_NOT_FOUND_MSG,
 		}
 	}
 }
 
 func (s *Server) SetHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := decodeRequestBody(r.Body)
+	data, err := decodeRequestBody(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	namespace, err := namespaceFor(r, data)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.WRITE_OP, namespace, data.Key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
+		return
+	}
+	ttl, softTTL, err := ttlsFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	precondition, err := preconditionFor(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	entry, err := entryFor(data)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	entry.Cost, err = costFor(r)
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
-	_, err = s.handleSetEvent(data.Key, data.Value)
+	_, err = s.handleSetEvent(namespace, data.Key, entry, ttl, softTTL, precondition)
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
-	buf, err := encodeResponse(createSetResponse())
+	buf, err := encodeResponse(w, r, createSetResponse())
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
 	w.Write(buf.Bytes())
 }
 
-func (s *Server) handleSetEvent(key string, value loop.CacheEntry) (loop.CacheEventResponse, error) {
-	event, r, e := loop.CreateSetEvent(key, value)
+func (s *Server) handleSetEvent(namespace string, key string, entry loop.Entry, ttl time.Duration, softTTL time.Duration, precondition loop.Precondition) (loop.CacheEventResponse, error) {
+	event, r, e := loop.CreateSetEvent(namespace, key, entry.Value)
+	event.ContentType = entry.ContentType
+	event.Flags = entry.Flags
+	event.Tags = entry.Tags
+	event.TTL = ttl
+	event.SoftTTL = softTTL
+	event.Cost = entry.Cost
+	event.Precondition = precondition
 
 	resp, err := s.sendEvent(event, r, e)
 	if err != nil {
@@ -108,6 +217,28 @@ func (s *Server) handleSetEvent(key string, value loop.CacheEntry) (loop.CacheEv
 	return resp, nil
 }
 
+func entryFor(data RequestBody) (loop.Entry, error) {
+	if (data.Value == nil) == (data.Data == nil) {
+		return loop.Entry{}, ErrMissingValue
+	}
+	if err := validateTags(data.Tags); err != nil {
+		return loop.Entry{}, err
+	}
+
+	entry := loop.Entry{Value: loop.CacheEntry(data.Data), ContentType: data.ContentType, Flags: data.Flags, Tags: data.Tags}
+	if data.Value != nil {
+		entry.Value = loop.CacheEntry(data.Value)
+		if entry.ContentType == "" {
+			entry.ContentType = JSON_CONTENT_TYPE
+		}
+	}
+	if entry.ContentType == "" {
+		entry.ContentType = DEFAULT_CONTENT_TYPE
+	}
+
+	return entry, nil
+}
+
 func createSetResponse() Response {
 	return Response{
 		Message: VALUE_SET_MSG,
@@ -115,29 +246,41 @@ func createSetResponse() Response {
 }
 
 func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := decodeRequestBody(r.Body)
+	data, err := decodeRequestBody(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	namespace, err := namespaceFor(r, data)
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.authorize(r, auth.DELETE_OP, namespace, data.Key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if !s.admitNamespace(w, r, namespace) {
 		return
 	}
 
-	_, err = s.handleDeleteEvent(data.Key)
+	_, err = s.handleDeleteEvent(namespace, data.Key)
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
-	buf, err := encodeResponse(createDeleteResponse())
+	buf, err := encodeResponse(w, r, createDeleteResponse())
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
 	w.Write(buf.Bytes())
 }
 
-func (s *Server) handleDeleteEvent(key string) (loop.CacheEventResponse, error) {
-	event, r, e := loop.CreateDeleteEvent(key)
+func (s *Server) handleDeleteEvent(namespace string, key string) (loop.CacheEventResponse, error) {
+	event, r, e := loop.CreateDeleteEvent(namespace, key)
 
 	resp, err := s.sendEvent(event, r, e)
 	if err != nil {
@@ -153,12 +296,32 @@ func createDeleteResponse() Response {
 	}
 }
 
+// the node only reports ready once the registry routes keys to it
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := Response{Message: READY_MSG}
+	ready := s.isRegistered()
+	if !ready {
+		resp.Message = NOT_READY_MSG
+	}
+
+	buf, err := encodeResponse(w, r, resp)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(buf.Bytes())
+}
+
 func (s *Server) sendEvent(
 	event *loop.CacheEvent,
 	respChan chan loop.CacheEventResponse,
 	errChan chan error,
 ) (loop.CacheEventResponse, error) {
-	log.Printf("Sending Event (%v) key: '%v'", event.Type, event.Key)
+	slog.Debug("Sending Event", "type", event.Type, "namespace", event.Namespace, "key", event.Key)
 	s.eventLoop.Send(event)
 
 	select {
@@ -169,32 +332,50 @@ func (s *Server) sendEvent(
 	}
 }
 
-func decodeRequestBody(r io.ReadCloser) (RequestBody, error) {
-	defer r.Close()
+func decodeRequestBody(r *http.Request) (RequestBody, error) {
 	var data RequestBody
-	err := json.NewDecoder(r).Decode(&data)
+	err := decode(r, &data)
 	return data, err
 }
 
-func writeErrorResponse(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
-	enc, _ := encodeResponse(createErrorResponse(err))
+// the body's format comes from its Content-Type
+func decode(r *http.Request, v any) error {
+	defer r.Body.Close()
+	c, err := codec.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if err := c.Decode(r.Body, v); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+// the status and code come from the error, anything unrecognised is a 500
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	code := codeFor(err)
+	enc, _ := encodeResponse(w, r, createErrorResponse(err, code))
+	w.WriteHeader(statuses[code])
 	w.Write(enc.Bytes())
 }
 
-func createErrorResponse(err error) Response {
+func createErrorResponse(err error, code string) Response {
 	return Response{
 		Error:   err.Error(),
+		Code:    code,
 		Message: ERROR_MSG,
 	}
 }
 
-func encodeResponse(resp Response) (*bytes.Buffer, error) {
+// in whichever format the request's Accept header prefers, and sets the Content-Type to match
+func encodeResponse(w http.ResponseWriter, r *http.Request, resp any) (*bytes.Buffer, error) {
+	c := codec.ForAccept(r.Header.Get("Accept"))
 	buf := bytes.NewBuffer([]byte{})
-	err := json.NewEncoder(buf).Encode(resp)
+	err := c.Encode(buf, resp)
 	if err != nil {
 		return nil, err
 	}
 
+	w.Header().Set("Content-Type", c.ContentType())
 	return buf, nil
 }
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
 	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,7 +22,8 @@ const (
 )
 
 type MockEventLoop struct {
-	events chan *loop.CacheEvent
+	events  chan *loop.CacheEvent
+	pending int
 }
 
 func (el *MockEventLoop) Send(event *loop.CacheEvent) {
@@ -30,7 +35,7 @@ func (el *MockEventLoop) Send(event *loop.CacheEvent) {
 		case loop.GET_EVENT_KEY:
 			event.ResponseChan <- loop.CacheEventResponse{
 				Ok:    true,
-				Value: SUCCESS_VALUE,
+				Value: loop.CacheEntry(SUCCESS_VALUE),
 			}
 		case loop.SET_EVENT_KEY:
 			fallthrough
@@ -55,6 +60,10 @@ func (el *MockEventLoop) Run() {}
 
 func (el *MockEventLoop) Stop() {}
 
+func (el *MockEventLoop) Pending() int {
+	return el.pending
+}
+
 /**
 Test Cases:
 createMux returns the correct handler
@@ -74,31 +83,31 @@ func TestHandleGet(t *testing.T) {
 	key := SUCCESS_KEY
 	server := createServerWithEventLoop()
 
-	resp, err := server.handleGetEvent(key)
+	resp, err := server.handleGetEvent(loop.DEFAULT_NAMESPACE, key)
 	if err != nil {
 		handleError(t, err)
 	}
 
 	assert.NotNil(t, resp)
 	assert.True(t, resp.Ok)
-	assert.Equal(t, SUCCESS_VALUE, resp.Value)
+	assert.Equal(t, loop.CacheEntry(SUCCESS_VALUE), resp.Value)
 }
 
 func TestHandleGetError(t *testing.T) {
 	key := ERROR_KEY
 	server := createServerWithEventLoop()
 
-	_, err := server.handleGetEvent(key)
+	_, err := server.handleGetEvent(loop.DEFAULT_NAMESPACE, key)
 
 	assert.NotNil(t, err)
 }
 
 func TestHandleSet(t *testing.T) {
 	key := SUCCESS_KEY
-	value := "test"
+	value := loop.Entry{Value: loop.CacheEntry("test")}
 	server := createServerWithEventLoop()
 
-	resp, err := server.handleSetEvent(key, value)
+	resp, err := server.handleSetEvent(loop.DEFAULT_NAMESPACE, key, value, 0, 0, loop.Precondition{})
 	if err != nil {
 		handleError(t, err)
 	}
@@ -109,10 +118,10 @@ func TestHandleSet(t *testing.T) {
 
 func TestHandleSetError(t *testing.T) {
 	key := ERROR_KEY
-	value := "test"
+	value := loop.Entry{Value: loop.CacheEntry("test")}
 	server := createServerWithEventLoop()
 
-	_, err := server.handleSetEvent(key, value)
+	_, err := server.handleSetEvent(loop.DEFAULT_NAMESPACE, key, value, 0, 0, loop.Precondition{})
 
 	assert.NotNil(t, err)
 }
@@ -121,7 +130,7 @@ func TestHandleDelete(t *testing.T) {
 	key := SUCCESS_KEY
 	server := createServerWithEventLoop()
 
-	resp, err := server.handleDeleteEvent(key)
+	resp, err := server.handleDeleteEvent(loop.DEFAULT_NAMESPACE, key)
 	if err != nil {
 		handleError(t, err)
 	}
@@ -134,19 +143,19 @@ func TestHandleDeleteError(t *testing.T) {
 	key := ERROR_KEY
 	server := createServerWithEventLoop()
 
-	_, err := server.handleDeleteEvent(key)
+	_, err := server.handleDeleteEvent(loop.DEFAULT_NAMESPACE, key)
 
 	assert.NotNil(t, err)
 }
 
 func TestReadRequestBody(t *testing.T) {
-	expectedKey, expectedValue := "test", "my value"
+	expectedKey, expectedValue := "test", json.RawMessage(`"my value"`)
 	r, err := createReqBody(expectedKey, expectedValue)
 	if err != nil {
 		handleError(t, err)
 	}
 
-	data, err := decodeRequestBody(r)
+	data, err := decodeRequestBody(httptest.NewRequest(http.MethodPost, "/set", r))
 	if err != nil {
 		handleError(t, err)
 	}
@@ -157,7 +166,7 @@ func TestReadRequestBody(t *testing.T) {
 
 func TestSendEvent(t *testing.T) {
 	server := createServerWithEventLoop()
-	event, r, e := loop.CreateGetEvent(SUCCESS_KEY)
+	event, r, e := loop.CreateGetEvent(loop.DEFAULT_NAMESPACE, SUCCESS_KEY)
 
 	resp, err := server.sendEvent(event, r, e)
 	if err != nil {
@@ -166,13 +175,13 @@ func TestSendEvent(t *testing.T) {
 
 	assert.NotNil(t, resp)
 	assert.True(t, resp.Ok)
-	assert.Equal(t, SUCCESS_VALUE, resp.Value)
+	assert.Equal(t, loop.CacheEntry(SUCCESS_VALUE), resp.Value)
 }
 
 func TestSendEventError(t *testing.T) {
 	el := createMockEventLoop()
 	server := createServer(el)
-	event, r, e := loop.CreateGetEvent(ERROR_KEY)
+	event, r, e := loop.CreateGetEvent(loop.DEFAULT_NAMESPACE, ERROR_KEY)
 	_, err := server.sendEvent(event, r, e)
 
 	assert.NotNil(t, err)
@@ -184,38 +193,84 @@ func TestEncodeResponse(t *testing.T) {
 		Message: expectedMsg,
 	}
 
-	enc, err := encodeResponse(resp)
+	w := httptest.NewRecorder()
+	enc, err := encodeResponse(w, httptest.NewRequest(http.MethodPost, "/get", nil), resp)
 
 	assert.Nil(t, err)
 	assert.Contains(t, enc.String(), expectedMsg)
+	assert.Equal(t, codec.JSON, w.Header().Get("Content-Type"))
+}
+
+func TestEncodeResponseFollowsAccept(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/get", nil)
+	r.Header.Set("Accept", codec.MSGPACK)
+	w := httptest.NewRecorder()
+
+	enc, err := encodeResponse(w, r, Response{Message: "test response"})
+
+	var decoded Response
+	assert.Nil(t, err)
+	assert.Nil(t, codec.Msgpack.Decode(enc, &decoded))
+	assert.Equal(t, "test response", decoded.Message)
+	assert.Equal(t, codec.MSGPACK, w.Header().Get("Content-Type"))
 }
 
 func TestCreateErrorResponse(t *testing.T) {
 	errMsg := "my fancy error"
 	err := fmt.Errorf(errMsg)
-	resp := createErrorResponse(err)
+	resp := createErrorResponse(err, loop.INTERNAL_CODE)
 
 	assert.Equal(t, ERROR_MSG, resp.Message)
 	assert.Equal(t, errMsg, resp.Error)
+	assert.Equal(t, loop.INTERNAL_CODE, resp.Code)
 }
 
 func TestCreateGetResponseOk(t *testing.T) {
-	var val loop.CacheEntry = "test"
-	resp := createGetResponse(true, val)
+	val := loop.CacheEntry(`"test"`)
+	resp := createGetResponse(loop.CacheEventResponse{Ok: true, Value: val, ContentType: JSON_CONTENT_TYPE})
 
 	assert.NotNil(t, resp.Value)
 	assert.Equal(t, VALUE_FOUND_MSG, resp.Message)
-	assert.Equal(t, val, resp.Value)
+	assert.Equal(t, json.RawMessage(val), resp.Value)
+	assert.Nil(t, resp.Data)
+}
+
+func TestCreateGetResponseBytes(t *testing.T) {
+	val := loop.CacheEntry("\x00\xff")
+	resp := createGetResponse(loop.CacheEventResponse{Ok: true, Value: val, ContentType: DEFAULT_CONTENT_TYPE, Flags: 7})
+
+	assert.Nil(t, resp.Value)
+	assert.Equal(t, []byte(val), resp.Data)
+	assert.Equal(t, DEFAULT_CONTENT_TYPE, resp.ContentType)
+	assert.Equal(t, uint32(7), resp.Flags)
 }
 
 func TestCreateGetResponseNotOk(t *testing.T) {
-	var val loop.CacheEntry = "test"
-	resp := createGetResponse(false, val)
+	val := loop.CacheEntry("test")
+	resp := createGetResponse(loop.CacheEventResponse{Ok: false, Value: val})
 
 	assert.Nil(t, resp.Value)
 	assert.Equal(t, VALUE_NOT_FOUND_MSG, resp.Message)
 }
 
+func TestEntryFor(t *testing.T) {
+	entry, err := entryFor(RequestBody{Value: json.RawMessage("12345678901234567890")})
+	assert.Nil(t, err)
+	assert.Equal(t, loop.CacheEntry("12345678901234567890"), entry.Value)
+	assert.Equal(t, JSON_CONTENT_TYPE, entry.ContentType)
+
+	entry, _ = entryFor(RequestBody{Data: []byte{0, 1}, Flags: 3})
+	assert.Equal(t, loop.CacheEntry{0, 1}, entry.Value)
+	assert.Equal(t, DEFAULT_CONTENT_TYPE, entry.ContentType)
+	assert.Equal(t, uint32(3), entry.Flags)
+
+	_, err = entryFor(RequestBody{})
+	assert.ErrorIs(t, err, ErrMissingValue)
+
+	_, err = entryFor(RequestBody{Value: json.RawMessage("1"), Data: []byte{1}})
+	assert.ErrorIs(t, err, ErrMissingValue)
+}
+
 func createServer(el EventLoop) *Server {
 	return &Server{
 		eventLoop: el,
@@ -247,7 +302,7 @@ func createMockEventLoop() *MockEventLoop {
 	}
 }
 
-func createReqBody(key string, value any) (io.ReadCloser, error) {
+func createReqBody(key string, value json.RawMessage) (io.ReadCloser, error) {
 	data := RequestBody{
 		Key:   key,
 		Value: value,
@@ -265,3 +320,30 @@ func handleError(t *testing.T, err error) {
 	t.Fatalf("error occurred: %s", err)
 	t.FailNow()
 }
+
+func TestBinaryBodies(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	body := bytes.NewBuffer(nil)
+	codec.Msgpack.Encode(body, RequestBody{Key: "key", Data: []byte{0, 255}, Flags: 2})
+
+	set := sendRequest(server, http.MethodPost, "/set", body.String(), http.Header{"Content-Type": {codec.MSGPACK}})
+	assert.Equal(t, http.StatusOK, set.Code)
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "key"}`, http.Header{"Accept": {codec.CBOR}})
+	var resp Response
+	assert.Equal(t, codec.CBOR, get.Header().Get("Content-Type"))
+	assert.Nil(t, codec.Cbor.Decode(get.Body, &resp))
+	assert.Equal(t, []byte{0, 255}, resp.Data)
+	assert.Equal(t, uint32(2), resp.Flags)
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+
+	w := sendRequest(server, http.MethodPost, "/set", "<key/>", http.Header{"Content-Type": {"text/xml"}})
+
+	var resp Response
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, loop.UNSUPPORTED_MEDIA_TYPE_CODE, resp.Code)
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+)
+
+// requests a connection can have running at once, reading stops until one finishes
+const TCP_MAX_IN_FLIGHT = 256
+
+var ErrBatchedAuth = badRequest(fmt.Errorf("auth can't be batched"))
+
+type tcpFrame struct {
+	id   uint32
+	resp wire.Response
+}
+
+// serves the wire protocol on addr next to http, must be called before Run
+func (s *Server) ListenTCP(addr string) {
+	s.tcpAddr = addr
+}
+
+func (s *Server) runTCP() {
+	listener, err := net.Listen("tcp", s.tcpAddr)
+	if err != nil {
+		log.Printf("Couldn't listen on '%s': %s", s.tcpAddr, err)
+		return
+	}
+	if s.useTLS {
+		listener = tls.NewListener(listener, s.Server.TLSConfig)
+	}
+
+	log.Printf("Wire protocol listening on '%v'", s.tcpAddr)
+	s.ServeTCP(listener)
+}
+
+// serves the wire protocol on connections from l until the server shuts down
+func (s *Server) ServeTCP(l net.Listener) error {
+	return s.tcpConns.serve(l, s.serveConn)
+}
+
+func (s *Server) closeTCP() {
+	s.tcpConns.close()
+}
+
+// requests run concurrently and are answered as they finish, the ids tie them back together
+func (s *Server) serveConn(conn net.Conn) {
+	frames := make(chan tcpFrame, TCP_MAX_IN_FLIGHT)
+	written := make(chan struct{})
+	go writeFrames(conn, frames, written)
+
+	var key string
+	var running sync.WaitGroup
+	inFlight := make(chan struct{}, TCP_MAX_IN_FLIGHT)
+	reader := bufio.NewReader(conn)
+	for {
+		id, req, err := wire.ReadRequest(reader)
+		if errors.Is(err, wire.ErrMalformedFrame) {
+			// the op could be anything, including a batch the error can't be put in
+			frames <- tcpFrame{id: id, resp: wireError(0, badRequest(err))}
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("Closing wire connection", "addr", conn.RemoteAddr(), "err", err)
+			}
+			break
+		}
+
+		// later requests use the key, so it's handled before reading on
+		if req.Op == wire.OP_AUTH {
+			resp := s.authenticate(string(req.Value))
+			if resp.Code == "" {
+				key = string(req.Value)
+			}
+			frames <- tcpFrame{id: id, resp: resp}
+			continue
+		}
+
+		inFlight <- struct{}{}
+		running.Add(1)
+		go func(key string) {
+			defer running.Done()
+			frames <- tcpFrame{id: id, resp: s.handleWire(key, conn.RemoteAddr().String(), req)}
+			<-inFlight
+		}(key)
+	}
+
+	running.Wait()
+	close(frames)
+	<-written
+}
+
+// flushes whenever it catches up, so responses finishing together share a write
+func writeFrames(conn net.Conn, frames <-chan tcpFrame, written chan<- struct{}) {
+	defer close(written)
+
+	writer := bufio.NewWriter(conn)
+	var err error
+	for frame := range frames {
+		// keep draining so the requests still running can finish
+		if err != nil {
+			continue
+		}
+
+		err = wire.WriteResponse(writer, frame.id, frame.resp)
+		if err == nil && len(frames) == 0 {
+			err = writer.Flush()
+		}
+		if err != nil {
+			slog.Debug("Closing wire connection", "addr", conn.RemoteAddr(), "err", err)
+			conn.Close()
+		}
+	}
+}
+
+func (s *Server) authenticate(key string) wire.Response {
+	if acl := s.acl.Load(); acl != nil {
+		if _, ok := acl.Authenticate(key); !ok {
+			return wireError(wire.OP_AUTH, auth.ErrUnauthenticated)
+		}
+	}
+
+	return wire.Response{Op: wire.OP_AUTH}
+}
+
+// a batch queues all of its events before waiting on any, they run in the order given
+func (s *Server) handleWire(key string, remoteAddr string, req wire.Request) wire.Response {
+	requests := []wire.Request{req}
+	if req.Op == wire.OP_BATCH {
+		requests = req.Batch
+	}
+
+	events := make([]*loop.CacheEvent, len(requests))
+	responses := make([]wire.Response, len(requests))
+	for i, r := range requests {
+		event, err := s.wireEvent(key, remoteAddr, r)
+		if err != nil {
+			responses[i] = wireError(r.Op, err)
+			continue
+		}
+		slog.Debug("Sending Event", "type", event.Type, "namespace", event.Namespace, "key", event.Key)
+		s.eventLoop.Send(event)
+		events[i] = event
+	}
+
+	for i, event := range events {
+		if event == nil {
+			continue
+		}
+		var resp loop.CacheEventResponse
+		var err error
+		select {
+		case resp = <-event.ResponseChan:
+		case err = <-event.ErrorChan:
+		}
+		if err == nil && event.Type == loop.GET_EVENT_KEY {
+			resp, err = s.readThrough(event.Namespace, event.Key, resp)
+		}
+		if err != nil {
+			responses[i] = wireError(requests[i].Op, err)
+			continue
+		}
+		responses[i] = wireResponse(requests[i].Op, resp)
+	}
+
+	if req.Op == wire.OP_BATCH {
+		return wire.Response{Op: wire.OP_BATCH, Batch: responses}
+	}
+	return responses[0]
+}
+
+// the same checks as the http routes, applied to every request in a batch
+func (s *Server) wireEvent(key string, remoteAddr string, req wire.Request) (*loop.CacheEvent, error) {
+	if s.overloaded() {
+		return nil, ErrOverloaded
+	}
+	if ok, _ := s.clientLimiter.Allow(s.clientIdentity(key, remoteAddr)); !ok {
+		return nil, ErrRateLimited
+	}
+
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = loop.DEFAULT_NAMESPACE
+	} else if err := loop.ValidateNamespace(namespace); err != nil {
+		return nil, err
+	}
+	if req.Key == "" {
+		return nil, ErrMissingKey
+	}
+
+	var event *loop.CacheEvent
+	var op string
+	switch req.Op {
+	case wire.OP_GET:
+		event, _, _ = loop.CreateGetEvent(namespace, req.Key)
+		op = auth.READ_OP
+	case wire.OP_SET:
+		event, _, _ = loop.CreateSetEvent(namespace, req.Key, loop.CacheEntry(req.Value))
+		event.ContentType = req.ContentType
+		if event.ContentType == "" {
+			event.ContentType = DEFAULT_CONTENT_TYPE
+		}
+		event.Flags = req.Flags
+		event.TTL = time.Duration(req.TTL) * time.Second
+		op = auth.WRITE_OP
+	case wire.OP_DELETE:
+		event, _, _ = loop.CreateDeleteEvent(namespace, req.Key)
+		op = auth.DELETE_OP
+	default:
+		return nil, ErrBatchedAuth
+	}
+	if req.Version != 0 {
+		event.Precondition = loop.Precondition{Versions: []uint64{req.Version}}
+	}
+
+	if acl := s.acl.Load(); acl != nil {
+		if err := acl.Authorize(key, op, namespace, req.Key); err != nil {
+			return nil, err
+		}
+	}
+	if ok, _ := s.namespaceLimiter.Allow(namespace); !ok {
+		return nil, ErrRateLimited
+	}
+
+	return event, nil
+}
+
+// a miss is a not_found error, deleting a missing key isn't
+func wireResponse(op byte, resp loop.CacheEventResponse) wire.Response {
+	if op == wire.OP_GET && !resp.Ok {
+		return wireError(op, ErrValueNotFound)
+	}
+
+	return wire.Response{
+		Op:          op,
+		Value:       resp.Value,
+		ContentType: resp.ContentType,
+		Flags:       resp.Flags,
+		Version:     resp.Version,
+	}
+}
+
+func wireError(op byte, err error) wire.Response {
+	return wire.Response{Op: op, Code: codeFor(err), Error: err.Error()}
+}
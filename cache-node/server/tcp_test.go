@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialTCP(t *testing.T, server *Server) (net.Conn, *bufio.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTCP(l)
+	t.Cleanup(server.closeTCP)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestMalformedFrameKeepsTheConnection(t *testing.T) {
+	conn, reader := dialTCP(t, createServerWithCache(t, data.Options{}))
+
+	conn.Write([]byte{0, 0, 0, 5, 0, 0, 0, 1, 99})
+	wire.WriteRequest(conn, 2, wire.Request{Op: wire.OP_GET, Key: "missing"})
+
+	id, resp, err := wire.ReadResponse(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), id)
+	assert.Equal(t, loop.BAD_REQUEST_CODE, resp.Code)
+
+	id, resp, _ = wire.ReadResponse(reader)
+	assert.Equal(t, uint32(2), id)
+	assert.Equal(t, loop.NOT_FOUND_CODE, resp.Code)
+}
+
+func TestBatchedAuth(t *testing.T) {
+	conn, reader := dialTCP(t, createServerWithCache(t, data.Options{}))
+
+	wire.WriteRequest(conn, 1, wire.Request{Op: wire.OP_BATCH, Batch: []wire.Request{
+		{Op: wire.OP_AUTH, Key: "key", Value: []byte("api key")},
+		{Op: wire.OP_SET, Key: "key", Value: []byte("value")},
+	}})
+
+	_, resp, err := wire.ReadResponse(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, loop.BAD_REQUEST_CODE, resp.Batch[0].Code)
+	assert.Equal(t, "", resp.Batch[1].Code)
+}
+
+func TestCloseTCPClosesConnections(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	conn, reader := dialTCP(t, server)
+	wire.WriteRequest(conn, 1, wire.Request{Op: wire.OP_GET, Key: "missing"})
+	wire.ReadResponse(reader)
+
+	server.closeTCP()
+
+	_, _, err := wire.ReadResponse(reader)
+	assert.NotNil(t, err)
+}
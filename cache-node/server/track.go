@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+)
+
+const (
+	// on reads, tracks the key for the client with this id. the response echoes it only when
+	// the key is tracked, so a client can tell it may keep the value
+	TRACKING_HEADER = "X-Cache-Tracking"
+
+	// the first event on a tracking stream, with the id reads send
+	TRACKING_EVENT = "tracking"
+	// a key the client read has changed, it should drop its copy
+	INVALIDATE_EVENT = "invalidate"
+)
+
+var ErrTrackingUnavailable = loop.WithCode(loop.INTERNAL_CODE, fmt.Errorf("this node isn't tracking keys"))
+
+type TrackingEvent struct {
+	Type      string `json:"type"`
+	Id        string `json:"id,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key,omitempty"`
+}
+
+// the loop has to publish to the same table, must be called before Run
+func (s *Server) SetTrackingTable(table *track.Table) {
+	s.tracking = table
+}
+
+// streams an invalidation the first time each key read with the stream's id changes. the
+// client has to drop everything it kept once the stream ends, invalidations aren't replayed
+func (s *Server) TrackHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := s.connectTracking(auth.KeyFromRequest(r), r.RemoteAddr)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	defer client.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	writeServerSentEvent(w, 0, TRACKING_EVENT, TrackingEvent{Type: TRACKING_EVENT, Id: client.ID()})
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(WATCH_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case key, ok := <-client.Invalidations():
+			if !ok {
+				err := client.Err()
+				writeServerSentEvent(w, 0, ERROR_EVENT, createErrorResponse(err, codeFor(err)))
+				rc.Flush()
+				return
+			}
+			writeServerSentEvent(w, 0, INVALIDATE_EVENT, TrackingEvent{Type: INVALIDATE_EVENT, Namespace: key.Namespace, Key: key.Key})
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// any credential can track, reads still need access to the keys they track
+func (s *Server) connectTracking(key string, remoteAddr string) (*track.Client, error) {
+	if s.tracking == nil {
+		return nil, ErrTrackingUnavailable
+	}
+	if ok, _ := s.clientLimiter.Allow(s.clientIdentity(key, remoteAddr)); !ok {
+		return nil, ErrRateLimited
+	}
+	if acl := s.acl.Load(); acl != nil {
+		if _, ok := acl.Authenticate(key); !ok {
+			return nil, auth.ErrUnauthenticated
+		}
+	}
+
+	return s.tracking.Connect(0), nil
+}
+
+// must come before the read, an unknown id reads the key without tracking it
+func (s *Server) trackRead(w http.ResponseWriter, r *http.Request, namespace string, key string) {
+	id := r.Header.Get(TRACKING_HEADER)
+	if id == "" || s.tracking == nil {
+		return
+	}
+	if err := s.tracking.Track(id, loop.Key{Namespace: namespace, Key: key}); err != nil {
+		return
+	}
+
+	w.Header().Set(TRACKING_HEADER, id)
+}
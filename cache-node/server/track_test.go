@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTrackingServer(t *testing.T) (*Server, *httptest.Server) {
+	namespaces := data.NewNamespaces[string, loop.Entry](data.Options{}, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	table := track.NewTable()
+	eventLoop.SetPublisher(table)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+
+	server := New(eventLoop, ":8080", nil)
+	server.SetTrackingTable(table)
+	ts := httptest.NewServer(server.Handler)
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// returns the stream and its id
+func openTracking(t *testing.T, ts *httptest.Server) (*bufio.Reader, string) {
+	resp, err := http.Get(ts.URL + "/track")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	reader := bufio.NewReader(resp.Body)
+	event, data := readTrackingEvent(t, reader)
+	assert.Equal(t, TRACKING_EVENT, event)
+	return reader, data.Id
+}
+
+func readTrackingEvent(t *testing.T, reader *bufio.Reader) (string, TrackingEvent) {
+	var event string
+	var data TrackingEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event, data
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			assert.Nil(t, json.Unmarshal([]byte(value), &data))
+		}
+	}
+}
+
+func TestTrackedReadsAreInvalidated(t *testing.T) {
+	server, ts := createTrackingServer(t)
+	stream, id := openTracking(t, ts)
+	sendRequest(server, http.MethodPut, "/v1/keys/a", "1", nil)
+	sendRequest(server, http.MethodPut, "/v1/keys/b", "1", nil)
+
+	get := sendRequest(server, http.MethodPost, "/get", `{"key": "a"}`, http.Header{TRACKING_HEADER: {id}})
+	assert.Equal(t, id, get.Header().Get(TRACKING_HEADER))
+	sendRequest(server, http.MethodGet, "/v1/keys/b", "", http.Header{TRACKING_HEADER: {id}})
+	// untracked reads and keys aren't reported
+	sendRequest(server, http.MethodPut, "/v1/keys/c", "2", nil)
+	sendRequest(server, http.MethodPut, "/v1/keys/a", "2", nil)
+	sendRequest(server, http.MethodPut, "/v1/keys/a", "3", nil)
+	sendRequest(server, http.MethodDelete, "/v1/keys/b", "", nil)
+
+	event, data := readTrackingEvent(t, stream)
+	assert.Equal(t, INVALIDATE_EVENT, event)
+	assert.Equal(t, TrackingEvent{Type: INVALIDATE_EVENT, Namespace: loop.DEFAULT_NAMESPACE, Key: "a"}, data)
+	_, data = readTrackingEvent(t, stream)
+	assert.Equal(t, "b", data.Key)
+}
+
+func TestUnknownTrackingIdsAreNotEchoed(t *testing.T) {
+	server, _ := createTrackingServer(t)
+	sendRequest(server, http.MethodPut, "/v1/keys/a", "1", nil)
+
+	get := sendRequest(server, http.MethodGet, "/v1/keys/a", "", http.Header{TRACKING_HEADER: {"nobody"}})
+
+	assert.Equal(t, http.StatusOK, get.Code)
+	assert.Equal(t, "", get.Header().Get(TRACKING_HEADER))
+}
+
+func TestTrackingNeedsACredential(t *testing.T) {
+	server := createServerWithCache(t, data.Options{})
+	assert.Equal(t, http.StatusInternalServerError, sendRequest(server, http.MethodGet, "/track", "", nil).Code)
+
+	server.SetTrackingTable(track.NewTable())
+	server.SetACL(auth.New([]auth.Credential{{Name: "reader", Key: "secret", Rules: []auth.Rule{{Ops: []string{auth.READ_OP}}}}}))
+	assert.Equal(t, http.StatusUnauthorized, sendRequest(server, http.MethodGet, "/track", "", nil).Code)
+}
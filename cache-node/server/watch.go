@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/auth"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
+)
+
+const (
+	// sent when the changes since the client's sequence number are gone, it should read its keys again
+	RESET_EVENT = "reset"
+	// sent when the watcher was dropped, the client can resume from the last id it saw
+	ERROR_EVENT = "error"
+
+	// a comment is sent this often so idle streams aren't closed by proxies
+	WATCH_KEEPALIVE = 15 * time.Second
+
+	LAST_EVENT_ID_HEADER = "Last-Event-ID"
+)
+
+var (
+	ErrWatchUnavailable = loop.WithCode(loop.INTERNAL_CODE, fmt.Errorf("this node isn't publishing changes"))
+	ErrKeyAndPrefix     = badRequest(fmt.Errorf("watch either a key or a prefix"))
+	ErrInvalidSeq       = badRequest(fmt.Errorf("after and %s must be a sequence number", LAST_EVENT_ID_HEADER))
+)
+
+// the data of every server-sent event, values are split between value and data like POST /get
+type WatchEvent struct {
+	Seq         uint64          `json:"seq"`
+	Type        string          `json:"type"`
+	Namespace   string          `json:"namespace,omitempty"`
+	Key         string          `json:"key,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Data        []byte          `json:"data,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	Flags       uint32          `json:"flags,omitempty"`
+	Version     uint64          `json:"version,omitempty"`
+}
+
+// the loop has to publish to the same hub, must be called before Run
+func (s *Server) SetWatchHub(hub *watch.Hub) {
+	s.hub = hub
+}
+
+// streams changes as server-sent events, each with the change's sequence number as its id.
+// reconnecting with Last-Event-ID, or ?after=, replays what the client missed
+func (s *Server) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	namespace, err := namespaceFor(r, RequestBody{Namespace: query.Get("namespace")})
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	seq, err := resumeSeq(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	filter := watch.Filter{Namespace: namespace, Key: query.Get("key"), Prefix: query.Get("prefix")}
+	watcher, complete, err := s.watch(auth.KeyFromRequest(r), r.RemoteAddr, filter, seq)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if !complete {
+		writeServerSentEvent(w, watcher.Start(), RESET_EVENT, WatchEvent{Seq: watcher.Start(), Type: RESET_EVENT})
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(WATCH_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case change, ok := <-watcher.Changes():
+			if !ok {
+				err := watcher.Err()
+				writeServerSentEvent(w, 0, ERROR_EVENT, createErrorResponse(err, codeFor(err)))
+				rc.Flush()
+				return
+			}
+			writeServerSentEvent(w, change.Seq, change.Type, createWatchEvent(change))
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Last-Event-ID wins, EventSource sends it on reconnects to the same url
+func resumeSeq(r *http.Request) (uint64, error) {
+	value := r.Header.Get(LAST_EVENT_ID_HEADER)
+	if value == "" {
+		value = r.URL.Query().Get("after")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSeq
+	}
+	return seq, nil
+}
+
+// an id of 0 leaves the client's last id alone
+func writeServerSentEvent(w http.ResponseWriter, id uint64, event string, data any) {
+	buf := bytes.NewBuffer(nil)
+	if err := codec.Json.Encode(buf, data); err != nil {
+		return
+	}
+
+	if id != 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func createWatchEvent(change loop.Change) WatchEvent {
+	event := WatchEvent{
+		Seq:       change.Seq,
+		Type:      change.Type,
+		Namespace: change.Namespace,
+		Key:       change.Key,
+	}
+	if change.Type == loop.SET_CHANGE {
+		entry := change.Entry
+		event.ContentType = entry.ContentType
+		event.Flags = entry.Flags
+		event.Version = entry.Version
+		if value, ok := jsonValue(entry.ContentType, entry.Value); ok {
+			event.Value = value
+		} else {
+			event.Data = entry.Value
+		}
+	}
+
+	return event
+}
+
+// watching a prefix needs read access to the prefix, an empty namespace is the default namespace.
+// complete is false when some changes after seq are no longer kept
+func (s *Server) watch(key string, remoteAddr string, filter watch.Filter, seq uint64) (*watch.Watcher, bool, error) {
+	if s.hub == nil {
+		return nil, false, ErrWatchUnavailable
+	}
+	if ok, _ := s.clientLimiter.Allow(s.clientIdentity(key, remoteAddr)); !ok {
+		return nil, false, ErrRateLimited
+	}
+
+	if filter.Namespace == "" {
+		filter.Namespace = loop.DEFAULT_NAMESPACE
+	} else if err := loop.ValidateNamespace(filter.Namespace); err != nil {
+		return nil, false, err
+	}
+	if filter.Key != "" && filter.Prefix != "" {
+		return nil, false, ErrKeyAndPrefix
+	}
+
+	if acl := s.acl.Load(); acl != nil {
+		if err := acl.Authorize(key, auth.READ_OP, filter.Namespace, filter.Key+filter.Prefix); err != nil {
+			return nil, false, err
+		}
+	}
+
+	watcher, complete := s.hub.WatchFrom(filter, seq, 0)
+	return watcher, complete, nil
+}
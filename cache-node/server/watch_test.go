@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/adapter"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/data"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/brendenehlers/go-distributed-cache/cache-node/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+type serverSentEvent struct {
+	id    string
+	event string
+	data  WatchEvent
+}
+
+func createWatchServer(t *testing.T) (*Server, *httptest.Server) {
+	namespaces := data.NewNamespaces[string, loop.Entry](data.Options{}, adapter.SizeOf)
+	eventLoop := loop.NewEventLoop(adapter.NewInMemoryCacheAdapter(namespaces))
+	hub := watch.NewHub()
+	eventLoop.SetPublisher(hub)
+	go eventLoop.Run()
+	t.Cleanup(eventLoop.Stop)
+
+	server := New(eventLoop, ":8080", nil)
+	server.SetWatchHub(hub)
+	ts := httptest.NewServer(server.Handler)
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// returns once the stream's headers arrive, the watcher is registered by then
+func openWatch(t *testing.T, ts *httptest.Server, query string, lastEventId string) *bufio.Reader {
+	r, _ := http.NewRequest(http.MethodGet, ts.URL+"/watch?"+query, nil)
+	if lastEventId != "" {
+		r.Header.Set(LAST_EVENT_ID_HEADER, lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	return bufio.NewReader(resp.Body)
+}
+
+func readEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	var event serverSentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			assert.Nil(t, json.Unmarshal([]byte(value), &event.data))
+		}
+	}
+}
+
+func TestWatchStreamsChanges(t *testing.T) {
+	server, ts := createWatchServer(t)
+	reader := openWatch(t, ts, "prefix=user:", "")
+
+	sendRequest(server, http.MethodPost, "/set", `{"key": "session:1", "value": 1}`, nil)
+	sendRequest(server, http.MethodPost, "/set", `{"key": "user:1", "value": {"name":"a"}}`, nil)
+	sendRequest(server, http.MethodPost, "/delete", `{"key": "user:1"}`, nil)
+
+	set := readEvent(t, reader)
+	assert.Equal(t, loop.SET_CHANGE, set.event)
+	assert.Equal(t, "user:1", set.data.Key)
+	assert.JSONEq(t, `{"name": "a"}`, string(set.data.Value))
+	assert.NotEmpty(t, set.id)
+
+	del := readEvent(t, reader)
+	assert.Equal(t, loop.DELETE_CHANGE, del.event)
+	assert.Greater(t, del.data.Seq, set.data.Seq)
+}
+
+func TestWatchResumes(t *testing.T) {
+	server, ts := createWatchServer(t)
+	reader := openWatch(t, ts, "key=config", "")
+	sendRequest(server, http.MethodPost, "/set", `{"key": "config", "value": 1}`, nil)
+	first := readEvent(t, reader)
+
+	// changes made while the client is away
+	sendRequest(server, http.MethodPost, "/set", `{"key": "config", "value": 2}`, nil)
+	sendRequest(server, http.MethodPost, "/set", `{"key": "config", "value": 3}`, nil)
+
+	reader = openWatch(t, ts, "key=config", first.id)
+	assert.JSONEq(t, "2", string(readEvent(t, reader).data.Value))
+	assert.JSONEq(t, "3", string(readEvent(t, reader).data.Value))
+
+	reader = openWatch(t, ts, "key=config&after="+first.id, "")
+	assert.JSONEq(t, "2", string(readEvent(t, reader).data.Value))
+}
+
+func TestWatchResetsWhenChangesAreGone(t *testing.T) {
+	server, ts := createWatchServer(t)
+	sendRequest(server, http.MethodPost, "/set", `{"key": "config", "value": 1}`, nil)
+
+	// from before the node started
+	reader := openWatch(t, ts, "key=config", "1")
+
+	reset := readEvent(t, reader)
+	assert.Equal(t, RESET_EVENT, reset.event)
+	assert.NotEmpty(t, reset.id)
+}
+
+func TestWatchRejectsBadRequests(t *testing.T) {
+	server, _ := createWatchServer(t)
+
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodGet, "/watch?key=a&prefix=b", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, sendRequest(server, http.MethodGet, "/watch?after=abc", "", nil).Code)
+
+	assert.Equal(t, http.StatusInternalServerError, sendRequest(createServerWithCache(t, data.Options{}), http.MethodGet, "/watch", "", nil).Code)
+}
@@ -0,0 +1,254 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+// one change as a line of json, the format of both the file store and the dead-letter file
+type Record struct {
+	// loop.SET_CHANGE or loop.DELETE_CHANGE
+	Type        string     `json:"type"`
+	Namespace   string     `json:"namespace"`
+	Key         string     `json:"key"`
+	Value       []byte     `json:"value,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Flags       uint32     `json:"flags,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Version     uint64     `json:"version,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	StaleAt     *time.Time `json:"stale_at,omitempty"`
+	// whole milliseconds
+	CostMs int64 `json:"cost_ms,omitempty"`
+	// why a dead letter couldn't be written, empty in the file store
+	Error string `json:"error,omitempty"`
+}
+
+func RecordOf(change loop.Change) Record {
+	record := Record{
+		Type:        change.Type,
+		Namespace:   change.Namespace,
+		Key:         change.Key,
+		Value:       change.Entry.Value,
+		ContentType: change.Entry.ContentType,
+		Flags:       change.Entry.Flags,
+		Tags:        change.Entry.Tags,
+		Version:     change.Entry.Version,
+		CostMs:      loop.CostMillis(change.Entry.Cost),
+	}
+	if !change.Entry.ExpiresAt.IsZero() {
+		record.ExpiresAt = &change.Entry.ExpiresAt
+	}
+	if !change.Entry.StaleAt.IsZero() {
+		record.StaleAt = &change.Entry.StaleAt
+	}
+
+	return record
+}
+
+func (r Record) Change() loop.Change {
+	change := loop.Change{
+		Type:      r.Type,
+		Namespace: r.Namespace,
+		Key:       r.Key,
+		Entry: loop.Entry{
+			Value:       r.Value,
+			ContentType: r.ContentType,
+			Flags:       r.Flags,
+			Tags:        r.Tags,
+			Version:     r.Version,
+			Cost:        loop.CostOfMillis(r.CostMs),
+		},
+	}
+	if r.ExpiresAt != nil {
+		change.Entry.ExpiresAt = *r.ExpiresAt
+	}
+	if r.StaleAt != nil {
+		change.Entry.StaleAt = *r.StaleAt
+	}
+
+	return change
+}
+
+// a store kept in one append-only file of json lines and replayed into memory when it's
+// opened. for a single node and for testing without a real database
+type File struct {
+	file    *os.File
+	path    string
+	size    int64
+	entries map[loop.Key]loop.Entry
+	now     func() time.Time
+	mux     sync.Mutex
+}
+
+// replays the file, then rewrites it with only the live entries
+func OpenFile(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		file:    file,
+		path:    path,
+		entries: make(map[loop.Key]loop.Entry),
+		now:     time.Now,
+	}
+	if err := f.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := f.Compact(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// a last line without a newline is a write cut short by a crash and is dropped
+func (f *File) replay() error {
+	reader := bufio.NewReader(f.file)
+	for line := 1; ; line++ {
+		buf, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record Record
+		if err := json.Unmarshal(buf, &record); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		f.apply(record.Change())
+	}
+}
+
+// appends the changes in one write and syncs before any of them can be read
+func (f *File) Apply(changes []loop.Change) error {
+	buf := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buf)
+	for _, change := range changes {
+		if err := encoder.Encode(RecordOf(change)); err != nil {
+			return err
+		}
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		f.apply(change)
+	}
+
+	return nil
+}
+
+// a failed write is cut off again, so the next one doesn't land after half a line
+func (f *File) write(buf []byte) error {
+	_, err := f.file.WriteAt(buf, f.size)
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if err != nil {
+		f.file.Truncate(f.size)
+		return err
+	}
+
+	f.size += int64(len(buf))
+	return nil
+}
+
+func (f *File) apply(change loop.Change) {
+	key := loop.Key{Namespace: change.Namespace, Key: change.Key}
+	if change.Type == loop.DELETE_CHANGE {
+		delete(f.entries, key)
+		return
+	}
+
+	f.entries[key] = change.Entry
+}
+
+// expired entries read as missing
+func (f *File) Get(namespace string, key string) (loop.Entry, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	entry, ok := f.entries[loop.Key{Namespace: namespace, Key: key}]
+	if !ok || (!entry.ExpiresAt.IsZero() && !f.now().Before(entry.ExpiresAt)) {
+		return loop.Entry{}, false
+	}
+
+	return entry, true
+}
+
+// rewrites the file with a set for each live entry, replacing it once the copy is synced
+func (f *File) Compact() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	now := f.now()
+	for key, entry := range f.entries {
+		if !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt) {
+			delete(f.entries, key)
+			continue
+		}
+		change := loop.Change{Type: loop.SET_CHANGE, Namespace: key.Namespace, Key: key.Key, Entry: entry}
+		if err := encoder.Encode(RecordOf(change)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	f.file.Close()
+	f.file = tmp
+	f.size = size
+	return nil
+}
+
+func (f *File) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.file.Close()
+}
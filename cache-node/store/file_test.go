@@ -0,0 +1,99 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func set(key string, value string) loop.Change {
+	return loop.Change{Type: loop.SET_CHANGE, Namespace: loop.DEFAULT_NAMESPACE, Key: key, Entry: loop.Entry{Value: loop.CacheEntry(value)}}
+}
+
+func del(key string) loop.Change {
+	return loop.Change{Type: loop.DELETE_CHANGE, Namespace: loop.DEFAULT_NAMESPACE, Key: key}
+}
+
+func openFile(t *testing.T, path string) *File {
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestFileSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	f := openFile(t, path)
+	expiring := set("expiring", "v")
+	expiring.Entry.ExpiresAt = time.Now().Add(-time.Second)
+
+	assert.Nil(t, f.Apply([]loop.Change{set("a", "1"), set("b", "2"), expiring}))
+	assert.Nil(t, f.Apply([]loop.Change{del("a"), set("b", "3")}))
+	f.Close()
+
+	f = openFile(t, path)
+	_, ok := f.Get(loop.DEFAULT_NAMESPACE, "a")
+	assert.False(t, ok)
+	entry, ok := f.Get(loop.DEFAULT_NAMESPACE, "b")
+	assert.True(t, ok)
+	assert.Equal(t, loop.CacheEntry("3"), entry.Value)
+	_, ok = f.Get(loop.DEFAULT_NAMESPACE, "expiring")
+	assert.False(t, ok)
+
+	// reopening compacted the file down to the one live key
+	buf, _ := os.ReadFile(path)
+	assert.Equal(t, "{\"type\":\"set\",\"namespace\":\"default\",\"key\":\"b\",\"value\":\"Mw==\"}\n", string(buf))
+}
+
+func TestFileDropsATornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	f := openFile(t, path)
+	assert.Nil(t, f.Apply([]loop.Change{set("a", "1")}))
+	f.Close()
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString(`{"type":"set","namespace":"default","key":"b"`)
+	file.Close()
+
+	f = openFile(t, path)
+	assert.Nil(t, f.Apply([]loop.Change{set("c", "3")}))
+	f.Close()
+
+	f = openFile(t, path)
+	_, ok := f.Get(loop.DEFAULT_NAMESPACE, "a")
+	assert.True(t, ok)
+	_, ok = f.Get(loop.DEFAULT_NAMESPACE, "b")
+	assert.False(t, ok)
+	_, ok = f.Get(loop.DEFAULT_NAMESPACE, "c")
+	assert.True(t, ok)
+}
+
+func TestFileRejectsACorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	os.WriteFile(path, []byte("not json\n"), 0o600)
+
+	_, err := OpenFile(path)
+
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestFileKeepsCostInMilliseconds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	f := openFile(t, path)
+	costly := set("a", "1")
+	costly.Entry.Cost = 1500*time.Millisecond + time.Microsecond
+
+	assert.Nil(t, f.Apply([]loop.Change{costly}))
+	f.Close()
+
+	buf, _ := os.ReadFile(path)
+	assert.Contains(t, string(buf), `"cost_ms":1501`)
+	f = openFile(t, path)
+	entry, _ := f.Get(loop.DEFAULT_NAMESPACE, "a")
+	assert.Equal(t, 1501*time.Millisecond, entry.Cost)
+}
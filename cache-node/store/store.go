@@ -0,0 +1,224 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	// the loop waits for each write, a failed one fails the client's request and goes to the
+	// dead-letter file
+	WRITE_THROUGH = "write-through"
+	// writes are queued and applied in batches, failed ones go to the dead-letter file
+	WRITE_BEHIND = "write-behind"
+
+	DEFAULT_ATTEMPTS       = 3
+	DEFAULT_RETRY_BACKOFF  = 100 * time.Millisecond
+	DEFAULT_BATCH_SIZE     = 100
+	DEFAULT_FLUSH_INTERVAL = time.Second
+	DEFAULT_QUEUE_SIZE     = 10000
+)
+
+var (
+	ErrUnknownMode = fmt.Errorf("mode must be %s or %s", WRITE_THROUGH, WRITE_BEHIND)
+	ErrWriteFailed = fmt.Errorf("backing store write failed")
+	// write-behind has fallen too far behind the store, the write wasn't made
+	ErrQueueFull = fmt.Errorf("backing store queue is full")
+)
+
+// a system of record the cache writes its changes to
+type Store interface {
+	// applies every change in order, or returns an error
+	Apply(changes []loop.Change) error
+}
+
+// zero values are replaced with the defaults
+type Options struct {
+	// WRITE_THROUGH or WRITE_BEHIND, defaults to write-through
+	Mode string
+	// tries per write or batch, the wait between them doubles each time
+	Attempts     int
+	RetryBackoff time.Duration
+	// write-behind applies a batch once it's this big or FlushInterval has passed
+	BatchSize     int
+	FlushInterval time.Duration
+	// write-behind writes past this many waiting fail with ErrQueueFull
+	QueueSize int
+	// a file the writes that ran out of attempts are appended to, they're only logged without one
+	DeadLetter string
+}
+
+func (o Options) withDefaults() Options {
+	if o.Mode == "" {
+		o.Mode = WRITE_THROUGH
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = DEFAULT_ATTEMPTS
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = DEFAULT_RETRY_BACKOFF
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DEFAULT_BATCH_SIZE
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DEFAULT_FLUSH_INTERVAL
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DEFAULT_QUEUE_SIZE
+	}
+
+	return o
+}
+
+// sits between the loop and a store, a loop.BackingStore
+type Writer struct {
+	store      Store
+	options    Options
+	deadLetter *os.File
+	// nil in write-through mode
+	queue chan loop.Change
+	stop  chan struct{}
+	done  chan struct{}
+	close sync.Once
+}
+
+func NewWriter(store Store, options Options) (*Writer, error) {
+	options = options.withDefaults()
+	if options.Mode != WRITE_THROUGH && options.Mode != WRITE_BEHIND {
+		return nil, fmt.Errorf("%w, got '%s'", ErrUnknownMode, options.Mode)
+	}
+
+	w := &Writer{store: store, options: options}
+	if options.DeadLetter != "" {
+		file, err := os.OpenFile(options.DeadLetter, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		w.deadLetter = file
+	}
+	if options.Mode == WRITE_BEHIND {
+		w.queue = make(chan loop.Change, options.QueueSize)
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.run()
+	}
+
+	return w, nil
+}
+
+// called from the loop, so write-behind never blocks it
+func (w *Writer) Write(change loop.Change) error {
+	if w.queue == nil {
+		if err := w.apply([]loop.Change{change}); err != nil {
+			// the store may have applied it before failing, so it's kept for whoever reconciles the two
+			w.sendToDeadLetter([]loop.Change{change}, err)
+			return fmt.Errorf("%w: %s", ErrWriteFailed, err)
+		}
+		return nil
+	}
+
+	select {
+	case w.queue <- change:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// applies whatever write-behind still has queued, must be called after the loop stops
+func (w *Writer) Close() error {
+	var err error
+	w.close.Do(func() {
+		if w.stop != nil {
+			close(w.stop)
+			<-w.done
+		}
+		if w.deadLetter != nil {
+			err = w.deadLetter.Close()
+		}
+	})
+
+	return err
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]loop.Change, 0, w.options.BatchSize)
+	add := func(change loop.Change) {
+		batch = append(batch, change)
+		if len(batch) >= w.options.BatchSize {
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case change := <-w.queue:
+			add(change)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-w.stop:
+			for {
+				select {
+				case change := <-w.queue:
+					add(change)
+				default:
+					if len(batch) > 0 {
+						w.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *Writer) flush(batch []loop.Change) {
+	if err := w.apply(batch); err != nil {
+		log.Printf("Backing store write of %d changes failed: %s", len(batch), err)
+		w.sendToDeadLetter(batch, err)
+	}
+}
+
+func (w *Writer) apply(changes []loop.Change) error {
+	backoff := w.options.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.store.Apply(changes)
+		if err == nil || attempt == w.options.Attempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Writer) sendToDeadLetter(changes []loop.Change, cause error) {
+	if w.deadLetter == nil {
+		return
+	}
+
+	encoder := json.NewEncoder(w.deadLetter)
+	for _, change := range changes {
+		record := RecordOf(change)
+		record.Error = cause.Error()
+		if err := encoder.Encode(record); err != nil {
+			log.Printf("Couldn't write to the dead-letter file, %d changes are lost: %s", len(changes), err)
+			return
+		}
+	}
+}
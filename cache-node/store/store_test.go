@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+// fails the first failures calls
+type MockStore struct {
+	mux      sync.Mutex
+	batches  [][]loop.Change
+	calls    int
+	failures int
+}
+
+func (s *MockStore) Apply(changes []loop.Change) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.calls++
+	if s.calls <= s.failures {
+		return fmt.Errorf("store down")
+	}
+	s.batches = append(s.batches, append([]loop.Change(nil), changes...))
+	return nil
+}
+
+func newWriter(t *testing.T, store Store, options Options) *Writer {
+	options.RetryBackoff = time.Millisecond
+	w, err := NewWriter(store, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func readDeadLetters(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		json.Unmarshal(scanner.Bytes(), &record)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWriteThroughRetries(t *testing.T) {
+	store := &MockStore{failures: 2}
+	w := newWriter(t, store, Options{})
+
+	assert.Nil(t, w.Write(set("a", "1")))
+	assert.Equal(t, 3, store.calls)
+	assert.Equal(t, [][]loop.Change{{set("a", "1")}}, store.batches)
+}
+
+func TestWriteThroughFailsAfterItsAttempts(t *testing.T) {
+	deadLetter := filepath.Join(t.TempDir(), "dead")
+	store := &MockStore{failures: 2}
+	w := newWriter(t, store, Options{Attempts: 2, DeadLetter: deadLetter})
+
+	err := w.Write(set("a", "1"))
+
+	assert.ErrorIs(t, err, ErrWriteFailed)
+	assert.Equal(t, 2, store.calls)
+	records := readDeadLetters(t, deadLetter)
+	assert.Len(t, records, 1)
+	assert.Equal(t, set("a", "1"), records[0].Change())
+	assert.Equal(t, "store down", records[0].Error)
+}
+
+func TestWriteBehindBatches(t *testing.T) {
+	store := &MockStore{}
+	w := newWriter(t, store, Options{Mode: WRITE_BEHIND, BatchSize: 2, FlushInterval: time.Hour})
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Nil(t, w.Write(set(key, "v")))
+	}
+	w.Close()
+
+	assert.Equal(t, [][]loop.Change{{set("a", "v"), set("b", "v")}, {set("c", "v")}}, store.batches)
+}
+
+func TestWriteBehindFlushesOnAnInterval(t *testing.T) {
+	store := &MockStore{}
+	w := newWriter(t, store, Options{Mode: WRITE_BEHIND, FlushInterval: time.Millisecond})
+
+	w.Write(del("a"))
+
+	assert.Eventually(t, func() bool {
+		store.mux.Lock()
+		defer store.mux.Unlock()
+		return len(store.batches) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestWriteBehindDeadLetters(t *testing.T) {
+	deadLetter := filepath.Join(t.TempDir(), "dead")
+	store := &MockStore{failures: 3}
+	w := newWriter(t, store, Options{Mode: WRITE_BEHIND, DeadLetter: deadLetter})
+
+	w.Write(set("a", "1"))
+	w.Write(del("b"))
+	w.Close()
+
+	records := readDeadLetters(t, deadLetter)
+	assert.Len(t, records, 2)
+	assert.Equal(t, set("a", "1"), records[0].Change())
+	assert.Equal(t, "store down", records[0].Error)
+	assert.Equal(t, del("b"), records[1].Change())
+}
+
+func TestWriteBehindQueueFull(t *testing.T) {
+	// nothing drains the queue without the writer's goroutine
+	w := &Writer{queue: make(chan loop.Change, 1)}
+
+	assert.Nil(t, w.Write(set("a", "1")))
+	assert.ErrorIs(t, w.Write(set("b", "2")), ErrQueueFull)
+}
+
+func TestUnknownMode(t *testing.T) {
+	_, err := NewWriter(&MockStore{}, Options{Mode: "write-around"})
+
+	assert.ErrorIs(t, err, ErrUnknownMode)
+}
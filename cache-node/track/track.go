@@ -0,0 +1,181 @@
+package track
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	// invalidations a client can fall behind by before it's dropped
+	DEFAULT_BUFFER = 1024
+	// keys tracked for one client, tracking another past it invalidates one of the others
+	DEFAULT_MAX_KEYS = 100000
+)
+
+var (
+	ErrSlowConsumer  = fmt.Errorf("tracking client fell more than its buffer behind")
+	ErrClosed        = fmt.Errorf("tracking client closed")
+	ErrUnknownClient = fmt.Errorf("no tracking client with that id")
+)
+
+// remembers which clients read which keys, and tells them the first time a key changes
+// afterwards, like redis client-side caching. a key is forgotten once its readers are told,
+// reading it again tracks it again
+type Table struct {
+	clients map[string]*Client
+	// the clients that read each key since it last changed
+	readers map[loop.Key]map[*Client]struct{}
+	maxKeys int
+	mux     sync.Mutex
+}
+
+// one connected client, its invalidations are read from Invalidations
+type Client struct {
+	id            string
+	table         *Table
+	invalidations chan loop.Key
+	keys          map[loop.Key]struct{}
+	closed        bool
+	// why the client stopped, set before invalidations is closed
+	err error
+}
+
+func NewTable() *Table {
+	return NewTableWithMaxKeys(DEFAULT_MAX_KEYS)
+}
+
+func NewTableWithMaxKeys(maxKeys int) *Table {
+	return &Table{
+		clients: map[string]*Client{},
+		readers: map[loop.Key]map[*Client]struct{}{},
+		maxKeys: max(1, maxKeys),
+	}
+}
+
+// buffer defaults to DEFAULT_BUFFER when it's 0. the client's id is random, so only whoever
+// connected can track keys for it
+func (t *Table) Connect(buffer int) *Client {
+	if buffer <= 0 {
+		buffer = DEFAULT_BUFFER
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	c := &Client{
+		id:            hex.EncodeToString(id),
+		table:         t,
+		invalidations: make(chan loop.Key, buffer),
+		keys:          map[loop.Key]struct{}{},
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.clients[c.id] = c
+
+	return c
+}
+
+// the client with id is told the next time key changes. call it before reading the key, so a
+// change that lands between the read and the tracking isn't missed
+func (t *Table) Track(id string, key loop.Key) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	c, ok := t.clients[id]
+	if !ok {
+		return ErrUnknownClient
+	}
+	if _, ok := c.keys[key]; ok {
+		return nil
+	}
+	if len(c.keys) >= t.maxKeys {
+		for old := range c.keys {
+			t.invalidate(c, old)
+			break
+		}
+		if c.closed {
+			return c.err
+		}
+	}
+
+	c.keys[key] = struct{}{}
+	readers, ok := t.readers[key]
+	if !ok {
+		readers = map[*Client]struct{}{}
+		t.readers[key] = readers
+	}
+	readers[c] = struct{}{}
+
+	return nil
+}
+
+// a loop.Publisher, never blocks. a client with a full buffer is dropped with ErrSlowConsumer
+func (t *Table) Publish(change loop.Change) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	key := loop.Key{Namespace: change.Namespace, Key: change.Key}
+	for c := range t.readers[key] {
+		t.invalidate(c, key)
+	}
+}
+
+func (t *Table) invalidate(c *Client, key loop.Key) {
+	t.untrack(c, key)
+	select {
+	case c.invalidations <- key:
+	default:
+		t.remove(c, ErrSlowConsumer)
+	}
+}
+
+func (t *Table) untrack(c *Client, key loop.Key) {
+	delete(c.keys, key)
+	readers := t.readers[key]
+	delete(readers, c)
+	if len(readers) == 0 {
+		delete(t.readers, key)
+	}
+}
+
+func (t *Table) remove(c *Client, err error) {
+	if c.closed {
+		return
+	}
+
+	for key := range c.keys {
+		t.untrack(c, key)
+	}
+	delete(t.clients, c.id)
+	c.closed = true
+	c.err = err
+	close(c.invalidations)
+}
+
+// sent with reads to track the keys they read
+func (c *Client) ID() string {
+	return c.id
+}
+
+// closed once the client stops, Err says why. everything the client kept has to be dropped then,
+// its invalidations from here on are lost
+func (c *Client) Invalidations() <-chan loop.Key {
+	return c.invalidations
+}
+
+// only meaningful once Invalidations is closed
+func (c *Client) Err() error {
+	c.table.mux.Lock()
+	defer c.table.mux.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() {
+	c.table.mux.Lock()
+	defer c.table.mux.Unlock()
+	c.table.remove(c, ErrClosed)
+}
@@ -0,0 +1,88 @@
+package track
+
+import (
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func key(k string) loop.Key {
+	return loop.Key{Namespace: loop.DEFAULT_NAMESPACE, Key: k}
+}
+
+func change(k string) loop.Change {
+	return loop.Change{Type: loop.SET_CHANGE, Namespace: loop.DEFAULT_NAMESPACE, Key: k}
+}
+
+func TestReadersAreToldOnce(t *testing.T) {
+	table := NewTable()
+	a := table.Connect(0)
+	b := table.Connect(0)
+	assert.Nil(t, table.Track(a.ID(), key("x")))
+	assert.Nil(t, table.Track(b.ID(), key("x")))
+	assert.Nil(t, table.Track(a.ID(), key("y")))
+
+	table.Publish(change("x"))
+	table.Publish(change("x"))
+	table.Publish(change("z"))
+
+	assert.Equal(t, key("x"), <-a.Invalidations())
+	assert.Equal(t, key("x"), <-b.Invalidations())
+	assert.Empty(t, a.Invalidations())
+	assert.Empty(t, b.Invalidations())
+
+	table.Publish(loop.Change{Type: loop.DELETE_CHANGE, Namespace: loop.DEFAULT_NAMESPACE, Key: "y"})
+	assert.Equal(t, key("y"), <-a.Invalidations())
+}
+
+func TestNamespacesAreTrackedApart(t *testing.T) {
+	table := NewTable()
+	c := table.Connect(0)
+	table.Track(c.ID(), loop.Key{Namespace: "other", Key: "x"})
+
+	table.Publish(change("x"))
+
+	assert.Empty(t, c.Invalidations())
+}
+
+func TestUnknownClient(t *testing.T) {
+	table := NewTable()
+	c := table.Connect(0)
+	c.Close()
+	c.Close()
+
+	assert.ErrorIs(t, table.Track(c.ID(), key("x")), ErrUnknownClient)
+	assert.ErrorIs(t, table.Track("nobody", key("x")), ErrUnknownClient)
+	_, ok := <-c.Invalidations()
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Err(), ErrClosed)
+}
+
+func TestTrackingPastTheLimitInvalidatesAnotherKey(t *testing.T) {
+	table := NewTableWithMaxKeys(1)
+	c := table.Connect(0)
+	table.Track(c.ID(), key("x"))
+
+	assert.Nil(t, table.Track(c.ID(), key("y")))
+
+	assert.Equal(t, key("x"), <-c.Invalidations())
+	table.Publish(change("y"))
+	assert.Equal(t, key("y"), <-c.Invalidations())
+}
+
+func TestSlowClientsAreDropped(t *testing.T) {
+	table := NewTable()
+	c := table.Connect(1)
+	table.Track(c.ID(), key("x"))
+	table.Track(c.ID(), key("y"))
+
+	table.Publish(change("x"))
+	table.Publish(change("y"))
+
+	<-c.Invalidations()
+	_, ok := <-c.Invalidations()
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Err(), ErrSlowConsumer)
+	assert.ErrorIs(t, table.Track(c.ID(), key("x")), ErrUnknownClient)
+}
@@ -0,0 +1,190 @@
+package watch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+)
+
+const (
+	// changes a watcher can fall behind by before it's dropped
+	DEFAULT_BUFFER = 256
+	// recent changes kept for watchers resuming from a sequence number
+	DEFAULT_HISTORY = 1024
+)
+
+var (
+	ErrSlowConsumer = fmt.Errorf("watcher fell more than its buffer behind")
+	ErrClosed       = fmt.Errorf("watcher closed")
+)
+
+// an exact key when Key is set, otherwise every key starting with Prefix
+type Filter struct {
+	Namespace string
+	Key       string
+	Prefix    string
+}
+
+func (f Filter) Matches(change loop.Change) bool {
+	if change.Namespace != f.Namespace {
+		return false
+	}
+	if f.Key != "" {
+		return change.Key == f.Key
+	}
+
+	return strings.HasPrefix(change.Key, f.Prefix)
+}
+
+// hands the loop's changes to every watcher whose filter they match
+type Hub struct {
+	watchers map[*Watcher]struct{}
+	// a ring of the last changes, oldest at next once it's full
+	history []loop.Change
+	next    int
+	full    bool
+	// the sequence number of the last change published
+	last uint64
+	mux  sync.Mutex
+}
+
+type Watcher struct {
+	filter  Filter
+	changes chan loop.Change
+	hub     *Hub
+	// the last change published before the watcher started
+	start uint64
+	// why the watcher stopped, set before changes is closed
+	err error
+}
+
+func NewHub() *Hub {
+	return NewHubWithHistory(DEFAULT_HISTORY)
+}
+
+func NewHubWithHistory(history int) *Hub {
+	return &Hub{
+		watchers: map[*Watcher]struct{}{},
+		history:  make([]loop.Change, max(1, history)),
+	}
+}
+
+// never blocks, a watcher with a full buffer is dropped with ErrSlowConsumer
+func (h *Hub) Publish(change loop.Change) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.history[h.next] = change
+	h.next = (h.next + 1) % len(h.history)
+	h.full = h.full || h.next == 0
+	h.last = change.Seq
+
+	for w := range h.watchers {
+		if !w.filter.Matches(change) {
+			continue
+		}
+		select {
+		case w.changes <- change:
+		default:
+			h.remove(w, ErrSlowConsumer)
+		}
+	}
+}
+
+// buffer defaults to DEFAULT_BUFFER when it's 0
+func (h *Hub) Watch(filter Filter, buffer int) *Watcher {
+	w, _ := h.WatchFrom(filter, 0, buffer)
+	return w
+}
+
+// starts with the matching changes after seq that are still in the history. complete is
+// false when some of them aren't, the watcher then only sees changes from now on and the
+// caller should read its keys again. a seq of 0 starts from now
+func (h *Hub) WatchFrom(filter Filter, seq uint64, buffer int) (w *Watcher, complete bool) {
+	if buffer <= 0 {
+		buffer = DEFAULT_BUFFER
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	var replay []loop.Change
+	complete = true
+	if seq > 0 {
+		replay, complete = h.since(seq)
+	}
+
+	w = &Watcher{
+		filter:  filter,
+		changes: make(chan loop.Change, buffer+len(replay)),
+		hub:     h,
+		start:   h.last,
+	}
+	for _, change := range replay {
+		if filter.Matches(change) {
+			w.changes <- change
+		}
+	}
+	h.watchers[w] = struct{}{}
+
+	return w, complete
+}
+
+// with nothing in the history any seq is from before this node started
+func (h *Hub) since(seq uint64) ([]loop.Change, bool) {
+	changes := h.ordered()
+	if len(changes) == 0 || seq+1 < changes[0].Seq || seq > changes[len(changes)-1].Seq {
+		return nil, false
+	}
+
+	for i, change := range changes {
+		if change.Seq > seq {
+			return changes[i:], true
+		}
+	}
+	return nil, true
+}
+
+func (h *Hub) ordered() []loop.Change {
+	if !h.full {
+		return h.history[:h.next]
+	}
+
+	return append(h.history[h.next:len(h.history):len(h.history)], h.history[:h.next]...)
+}
+
+func (h *Hub) remove(w *Watcher, err error) {
+	if _, ok := h.watchers[w]; !ok {
+		return
+	}
+
+	delete(h.watchers, w)
+	w.err = err
+	close(w.changes)
+}
+
+// closed once the watcher stops, Err says why
+func (w *Watcher) Changes() <-chan loop.Change {
+	return w.changes
+}
+
+// every change the watcher sees comes after this one, resuming from it after an incomplete
+// WatchFrom misses nothing more
+func (w *Watcher) Start() uint64 {
+	return w.start
+}
+
+// only meaningful once Changes is closed
+func (w *Watcher) Err() error {
+	w.hub.mux.Lock()
+	defer w.hub.mux.Unlock()
+	return w.err
+}
+
+func (w *Watcher) Close() {
+	w.hub.mux.Lock()
+	defer w.hub.mux.Unlock()
+	w.hub.remove(w, ErrClosed)
+}
@@ -0,0 +1,106 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/cache-node/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+func change(namespace string, key string) loop.Change {
+	return loop.Change{Type: loop.SET_CHANGE, Namespace: namespace, Key: key}
+}
+
+func TestFilterMatches(t *testing.T) {
+	key := Filter{Namespace: "ns", Key: "user:1"}
+	assert.True(t, key.Matches(change("ns", "user:1")))
+	assert.False(t, key.Matches(change("ns", "user:10")))
+	assert.False(t, key.Matches(change("other", "user:1")))
+
+	prefix := Filter{Namespace: "ns", Prefix: "user:"}
+	assert.True(t, prefix.Matches(change("ns", "user:10")))
+	assert.False(t, prefix.Matches(change("ns", "session:1")))
+
+	assert.True(t, Filter{Namespace: "ns"}.Matches(change("ns", "anything")))
+}
+
+func TestWatchersOnlyGetMatchingChanges(t *testing.T) {
+	hub := NewHub()
+	w := hub.Watch(Filter{Namespace: "ns", Prefix: "a"}, 0)
+
+	hub.Publish(change("ns", "b"))
+	hub.Publish(change("ns", "a1"))
+
+	assert.Equal(t, "a1", (<-w.Changes()).Key)
+	assert.Empty(t, w.Changes())
+}
+
+func TestSlowWatchersAreDropped(t *testing.T) {
+	hub := NewHub()
+	w := hub.Watch(Filter{Namespace: "ns"}, 1)
+
+	hub.Publish(change("ns", "a"))
+	hub.Publish(change("ns", "b"))
+
+	<-w.Changes()
+	_, ok := <-w.Changes()
+	assert.False(t, ok)
+	assert.ErrorIs(t, w.Err(), ErrSlowConsumer)
+}
+
+func TestClose(t *testing.T) {
+	hub := NewHub()
+	w := hub.Watch(Filter{Namespace: "ns"}, 0)
+
+	w.Close()
+	w.Close()
+	hub.Publish(change("ns", "a"))
+
+	_, ok := <-w.Changes()
+	assert.False(t, ok)
+	assert.ErrorIs(t, w.Err(), ErrClosed)
+}
+
+func publishSeq(hub *Hub, seq uint64, key string) {
+	c := change("ns", key)
+	c.Seq = seq
+	hub.Publish(c)
+}
+
+func TestWatchFromReplaysHistory(t *testing.T) {
+	hub := NewHubWithHistory(3)
+	for seq := uint64(10); seq <= 13; seq++ {
+		publishSeq(hub, seq, "a")
+	}
+
+	w, complete := hub.WatchFrom(Filter{Namespace: "ns"}, 11, 0)
+	publishSeq(hub, 14, "a")
+
+	assert.True(t, complete)
+	assert.Equal(t, uint64(12), (<-w.Changes()).Seq)
+	assert.Equal(t, uint64(13), (<-w.Changes()).Seq)
+	assert.Equal(t, uint64(14), (<-w.Changes()).Seq)
+}
+
+func TestWatchFromReportsMissedChanges(t *testing.T) {
+	hub := NewHubWithHistory(3)
+	_, complete := hub.WatchFrom(Filter{Namespace: "ns"}, 5, 0)
+	assert.False(t, complete)
+
+	for seq := uint64(10); seq <= 13; seq++ {
+		publishSeq(hub, seq, "a")
+	}
+
+	// 10 left the history
+	w, complete := hub.WatchFrom(Filter{Namespace: "ns"}, 9, 0)
+	assert.False(t, complete)
+	assert.Empty(t, w.Changes())
+	assert.Equal(t, uint64(13), w.Start())
+
+	// from before a restart, the node's sequence started past it
+	_, complete = hub.WatchFrom(Filter{Namespace: "ns"}, 20, 0)
+	assert.False(t, complete)
+
+	_, complete = hub.WatchFrom(Filter{Namespace: "ns"}, 10, 0)
+	assert.True(t, complete)
+}
@@ -0,0 +1,373 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// every frame is
+//
+//	length u32 | id u32 | body
+//
+// where length counts the id and the body, and every integer is big endian.
+// a request body is
+//
+//	op u8 | namespace str8 | key str16 | content_type str8 | flags u32 | ttl u32 | version u64 | value bytes32
+//
+// or op u8 | count u16 | count request bodies for a batch. a response body is
+//
+//	op u8 | code str8 | error str16 | content_type str8 | flags u32 | version u64 | value bytes32
+//
+// or op u8 | count u16 | count response bodies, in the order the batch named them.
+// strN and bytesN are a uN length followed by that many bytes
+const (
+	OP_GET    byte = 1
+	OP_SET    byte = 2
+	OP_DELETE byte = 3
+	// runs every request in it and answers with one frame
+	OP_BATCH byte = 4
+	// the value is an api key used for every later request on the connection
+	OP_AUTH byte = 5
+
+	HEADER_SIZE    = 8
+	MAX_FRAME_SIZE = 64 << 20
+	MAX_BATCH_SIZE = math.MaxUint16
+)
+
+var (
+	ErrFrameTooLarge = fmt.Errorf("frame is larger than %d bytes", MAX_FRAME_SIZE)
+	// the frame was read whole, so the connection can carry on past it
+	ErrMalformedFrame = fmt.Errorf("malformed frame")
+	ErrShortFrame     = fmt.Errorf("frame ended early")
+	ErrUnknownOp      = fmt.Errorf("unknown op")
+	ErrNestedBatch    = fmt.Errorf("batches can't contain batches")
+	ErrBatchTooLarge  = fmt.Errorf("batches hold at most %d requests", MAX_BATCH_SIZE)
+)
+
+// maps onto a loop.CacheEvent, namespace defaults to the default namespace
+type Request struct {
+	Op          byte
+	Namespace   string
+	Key         string
+	Value       []byte
+	ContentType string
+	Flags       uint32
+	// seconds, 0 never expires
+	TTL uint32
+	// sets and deletes only apply to this version when it isn't 0
+	Version uint64
+	Batch   []Request
+}
+
+// a miss or a failure sets Code to one of the loop error codes
+type Response struct {
+	Op          byte
+	Code        string
+	Error       string
+	Value       []byte
+	ContentType string
+	Flags       uint32
+	Version     uint64
+	Batch       []Response
+}
+
+func WriteRequest(w io.Writer, id uint32, req Request) error {
+	e := newEncoder(id)
+	if err := e.request(req, true); err != nil {
+		return err
+	}
+	return e.writeTo(w)
+}
+
+func ReadRequest(r *bufio.Reader) (uint32, Request, error) {
+	id, d, err := readFrame(r)
+	if err != nil {
+		return 0, Request{}, err
+	}
+
+	req := d.request(true)
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("%d unread bytes at the end of the frame", len(d.buf))
+	}
+	return id, req, d.malformed()
+}
+
+func WriteResponse(w io.Writer, id uint32, resp Response) error {
+	e := newEncoder(id)
+	if err := e.response(resp, true); err != nil {
+		return err
+	}
+	return e.writeTo(w)
+}
+
+func ReadResponse(r *bufio.Reader) (uint32, Response, error) {
+	id, d, err := readFrame(r)
+	if err != nil {
+		return 0, Response{}, err
+	}
+
+	resp := d.response(true)
+	return id, resp, d.malformed()
+}
+
+func readFrame(r *bufio.Reader) (uint32, *decoder, error) {
+	var header [HEADER_SIZE]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > MAX_FRAME_SIZE {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if length < 4 {
+		return 0, nil, ErrShortFrame
+	}
+
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return binary.BigEndian.Uint32(header[4:]), &decoder{buf: body}, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+// leaves room for the length, filled in once the body is written
+func newEncoder(id uint32) *encoder {
+	buf := make([]byte, HEADER_SIZE, 64)
+	binary.BigEndian.PutUint32(buf[4:], id)
+	return &encoder{buf: buf}
+}
+
+func (e *encoder) writeTo(w io.Writer) error {
+	if len(e.buf)-4 > MAX_FRAME_SIZE {
+		return ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+func (e *encoder) request(req Request, top bool) error {
+	e.buf = append(e.buf, req.Op)
+	switch req.Op {
+	case OP_BATCH:
+		if !top {
+			return ErrNestedBatch
+		}
+		if len(req.Batch) > MAX_BATCH_SIZE {
+			return ErrBatchTooLarge
+		}
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(len(req.Batch)))
+		for _, r := range req.Batch {
+			if err := e.request(r, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OP_GET, OP_SET, OP_DELETE, OP_AUTH:
+	default:
+		return ErrUnknownOp
+	}
+
+	if err := e.string8(req.Namespace); err != nil {
+		return err
+	}
+	if err := e.string16(req.Key); err != nil {
+		return err
+	}
+	if err := e.string8(req.ContentType); err != nil {
+		return err
+	}
+	e.buf = binary.BigEndian.AppendUint32(e.buf, req.Flags)
+	e.buf = binary.BigEndian.AppendUint32(e.buf, req.TTL)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, req.Version)
+	return e.bytes32(req.Value)
+}
+
+func (e *encoder) response(resp Response, top bool) error {
+	e.buf = append(e.buf, resp.Op)
+	if resp.Op == OP_BATCH {
+		if !top {
+			return ErrNestedBatch
+		}
+		if len(resp.Batch) > MAX_BATCH_SIZE {
+			return ErrBatchTooLarge
+		}
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(len(resp.Batch)))
+		for _, r := range resp.Batch {
+			if err := e.response(r, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := e.string8(resp.Code); err != nil {
+		return err
+	}
+	if err := e.string16(resp.Error); err != nil {
+		return err
+	}
+	if err := e.string8(resp.ContentType); err != nil {
+		return err
+	}
+	e.buf = binary.BigEndian.AppendUint32(e.buf, resp.Flags)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, resp.Version)
+	return e.bytes32(resp.Value)
+}
+
+func (e *encoder) string8(s string) error {
+	if len(s) > math.MaxUint8 {
+		return fmt.Errorf("'%.16s...' is longer than %d bytes", s, math.MaxUint8)
+	}
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	return nil
+}
+
+func (e *encoder) string16(s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("'%.16s...' is longer than %d bytes", s, math.MaxUint16)
+	}
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(len(s)))
+	e.buf = append(e.buf, s...)
+	return nil
+}
+
+func (e *encoder) bytes32(b []byte) error {
+	if len(b) > MAX_FRAME_SIZE {
+		return ErrFrameTooLarge
+	}
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(len(b)))
+	e.buf = append(e.buf, b...)
+	return nil
+}
+
+// the first error sticks, every read after it returns zero values
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) request(top bool) Request {
+	req := Request{Op: d.uint8()}
+	switch req.Op {
+	case OP_BATCH:
+		if !top {
+			d.fail(ErrNestedBatch)
+			return req
+		}
+		count := int(d.uint16())
+		req.Batch = make([]Request, 0, min(count, len(d.buf)))
+		for i := 0; i < count && d.err == nil; i++ {
+			req.Batch = append(req.Batch, d.request(false))
+		}
+		return req
+	case OP_GET, OP_SET, OP_DELETE, OP_AUTH:
+	default:
+		d.fail(ErrUnknownOp)
+		return req
+	}
+
+	req.Namespace = string(d.next(int(d.uint8())))
+	req.Key = string(d.next(int(d.uint16())))
+	req.ContentType = string(d.next(int(d.uint8())))
+	req.Flags = d.uint32()
+	req.TTL = d.uint32()
+	req.Version = d.uint64()
+	req.Value = d.next(int(d.uint32()))
+	return req
+}
+
+func (d *decoder) response(top bool) Response {
+	resp := Response{Op: d.uint8()}
+	if resp.Op == OP_BATCH {
+		if !top {
+			d.fail(ErrNestedBatch)
+			return resp
+		}
+		count := int(d.uint16())
+		resp.Batch = make([]Response, 0, min(count, len(d.buf)))
+		for i := 0; i < count && d.err == nil; i++ {
+			resp.Batch = append(resp.Batch, d.response(false))
+		}
+		return resp
+	}
+
+	resp.Code = string(d.next(int(d.uint8())))
+	resp.Error = string(d.next(int(d.uint16())))
+	resp.ContentType = string(d.next(int(d.uint8())))
+	resp.Flags = d.uint32()
+	resp.Version = d.uint64()
+	resp.Value = d.next(int(d.uint32()))
+	return resp
+}
+
+func (d *decoder) malformed() error {
+	if d.err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedFrame, d.err)
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// the returned slice shares the frame's memory, which isn't reused
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.fail(ErrShortFrame)
+		return nil
+	}
+
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
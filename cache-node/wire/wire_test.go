@@ -0,0 +1,112 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	req := Request{
+		Op:          OP_SET,
+		Namespace:   "ns",
+		Key:         "key",
+		Value:       []byte{0, 255},
+		ContentType: "image/png",
+		Flags:       7,
+		TTL:         60,
+		Version:     42,
+	}
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, WriteRequest(buf, 3, req))
+
+	id, decoded, err := ReadRequest(bufio.NewReader(buf))
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), id)
+	assert.Equal(t, req, decoded)
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	req := Request{Op: OP_BATCH, Batch: []Request{
+		{Op: OP_GET, Key: "a", Value: []byte{}},
+		{Op: OP_DELETE, Key: "b", Value: []byte{}},
+	}}
+	resp := Response{Op: OP_BATCH, Batch: []Response{
+		{Op: OP_GET, Value: []byte("value")},
+		{Op: OP_DELETE, Code: "not_found", Error: "value not found", Value: []byte{}},
+	}}
+	buf := bytes.NewBuffer(nil)
+	WriteRequest(buf, 1, req)
+	WriteResponse(buf, 1, resp)
+	r := bufio.NewReader(buf)
+
+	_, decodedReq, err := ReadRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, req, decodedReq)
+
+	_, decodedResp, err := ReadResponse(r)
+	assert.Nil(t, err)
+	assert.Equal(t, resp, decodedResp)
+}
+
+func TestFramesArePipelined(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	for id := uint32(1); id <= 3; id++ {
+		WriteRequest(buf, id, Request{Op: OP_GET, Key: "key"})
+	}
+	r := bufio.NewReader(buf)
+
+	for id := uint32(1); id <= 3; id++ {
+		read, _, err := ReadRequest(r)
+		assert.Nil(t, err)
+		assert.Equal(t, id, read)
+	}
+}
+
+func TestNestedBatch(t *testing.T) {
+	err := WriteRequest(bytes.NewBuffer(nil), 1, Request{Op: OP_BATCH, Batch: []Request{{Op: OP_BATCH}}})
+
+	assert.ErrorIs(t, err, ErrNestedBatch)
+}
+
+func TestUnknownOp(t *testing.T) {
+	assert.ErrorIs(t, WriteRequest(bytes.NewBuffer(nil), 1, Request{Op: 99}), ErrUnknownOp)
+
+	frame := []byte{0, 0, 0, 5, 0, 0, 0, 1, 99}
+	id, _, err := ReadRequest(bufio.NewReader(bytes.NewReader(frame)))
+	assert.ErrorIs(t, err, ErrUnknownOp)
+	assert.ErrorIs(t, err, ErrMalformedFrame)
+	assert.Equal(t, uint32(1), id)
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	header := binary.BigEndian.AppendUint32(nil, MAX_FRAME_SIZE+1)
+	header = binary.BigEndian.AppendUint32(header, 1)
+
+	_, _, err := ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+func TestShortFrame(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	WriteRequest(buf, 1, Request{Op: OP_GET, Key: "key"})
+	frame := buf.Bytes()
+	// claim a shorter body than the request needs
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4-3))
+
+	_, _, err := ReadRequest(bufio.NewReader(bytes.NewReader(frame)))
+
+	assert.ErrorIs(t, err, ErrShortFrame)
+}
+
+func TestLongNamespace(t *testing.T) {
+	err := WriteRequest(bytes.NewBuffer(nil), 1, Request{Op: OP_GET, Namespace: strings.Repeat("a", 256)})
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,260 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	DEFAULT_APPLY_TIMEOUT = 5 * time.Second
+	DEFAULT_MAX_POOL      = 3
+	DEFAULT_TCP_TIMEOUT   = 10 * time.Second
+	DEFAULT_SNAPSHOTS     = 2
+
+	LOG_FILE_NAME = "raft.db"
+)
+
+var (
+	ErrNotLeader   = fmt.Errorf("not the cluster leader")
+	ErrPeersFormat = fmt.Errorf("peers must be formatted as 'httpAddr=raftAddr'")
+)
+
+// every member is identified by the address its http server listens on,
+// so a follower can point writes at the leader's http server
+type Peer struct {
+	HttpAddr string
+	RaftAddr string
+}
+
+type Options struct {
+	HttpAddr string
+	RaftAddr string
+	Peers    []Peer
+	// the log and snapshots are kept in memory when empty
+	DataDir string
+	// raft traffic is sent over tls when set, every member needs a certificate from the same ca
+	Certs *certs.Certs
+}
+
+type Cluster struct {
+	raft *raft.Raft
+	fsm  *fsm
+}
+
+func New(options Options) (*Cluster, error) {
+	transport, err := newTransport(options)
+	if err != nil {
+		return nil, err
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(options.HttpAddr)
+	config.LogLevel = "INFO"
+
+	if options.DataDir == "" {
+		store := raft.NewInmemStore()
+		return newCluster(config, transport, store, store, raft.NewInmemSnapshotStore(), options.Peers)
+	}
+
+	if err := os.MkdirAll(options.DataDir, 0o755); err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(options.DataDir, LOG_FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(options.DataDir, DEFAULT_SNAPSHOTS, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCluster(config, transport, store, store, snapshots, options.Peers)
+}
+
+func newTransport(options Options) (raft.Transport, error) {
+	if options.Certs == nil {
+		return raft.NewTCPTransport(options.RaftAddr, nil, DEFAULT_MAX_POOL, DEFAULT_TCP_TIMEOUT, os.Stderr)
+	}
+
+	stream, err := newTlsStreamLayer(options.RaftAddr, options.Certs.MutualServerConfig(), options.Certs.ClientConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return raft.NewNetworkTransport(stream, DEFAULT_MAX_POOL, DEFAULT_TCP_TIMEOUT, os.Stderr), nil
+}
+
+func newCluster(
+	config *raft.Config,
+	transport raft.Transport,
+	logs raft.LogStore,
+	stable raft.StableStore,
+	snapshots raft.SnapshotStore,
+	peers []Peer,
+) (*Cluster, error) {
+	f := newFsm()
+
+	r, err := raft.NewRaft(config, f, logs, stable, snapshots, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	// every member bootstraps the same configuration, only the first call has any effect
+	err = r.BootstrapCluster(configuration(peers)).Error()
+	if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		return nil, err
+	}
+
+	return &Cluster{
+		raft: r,
+		fsm:  f,
+	}, nil
+}
+
+func configuration(peers []Peer) raft.Configuration {
+	servers := make([]raft.Server, 0, len(peers))
+	for _, peer := range peers {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(peer.HttpAddr),
+			Address: raft.ServerAddress(peer.RaftAddr),
+		})
+	}
+
+	return raft.Configuration{Servers: servers}
+}
+
+func ParsePeers(value string) ([]Peer, error) {
+	peers := []Peer{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		httpAddr, raftAddr, ok := strings.Cut(part, "=")
+		if !ok || httpAddr == "" || raftAddr == "" {
+			return nil, ErrPeersFormat
+		}
+		peers = append(peers, Peer{HttpAddr: httpAddr, RaftAddr: raftAddr})
+	}
+
+	return peers, nil
+}
+
+func (c *Cluster) Register(url string) ([]registry.Migration, error) {
+	return c.apply(command{Op: REGISTER_OP, Url: url, At: time.Now()})
+}
+
+func (c *Cluster) Unregister(url string) ([]registry.Migration, error) {
+	return c.apply(command{Op: UNREGISTER_OP, Url: url})
+}
+
+// renewals go through the log so whichever member leads next knows when each node was last
+// heard from. the leader has applied it by the time it returns, so its copy is current
+func (c *Cluster) Heartbeat(url string) (bool, error) {
+	if _, err := c.apply(command{Op: HEARTBEAT_OP, Url: url, At: time.Now()}); err != nil {
+		return false, err
+	}
+
+	return c.HasNode(url), nil
+}
+
+// the leader decides which nodes expired and logs an unregistration for each, so every
+// member removes the same ones
+func (c *Cluster) Expire(lease time.Duration) ([]registry.Migration, error) {
+	if !c.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	migrations := []registry.Migration{}
+	for _, url := range c.fsm.current().Expired(time.Now(), lease) {
+		moved, err := c.apply(command{Op: UNREGISTER_OP, Url: url})
+		if err != nil {
+			return migrations, err
+		}
+		migrations = append(migrations, moved...)
+	}
+
+	return migrations, nil
+}
+
+// reads are served from the local copy, which may trail the leader slightly
+func (c *Cluster) GetNode() (*registry.RegistryEntry, error) {
+	return c.fsm.current().GetNode()
+}
+
+func (c *Cluster) GetNodeForKey(key string) (*registry.RegistryEntry, error) {
+	return c.fsm.current().GetNodeForKey(key)
+}
+
+func (c *Cluster) HasNode(url string) bool {
+	return c.fsm.current().HasNode(url)
+}
+
+func (c *Cluster) ListNodes() []*registry.RegistryEntry {
+	return c.fsm.current().ListNodes()
+}
+
+func (c *Cluster) Changed() <-chan struct{} {
+	return c.fsm.changes()
+}
+
+func (c *Cluster) Epoch() string {
+	return c.fsm.current().Epoch()
+}
+
+func (c *Cluster) IsLeader() bool {
+	return c.raft.State() == raft.Leader
+}
+
+func (c *Cluster) LeaderUrl() string {
+	_, id := c.raft.LeaderWithID()
+	return string(id)
+}
+
+func (c *Cluster) Shutdown() error {
+	return c.raft.Shutdown().Error()
+}
+
+func (c *Cluster) apply(cmd command) ([]registry.Migration, error) {
+	if !c.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	// a brand new group picks its epoch with the first write
+	if c.Epoch() == "" {
+		if _, err := c.applyCommand(command{Op: EPOCH_OP, Epoch: regmap.NewEpoch()}); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.applyCommand(cmd)
+}
+
+func (c *Cluster) applyCommand(cmd command) ([]registry.Migration, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	future := c.raft.Apply(data, DEFAULT_APPLY_TIMEOUT)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return nil, ErrNotLeader
+		}
+		return nil, err
+	}
+
+	result := future.Response().(applyResult)
+	return result.migrations, result.err
+}
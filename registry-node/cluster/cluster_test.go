@@ -0,0 +1,247 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockSnapshotSink struct {
+	bytes.Buffer
+}
+
+func (s *MockSnapshotSink) ID() string    { return "mock" }
+func (s *MockSnapshotSink) Cancel() error { return nil }
+func (s *MockSnapshotSink) Close() error  { return nil }
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("a:1=a:2, b:1=b:2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer{{HttpAddr: "a:1", RaftAddr: "a:2"}, {HttpAddr: "b:1", RaftAddr: "b:2"}}, peers)
+}
+
+func TestParsePeersInvalid(t *testing.T) {
+	_, err := ParsePeers("a:1")
+
+	assert.ErrorIs(t, err, ErrPeersFormat)
+}
+
+func TestFsmAppliesCommands(t *testing.T) {
+	f := newFsm()
+
+	applyCommand(f, command{Op: REGISTER_OP, Url: "a.com"})
+	result := applyCommand(f, command{Op: REGISTER_OP, Url: "b.com"})
+	assert.Nil(t, result.err)
+	assert.NotEmpty(t, result.migrations)
+
+	result = applyCommand(f, command{Op: UNREGISTER_OP, Url: "a.com"})
+	assert.Nil(t, result.err)
+
+	nodes := f.current().ListNodes()
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "b.com", nodes[0].Url)
+}
+
+func TestFsmSignalsChanges(t *testing.T) {
+	f := newFsm()
+	changed := f.changes()
+
+	applyCommand(f, command{Op: REGISTER_OP, Url: "a.com"})
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected the change channel to close")
+	}
+	assert.NotEqual(t, changed, f.changes())
+}
+
+func TestFsmKeepsFirstEpoch(t *testing.T) {
+	f := newFsm()
+	assert.Equal(t, "", f.current().Epoch())
+
+	applyCommand(f, command{Op: EPOCH_OP, Epoch: "first"})
+	applyCommand(f, command{Op: EPOCH_OP, Epoch: "second"})
+
+	assert.Equal(t, "first", f.current().Epoch())
+}
+
+func TestFsmRejectsUnknownCommand(t *testing.T) {
+	f := newFsm()
+
+	result := applyCommand(f, command{Op: "nope"})
+
+	assert.ErrorIs(t, result.err, ErrUnknownOp)
+}
+
+func TestFsmSnapshotRestore(t *testing.T) {
+	f := newFsm()
+	applyCommand(f, command{Op: REGISTER_OP, Url: "a.com"})
+	applyCommand(f, command{Op: REGISTER_OP, Url: "b.com"})
+
+	snapshot, err := f.Snapshot()
+	assert.Nil(t, err)
+	sink := &MockSnapshotSink{}
+	assert.Nil(t, snapshot.Persist(sink))
+
+	restored := newFsm()
+	assert.Nil(t, restored.Restore(io.NopCloser(&sink.Buffer)))
+
+	assert.Equal(t, f.current().State(), restored.current().State())
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		expected, _ := f.current().GetNodeForKey(key)
+		actual, _ := restored.current().GetNodeForKey(key)
+		assert.Equal(t, expected.Url, actual.Url)
+	}
+}
+
+func TestClusterReplicatesWritesFromLeader(t *testing.T) {
+	clusters := createInmemCluster(t, 3)
+	defer func() {
+		for _, c := range clusters {
+			c.Shutdown()
+		}
+	}()
+
+	leader := waitForLeader(t, clusters)
+	for _, c := range clusters {
+		if c == leader {
+			continue
+		}
+		_, err := c.Register("follower.com")
+		assert.ErrorIs(t, err, ErrNotLeader)
+		assert.Eventually(t, func() bool {
+			return c.LeaderUrl() == leader.LeaderUrl()
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+
+	_, err := leader.Register("a.com")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, leader.Epoch())
+
+	assert.Eventually(t, func() bool {
+		for _, c := range clusters {
+			node, err := c.GetNode()
+			if err != nil || node.Url != "a.com" || c.Epoch() != leader.Epoch() {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFsmAppliesHeartbeats(t *testing.T) {
+	f := newFsm()
+	at := time.Now()
+	applyCommand(f, command{Op: REGISTER_OP, Url: "a.com", At: at.Add(-time.Minute)})
+	changed := f.changes()
+
+	result := applyCommand(f, command{Op: HEARTBEAT_OP, Url: "a.com", At: at})
+
+	assert.Nil(t, result.err)
+	assert.Equal(t, changed, f.changes())
+	assert.Empty(t, f.current().Expired(at, time.Second))
+}
+
+func TestClusterExpiresNodesOnEveryMember(t *testing.T) {
+	clusters := createInmemCluster(t, 3)
+	defer func() {
+		for _, c := range clusters {
+			c.Shutdown()
+		}
+	}()
+
+	leader := waitForLeader(t, clusters)
+	for _, c := range clusters {
+		if c == leader {
+			continue
+		}
+		_, err := c.Heartbeat("a.com")
+		assert.ErrorIs(t, err, ErrNotLeader)
+		_, err = c.Expire(time.Second)
+		assert.ErrorIs(t, err, ErrNotLeader)
+	}
+
+	leader.Register("a.com")
+	leader.Register("b.com")
+	time.Sleep(50 * time.Millisecond)
+	registered, err := leader.Heartbeat("b.com")
+	assert.Nil(t, err)
+	assert.True(t, registered)
+
+	migrations, err := leader.Expire(25 * time.Millisecond)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+
+	assert.Eventually(t, func() bool {
+		for _, c := range clusters {
+			if c.HasNode("a.com") || !c.HasNode("b.com") {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func applyCommand(f *fsm, cmd command) applyResult {
+	data, _ := json.Marshal(cmd)
+	return f.Apply(&raft.Log{Data: data}).(applyResult)
+}
+
+func createInmemCluster(t *testing.T, size int) []*Cluster {
+	peers := make([]Peer, size)
+	transports := make([]*raft.InmemTransport, size)
+	for i := range transports {
+		addr, transport := raft.NewInmemTransport("")
+		transports[i] = transport
+		peers[i] = Peer{HttpAddr: fmt.Sprintf("registry-%d", i), RaftAddr: string(addr)}
+	}
+	for _, a := range transports {
+		for _, b := range transports {
+			a.Connect(b.LocalAddr(), b)
+		}
+	}
+
+	clusters := make([]*Cluster, size)
+	for i := range clusters {
+		config := raft.DefaultConfig()
+		config.LocalID = raft.ServerID(peers[i].HttpAddr)
+		config.HeartbeatTimeout = 50 * time.Millisecond
+		config.ElectionTimeout = 50 * time.Millisecond
+		config.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.CommitTimeout = 5 * time.Millisecond
+		config.LogLevel = "ERROR"
+
+		store := raft.NewInmemStore()
+		c, err := newCluster(config, transports[i], store, store, raft.NewInmemSnapshotStore(), peers)
+		if err != nil {
+			t.Fatalf("error creating cluster: %s", err)
+		}
+		clusters[i] = c
+	}
+
+	return clusters
+}
+
+func waitForLeader(t *testing.T, clusters []*Cluster) *Cluster {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, c := range clusters {
+			if c.IsLeader() {
+				return c
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("no leader elected")
+	return nil
+}
@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	REGISTER_OP   = "register"
+	UNREGISTER_OP = "unregister"
+	EPOCH_OP      = "epoch"
+	HEARTBEAT_OP  = "heartbeat"
+)
+
+var ErrUnknownOp = fmt.Errorf("unknown command")
+
+type command struct {
+	Op    string    `json:"op"`
+	Url   string    `json:"url,omitempty"`
+	At    time.Time `json:"at,omitempty"`
+	Epoch string    `json:"epoch,omitempty"`
+}
+
+type applyResult struct {
+	migrations []registry.Migration
+	err        error
+}
+
+// replicated state machine over the registry map, every member applies the same log
+type fsm struct {
+	registry *regmap.RegistryMap
+	// closed and replaced whenever a command or a restore changes the registry
+	changed chan struct{}
+	mux     sync.RWMutex
+}
+
+// the epoch is left empty here, the leader picks one and replicates it
+func newFsm() *fsm {
+	return &fsm{
+		registry: regmap.FromState(regmap.State{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return applyResult{err: err}
+	}
+
+	reg := f.current()
+	// renewals leave the topology as it was, watchers aren't woken for them
+	if cmd.Op == HEARTBEAT_OP {
+		reg.HeartbeatAt(cmd.Url, cmd.At)
+		return applyResult{}
+	}
+	defer f.notify()
+	switch cmd.Op {
+	case REGISTER_OP:
+		migrations, err := reg.RegisterAt(cmd.Url, cmd.At)
+		return applyResult{migrations: migrations, err: err}
+	case UNREGISTER_OP:
+		migrations, err := reg.Unregister(cmd.Url)
+		return applyResult{migrations: migrations, err: err}
+	case EPOCH_OP:
+		return applyResult{err: reg.InitEpoch(cmd.Epoch)}
+	default:
+		return applyResult{err: ErrUnknownOp}
+	}
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{state: f.current().State()}, nil
+}
+
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	var state regmap.State
+	if err := json.NewDecoder(snapshot).Decode(&state); err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	f.registry = regmap.FromState(state)
+	f.mux.Unlock()
+	f.notify()
+
+	return nil
+}
+
+func (f *fsm) current() *regmap.RegistryMap {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.registry
+}
+
+func (f *fsm) changes() <-chan struct{} {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.changed
+}
+
+func (f *fsm) notify() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fsmSnapshot struct {
+	state regmap.State
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.state); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raft traffic between registries over tls, both sides present a certificate
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	client    *tls.Config
+}
+
+func newTlsStreamLayer(bindAddr string, server *tls.Config, client *tls.Config) (*tlsStreamLayer, error) {
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsStreamLayer{
+		Listener:  tls.NewListener(listener, server),
+		advertise: listener.Addr(),
+		client:    client,
+	}, nil
+}
+
+func (s *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	config := s.client.Clone()
+	if host, _, err := net.SplitHostPort(string(address)); err == nil {
+		config.ServerName = host
+	}
+
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), config)
+}
+
+func (s *tlsStreamLayer) Addr() net.Addr {
+	return s.advertise
+}
@@ -1,26 +1,165 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/config"
 	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
 	"github.com/brendenehlers/go-distributed-cache/registry-node/server"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/store"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
 )
 
 var (
-	hostnameFlag = flag.String("hostname", "localhost", "host name for the server")
-	portFlag     = flag.Int("port", 8081, "port for the server")
+	configFlag    = flag.String("config", "", "path to a yaml config file, REGISTRY_* environment variables override it")
+	hostnameFlag  = flag.String("hostname", "localhost", "host name for the server")
+	portFlag      = flag.Int("port", 8081, "port for the server")
+	raftAddrFlag  = flag.String("raft-addr", "", "address for raft traffic, leave empty to run a single registry")
+	raftPeersFlag = flag.String("raft-peers", "", "comma separated 'httpAddr=raftAddr' list of every registry in the group, including this one")
+	dataDirFlag   = flag.String("data-dir", "", "directory to keep the registry state in across restarts, kept in memory when empty")
 )
 
+var logLevel = new(slog.LevelVar)
+
 func init() {
 	flag.Parse()
 }
 
 func main() {
-	host := fmt.Sprintf("%s:%d", *hostnameFlag, *portFlag)
-	reg := regmap.New()
-	server := server.New(host, reg)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := conf.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%s", err)
+	}
+
+	apply := func(conf config.Config) {
+		level, _ := conf.Level()
+		logLevel.Set(level)
+	}
+	apply(conf)
+	reloader := config.NewReloader(conf, loadConfig, apply)
+	go reloadOnSignal(reloader)
+
+	var c *certs.Certs
+	if conf.TLS.CertFile != "" || conf.TLS.CaFile != "" {
+		c, err = certs.New(conf.CertOptions())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	reg, err := createRegistry(conf, c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := server.New(conf.Listen, reg)
+	server.SetReloader(reloader)
+	server.SetNodeToken(conf.Auth.NodeToken)
+	if conf.GRPCListen != "" {
+		server.ListenGRPC(conf.GRPCListen)
+	}
+	if c != nil {
+		server.UseTLS(c, conf.TLS.RequireClientCert)
+	}
 
 	server.Start()
 }
+
+func reloadOnSignal(reloader *config.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		result, err := reloader.Reload()
+		if err != nil {
+			slog.Error("Config reload failed, keeping the running config", "err", err)
+			continue
+		}
+		slog.Info("Config reloaded", "applied", result.Applied, "restart_required", result.RestartRequired)
+	}
+}
+
+// flags given on the command line win over the file and environment
+func loadConfig() (config.Config, error) {
+	conf, err := config.Load(*configFlag)
+	if err != nil {
+		return conf, err
+	}
+
+	host, port, err := net.SplitHostPort(conf.Listen)
+	if err != nil {
+		host, port = conf.Listen, ""
+	}
+	var errs []error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			port = strconv.Itoa(*portFlag)
+		case "hostname":
+			host = *hostnameFlag
+		case "raft-addr":
+			conf.Raft.Addr = *raftAddrFlag
+		case "raft-peers":
+			peers, err := config.ParsePeers(*raftPeersFlag)
+			errs = append(errs, err)
+			conf.Raft.Peers = peers
+		case "data-dir":
+			conf.DataDir = *dataDirFlag
+		}
+	})
+	if port != "" {
+		conf.Listen = net.JoinHostPort(host, port)
+	}
+
+	return conf, errors.Join(errs...)
+}
+
+func createRegistry(conf config.Config, c *certs.Certs) (registry.Registry, error) {
+	if conf.Raft.Addr == "" {
+		return createRegistryMap(conf.DataDir)
+	}
+
+	options := conf.ClusterOptions()
+	if c != nil && c.HasCertificate() {
+		options.Certs = c
+	}
+	return cluster.New(options)
+}
+
+func createRegistryMap(dataDir string) (*regmap.RegistryMap, error) {
+	if dataDir == "" {
+		return regmap.New(), nil
+	}
+
+	fileStore, err := store.NewFileStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok, err := fileStore.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	reg := regmap.New()
+	if ok {
+		log.Printf("Restored %d nodes from '%s'", len(state.Nodes), dataDir)
+		reg = regmap.FromState(state)
+	}
+
+	return reg, reg.SetStore(fileStore)
+}
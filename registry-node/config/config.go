@@ -0,0 +1,253 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_LISTEN    = "localhost:8081"
+	DEFAULT_LOG_LEVEL = "info"
+
+	ENV_PREFIX = "REGISTRY_"
+)
+
+type Config struct {
+	Listen string `yaml:"listen"`
+	// serves the Registry grpc service next to http when set
+	GRPCListen string `yaml:"grpc_listen"`
+	// debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// membership and ring are kept in memory when empty
+	DataDir string `yaml:"data_dir"`
+	Raft    Raft   `yaml:"raft"`
+	TLS     TLS    `yaml:"tls"`
+	Auth    Auth   `yaml:"auth"`
+}
+
+// leave Addr empty to run a single registry
+type Raft struct {
+	Addr string `yaml:"addr"`
+	// every member of the group, including this one
+	Peers []Peer `yaml:"peers"`
+}
+
+type Peer struct {
+	Http string `yaml:"http"`
+	Raft string `yaml:"raft"`
+}
+
+// with a certificate raft traffic between registries is sent over mutual tls as well
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// verifies cache nodes and other registries, the system roots are used when empty
+	CaFile string `yaml:"ca_file"`
+	// only nodes with a certificate signed by ca_file can register, unregister and heartbeat
+	RequireClientCert bool `yaml:"require_client_cert"`
+}
+
+type Auth struct {
+	// cache nodes send it to register, unregister and heartbeat, open when empty
+	NodeToken string `yaml:"node_token"`
+}
+
+func Default() Config {
+	return Config{
+		Listen:   DEFAULT_LISTEN,
+		LogLevel: DEFAULT_LOG_LEVEL,
+	}
+}
+
+// defaults, then the file if there is one, then the environment
+func Load(path string) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer file.Close()
+
+		// misspelled settings fail instead of silently falling back to the default
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	env := func(name string, apply func(value string) error) {
+		value, ok := lookup(ENV_PREFIX + name)
+		if !ok {
+			return
+		}
+		if err := apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", ENV_PREFIX, name, err))
+		}
+	}
+
+	env("LISTEN", func(value string) error {
+		c.Listen = value
+		return nil
+	})
+	env("GRPC_LISTEN", func(value string) error {
+		c.GRPCListen = value
+		return nil
+	})
+	env("LOG_LEVEL", func(value string) error {
+		c.LogLevel = value
+		return nil
+	})
+	env("DATA_DIR", func(value string) error {
+		c.DataDir = value
+		return nil
+	})
+	env("RAFT_ADDR", func(value string) error {
+		c.Raft.Addr = value
+		return nil
+	})
+	env("RAFT_PEERS", func(value string) error {
+		peers, err := ParsePeers(value)
+		c.Raft.Peers = peers
+		return err
+	})
+	env("NODE_TOKEN", func(value string) error {
+		c.Auth.NodeToken = value
+		return nil
+	})
+	env("TLS_CERT_FILE", func(value string) error {
+		c.TLS.CertFile = value
+		return nil
+	})
+	env("TLS_KEY_FILE", func(value string) error {
+		c.TLS.KeyFile = value
+		return nil
+	})
+	env("TLS_CA_FILE", func(value string) error {
+		c.TLS.CaFile = value
+		return nil
+	})
+	env("TLS_REQUIRE_CLIENT_CERT", func(value string) error {
+		required, err := strconv.ParseBool(value)
+		c.TLS.RequireClientCert = required
+		return err
+	})
+
+	return errors.Join(errs...)
+}
+
+// same 'httpAddr=raftAddr,...' format as the -raft-peers flag
+func ParsePeers(value string) ([]Peer, error) {
+	clusterPeers, err := cluster.ParsePeers(value)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]Peer, 0, len(clusterPeers))
+	for _, peer := range clusterPeers {
+		peers = append(peers, Peer{Http: peer.HttpAddr, Raft: peer.RaftAddr})
+	}
+
+	return peers, nil
+}
+
+// reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen must be 'host:port', got '%s'", c.Listen))
+	}
+
+	if c.GRPCListen != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCListen); err != nil {
+			errs = append(errs, fmt.Errorf("grpc_listen must be 'host:port', got '%s'", c.GRPCListen))
+		}
+	}
+
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error, got '%s'", c.LogLevel))
+	}
+
+	if c.Raft.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Raft.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("raft.addr must be 'host:port', got '%s'", c.Raft.Addr))
+		}
+
+		self := false
+		for _, peer := range c.Raft.Peers {
+			if peer.Http == "" || peer.Raft == "" {
+				errs = append(errs, fmt.Errorf("raft.peers entries need both http and raft addresses"))
+			}
+			if peer.Http == c.Listen && peer.Raft == c.Raft.Addr {
+				self = true
+			}
+		}
+		if !self {
+			errs = append(errs, fmt.Errorf("raft.peers must include this registry (%s=%s)", c.Listen, c.Raft.Addr))
+		}
+	} else if len(c.Raft.Peers) > 0 {
+		errs = append(errs, fmt.Errorf("raft.peers is set without raft.addr"))
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.RequireClientCert && (c.TLS.CertFile == "" || c.TLS.CaFile == "") {
+		errs = append(errs, fmt.Errorf("tls.require_client_cert needs tls.cert_file and tls.ca_file"))
+	}
+	if c.Raft.Addr != "" && c.TLS.CertFile != "" && c.TLS.CaFile == "" {
+		errs = append(errs, fmt.Errorf("raft over tls needs tls.ca_file to verify the other registries"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+func (c Config) CertOptions() certs.Options {
+	return certs.Options{
+		CertFile: c.TLS.CertFile,
+		KeyFile:  c.TLS.KeyFile,
+		CaFile:   c.TLS.CaFile,
+	}
+}
+
+// Certs is left for the caller, it's shared with the http server
+func (c Config) ClusterOptions() cluster.Options {
+	peers := make([]cluster.Peer, 0, len(c.Raft.Peers))
+	for _, peer := range c.Raft.Peers {
+		peers = append(peers, cluster.Peer{HttpAddr: peer.Http, RaftAddr: peer.Raft})
+	}
+
+	return cluster.Options{
+		HttpAddr: c.Listen,
+		RaftAddr: c.Raft.Addr,
+		Peers:    peers,
+		DataDir:  c.DataDir,
+	}
+}
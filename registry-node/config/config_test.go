@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Default(), config)
+	assert.Nil(t, config.Validate())
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+listen: localhost:8081
+data_dir: /var/lib/registry
+raft:
+  addr: localhost:9081
+  peers:
+    - http: localhost:8081
+      raft: localhost:9081
+    - http: localhost:8082
+      raft: localhost:9082
+`)
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, cluster.Options{
+		HttpAddr: "localhost:8081",
+		RaftAddr: "localhost:9081",
+		Peers: []cluster.Peer{
+			{HttpAddr: "localhost:8081", RaftAddr: "localhost:9081"},
+			{HttpAddr: "localhost:8082", RaftAddr: "localhost:9082"},
+		},
+		DataDir: "/var/lib/registry",
+	}, config.ClusterOptions())
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	path := writeConfig(t, "datadir: /var/lib/registry\n")
+
+	_, err := Load(path)
+
+	assert.NotNil(t, err)
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "data_dir: /var/lib/registry\n")
+	t.Setenv("REGISTRY_DATA_DIR", "/tmp/registry")
+	t.Setenv("REGISTRY_RAFT_ADDR", "localhost:9081")
+	t.Setenv("REGISTRY_RAFT_PEERS", "localhost:8081=localhost:9081")
+	t.Setenv("REGISTRY_NODE_TOKEN", "node-secret")
+	t.Setenv("REGISTRY_GRPC_LISTEN", "localhost:9091")
+
+	config, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/registry", config.DataDir)
+	assert.Equal(t, []Peer{{Http: "localhost:8081", Raft: "localhost:9081"}}, config.Raft.Peers)
+	assert.Equal(t, "node-secret", config.Auth.NodeToken)
+	assert.Equal(t, "localhost:9091", config.GRPCListen)
+}
+
+func TestEnvParseError(t *testing.T) {
+	t.Setenv("REGISTRY_RAFT_PEERS", "localhost:8081")
+
+	_, err := Load("")
+
+	assert.ErrorContains(t, err, "REGISTRY_RAFT_PEERS")
+}
+
+func TestValidateRaftPeersIncludeSelf(t *testing.T) {
+	config := Default()
+	config.Raft.Addr = "localhost:9081"
+	config.Raft.Peers = []Peer{{Http: "localhost:8082", Raft: "localhost:9082"}}
+
+	assert.ErrorContains(t, config.Validate(), "raft.peers must include this registry")
+}
+
+func TestValidate(t *testing.T) {
+	config := Default()
+	config.Listen = "8081"
+	config.GRPCListen = "9091"
+	config.Raft.Peers = []Peer{{Http: "localhost:8081", Raft: "localhost:9081"}}
+	config.TLS.KeyFile = "key.pem"
+	config.TLS.RequireClientCert = true
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "listen")
+	assert.ErrorContains(t, err, "grpc_listen")
+	assert.ErrorContains(t, err, "raft.peers is set without raft.addr")
+	assert.ErrorContains(t, err, "tls.cert_file")
+	assert.ErrorContains(t, err, "tls.require_client_cert")
+}
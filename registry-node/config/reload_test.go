@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	before := Default()
+	after := Default()
+	after.LogLevel = "debug"
+	after.Raft.Peers = []Peer{{Http: "localhost:8081", Raft: "localhost:9081"}}
+
+	assert.Equal(t, []string{"log_level", "raft.peers"}, Changes(before, after))
+	assert.Empty(t, Changes(before, before))
+}
+
+func TestReload(t *testing.T) {
+	next := Default()
+	next.LogLevel = "debug"
+	next.DataDir = "/var/lib/registry"
+
+	var applied Config
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { applied = c })
+
+	result, err := reloader.Reload()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"log_level"}, result.Applied)
+	assert.Equal(t, []string{"data_dir"}, result.RestartRequired)
+	assert.Equal(t, "debug", applied.LogLevel)
+	assert.Equal(t, "", applied.DataDir)
+}
+
+func TestReloadInvalid(t *testing.T) {
+	next := Default()
+	next.LogLevel = "loud"
+
+	called := false
+	reloader := NewReloader(Default(), func() (Config, error) { return next, nil }, func(c Config) { called = true })
+
+	_, err := reloader.Reload()
+
+	assert.ErrorContains(t, err, "log_level")
+	assert.False(t, called)
+}
@@ -1,10 +1,16 @@
 package regmap
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/ring"
 )
 
 var (
@@ -12,31 +18,249 @@ var (
 	ErrNodeInvalid = fmt.Errorf("invalid node")
 )
 
+type State struct {
+	Epoch string                   `json:"epoch"`
+	Nodes []registry.RegistryEntry `json:"nodes"`
+	Ring  ring.State               `json:"ring"`
+}
+
+type Store interface {
+	Save(state State) error
+}
+
 // key: url
 type RegistryMap struct {
 	values map[string]*registry.RegistryEntry
+	ring   *ring.Ring
+	epoch  string
+	store  Store
+	// closed and replaced on every change
+	changed chan struct{}
+	mux     sync.RWMutex
 }
 
 func New() *RegistryMap {
 	return &RegistryMap{
-		values: make(map[string]*registry.RegistryEntry),
+		values:  make(map[string]*registry.RegistryEntry),
+		ring:    ring.New(ring.DefaultReplicas),
+		epoch:   NewEpoch(),
+		changed: make(chan struct{}),
+	}
+}
+
+func FromState(state State) *RegistryMap {
+	values := make(map[string]*registry.RegistryEntry)
+	for _, node := range state.Nodes {
+		entry := node
+		values[entry.Url] = &entry
+	}
+
+	r := ring.New(ring.DefaultReplicas)
+	if len(state.Ring.Points) > 0 {
+		r = ring.FromState(state.Ring)
+	}
+
+	return &RegistryMap{
+		values:  values,
+		ring:    r,
+		epoch:   state.Epoch,
+		changed: make(chan struct{}),
+	}
+}
+
+func NewEpoch() string {
+	buf := make([]byte, 8)
+	crand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// every change is saved to the store before it's acknowledged
+func (r *RegistryMap) SetStore(store Store) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.store = store
+	return r.save()
+}
+
+func (r *RegistryMap) State() State {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.state()
+}
+
+func (r *RegistryMap) state() State {
+	nodes := make([]registry.RegistryEntry, 0, len(r.values))
+	for _, node := range r.values {
+		if node != nil {
+			nodes = append(nodes, *node)
+		}
 	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Url < nodes[j].Url })
+
+	return State{
+		Epoch: r.epoch,
+		Nodes: nodes,
+		Ring:  r.ring.State(),
+	}
+}
+
+func (r *RegistryMap) save() error {
+	if r.store == nil {
+		return nil
+	}
+
+	return r.store.Save(r.state())
+}
+
+func (r *RegistryMap) Register(url string) ([]registry.Migration, error) {
+	return r.RegisterAt(url, time.Now())
 }
 
-func (r *RegistryMap) Register(url string) error {
+// replicas replay registrations, so the time comes from the log rather than the clock
+func (r *RegistryMap) RegisterAt(url string, at time.Time) ([]registry.Migration, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
 	r.values[url] = &registry.RegistryEntry{
-		Url: url,
+		Url:          url,
+		RegisteredAt: at,
 	}
+	migrations := r.updateRing(func(next *ring.Ring) { next.Add(url) })
+	r.notify()
 
-	return nil
+	return migrations, r.save()
 }
 
-func (r *RegistryMap) Unregister(url string) error {
+func (r *RegistryMap) Unregister(url string) ([]registry.Migration, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
 	delete(r.values, url)
-	return nil
+	migrations := r.updateRing(func(next *ring.Ring) { next.Remove(url) })
+	r.notify()
+
+	return migrations, r.save()
+}
+
+func (r *RegistryMap) Heartbeat(url string) (bool, error) {
+	return r.HeartbeatAt(url, time.Now()), nil
+}
+
+// like RegisterAt the time comes from the log. renewals aren't saved or notified, they don't
+// change the topology and a restarted registry gives every node a full lease anyway
+func (r *RegistryMap) HeartbeatAt(url string, at time.Time) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	node, ok := r.values[url]
+	if !ok || node == nil {
+		return false
+	}
+	// ListNodes hands out the old entry
+	renewed := *node
+	renewed.RenewedAt = at
+	r.values[url] = &renewed
+
+	return true
+}
+
+func (r *RegistryMap) Expire(lease time.Duration) ([]registry.Migration, error) {
+	migrations := []registry.Migration{}
+	for _, url := range r.Expired(time.Now(), lease) {
+		moved, err := r.Unregister(url)
+		if err != nil {
+			return migrations, err
+		}
+		migrations = append(migrations, moved...)
+	}
+
+	return migrations, nil
+}
+
+// the nodes that haven't registered or heartbeated since now minus lease, sorted by url
+func (r *RegistryMap) Expired(now time.Time, lease time.Duration) []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	expired := []string{}
+	for url, node := range r.values {
+		if node == nil {
+			continue
+		}
+		last := node.RegisteredAt
+		if node.RenewedAt.After(last) {
+			last = node.RenewedAt
+		}
+		if now.Sub(last) > lease {
+			expired = append(expired, url)
+		}
+	}
+	sort.Strings(expired)
+
+	return expired
+}
+
+func (r *RegistryMap) HasNode(url string) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	_, ok := r.values[url]
+	return ok
+}
+
+func (r *RegistryMap) Epoch() string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.epoch
+}
+
+// only takes effect when no epoch has been set, so replicas agree on the first one
+func (r *RegistryMap) InitEpoch(epoch string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.epoch != "" {
+		return nil
+	}
+	r.epoch = epoch
+	r.notify()
+
+	return r.save()
+}
+
+func (r *RegistryMap) Changed() <-chan struct{} {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.changed == nil {
+		r.changed = make(chan struct{})
+	}
+	return r.changed
+}
+
+func (r *RegistryMap) notify() {
+	if r.changed != nil {
+		close(r.changed)
+	}
+	r.changed = make(chan struct{})
+}
+
+func (r *RegistryMap) updateRing(change func(next *ring.Ring)) []registry.Migration {
+	next := r.ring.Clone()
+	change(next)
+	migrations := ring.Diff(r.ring, next)
+	r.ring = next
+
+	return migrations
 }
 
 func (r *RegistryMap) GetNode() (*registry.RegistryEntry, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
 	if len(r.values) == 0 {
 		return nil, ErrMapSize
 	}
@@ -50,6 +274,38 @@ func (r *RegistryMap) GetNode() (*registry.RegistryEntry, error) {
 	return node, nil
 }
 
+func (r *RegistryMap) GetNodeForKey(key string) (*registry.RegistryEntry, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	url, ok := r.ring.Get(key)
+	if !ok {
+		return nil, ErrMapSize
+	}
+
+	node := r.values[url]
+	if node == nil {
+		return nil, ErrNodeInvalid
+	}
+
+	return node, nil
+}
+
+func (r *RegistryMap) ListNodes() []*registry.RegistryEntry {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	nodes := make([]*registry.RegistryEntry, 0, len(r.values))
+	for _, node := range r.values {
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Url < nodes[j].Url })
+
+	return nodes
+}
+
 func (r *RegistryMap) getRandomNode() *registry.RegistryEntry {
 	if len(r.values) == 0 {
 		return nil
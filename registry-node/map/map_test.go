@@ -2,14 +2,17 @@ package regmap
 
 import (
 	"testing"
+	"time"
 
 	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/ring"
 	"github.com/stretchr/testify/assert"
 )
 
 func setup() (regmap *RegistryMap) {
 	regmap = &RegistryMap{
 		values: make(map[string]*registry.RegistryEntry),
+		ring:   ring.New(ring.DefaultReplicas),
 	}
 
 	return
@@ -19,7 +22,7 @@ func TestRegisterNewValue(t *testing.T) {
 	regmap := setup()
 
 	expectedUrl := "google.com"
-	err := regmap.Register(expectedUrl)
+	_, err := regmap.Register(expectedUrl)
 	assert.Nil(t, err)
 
 	value := regmap.values[expectedUrl]
@@ -35,7 +38,7 @@ func TestUnregister(t *testing.T) {
 		Url: url,
 	}
 
-	err := regmap.Unregister(url)
+	_, err := regmap.Unregister(url)
 	assert.Nil(t, err)
 
 	value, ok := regmap.values[url]
@@ -97,3 +100,178 @@ func TestGetRandomNodeReturnsNode(t *testing.T) {
 	assert.NotNil(t, node)
 	assert.Equal(t, url, node.Url)
 }
+
+func TestGetNodeForKeyFailsWithNoValues(t *testing.T) {
+	regmap := setup()
+
+	_, err := regmap.GetNodeForKey("key")
+
+	assert.Error(t, err)
+}
+
+func TestGetNodeForKeyIsStable(t *testing.T) {
+	regmap := setup()
+	regmap.Register("a.com")
+	regmap.Register("b.com")
+	regmap.Register("c.com")
+
+	first, err := regmap.GetNodeForKey("key")
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		node, err := regmap.GetNodeForKey("key")
+		assert.Nil(t, err)
+		assert.Equal(t, first.Url, node.Url)
+	}
+}
+
+func TestRegisterReturnsMigrationsToNewNode(t *testing.T) {
+	regmap := setup()
+
+	migrations, err := regmap.Register("a.com")
+	assert.Nil(t, err)
+	assert.Empty(t, migrations)
+
+	migrations, err = regmap.Register("b.com")
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "a.com", migrations[0].From)
+	assert.Equal(t, "b.com", migrations[0].To)
+}
+
+func TestUnregisterReturnsMigrationsFromLeavingNode(t *testing.T) {
+	regmap := setup()
+	regmap.Register("a.com")
+	regmap.Register("b.com")
+
+	migrations, err := regmap.Unregister("b.com")
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "b.com", migrations[0].From)
+	assert.Equal(t, "a.com", migrations[0].To)
+}
+
+func TestListNodesIsSorted(t *testing.T) {
+	regmap := setup()
+	regmap.Register("b.com")
+	regmap.Register("a.com")
+
+	nodes := regmap.ListNodes()
+
+	assert.Len(t, nodes, 2)
+	assert.Equal(t, "a.com", nodes[0].Url)
+	assert.Equal(t, "b.com", nodes[1].Url)
+}
+
+func TestChangedClosesOnChange(t *testing.T) {
+	regmap := setup()
+	changed := regmap.Changed()
+
+	regmap.Register("a.com")
+
+	assert.True(t, isClosed(changed))
+	assert.False(t, isClosed(regmap.Changed()))
+
+	changed = regmap.Changed()
+	regmap.Unregister("a.com")
+	assert.True(t, isClosed(changed))
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+type MockStore struct {
+	saved []State
+}
+
+func (ms *MockStore) Save(state State) error {
+	ms.saved = append(ms.saved, state)
+	return nil
+}
+
+func TestNewHasEpoch(t *testing.T) {
+	regmap := New()
+
+	assert.NotEmpty(t, regmap.Epoch())
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	regmap := New()
+	regmap.Register("a.com")
+	regmap.Register("b.com")
+
+	restored := FromState(regmap.State())
+
+	assert.Equal(t, regmap.Epoch(), restored.Epoch())
+	assert.True(t, restored.HasNode("a.com"))
+	assert.True(t, restored.HasNode("b.com"))
+	expected, _ := regmap.GetNodeForKey("key")
+	actual, _ := restored.GetNodeForKey("key")
+	assert.Equal(t, expected.Url, actual.Url)
+}
+
+func TestChangesAreSaved(t *testing.T) {
+	regmap := New()
+	store := &MockStore{}
+	regmap.SetStore(store)
+
+	regmap.Register("a.com")
+	regmap.Unregister("a.com")
+
+	assert.Len(t, store.saved, 3)
+	assert.Len(t, store.saved[1].Nodes, 1)
+	assert.Empty(t, store.saved[2].Nodes)
+}
+
+func TestInitEpochKeepsExisting(t *testing.T) {
+	regmap := FromState(State{})
+
+	regmap.InitEpoch("first")
+	regmap.InitEpoch("second")
+
+	assert.Equal(t, "first", regmap.Epoch())
+}
+
+func TestExpiredNodes(t *testing.T) {
+	regmap := New()
+	now := time.Now()
+	regmap.RegisterAt("old.com", now.Add(-time.Minute))
+	regmap.RegisterAt("renewed.com", now.Add(-time.Minute))
+	regmap.RegisterAt("new.com", now)
+
+	assert.True(t, regmap.HeartbeatAt("renewed.com", now))
+	assert.False(t, regmap.HeartbeatAt("unknown.com", now))
+
+	assert.Equal(t, []string{"old.com"}, regmap.Expired(now, 10*time.Second))
+}
+
+func TestExpireUnregistersNodes(t *testing.T) {
+	regmap := New()
+	regmap.RegisterAt("a.com", time.Now().Add(-time.Minute))
+	regmap.Register("b.com")
+
+	migrations, err := regmap.Expire(10 * time.Second)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+	assert.False(t, regmap.HasNode("a.com"))
+	assert.True(t, regmap.HasNode("b.com"))
+}
+
+func TestHeartbeatsDontSignalChanges(t *testing.T) {
+	regmap := New()
+	regmap.Register("a.com")
+	changed := regmap.Changed()
+
+	registered, err := regmap.Heartbeat("a.com")
+
+	assert.Nil(t, err)
+	assert.True(t, registered)
+	assert.Equal(t, changed, regmap.Changed())
+}
@@ -1,11 +1,46 @@
 package registry
 
+import "time"
+
 type RegistryEntry struct {
-	Url string
+	Url          string    `json:"url"`
+	RegisteredAt time.Time `json:"registered_at"`
+	// the last heartbeat, the zero time until the first one
+	RenewedAt time.Time `json:"renewed_at,omitempty"`
+}
+
+// keys with a hash in (Start, End] on the ring, wrapping past zero when Start >= End
+type HashRange struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+type Migration struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Ranges []HashRange `json:"ranges"`
 }
 
 type Registry interface {
-	Register(url string) error
-	Unregister(url string) error
+	Register(url string) ([]Migration, error)
+	Unregister(url string) ([]Migration, error)
 	GetNode() (*RegistryEntry, error)
-}
\ No newline at end of file
+	GetNodeForKey(key string) (*RegistryEntry, error)
+	HasNode(url string) bool
+	// renews the node's lease, registered is false when the registry doesn't know it
+	Heartbeat(url string) (registered bool, err error)
+	// unregisters every node that hasn't registered or heartbeated within lease
+	Expire(lease time.Duration) ([]Migration, error)
+	// sorted by url
+	ListNodes() []*RegistryEntry
+	// closed the next time the nodes or the epoch change, call again to wait for the change after
+	Changed() <-chan struct{}
+	// changes whenever the registry starts over without its previous state
+	Epoch() string
+}
+
+// implemented by registries replicated across several registry nodes, where only the leader takes writes
+type Replicated interface {
+	IsLeader() bool
+	LeaderUrl() string
+}
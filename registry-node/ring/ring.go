@@ -0,0 +1,210 @@
+package ring
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+)
+
+const DefaultReplicas = 64
+
+type Point struct {
+	Hash uint32 `json:"hash"`
+	Node string `json:"node"`
+}
+
+// the exact points of a ring, so a restored ring keeps its ownership even if the defaults change
+type State struct {
+	Replicas int     `json:"replicas"`
+	Points   []Point `json:"points"`
+}
+
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+	nodes    map[string]bool
+}
+
+func New(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		nodes:    make(map[string]bool),
+	}
+}
+
+func FromState(state State) *Ring {
+	r := New(state.Replicas)
+	for _, point := range state.Points {
+		if _, ok := r.owners[point.Hash]; !ok {
+			r.hashes = append(r.hashes, point.Hash)
+		}
+		r.owners[point.Hash] = point.Node
+		r.nodes[point.Node] = true
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+func (r *Ring) State() State {
+	points := make([]Point, 0, len(r.hashes))
+	for _, hash := range r.hashes {
+		points = append(points, Point{Hash: hash, Node: r.owners[hash]})
+	}
+
+	return State{
+		Replicas: r.replicas,
+		Points:   points,
+	}
+}
+
+// Hash must stay in sync with the cache node, which uses it to find the keys in a migrated range
+func Hash(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (r *Ring) Add(node string) {
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+
+	for i := 0; i < r.replicas; i++ {
+		hash := Hash(fmt.Sprintf("%s#%d", node, i))
+		owner, ok := r.owners[hash]
+		if !ok {
+			r.hashes = append(r.hashes, hash)
+		}
+		// the smaller node wins a shared point so the ring doesn't depend on insert order
+		if !ok || node < owner {
+			r.owners[hash] = node
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *Ring) Remove(node string) {
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+
+	hashes := r.hashes[:0]
+	for _, hash := range r.hashes {
+		if r.owners[hash] == node {
+			delete(r.owners, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	r.hashes = hashes
+}
+
+func (r *Ring) Get(key string) (string, bool) {
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	return r.ownerOf(Hash(key)), true
+}
+
+func (r *Ring) Nodes() []string {
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+func (r *Ring) Clone() *Ring {
+	clone := New(r.replicas)
+	clone.hashes = append(clone.hashes, r.hashes...)
+	for hash, owner := range r.owners {
+		clone.owners[hash] = owner
+	}
+	for node := range r.nodes {
+		clone.nodes[node] = true
+	}
+
+	return clone
+}
+
+// the owner of a hash is the first point at or after it, wrapping to the start
+func (r *Ring) ownerOf(hash uint32) string {
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+// Diff returns the ranges that change owner going from before to after, grouped by
+// the losing and gaining node. Ranges with no previous or no new owner are skipped
+// since there is nothing to move.
+func Diff(before, after *Ring) []registry.Migration {
+	if len(before.hashes) == 0 || len(after.hashes) == 0 {
+		return nil
+	}
+
+	points := mergePoints(before.hashes, after.hashes)
+	migrations := []registry.Migration{}
+	index := make(map[[2]string]int)
+
+	for i, end := range points {
+		start := points[(i+len(points)-1)%len(points)]
+		from, to := before.ownerOf(end), after.ownerOf(end)
+		if from == to {
+			continue
+		}
+
+		pair := [2]string{from, to}
+		j, ok := index[pair]
+		if !ok {
+			j = len(migrations)
+			index[pair] = j
+			migrations = append(migrations, registry.Migration{From: from, To: to})
+		}
+		migrations[j].Ranges = appendRange(migrations[j].Ranges, registry.HashRange{Start: start, End: end})
+	}
+
+	return migrations
+}
+
+func mergePoints(a, b []uint32) []uint32 {
+	seen := make(map[uint32]bool, len(a)+len(b))
+	points := make([]uint32, 0, len(a)+len(b))
+	for _, hash := range append(append([]uint32{}, a...), b...) {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		points = append(points, hash)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+
+	return points
+}
+
+// adjacent ranges are merged to keep the plans small
+func appendRange(ranges []registry.HashRange, next registry.HashRange) []registry.HashRange {
+	if n := len(ranges); n > 0 && ranges[n-1].End == next.Start {
+		ranges[n-1].End = next.End
+		return ranges
+	}
+
+	return append(ranges, next)
+}
@@ -0,0 +1,127 @@
+package ring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/stretchr/testify/assert"
+)
+
+func contains(r registry.HashRange, hash uint32) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+func TestGetOnEmptyRing(t *testing.T) {
+	r := New(DefaultReplicas)
+
+	_, ok := r.Get("key")
+
+	assert.False(t, ok)
+}
+
+func TestGetSingleNode(t *testing.T) {
+	r := New(DefaultReplicas)
+	r.Add("a.com")
+
+	node, ok := r.Get("key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "a.com", node)
+}
+
+func TestAddIsIdempotent(t *testing.T) {
+	r := New(DefaultReplicas)
+	r.Add("a.com")
+	r.Add("a.com")
+
+	assert.Len(t, r.hashes, DefaultReplicas)
+}
+
+func TestRemoveDropsPoints(t *testing.T) {
+	r := New(DefaultReplicas)
+	r.Add("a.com")
+	r.Add("b.com")
+
+	r.Remove("a.com")
+
+	assert.Equal(t, []string{"b.com"}, r.Nodes())
+	for _, hash := range r.hashes {
+		assert.Equal(t, "b.com", r.owners[hash])
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	r := New(DefaultReplicas)
+	r.Add("a.com")
+
+	clone := r.Clone()
+	clone.Add("b.com")
+
+	assert.Equal(t, []string{"a.com"}, r.Nodes())
+	assert.Equal(t, []string{"a.com", "b.com"}, clone.Nodes())
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	r := New(DefaultReplicas)
+	r.Add("a.com")
+	r.Add("b.com")
+
+	restored := FromState(r.State())
+
+	assert.Equal(t, r.Nodes(), restored.Nodes())
+	assert.Equal(t, r.hashes, restored.hashes)
+	assert.Empty(t, Diff(r, restored))
+}
+
+func TestDiffWithEmptyRingIsEmpty(t *testing.T) {
+	before := New(DefaultReplicas)
+	after := before.Clone()
+	after.Add("a.com")
+
+	assert.Empty(t, Diff(before, after))
+}
+
+func TestDiffCoversMovedKeys(t *testing.T) {
+	before := New(DefaultReplicas)
+	before.Add("a.com")
+	before.Add("b.com")
+	after := before.Clone()
+	after.Add("c.com")
+
+	migrations := Diff(before, after)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		from, _ := before.Get(key)
+		to, _ := after.Get(key)
+
+		covered := false
+		for _, m := range migrations {
+			for _, hr := range m.Ranges {
+				if contains(hr, Hash(key)) {
+					assert.Equal(t, from, m.From)
+					assert.Equal(t, to, m.To)
+					covered = true
+				}
+			}
+		}
+		assert.Equal(t, from != to, covered, key)
+	}
+}
+
+func TestDiffOnlyMovesToNewNode(t *testing.T) {
+	before := New(DefaultReplicas)
+	before.Add("a.com")
+	before.Add("b.com")
+	after := before.Clone()
+	after.Add("c.com")
+
+	for _, m := range Diff(before, after) {
+		assert.Equal(t, "c.com", m.To)
+		assert.NotEmpty(t, m.Ranges)
+	}
+}
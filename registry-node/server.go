@@ -5,7 +5,9 @@ import "net/http"
 type Server interface {
 	HandleRegister(w http.ResponseWriter, r *http.Request)
 	HandleUnregister(w http.ResponseWriter, r *http.Request)
+	HandleHeartbeat(w http.ResponseWriter, r *http.Request)
 	HandleGetNode(w http.ResponseWriter, r *http.Request)
+	HandleReload(w http.ResponseWriter, r *http.Request)
 	Start()
 	Stop()
 }
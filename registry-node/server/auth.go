@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+)
+
+var (
+	ErrClientCertRequired = fmt.Errorf("a client certificate signed by the node ca is required")
+	ErrNodeTokenRequired  = fmt.Errorf("a node credential is required")
+)
+
+// required from cache nodes, and sent with the migrations handed to them
+func (hs *HttpServer) SetNodeToken(token string) {
+	hs.nodeToken = token
+	hs.transport.token = token
+}
+
+// membership changes only come from cache nodes
+func (hs *HttpServer) requireNode(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hs.requireClientCert && !certs.Verified(r.TLS) {
+			handleError(w, r, ErrClientCertRequired, http.StatusForbidden)
+			return
+		}
+		if hs.nodeToken != "" && !tokenMatches(bearerToken(r), hs.nodeToken) {
+			handleError(w, r, ErrNodeTokenRequired, http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func tokenMatches(token string, expected string) bool {
+	a := sha256.Sum256([]byte(token))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+type nodeTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *nodeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(r)
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/registrypb"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the trailer followers put the leader's address in when they refuse a write
+const LEADER_TRAILER = "leader"
+
+type grpcRegistry struct {
+	registrypb.UnimplementedRegistryServer
+	server *HttpServer
+}
+
+// serves grpc on addr next to http, must be called before Start
+func (hs *HttpServer) ListenGRPC(addr string) {
+	hs.grpcAddr = addr
+}
+
+func (hs *HttpServer) runGRPC() {
+	listener, err := net.Listen("tcp", hs.grpcAddr)
+	if err != nil {
+		log.Printf("Couldn't listen on '%s': %s", hs.grpcAddr, err)
+		return
+	}
+
+	log.Printf("gRPC listening on '%s'", hs.grpcAddr)
+	hs.ServeGRPC(listener)
+}
+
+// serves the Registry service on connections from l until the server stops
+func (hs *HttpServer) ServeGRPC(l net.Listener) error {
+	options := []grpc.ServerOption{}
+	if hs.TLSConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(grpcTLSConfig(hs.TLSConfig))))
+	}
+	g := grpc.NewServer(options...)
+	registrypb.RegisterRegistryServer(g, &grpcRegistry{server: hs})
+
+	hs.grpcMux.Lock()
+	if hs.grpcClosed {
+		hs.grpcMux.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	hs.grpcServers = append(hs.grpcServers, g)
+	hs.grpcMux.Unlock()
+
+	return g.Serve(l)
+}
+
+// topology watches never finish on their own, so there's nothing to wait for
+func (hs *HttpServer) closeGRPC() {
+	hs.grpcMux.Lock()
+	defer hs.grpcMux.Unlock()
+
+	hs.grpcClosed = true
+	for _, g := range hs.grpcServers {
+		g.Stop()
+	}
+}
+
+// the certificate is picked per connection, grpc also needs h2 offered on each
+func grpcTLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	if base.GetConfigForClient == nil {
+		return config
+	}
+
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := base.GetConfigForClient(hello)
+		if c == nil || err != nil {
+			return c, err
+		}
+		c = c.Clone()
+		c.NextProtos = []string{"h2"}
+		return c, nil
+	}
+	return config
+}
+
+func (g *grpcRegistry) Register(ctx context.Context, req *registrypb.RegisterRequest) (*registrypb.RegisterResponse, error) {
+	if err := g.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+
+	migrations, err := g.server.registry.Register(req.GetUrl())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.server.dispatchMigrations(migrations)
+
+	return &registrypb.RegisterResponse{Epoch: g.server.registry.Epoch()}, nil
+}
+
+func (g *grpcRegistry) Unregister(ctx context.Context, req *registrypb.UnregisterRequest) (*registrypb.UnregisterResponse, error) {
+	if err := g.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+
+	migrations, err := g.server.registry.Unregister(req.GetUrl())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.server.dispatchMigrations(migrations)
+
+	return &registrypb.UnregisterResponse{}, nil
+}
+
+func (g *grpcRegistry) Heartbeat(ctx context.Context, req *registrypb.HeartbeatRequest) (*registrypb.HeartbeatResponse, error) {
+	if err := g.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+
+	registered, err := g.server.registry.Heartbeat(req.GetUrl())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &registrypb.HeartbeatResponse{
+		Epoch:      g.server.registry.Epoch(),
+		Registered: registered,
+	}, nil
+}
+
+func (g *grpcRegistry) GetNode(ctx context.Context, req *registrypb.GetNodeRequest) (*registrypb.GetNodeResponse, error) {
+	node, err := g.server.getNode(req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &registrypb.GetNodeResponse{Node: grpcNode(node)}, nil
+}
+
+func (g *grpcRegistry) ListNodes(ctx context.Context, req *registrypb.ListNodesRequest) (*registrypb.ListNodesResponse, error) {
+	return &registrypb.ListNodesResponse{Nodes: grpcNodes(g.server.registry.ListNodes())}, nil
+}
+
+func (g *grpcRegistry) WatchTopology(req *registrypb.WatchTopologyRequest, stream registrypb.Registry_WatchTopologyServer) error {
+	ctx := stream.Context()
+	for {
+		// taken before reading so a change in between is sent again rather than missed
+		changed := g.server.registry.Changed()
+		topology := &registrypb.Topology{
+			Epoch: g.server.registry.Epoch(),
+			Nodes: grpcNodes(g.server.registry.ListNodes()),
+		}
+		if err := stream.Send(topology); err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// the same checks as requireNode on the http routes
+func (g *grpcRegistry) requireNode(ctx context.Context) error {
+	if g.server.requireClientCert && !certs.Verified(grpcTLSState(ctx)) {
+		return status.Error(codes.PermissionDenied, ErrClientCertRequired.Error())
+	}
+	if g.server.nodeToken != "" && !tokenMatches(grpcBearerToken(ctx), g.server.nodeToken) {
+		return status.Error(codes.Unauthenticated, ErrNodeTokenRequired.Error())
+	}
+
+	return nil
+}
+
+// grpc can't redirect, followers refuse and name the leader instead
+func (g *grpcRegistry) requireLeader(ctx context.Context) error {
+	if err := g.requireNode(ctx); err != nil {
+		return err
+	}
+
+	replicated, ok := g.server.registry.(registry.Replicated)
+	if !ok || replicated.IsLeader() {
+		return nil
+	}
+
+	if leader := replicated.LeaderUrl(); leader != "" {
+		grpc.SetTrailer(ctx, metadata.Pairs(LEADER_TRAILER, leader))
+	}
+	return status.Error(codes.Unavailable, cluster.ErrNotLeader.Error())
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, cluster.ErrNotLeader), errors.Is(err, regmap.ErrMapSize):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, regmap.ErrNodeInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func grpcNode(node *registry.RegistryEntry) *registrypb.Node {
+	return &registrypb.Node{Url: node.Url, RegisteredAt: timestamppb.New(node.RegisteredAt)}
+}
+
+func grpcNodes(nodes []*registry.RegistryEntry) []*registrypb.Node {
+	result := make([]*registrypb.Node, len(nodes))
+	for i, node := range nodes {
+		result[i] = grpcNode(node)
+	}
+
+	return result
+}
+
+func grpcTLSState(ctx context.Context) *tls.ConnectionState {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+
+	return &info.State
+}
+
+func grpcBearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(value, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+)
+
+// followers send writes on to the leader, the redirect keeps the method and body
+func (hs *HttpServer) requireLeader(next http.HandlerFunc) http.HandlerFunc {
+	replicated, ok := hs.registry.(registry.Replicated)
+	if !ok {
+		return next
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if replicated.IsLeader() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader := replicated.LeaderUrl()
+		if leader == "" {
+			handleError(w, r, cluster.ErrNotLeader, http.StatusServiceUnavailable)
+			return
+		}
+
+		http.Redirect(w, r, leaderUrl(r, leader), http.StatusTemporaryRedirect)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// members of a group share the same scheme
+func leaderUrl(r *http.Request, leader string) string {
+	if r.TLS != nil {
+		return "https://" + leader + r.URL.RequestURI()
+	}
+
+	return nodeUrl(leader, r.URL.RequestURI())
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+)
+
+// three of the cache nodes' heartbeats
+const DEFAULT_LEASE = 15 * time.Second
+
+// must be called before Start
+func (hs *HttpServer) SetLease(lease time.Duration) {
+	hs.lease = lease
+}
+
+// unregisters nodes whose lease ran out and moves their ranges, only on the leader of a
+// replicated registry. a registry that just started or just took over waits a full lease
+// first, the renewals it knows of may be older than the nodes' last heartbeats
+func (hs *HttpServer) expireNodes() {
+	ticker := time.NewTicker(hs.lease / 3)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-hs.done:
+			return
+		}
+
+		if !hs.isLeader() {
+			since = time.Time{}
+			continue
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		if time.Since(since) < hs.lease {
+			continue
+		}
+
+		migrations, err := hs.registry.Expire(hs.lease)
+		if err != nil {
+			log.Printf("Unable to expire nodes: %s", err)
+		}
+		hs.dispatchMigrations(migrations)
+	}
+}
+
+func (hs *HttpServer) isLeader() bool {
+	replicated, ok := hs.registry.(registry.Replicated)
+	return !ok || replicated.IsLeader()
+}
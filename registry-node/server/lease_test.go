@@ -0,0 +1,32 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodesWithoutHeartbeatsAreUnregistered(t *testing.T) {
+	reg := regmap.New()
+	reg.Register("127.0.0.1:1")
+	reg.Register("127.0.0.1:2")
+	hs := New("localhost:0", reg)
+	hs.SetLease(30 * time.Millisecond)
+	go hs.expireNodes()
+	defer close(hs.done)
+
+	stop := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case <-stop:
+			done = true
+		case <-time.After(5 * time.Millisecond):
+			reg.Heartbeat("127.0.0.1:1")
+		}
+	}
+
+	assert.False(t, reg.HasNode("127.0.0.1:2"))
+	assert.True(t, reg.HasNode("127.0.0.1:1"))
+}
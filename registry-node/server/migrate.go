@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node"
+)
+
+const DEFAULT_CLIENT_TIMEOUT = 5 * time.Second
+
+type MigrateRequestBody struct {
+	To     string               `json:"to"`
+	Ranges []registry.HashRange `json:"ranges"`
+}
+
+// the losing node streams the moved keys itself, the registry only tells it what moved.
+// a node that is unregistering is still serving, so it receives its own plan here too
+func (hs *HttpServer) dispatchMigrations(migrations []registry.Migration) {
+	for _, migration := range migrations {
+		if err := hs.sendMigration(migration); err != nil {
+			log.Printf("Unable to start migration %s -> %s: %s", migration.From, migration.To, err)
+			continue
+		}
+		log.Printf("Started migration %s -> %s (%d ranges)", migration.From, migration.To, len(migration.Ranges))
+	}
+}
+
+func (hs *HttpServer) sendMigration(migration registry.Migration) error {
+	buf, err := json.Marshal(MigrateRequestBody{
+		To:     migration.To,
+		Ranges: migration.Ranges,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := hs.client.Post(nodeUrl(migration.From, "/internal/migrate"), "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func nodeUrl(node string, path string) string {
+	if strings.HasPrefix(node, "http://") || strings.HasPrefix(node, "https://") {
+		return node + path
+	}
+
+	return "http://" + node + path
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node/config"
+)
+
+var ErrReloadUnavailable = fmt.Errorf("config reload isn't enabled on this registry")
+
+type Reloader interface {
+	Reload() (config.ReloadResult, error)
+}
+
+type ReloadResponseBody struct {
+	ResponseBody
+	config.ReloadResult
+}
+
+func (hs *HttpServer) SetReloader(reloader Reloader) {
+	hs.reloader = reloader
+}
+
+// reloads this member only, every member of a group is reloaded on its own
+func (hs *HttpServer) HandleReload(w http.ResponseWriter, r *http.Request) {
+	if hs.reloader == nil {
+		handleError(w, r, ErrReloadUnavailable, http.StatusNotFound)
+		return
+	}
+
+	result, err := hs.reloader.Reload()
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	resp := &ReloadResponseBody{
+		ResponseBody: ResponseBody{
+			Message: "Success",
+		},
+		ReloadResult: result,
+	}
+	encodeResponse(w, r, resp)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendenehlers/go-distributed-cache/registry-node/config"
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockReloader struct {
+	reloads int
+}
+
+func (m *MockReloader) Reload() (config.ReloadResult, error) {
+	m.reloads++
+	return config.ReloadResult{}, nil
+}
+
+func TestReloadRequiresTheNodeToken(t *testing.T) {
+	hs := New("localhost:0", regmap.New())
+	reloader := &MockReloader{}
+	hs.SetReloader(reloader)
+	hs.SetNodeToken("secret")
+
+	resp := sendReload(hs, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = sendReload(hs, "Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, 0, reloader.reloads)
+
+	resp = sendReload(hs, "Bearer secret")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 1, reloader.reloads)
+}
+
+func sendReload(hs *HttpServer, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	hs.Handler.ServeHTTP(w, r)
+	return w
+}
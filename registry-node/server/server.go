@@ -2,18 +2,37 @@ package server
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/brendenehlers/go-distributed-cache/registry-node"
+	"github.com/brendenehlers/go-distributed-cache/registry-node/cluster"
+	"github.com/brendenehlers/go-distributed-cache/shared/certs"
+	"github.com/brendenehlers/go-distributed-cache/shared/codec"
+	"google.golang.org/grpc"
 )
 
 type HttpServer struct {
 	http.Server
 
-	registry registry.Registry
+	registry  registry.Registry
+	client    *http.Client
+	transport *nodeTransport
+	reloader  Reloader
+	// only cache nodes with a verified client certificate can change membership
+	requireClientCert bool
+	nodeToken         string
+	// nodes that go this long without a heartbeat are unregistered
+	lease time.Duration
+	done  chan struct{}
+
+	grpcAddr    string
+	grpcServers []*grpc.Server
+	grpcClosed  bool
+	grpcMux     sync.Mutex
 }
 
 type RequestBody struct {
@@ -33,61 +52,118 @@ type NodeResponseBody struct {
 	Url string `json:"url"`
 }
 
+type NodesResponseBody struct {
+	ResponseBody
+	Urls []string `json:"urls"`
+}
+
+type RegisterResponseBody struct {
+	ResponseBody
+	Epoch string `json:"epoch"`
+}
+
+type HeartbeatResponseBody struct {
+	ResponseBody
+	Epoch      string `json:"epoch"`
+	Registered bool   `json:"registered"`
+}
+
 func New(host string, reg registry.Registry) *HttpServer {
 	handler := http.NewServeMux()
+	transport := &nodeTransport{base: http.DefaultTransport}
 
 	server := &HttpServer{
 		Server: http.Server{
 			Handler: handler,
 			Addr:    host,
 		},
-		registry: reg,
+		registry:  reg,
+		client:    &http.Client{Timeout: DEFAULT_CLIENT_TIMEOUT, Transport: transport},
+		transport: transport,
+		lease:     DEFAULT_LEASE,
+		done:      make(chan struct{}),
 	}
 
-	handler.HandleFunc("POST /register", logRequest(server.HandleRegister))
-	handler.HandleFunc("POST /unregister", logRequest(server.HandleUnregister))
+	handler.HandleFunc("POST /register", logRequest(server.requireNode(server.requireLeader(server.HandleRegister))))
+	handler.HandleFunc("POST /unregister", logRequest(server.requireNode(server.requireLeader(server.HandleUnregister))))
+	handler.HandleFunc("POST /heartbeat", logRequest(server.requireNode(server.requireLeader(server.HandleHeartbeat))))
 	handler.HandleFunc("GET /node", logRequest(server.HandleGetNode))
+	handler.HandleFunc("GET /nodes", logRequest(server.HandleListNodes))
+	handler.HandleFunc("POST /admin/reload", logRequest(server.requireNode(server.HandleReload)))
 
 	return server
 }
 
 func (hs *HttpServer) HandleRegister(w http.ResponseWriter, r *http.Request) {
-	url, err := getUrlFromBody(r.Body)
+	url, err := getUrlFromBody(r)
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleError(w, r, err, decodeErrorStatus(err))
 		return
 	}
 
-	err = hs.registry.Register(url)
+	migrations, err := hs.registry.Register(url)
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleError(w, r, err, writeErrorStatus(err))
+		return
 	}
+	hs.dispatchMigrations(migrations)
 
-	resp := &ResponseBody{Message: "Success"}
-	encodeResponse(w, resp)
+	resp := &RegisterResponseBody{
+		ResponseBody: ResponseBody{
+			Message: "Success",
+		},
+		Epoch: hs.registry.Epoch(),
+	}
+	encodeResponse(w, r, resp)
 }
 
 func (hs *HttpServer) HandleUnregister(w http.ResponseWriter, r *http.Request) {
-	url, err := getUrlFromBody(r.Body)
+	url, err := getUrlFromBody(r)
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleError(w, r, err, decodeErrorStatus(err))
 		return
 	}
 
-	err = hs.registry.Unregister(url)
+	migrations, err := hs.registry.Unregister(url)
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleError(w, r, err, writeErrorStatus(err))
 		return
 	}
+	hs.dispatchMigrations(migrations)
 
 	resp := &ResponseBody{Message: "Success"}
-	encodeResponse(w, resp)
+	encodeResponse(w, r, resp)
+}
+
+// nodes compare the epoch with the one they registered under to find out the registry lost its state.
+// each heartbeat renews the node's lease, so like other writes it's taken by the leader
+func (hs *HttpServer) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	url, err := getUrlFromBody(r)
+	if err != nil {
+		handleError(w, r, err, decodeErrorStatus(err))
+		return
+	}
+
+	registered, err := hs.registry.Heartbeat(url)
+	if err != nil {
+		handleError(w, r, err, writeErrorStatus(err))
+		return
+	}
+
+	resp := &HeartbeatResponseBody{
+		ResponseBody: ResponseBody{
+			Message: "Success",
+		},
+		Epoch:      hs.registry.Epoch(),
+		Registered: registered,
+	}
+	encodeResponse(w, r, resp)
 }
 
 func (hs *HttpServer) HandleGetNode(w http.ResponseWriter, r *http.Request) {
-	node, err := hs.registry.GetNode()
+	node, err := hs.getNode(r.URL.Query().Get("key"))
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -97,22 +173,75 @@ func (hs *HttpServer) HandleGetNode(w http.ResponseWriter, r *http.Request) {
 		},
 		Url: node.Url,
 	}
-	encodeResponse(w, resp)
+	encodeResponse(w, r, resp)
+}
+
+// every registered node, for callers that need to reach all of them
+func (hs *HttpServer) HandleListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes := hs.registry.ListNodes()
+	urls := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		urls = append(urls, node.Url)
+	}
+
+	resp := &NodesResponseBody{
+		ResponseBody: ResponseBody{
+			Message: "Success",
+		},
+		Urls: urls,
+	}
+	encodeResponse(w, r, resp)
+}
+
+func (hs *HttpServer) getNode(key string) (*registry.RegistryEntry, error) {
+	if key == "" {
+		return hs.registry.GetNode()
+	}
+
+	return hs.registry.GetNodeForKey(key)
 }
 
 func (hs *HttpServer) Start() {
+	if hs.grpcAddr != "" {
+		go hs.runGRPC()
+	}
+	go hs.expireNodes()
+
 	log.Printf("Listening on '%s'", hs.Addr)
-	hs.ListenAndServe()
+	if hs.TLSConfig != nil {
+		hs.ListenAndServeTLS("", "")
+	} else {
+		hs.ListenAndServe()
+	}
+}
+
+// migrations sent to cache nodes present the registry's certificate. with a certificate
+// the registry also serves https, must be called before Start
+func (hs *HttpServer) UseTLS(c *certs.Certs, requireClientCert bool) {
+	hs.transport.base = &http.Transport{TLSClientConfig: c.ClientConfig()}
+	if c.HasCertificate() {
+		hs.TLSConfig = c.ServerConfig()
+	}
+	hs.requireClientCert = requireClientCert
 }
 
 func (hs *HttpServer) Stop() {
 	log.Printf("Stopping server...")
+	close(hs.done)
+	hs.closeGRPC()
 	hs.Shutdown(context.Background())
 }
 
-func getUrlFromBody(r io.Reader) (string, error) {
+// the body's format comes from its Content-Type
+func getUrlFromBody(r *http.Request) (string, error) {
+	defer r.Body.Close()
+	c, err := codec.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+
 	var body RequestBody
-	err := json.NewDecoder(r).Decode(&body)
+	err = c.Decode(r.Body, &body)
 	if err != nil {
 		return "", err
 	}
@@ -120,15 +249,37 @@ func getUrlFromBody(r io.Reader) (string, error) {
 	return body.Url, nil
 }
 
-func handleError(w http.ResponseWriter, err error, status int) {
+func decodeErrorStatus(err error) int {
+	if errors.Is(err, codec.ErrUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+
+	return http.StatusBadRequest
+}
+
+// leadership can move between the redirect check and the write
+func writeErrorStatus(err error) int {
+	if errors.Is(err, cluster.ErrNotLeader) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	c := codec.ForAccept(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", c.ContentType())
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(&ErrResponseBody{Error: err.Error()}); err != nil {
+	if err := c.Encode(w, &ErrResponseBody{Error: err.Error()}); err != nil {
 		log.Println(err)
 	}
 }
 
-func encodeResponse(w http.ResponseWriter, val any) {
-	if err := json.NewEncoder(w).Encode(val); err != nil {
+// in whichever format the request's Accept header prefers
+func encodeResponse(w http.ResponseWriter, r *http.Request, val any) {
+	c := codec.ForAccept(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", c.ContentType())
+	if err := c.Encode(w, val); err != nil {
 		log.Println(err.Error())
 	}
 }
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+)
+
+const STATE_FILE_NAME = "registry.json"
+
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		path: filepath.Join(dir, STATE_FILE_NAME),
+	}, nil
+}
+
+// ok is false when nothing has been saved yet
+func (s *FileStore) Load() (state regmap.State, ok bool, err error) {
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return state, false, err
+	}
+
+	return state, true, nil
+}
+
+// writes to a temp file and renames it so a crash never leaves a partial state behind
+func (s *FileStore) Save(state regmap.State) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), STATE_FILE_NAME+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
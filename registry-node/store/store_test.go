@@ -0,0 +1,66 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	regmap "github.com/brendenehlers/go-distributed-cache/registry-node/map"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadWithoutState(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+
+	_, ok, err := store.Load()
+
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+
+	reg := regmap.New()
+	reg.Register("a.com")
+	reg.Register("b.com")
+	expected := reg.State()
+
+	err = store.Save(expected)
+	assert.Nil(t, err)
+
+	state, ok, err := store.Load()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, expected.Epoch, state.Epoch)
+	assert.Equal(t, expected.Ring, state.Ring)
+	assert.Len(t, state.Nodes, 2)
+}
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+
+	err = store.Save(regmap.New().State())
+	assert.Nil(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, STATE_FILE_NAME, entries[0].Name())
+}
+
+func TestLoadCorruptState(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	os.WriteFile(filepath.Join(dir, STATE_FILE_NAME), []byte("{"), 0o644)
+
+	_, ok, err := store.Load()
+
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
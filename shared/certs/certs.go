@@ -0,0 +1,206 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DEFAULT_CHECK_INTERVAL = 10 * time.Second
+
+var (
+	ErrNoCertificates = fmt.Errorf("no certificates found in ca file")
+	ErrNoPeerCert     = fmt.Errorf("peer sent no certificate")
+)
+
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// verifies peers, the system roots are used when empty
+	CaFile string
+	// how often the files are checked for changes
+	CheckInterval time.Duration
+}
+
+// keeps the certificate and ca in sync with the files on disk, so they can be rotated without a restart
+type Certs struct {
+	options   Options
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+	mux       sync.Mutex
+}
+
+func New(options Options) (*Certs, error) {
+	if options.CheckInterval == 0 {
+		options.CheckInterval = DEFAULT_CHECK_INTERVAL
+	}
+
+	c := &Certs{
+		options:  options,
+		modTimes: make(map[string]time.Time),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.checkedAt = time.Now()
+
+	return c, nil
+}
+
+func (c *Certs) load() error {
+	var cert *tls.Certificate
+	if c.options.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.options.CertFile, c.options.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if c.options.CaFile != "" {
+		buf, err := os.ReadFile(c.options.CaFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return ErrNoCertificates
+		}
+	}
+
+	for _, path := range c.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		c.modTimes[path] = info.ModTime()
+	}
+	c.cert = cert
+	c.pool = pool
+
+	return nil
+}
+
+func (c *Certs) files() []string {
+	files := []string{}
+	for _, path := range []string{c.options.CertFile, c.options.KeyFile, c.options.CaFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
+
+// a failed reload, like a half written file, keeps the previous certificates
+func (c *Certs) current() (*tls.Certificate, *x509.CertPool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if time.Since(c.checkedAt) >= c.options.CheckInterval {
+		c.checkedAt = time.Now()
+		if c.changed() {
+			if err := c.load(); err != nil {
+				log.Printf("Unable to reload certificates: %s", err)
+			}
+		}
+	}
+
+	return c.cert, c.pool
+}
+
+func (c *Certs) changed() bool {
+	for _, path := range c.files() {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(c.modTimes[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Certs) HasCertificate() bool {
+	return c.options.CertFile != ""
+}
+
+// client certificates are verified against the ca when they're sent, requiring them is up to the handlers
+func (c *Certs) ServerConfig() *tls.Config {
+	return c.serverConfig(tls.VerifyClientCertIfGiven)
+}
+
+// for connections only other nodes make, like raft traffic between registries
+func (c *Certs) MutualServerConfig() *tls.Config {
+	return c.serverConfig(tls.RequireAndVerifyClientCert)
+}
+
+func (c *Certs) serverConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = clientAuth
+			}
+			return config, nil
+		},
+	}
+}
+
+// presents this node's certificate to peers that ask for one
+func (c *Certs) ClientConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+
+	if c.options.CaFile != "" {
+		// the roots can rotate, so the chain is verified here rather than with a fixed RootCAs
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = c.verifyServer
+	}
+
+	return config
+}
+
+func (c *Certs) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrNoPeerCert
+	}
+	_, pool := c.current()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         pool,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// true when the peer sent a certificate signed by the ca
+func Verified(state *tls.ConnectionState) bool {
+	return state != nil && len(state.VerifiedChains) > 0
+}
@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCa struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCa(t *testing.T) *testCa {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCa{cert: cert, key: key}
+}
+
+func (ca *testCa) writeCa(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "ca.pem")
+	writePem(t, path, "CERTIFICATE", ca.cert.Raw)
+	return path
+}
+
+// writes a certificate for localhost signed by the ca
+func (ca *testCa) writeCert(t *testing.T, dir string, serial int64) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePem(t, certFile, "CERTIFICATE", der)
+	writePem(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePem(t *testing.T, path string, kind string, der []byte) {
+	buf := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCerts(t *testing.T, ca *testCa, interval time.Duration) *Certs {
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeCert(t, dir, 2)
+	certs, err := New(Options{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CaFile:        ca.writeCa(t, dir),
+		CheckInterval: interval,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func TestNewMissingFile(t *testing.T) {
+	_, err := New(Options{CertFile: "missing.pem", KeyFile: "missing.pem"})
+
+	assert.NotNil(t, err)
+}
+
+func TestReloadOnRotation(t *testing.T) {
+	ca := newTestCa(t)
+	certs := newTestCerts(t, ca, time.Nanosecond)
+	before, _ := certs.current()
+
+	// make sure the modification time moves on coarse filesystems
+	time.Sleep(10 * time.Millisecond)
+	ca.writeCert(t, filepath.Dir(certs.options.CertFile), 3)
+	after, _ := certs.current()
+
+	assert.NotEqual(t, before.Certificate[0], after.Certificate[0])
+}
+
+func TestFailedReloadKeepsCertificate(t *testing.T) {
+	ca := newTestCa(t)
+	certs := newTestCerts(t, ca, time.Nanosecond)
+	before, _ := certs.current()
+
+	time.Sleep(10 * time.Millisecond)
+	os.WriteFile(certs.options.CertFile, []byte("half written"), 0o600)
+	after, _ := certs.current()
+
+	assert.Equal(t, before, after)
+}
+
+func TestMutualTls(t *testing.T) {
+	ca := newTestCa(t)
+	serverCerts := newTestCerts(t, ca, time.Hour)
+	clientCerts := newTestCerts(t, ca, time.Hour)
+
+	verified := false
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified = Verified(r.TLS)
+	}))
+	server.TLS = serverCerts.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCerts.ClientConfig()}}
+	resp, err := client.Get(server.URL)
+
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.True(t, verified)
+}
+
+func TestClientRejectsUnknownServer(t *testing.T) {
+	serverCerts := newTestCerts(t, newTestCa(t), time.Hour)
+	clientCerts := newTestCerts(t, newTestCa(t), time.Hour)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverCerts.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCerts.ClientConfig()}}
+	_, err := client.Get(server.URL)
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	JSON    = "application/json"
+	MSGPACK = "application/msgpack"
+	CBOR    = "application/cbor"
+)
+
+var ErrUnsupportedMediaType = fmt.Errorf("body must be %s, %s or %s", JSON, MSGPACK, CBOR)
+
+// every format uses the json struct tags
+type Codec interface {
+	ContentType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return JSON }
+
+// html is left unescaped, so json values come back as they were stored
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return MSGPACK }
+
+func (msgpackCodec) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string { return CBOR }
+
+func (cborCodec) Encode(w io.Writer, v any) error {
+	return cbor.NewEncoder(w).Encode(v)
+}
+
+func (cborCodec) Decode(r io.Reader, v any) error {
+	return cbor.NewDecoder(r).Decode(v)
+}
+
+var (
+	Json    Codec = jsonCodec{}
+	Msgpack Codec = msgpackCodec{}
+	Cbor    Codec = cborCodec{}
+)
+
+// msgpack has never had one registered type, so the common ones are all accepted
+var mediaTypes = map[string]Codec{
+	JSON:                      Json,
+	MSGPACK:                   Msgpack,
+	"application/x-msgpack":   Msgpack,
+	"application/vnd.msgpack": Msgpack,
+	CBOR:                      Cbor,
+}
+
+// a request without a Content-Type is json
+func ForContentType(header string) (Codec, error) {
+	if header == "" {
+		return Json, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+	c, ok := mediaTypes[mediaType]
+	if !ok {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	return c, nil
+}
+
+// the supported type with the highest q, json when nothing listed is supported
+func ForAccept(header string) Codec {
+	type accepted struct {
+		codec Codec
+		q     float64
+	}
+
+	var candidates []accepted
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		c, ok := mediaTypes[mediaType]
+		if !ok || q <= 0 {
+			continue
+		}
+		candidates = append(candidates, accepted{codec: c, q: q})
+	}
+	if len(candidates) == 0 {
+		return Json
+	}
+
+	// stable so ties keep the client's order
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].codec
+}
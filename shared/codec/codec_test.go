@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type body struct {
+	Key   string `json:"key"`
+	Data  []byte `json:"data,omitempty"`
+	Flags uint32 `json:"flags,omitempty"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{Json, Msgpack, Cbor} {
+		buf := bytes.NewBuffer(nil)
+		assert.Nil(t, c.Encode(buf, body{Key: "key", Data: []byte{0, 255}, Flags: 7}))
+
+		var decoded body
+		assert.Nil(t, c.Decode(buf, &decoded))
+		assert.Equal(t, body{Key: "key", Data: []byte{0, 255}, Flags: 7}, decoded, c.ContentType())
+	}
+}
+
+func TestBinaryFormatsUseJSONNames(t *testing.T) {
+	for _, c := range []Codec{Msgpack, Cbor} {
+		buf := bytes.NewBuffer(nil)
+		c.Encode(buf, body{Key: "key"})
+
+		var decoded map[string]any
+		assert.Nil(t, c.Decode(buf, &decoded))
+		assert.Equal(t, map[string]any{"key": "key"}, decoded, c.ContentType())
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	c, err := ForContentType("")
+	assert.Nil(t, err)
+	assert.Equal(t, Json, c)
+
+	c, _ = ForContentType("application/x-msgpack")
+	assert.Equal(t, Msgpack, c)
+
+	c, _ = ForContentType("application/cbor; charset=binary")
+	assert.Equal(t, Cbor, c)
+
+	_, err = ForContentType("text/xml")
+	assert.ErrorIs(t, err, ErrUnsupportedMediaType)
+}
+
+func TestForAccept(t *testing.T) {
+	assert.Equal(t, Json, ForAccept(""))
+	assert.Equal(t, Json, ForAccept("*/*"))
+	assert.Equal(t, Json, ForAccept("text/html"))
+	assert.Equal(t, Msgpack, ForAccept("application/msgpack"))
+	assert.Equal(t, Cbor, ForAccept("application/msgpack;q=0.5, application/cbor"))
+	assert.Equal(t, Msgpack, ForAccept("application/msgpack, application/cbor"))
+	assert.Equal(t, Json, ForAccept("application/cbor;q=0, application/json"))
+}
+
+func TestJSONLeavesHTMLUnescaped(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, Json.Encode(buf, map[string]json.RawMessage{"value": json.RawMessage(`"<b> & </b>"`)}))
+
+	assert.Equal(t, "{\"value\":\"<b> & </b>\"}\n", buf.String())
+}